@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apperrors "ollama-model-downloader/internal/errors"
+)
+
+// baseModelFileName is the reference an adapter archive carries to the model
+// its adapter layers apply to.
+const baseModelFileName = "base-model.json"
+
+// blobSource is where a blob is fetched from. Blobs of a base model pulled
+// along with an adapter may live in another repository or registry.
+type blobSource struct {
+	client     *http.Client
+	registry   string
+	repository string
+	token      string
+	// renew gets a new token when the registry rejects token, as short-lived
+	// bearer tokens expire during long downloads. nil keeps token.
+	renew func(ctx context.Context) (string, error)
+}
+
+func (b blobSource) blobURL(digest string) string {
+	return fmt.Sprintf("%s/v2/%s/blobs/%s", strings.TrimRight(b.registry, "/"), b.repository, digest)
+}
+
+// download fetches it from b into blobsDir with downloadBlob. When the
+// registry answers 401 or 403 the token is renewed once and the blob
+// resumed from what was fetched so far.
+func (b blobSource) download(ctx context.Context, it blobItem, blobsDir string, retries int, p *progress, verbose bool) error {
+	err := downloadBlob(ctx, b.client, b.registry, b.repository, it.digest, b.token, blobsDir, retries, p, it.size, verbose)
+	if b.renew == nil || apperrors.KindOf(err) != apperrors.KindUnauthorized {
+		return err
+	}
+	token, rerr := b.renew(ctx)
+	if rerr != nil {
+		return fmt.Errorf("%w (renewing the token: %v)", err, rerr)
+	}
+	logf(ctx, verbose, "token rejected for %s; renewed\n", it.digest)
+	return downloadBlob(ctx, b.client, b.registry, b.repository, it.digest, token, blobsDir, retries, p, it.size, verbose)
+}
+
+// isAdapterOnly reports whether m carries adapter layers but no model
+// weights, i.e. it only works on top of a base model.
+func isAdapterOnly(m imageManifest) bool {
+	adapter := false
+	for _, l := range m.Layers {
+		switch l.MediaType {
+		case mtOllamaModel:
+			return false
+		case mtOllamaAdapter:
+			adapter = true
+		}
+	}
+	return adapter
+}
+
+// baseModelReference is written as docs/<host>/<repo>/<tag>/base-model.json
+// in adapter archives.
+type baseModelReference struct {
+	Model          string `json:"model"`
+	Registry       string `json:"registry"`
+	Repository     string `json:"repository"`
+	Reference      string `json:"reference"`
+	ManifestDigest string `json:"manifestDigest"`
+	// Included is true when the base model's manifest and blobs are in the
+	// same archive (-fetch-base).
+	Included bool `json:"included"`
+}
+
+// baseModel is a resolved -base-model.
+type baseModel struct {
+	ref modelRef
+	res resolvedManifest
+	src blobSource
+}
+
+// resolveBaseModel resolves opt.baseModel with its own registry profile,
+// since a fine-tune from a private registry usually builds on a public base.
+func resolveBaseModel(ctx context.Context, opt options) (*baseModel, error) {
+	ref, err := parseModel(opt.registry, opt.baseModel, opt.modelConfig)
+	if err != nil {
+		return nil, err
+	}
+	bopt, err := withProfile(opt, ref)
+	if err != nil {
+		return nil, err
+	}
+	client := newHTTPClient(bopt)
+	res, err := resolveManifest(ctx, client, bopt, ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.authorize(ctx, client, bopt); err != nil {
+		return nil, err
+	}
+	return &baseModel{
+		ref: res.ref,
+		res: res,
+		src: blobSource{client: client, registry: bopt.registry, repository: res.ref.Repository, token: res.token, renew: tokenRenewer(client, bopt, res.ref)},
+	}, nil
+}
+
+func writeBaseModelReference(dir string, opt options, base *baseModel) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(baseModelReference{
+		Model:          opt.baseModel,
+		Registry:       base.src.registry,
+		Repository:     base.ref.Repository,
+		Reference:      base.ref.Reference,
+		ManifestDigest: manifestDigest(base.res.raw),
+		Included:       opt.fetchBase,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, baseModelFileName), data, 0o644)
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/models"
+)
+
+// apiVersion is bumped on breaking changes; old versions keep their prefix
+// until clients have moved.
+const (
+	apiVersion = "1"
+	apiPrefix  = "/api/v" + apiVersion
+)
+
+// apiParam is a query parameter of an API route.
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// apiRoute defines one JSON endpoint. The same table registers the handlers
+// and generates /api/openapi.json, so the schema cannot drift from the code.
+// Request and Response are zero values of the body types and are only used
+// for their reflected schema.
+type apiRoute struct {
+	Method   string
+	Path     string // below apiPrefix
+	Summary  string
+	Params   []apiParam
+	Request  interface{}
+	Response interface{}
+	Handle   func(s *server, r *http.Request) (interface{}, *apperrors.AppError)
+}
+
+type downloadRequest struct {
+	Model       string `json:"model"`
+	Concurrency int    `json:"concurrency,omitempty"`
+	Retries     int    `json:"retries,omitempty"`
+	StartAt     string `json:"startAt,omitempty"` // HH:MM (next occurrence), "YYYY-MM-DD HH:MM" or RFC 3339; empty starts now
+}
+
+type batchRequest struct {
+	Action string   `json:"action"` // delete, verify or unzip
+	Names  []string `json:"names"`
+}
+
+type renameRequest struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName"`
+}
+
+type annotateRequest struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+	Notes  string   `json:"notes"`
+}
+
+type archiveResponse struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+type sessionResponse struct {
+	SessionID string `json:"sessionId"`
+	Message   string `json:"message"`
+}
+
+var sessionParam = apiParam{Name: "session", Description: "session ID; may be omitted while exactly one download runs"}
+
+var apiRoutes = []apiRoute{
+	{
+		Method:   http.MethodGet,
+		Path:     "/sessions",
+		Summary:  "List stored download sessions, newest first",
+		Response: []models.SessionMeta{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			metas, err := models.DiscoverPartialSessions(s.downloadsDir)
+			if err != nil {
+				return nil, apperrors.InternalServerError("list sessions", err)
+			}
+			if metas == nil {
+				metas = []models.SessionMeta{}
+			}
+			return metas, nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/downloads",
+		Summary:  "List finished archives in the output directory",
+		Response: []models.DownloadEntry{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			entries := models.DownloadsFromDir(s.downloadsDir)
+			s.checks.annotate(entries)
+			if entries == nil {
+				entries = []models.DownloadEntry{}
+			}
+			return entries, nil
+		},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/downloads",
+		Summary:  "Start or schedule a download, or attach to / resume the model's existing session",
+		Request:  downloadRequest{},
+		Response: sessionResponse{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			var req downloadRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return nil, apperrors.BadRequest("invalid JSON body", err)
+			}
+			if strings.TrimSpace(req.Model) == "" {
+				return nil, apperrors.BadRequest("model is required", nil)
+			}
+			startAt, err := parseStartAt(req.StartAt, time.Now())
+			if err != nil {
+				return nil, apperrors.BadRequest("invalid startAt", err)
+			}
+			id, msg := s.submit(req.Model, req.Concurrency, req.Retries, startAt)
+			return sessionResponse{SessionID: id, Message: msg}, nil
+		},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/downloads/rename",
+		Summary:  "Rename an archive together with its checksum, signature and notes files",
+		Request:  renameRequest{},
+		Response: archiveResponse{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			var req renameRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return nil, apperrors.BadRequest("invalid JSON body", err)
+			}
+			msg, err := s.renameArchive(req.Name, req.NewName)
+			if err != nil {
+				return nil, archiveError(err)
+			}
+			return archiveResponse{Name: zipFileName(req.NewName), Message: msg}, nil
+		},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/downloads/annotate",
+		Summary:  "Replace the labels and notes of an archive",
+		Request:  annotateRequest{},
+		Response: archiveResponse{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			var req annotateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return nil, apperrors.BadRequest("invalid JSON body", err)
+			}
+			msg, err := s.annotateArchive(req.Name, req.Labels, req.Notes)
+			if err != nil {
+				return nil, archiveError(err)
+			}
+			return archiveResponse{Name: req.Name, Message: msg}, nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/progress",
+		Summary:  "Byte progress of a running download",
+		Params:   []apiParam{sessionParam},
+		Response: ProgressData{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			return s.progressOf(r.URL.Query().Get("session")), nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/speed",
+		Summary:  "Transfer-rate samples of a session, one every few seconds while it downloads, oldest first",
+		Params:   []apiParam{{Name: "session", Description: "session ID", Required: true}},
+		Response: []models.SpeedSample{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			id := r.URL.Query().Get("session")
+			if id == "" || id != filepath.Base(id) {
+				return nil, apperrors.BadRequest("session is required", nil)
+			}
+			staging := filepath.Join(s.downloadsDir, id+".staging")
+			if _, err := models.LoadSessionMeta(staging); err != nil {
+				return nil, apperrors.NotFound("session not found", err)
+			}
+			samples, err := models.LoadSpeedSamples(staging)
+			if err != nil {
+				return nil, apperrors.InternalServerError("read speed log", err)
+			}
+			if samples == nil {
+				samples = []models.SpeedSample{}
+			}
+			return samples, nil
+		},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/pause",
+		Summary:  "Pause a running download; it can be resumed later",
+		Params:   []apiParam{sessionParam},
+		Response: sessionResponse{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			return apiStop(s, r, true)
+		},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/cancel",
+		Summary:  "Cancel a running download",
+		Params:   []apiParam{sessionParam},
+		Response: sessionResponse{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			return apiStop(s, r, false)
+		},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/resume",
+		Summary:  "Resume a paused, canceled or failed session",
+		Params:   []apiParam{{Name: "session", Description: "session ID", Required: true}},
+		Response: sessionResponse{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			id := r.URL.Query().Get("session")
+			if id == "" {
+				return nil, apperrors.BadRequest("session is required", nil)
+			}
+			if !validSessionID(id) {
+				return nil, apperrors.BadRequest("invalid session ID", nil)
+			}
+			msg, err := s.resume(id)
+			if err != nil {
+				return nil, apperrors.NotFound("session not found", err)
+			}
+			return sessionResponse{SessionID: id, Message: msg}, nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/usage",
+		Summary:  "Report the bytes in the output directory, the -quota and the free space on its volume",
+		Response: usageResponse{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			u, err := outputUsage(s.base, s.downloadsDir)
+			if err != nil {
+				return nil, apperrors.InternalServerError("disk usage", err)
+			}
+			return u, nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/estimate",
+		Summary:  "Resolve a model's manifest and report its size and what is already cached, without downloading",
+		Params:   []apiParam{{Name: "model", Description: "model reference, e.g. llama3:70b", Required: true}},
+		Response: sizeEstimate{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			model := strings.TrimSpace(r.URL.Query().Get("model"))
+			if model == "" {
+				return nil, apperrors.BadRequest("model is required", nil)
+			}
+			opt := s.base
+			opt.outputDir = s.downloadsDir
+			opt.outZip = ""
+			est, err := estimateModel(r.Context(), withModel(opt, model))
+			if err != nil {
+				return nil, apperrors.New(http.StatusBadGateway, err.Error(), err)
+			}
+			return est, nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/library/trending",
+		Summary:  "Popular models of the Ollama library with estimated download sizes, refreshed every few hours",
+		Response: trendingResponse{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			return s.trending.snapshot(), nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/stats/summary",
+		Summary:  "Totals over all recorded runs: bytes, outcomes, failure rate and average speed",
+		Response: models.StatsSummary{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			stats, appErr := s.statistics()
+			return stats.Summary, appErr
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/stats/daily",
+		Summary:  "Bytes fetched and run outcomes per day, oldest first",
+		Params:   []apiParam{{Name: "days", Description: "only the last N days (default: all)"}},
+		Response: []models.DayStats{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			stats, appErr := s.statistics()
+			if appErr != nil {
+				return nil, appErr
+			}
+			daily := stats.Daily
+			if v := r.URL.Query().Get("days"); v != "" {
+				days, err := strconv.Atoi(v)
+				if err != nil || days < 1 {
+					return nil, apperrors.BadRequest("days must be a positive integer", err)
+				}
+				cutoff := time.Now().AddDate(0, 0, 1-days).Format("2006-01-02")
+				for len(daily) > 0 && daily[0].Date < cutoff {
+					daily = daily[1:]
+				}
+			}
+			return daily, nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/stats/models",
+		Summary:  "Bytes, runs, failures and average speed per model, most bytes first",
+		Response: []models.ModelStats{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			stats, appErr := s.statistics()
+			return stats.Models, appErr
+		},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/batch",
+		Summary:  "Apply delete, verify or unzip to several archives as a background job",
+		Request:  batchRequest{},
+		Response: batchJob{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			var req batchRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return nil, apperrors.BadRequest("invalid JSON body", err)
+			}
+			if !batchActions[req.Action] {
+				return nil, apperrors.BadRequest("unsupported batch action", nil)
+			}
+			if len(req.Names) == 0 {
+				return nil, apperrors.BadRequest("names is required", nil)
+			}
+			return s.jobs.start(req.Action, req.Names, s.modelAction), nil
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/batch",
+		Summary:  "Per-archive results of a batch job",
+		Params:   []apiParam{{Name: "job", Description: "job ID returned by POST /batch", Required: true}},
+		Response: batchJob{},
+		Handle: func(s *server, r *http.Request) (interface{}, *apperrors.AppError) {
+			job, ok := s.jobs.get(r.URL.Query().Get("job"))
+			if !ok {
+				return nil, apperrors.NotFound("job not found", nil)
+			}
+			return job, nil
+		},
+	},
+}
+
+func apiStop(s *server, r *http.Request, pause bool) (interface{}, *apperrors.AppError) {
+	id := r.URL.Query().Get("session")
+	if !s.stop(id, pause) {
+		return nil, apperrors.NotFound("no running download for session", nil)
+	}
+	return sessionResponse{SessionID: id, Message: "ok"}, nil
+}
+
+// statistics aggregates the download history of the output directory.
+func (s *server) statistics() (models.Statistics, *apperrors.AppError) {
+	entries, err := models.LoadHistory(s.downloadsDir)
+	if err != nil {
+		return models.Statistics{}, apperrors.InternalServerError("read history", err)
+	}
+	return models.ComputeStatistics(entries), nil
+}
+
+// archiveError maps a failed archive operation to a status: missing files
+// are 404, everything else is the caller's fault.
+func archiveError(err error) *apperrors.AppError {
+	if os.IsNotExist(err) {
+		return apperrors.NotFound("archive not found", err)
+	}
+	return apperrors.BadRequest(err.Error(), err)
+}
+
+// handlerRegistry is what registerAPI mounts its handlers on: the server's
+// *http.ServeMux, or in tests a list of the patterns.
+type handlerRegistry interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// registerAPI mounts apiRoutes under apiPrefix plus the OpenAPI document.
+// Routes sharing a path are dispatched on the method.
+func (s *server) registerAPI(mux handlerRegistry) {
+	byPath := map[string][]apiRoute{}
+	var paths []string
+	for _, rt := range apiRoutes {
+		if _, ok := byPath[rt.Path]; !ok {
+			paths = append(paths, rt.Path)
+		}
+		byPath[rt.Path] = append(byPath[rt.Path], rt)
+	}
+	for _, p := range paths {
+		routes := byPath[p]
+		mux.HandleFunc(apiPrefix+p, func(w http.ResponseWriter, r *http.Request) {
+			for _, rt := range routes {
+				if rt.Method != r.Method {
+					continue
+				}
+				body, appErr := rt.Handle(s, r)
+				if appErr != nil {
+					appErr.WriteHTTPResponse(w)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(body)
+				return
+			}
+			apperrors.New(http.StatusMethodNotAllowed, "method not allowed", nil).WriteHTTPResponse(w)
+		})
+	}
+	mux.HandleFunc(apiPrefix+"/downloads/", s.handleSessionArchive)
+	mux.HandleFunc(apiPrefix+"/sessions/", s.handleSessionDetail)
+	serveSpec := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openAPISpec())
+	}
+	mux.HandleFunc("/api/openapi.json", serveSpec)
+	mux.HandleFunc(apiPrefix+"/openapi.json", serveSpec)
+}
+
+// openAPISpec builds an OpenAPI 3.0 document from apiRoutes.
+func openAPISpec() map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, rt := range apiRoutes {
+		op := map[string]interface{}{
+			"summary": rt.Summary,
+			"responses": map[string]interface{}{
+				"200": jsonContent("OK", rt.Response),
+				"default": jsonContent("Error", struct {
+					Error string `json:"error"`
+				}{}),
+			},
+		}
+		if len(rt.Params) > 0 {
+			var params []interface{}
+			for _, p := range rt.Params {
+				params = append(params, map[string]interface{}{
+					"name":        p.Name,
+					"in":          "query",
+					"description": p.Description,
+					"required":    p.Required,
+					"schema":      map[string]interface{}{"type": "string"},
+				})
+			}
+			op["parameters"] = params
+		}
+		if rt.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(rt.Request))}},
+			}
+		}
+		item, _ := paths[apiPrefix+rt.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[apiPrefix+rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+	// Routes with the session ID in the path are mounted outside apiRoutes,
+	// so they are described here.
+	sessionParam := []interface{}{map[string]interface{}{
+		"name":     "session",
+		"in":       "path",
+		"required": true,
+		"schema":   map[string]interface{}{"type": "string"},
+	}}
+	errorContent := jsonContent("Error", struct {
+		Error string `json:"error"`
+	}{})
+	paths[apiPrefix+"/downloads/{session}"+archivePathSuffix] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":    "Stream a zip of a session's models directory once its blobs have verified (waiting for that while it downloads), or its packaged zip once completed",
+			"parameters": sessionParam,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content": map[string]interface{}{
+						"application/zip": map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+					},
+				},
+				"default": errorContent,
+			},
+		},
+	}
+	paths[apiPrefix+"/sessions/{session}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":    "A stored session's metadata with its state, per-blob retry counts, elapsed time and archive",
+			"parameters": sessionParam,
+			"responses": map[string]interface{}{
+				"200":     jsonContent("OK", models.SessionDetail{}),
+				"default": errorContent,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":    "Cancel the session if it is running and remove its staging directory, but not a packaged archive; 409 while another process holds it",
+			"parameters": sessionParam,
+			"responses": map[string]interface{}{
+				"200":     jsonContent("OK", sessionResponse{}),
+				"default": errorContent,
+			},
+		},
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ollama-model-downloader",
+			"version": apiVersion,
+		},
+		"paths": paths,
+	}
+}
+
+func jsonContent(description string, v interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(v))},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema describes how encoding/json renders t.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n, _, _ := strings.Cut(tag, ","); n != "" {
+					name = n
+				}
+			}
+			props[name] = jsonSchema(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	default:
+		return map[string]interface{}{}
+	}
+}
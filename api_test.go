@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// patternList records the patterns registerAPI mounts.
+type patternList []string
+
+func (p *patternList) HandleFunc(pattern string, _ func(http.ResponseWriter, *http.Request)) {
+	*p = append(*p, pattern)
+}
+
+// TestOpenAPISpecCoversRoutes fails when a handler is mounted under
+// apiPrefix without an entry in the OpenAPI document.
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	var patterns patternList
+	(&server{}).registerAPI(&patterns)
+	spec := openAPISpec()
+	if _, err := json.Marshal(spec); err != nil {
+		t.Fatal(err)
+	}
+	paths := spec["paths"].(map[string]interface{})
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, apiPrefix+"/") || p == apiPrefix+"/openapi.json" {
+			continue
+		}
+		if strings.HasSuffix(p, "/") {
+			// A subtree takes an ID in the path, like /sessions/{session}.
+			var documented bool
+			for sp := range paths {
+				documented = documented || strings.HasPrefix(sp, p+"{")
+			}
+			if !documented {
+				t.Errorf("%s... is served but has no path in the OpenAPI document", p)
+			}
+			continue
+		}
+		if _, ok := paths[p]; !ok {
+			t.Errorf("%s is served but not in the OpenAPI document", p)
+		}
+	}
+	for path, methods := range map[string][]string{
+		apiPrefix + "/sessions/{session}":                      {"get", "delete"},
+		apiPrefix + "/downloads/{session}" + archivePathSuffix: {"get"},
+	} {
+		item, _ := paths[path].(map[string]interface{})
+		for _, m := range methods {
+			if _, ok := item[m]; !ok {
+				t.Errorf("%s %s is not in the OpenAPI document", strings.ToUpper(m), path)
+			}
+		}
+	}
+}
+
+// TestResumeRejectsPaths checks that a session ID naming anything but a
+// staging directory in the output directory is refused before it is used.
+func TestResumeRejectsPaths(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newServer(options{outputDir: dir}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.handler()
+	for _, id := range []string{"../x", "a/b", "/etc/x", ".", ".."} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, apiPrefix+"/resume?session="+url.QueryEscape(id), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /resume?session=%s = %d, want 400", id, rec.Code)
+		}
+		rec = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/resume", strings.NewReader(url.Values{"session": {id}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("form resume %s = %d, want 400", id, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+// Integrity states of a listed archive (models.DownloadEntry.Integrity).
+const (
+	integrityChecking = "checking"
+	integrityOK       = "ok"
+	integrityCorrupt  = "corrupt"
+)
+
+// checkArchiveIntegrity is the quick check run on every archive the web UI
+// lists: the central directory must be readable and every entry's local
+// header and data must lie inside the file, which a zip truncated by a
+// crash or a partial copy fails. Up to spot blob entries, the smallest
+// ones so the check stays cheap, are also hashed against the digest in
+// their name. verifyArchive (the verify action) reads everything.
+func checkArchiveIntegrity(archive string, spot int) error {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	var blobs []*zip.File
+	for _, f := range zr.File {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if offset+int64(f.CompressedSize64) > info.Size() {
+			return fmt.Errorf("%s: data runs past the end of the file", f.Name)
+		}
+		if strings.HasPrefix(pathBase(f.Name), "sha256-") && strings.Contains(f.Name, "blobs/") {
+			blobs = append(blobs, f)
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].UncompressedSize64 < blobs[j].UncompressedSize64 })
+	for _, f := range blobs[:min(spot, len(blobs))] {
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		_, sum, err := hashReader(rc, nil)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if want := strings.TrimPrefix(pathBase(f.Name), "sha256-"); sum != want {
+			return fmt.Errorf("%s: sha256 mismatch", f.Name)
+		}
+	}
+	return nil
+}
+
+// pathBase is the last element of a slash-separated zip entry name.
+func pathBase(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// archiveCheck is the integrity result for one version of an archive.
+type archiveCheck struct {
+	size    int64
+	modTime time.Time
+	state   string
+	err     string
+}
+
+// archiveChecks runs checkArchiveIntegrity in the background, one archive
+// at a time, and keeps each result until the file's size or modification
+// time changes.
+type archiveChecks struct {
+	spot int // -archive-spot-check
+
+	mu      sync.Mutex
+	results map[string]archiveCheck // by cleaned path
+	running chan struct{}
+}
+
+// annotate fills in the integrity of entries, starting checks for archives
+// that are new or changed since they were last checked.
+func (c *archiveChecks) annotate(entries []models.DownloadEntry) {
+	for i := range entries {
+		e := &entries[i]
+		info, err := os.Stat(e.Path)
+		if err != nil {
+			continue
+		}
+		key := filepath.Clean(e.Path)
+		c.mu.Lock()
+		c.initLocked()
+		r, ok := c.results[key]
+		if !ok || r.size != info.Size() || !r.modTime.Equal(info.ModTime()) {
+			r = archiveCheck{size: info.Size(), modTime: info.ModTime(), state: integrityChecking}
+			c.results[key] = r
+			go c.check(key, r)
+		}
+		c.mu.Unlock()
+		e.Integrity, e.IntegrityError = r.state, r.err
+	}
+}
+
+func (c *archiveChecks) initLocked() {
+	if c.results == nil {
+		c.results = map[string]archiveCheck{}
+		c.running = make(chan struct{}, 1)
+	}
+}
+
+func (c *archiveChecks) check(key string, r archiveCheck) {
+	c.running <- struct{}{}
+	defer func() { <-c.running }()
+	err := checkArchiveIntegrity(key, c.spot)
+	c.record(key, r, err)
+}
+
+// record stores the outcome of a check of the archive at key as it was
+// when r was taken; a result for an archive that has changed since is
+// dropped.
+func (c *archiveChecks) record(key string, r archiveCheck, err error) {
+	r.state, r.err = integrityOK, ""
+	if err != nil {
+		r.state, r.err = integrityCorrupt, err.Error()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cur, ok := c.results[key]; ok && cur.size == r.size && cur.modTime.Equal(r.modTime) {
+		c.results[key] = r
+	}
+}
+
+// verified records the outcome of a full verifyArchive of path.
+func (c *archiveChecks) verified(path string, err error) {
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		return
+	}
+	r := archiveCheck{size: info.Size(), modTime: info.ModTime()}
+	key := filepath.Clean(path)
+	c.mu.Lock()
+	c.initLocked()
+	c.results[key] = r
+	c.mu.Unlock()
+	c.record(key, r, err)
+}
+
+// corrupt returns why the archive at path failed its last check, if it
+// did and has not changed since.
+func (c *archiveChecks) corrupt(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.results[filepath.Clean(path)]
+	if !ok || r.state != integrityCorrupt || r.size != info.Size() || !r.modTime.Equal(info.ModTime()) {
+		return nil
+	}
+	return fmt.Errorf("%s is corrupt: %s", filepath.Base(path), r.err)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+func TestCheckArchiveIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "models")
+	blob := bytes.Repeat([]byte("layer"), 4096)
+	sum := sha256.Sum256(blob)
+	name := "sha256-" + hex.EncodeToString(sum[:])
+	if err := os.MkdirAll(filepath.Join(root, "blobs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "blobs", name), blob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "m.zip")
+	if _, err := zipDir(root, archive, zipOptions{compression: "none", workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkArchiveIntegrity(archive, 1); err != nil {
+		t.Fatalf("intact archive: %v", err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := filepath.Join(dir, "truncated.zip")
+	os.WriteFile(truncated, data[:len(data)/2], 0o644)
+	if err := checkArchiveIntegrity(truncated, 0); err == nil {
+		t.Error("truncated archive passed")
+	}
+
+	// A flipped byte inside a stored blob is only seen when it is hashed.
+	flipped := filepath.Join(dir, "flipped.zip")
+	bad := bytes.Clone(data)
+	bad[bytes.Index(bad, blob)+10] ^= 0xff
+	os.WriteFile(flipped, bad, 0o644)
+	if err := checkArchiveIntegrity(flipped, 0); err != nil {
+		t.Errorf("directory-only check of flipped archive: %v", err)
+	}
+	if err := checkArchiveIntegrity(flipped, 1); err == nil {
+		t.Error("spot check missed a corrupt blob")
+	}
+
+	c := archiveChecks{spot: 1}
+	entries := []models.DownloadEntry{{Path: archive}, {Path: flipped}}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.annotate(entries)
+		if entries[0].Integrity != integrityChecking && entries[1].Integrity != integrityChecking {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("checks did not finish: %+v", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if entries[0].Integrity != integrityOK || entries[1].Integrity != integrityCorrupt || entries[1].IntegrityError == "" {
+		t.Errorf("entries = %+v", entries)
+	}
+	if c.corrupt(archive) != nil || c.corrupt(flipped) == nil {
+		t.Errorf("corrupt(intact) = %v, corrupt(flipped) = %v", c.corrupt(archive), c.corrupt(flipped))
+	}
+	c.verified(flipped, nil)
+	if err := c.corrupt(flipped); err != nil {
+		t.Errorf("after a passing verify: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"ollama-model-downloader/models"
+)
+
+// archiveNameData is what a -name-template is executed against.
+type archiveNameData struct {
+	Model     string // model name without owner or tag, e.g. llama3
+	Namespace string // owner, e.g. library
+	Tag       string // tag, or the digest's first 12 hex digits for digest references
+	Platform  string // e.g. linux-amd64
+	Digest    string // manifest digest, e.g. sha256:0123...
+	Digest8   string // first 8 hex digits of the manifest digest
+}
+
+// parseNameTemplate parses a -name-template and runs it once against
+// sample values, so unknown fields are reported at startup rather than
+// after a download has finished resolving.
+func parseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := archiveNameData{Model: "model", Namespace: "library", Tag: "latest", Platform: "linux-amd64", Digest: "sha256:0123456789abcdef", Digest8: "01234567"}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// archiveName renders tmpl for ref, resolved to the manifest digest, into a
+// file name: path separators and other characters sanitizeModelName
+// replaces become dashes and .zip is appended when missing.
+func archiveName(tmpl *template.Template, ref modelRef, platform, digest string) (string, error) {
+	sum := strings.TrimPrefix(digest, "sha256:")
+	data := archiveNameData{
+		Model:     path.Base(ref.Repository),
+		Namespace: path.Dir(ref.Repository),
+		Tag:       ref.ReferenceTag,
+		Platform:  strings.ReplaceAll(platform, "/", "-"),
+		Digest:    digest,
+		Digest8:   sum[:min(8, len(sum))],
+	}
+	if data.Namespace == "." {
+		data.Namespace = ""
+	}
+	if data.Tag == "" {
+		data.Tag = sum[:min(12, len(sum))]
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("-name-template: %w", err)
+	}
+	name := strings.TrimSpace(b.String())
+	if name == "" || strings.Trim(name, ".") == "" {
+		return "", errors.New("-name-template: rendered an empty file name")
+	}
+	name = sanitizeModelName(name)
+	if !strings.HasSuffix(strings.ToLower(name), ".zip") {
+		name += ".zip"
+	}
+	return name, nil
+}
+
+// archivePath is where the download of opt put (or will put) its archive.
+// run records the final name in session.json, which differs from
+// opt.outZip when -name-template renamed it after resolving the digest.
+func archivePath(opt options) string {
+	if opt.stagingDir != "" {
+		if meta, err := models.LoadSessionMeta(opt.stagingDir); err == nil && meta.OutZip != "" {
+			return meta.OutZip
+		}
+	}
+	return opt.outZip
+}
+
+// withArchiveName points opt.outZip at the -name-template name for ref
+// once the manifest digest is known. An explicit -o is kept.
+func withArchiveName(opt options, ref modelRef, digest string) (options, error) {
+	if opt.nameTemplate == nil || !opt.defaultZip {
+		return opt, nil
+	}
+	name, err := archiveName(opt.nameTemplate, ref, opt.platform, digest)
+	if err != nil {
+		return opt, err
+	}
+	opt.outZip = filepath.Join(filepath.Dir(opt.outZip), name)
+	return opt, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/models"
+)
+
+// archivePathSuffix ends the streaming route, /downloads/<session>/archive.
+const archivePathSuffix = "/archive"
+
+// archiveStreams tracks the clients streaming each session's models
+// directory, by session ID. Once the blobs verify, run asks it whether a
+// client is waiting for the stream; if so the server packages no zip of its
+// own. Removing the staging files is left to the last stream to close.
+// verified and removeAfter, which run calls, take a nil value as no
+// streams.
+type archiveStreams struct {
+	mu       sync.Mutex
+	sessions map[string]*streamedSession
+}
+
+type streamedSession struct {
+	open     int
+	verified chan struct{} // closed when run has verified the blobs
+	cleanup  func()        // run's removal of the staging files, held while streams are open
+}
+
+func newArchiveStreams() *archiveStreams {
+	return &archiveStreams{sessions: map[string]*streamedSession{}}
+}
+
+// open registers a stream of session id and returns a channel closed once
+// its blobs have verified. Each open is paired with a close.
+func (a *archiveStreams) open(id string) <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := a.sessions[id]
+	if st == nil {
+		st = &streamedSession{verified: make(chan struct{})}
+		a.sessions[id] = st
+	}
+	st.open++
+	return st.verified
+}
+
+// close ends a stream of id and, after the last one, runs the cleanup run
+// left behind.
+func (a *archiveStreams) close(id string) {
+	a.mu.Lock()
+	st := a.sessions[id]
+	st.open--
+	var cleanup func()
+	if st.open == 0 {
+		cleanup = st.cleanup
+		delete(a.sessions, id)
+	}
+	a.mu.Unlock()
+	if cleanup != nil {
+		cleanup()
+	}
+}
+
+// verified wakes the streams waiting on session id and reports whether
+// there are any.
+func (a *archiveStreams) verified(id string) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := a.sessions[id]
+	if st == nil {
+		return false
+	}
+	select {
+	case <-st.verified:
+	default:
+		close(st.verified)
+	}
+	return st.open > 0
+}
+
+// removeAfter runs cleanup now, or when the last stream of id closes.
+func (a *archiveStreams) removeAfter(id string, cleanup func()) {
+	if a != nil {
+		a.mu.Lock()
+		if st := a.sessions[id]; st != nil {
+			st.cleanup = cleanup
+			a.mu.Unlock()
+			return
+		}
+		a.mu.Unlock()
+	}
+	cleanup()
+}
+
+// handleSessionArchive streams a zip of a session's models directory as it
+// is on disk. Entries are stored, not deflated, so the archive costs no CPU
+// and the client gets the artifact as soon as the blobs have verified. A
+// request made while the session is still downloading waits for that, and
+// the session then keeps no zip of its own. Once the session has completed
+// with a packaged zip, that file is served instead.
+func (s *server) handleSessionArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apperrors.New(http.StatusMethodNotAllowed, "method not allowed", nil).WriteHTTPResponse(w)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, apiPrefix+"/downloads/")
+	id, ok := strings.CutSuffix(rest, archivePathSuffix)
+	if !ok || id == "" || id != filepath.Base(id) {
+		apperrors.NotFound("not found", nil).WriteHTTPResponse(w)
+		return
+	}
+	streams := s.base.archiveStreams
+	verified := streams.open(id)
+	defer streams.close(id)
+
+	staging := filepath.Join(s.downloadsDir, id+".staging")
+	meta, err := models.LoadSessionMeta(staging)
+	if err != nil {
+		apperrors.NotFound("session not found", err).WriteHTTPResponse(w)
+		return
+	}
+	if !blobsVerified(meta.State) {
+		s.mu.Lock()
+		active := s.sessions[id]
+		s.mu.Unlock()
+		if active == nil {
+			apperrors.New(http.StatusConflict, "the session's blobs have not been verified yet", nil).WriteHTTPResponse(w)
+			return
+		}
+		select {
+		case <-verified:
+		case <-active.done:
+			if meta, err = models.LoadSessionMeta(staging); err != nil || !blobsVerified(meta.State) {
+				apperrors.New(http.StatusConflict, "the session stopped before its blobs were verified", err).WriteHTTPResponse(w)
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	name := filepath.Base(meta.OutZip)
+	if meta.OutZip == "" {
+		name = id + ".zip"
+	}
+	if meta.State == models.StateCompleted && meta.OutZip != "" {
+		if f, err := os.Open(meta.OutZip); err == nil {
+			defer f.Close()
+			if st, err := f.Stat(); err == nil {
+				w.Header().Set("Content-Type", "application/zip")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+				http.ServeContent(w, r, name, st.ModTime(), f)
+				return
+			}
+		}
+	}
+	modelsRoot := filepath.Join(staging, "models")
+	if _, err := os.Stat(modelsRoot); err != nil {
+		apperrors.NotFound("session files not found", err).WriteHTTPResponse(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if err := zipTo(w, modelsRoot, "", nil, zipOptions{compression: compressionNone}); err != nil {
+		// The status line is gone; a truncated archive fails to open.
+		s.log.Warn("archive stream failed", "session", id, "err", err)
+	}
+}
+
+// blobsVerified reports whether a session in state has verified its blobs.
+func blobsVerified(state models.SessionState) bool {
+	return state == models.StatePackaging || state == models.StateUploading || state == models.StateCompleted
+}
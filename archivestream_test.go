@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-model-downloader/internal/registrytest"
+	"ollama-model-downloader/models"
+)
+
+func archiveTestServer(t *testing.T, layerSize int) (*server, *httptest.Server, *registrytest.Registry, []byte, options) {
+	t.Helper()
+	reg, srv, layer := testModel(t, layerSize)
+	base := testOptions(t, srv.URL)
+	base.outZip, base.defaultZip = "", false
+	s, err := newServer(base, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := httptest.NewServer(s.handler())
+	t.Cleanup(ui.Close)
+	return s, ui, reg, layer, base
+}
+
+func startTestDownload(t *testing.T, ui *httptest.Server) {
+	t.Helper()
+	resp, err := http.Post(ui.URL+apiPrefix+"/downloads", "application/json", strings.NewReader(`{"model": "test/m:latest"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST downloads = %s", resp.Status)
+	}
+}
+
+func waitSessionState(t *testing.T, staging string, state models.SessionState) models.SessionMeta {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		meta, _ := models.LoadSessionMeta(staging)
+		if meta.State == state {
+			return meta
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session did not reach %s: %+v", state, meta)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func zipHasBlob(t *testing.T, data, blob []byte) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("archive does not open: %v", err)
+	}
+	name := "blobs/" + blobFileName(registrytest.Digest(blob))
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(got, blob) {
+			t.Fatalf("%s: %d bytes, %v", name, len(got), err)
+		}
+		return
+	}
+	t.Fatalf("no %s in the archive", name)
+}
+
+// TestSessionArchiveStream asks for the archive while the session is still
+// downloading and reads it only after the session completed. The layer is
+// larger than the socket buffers, so the stream is still being written
+// when the session cleans up.
+func TestSessionArchiveStream(t *testing.T) {
+	s, ui, reg, layer, base := archiveTestServer(t, 64<<20)
+	reg.Latency = 50 * time.Millisecond
+	startTestDownload(t, ui)
+	active := s.session("test-m-latest")
+	if active == nil {
+		t.Fatal("download not running")
+	}
+	staging := filepath.Join(base.outputDir, "test-m-latest.staging")
+
+	resp, err := http.Get(ui.URL + apiPrefix + "/downloads/test-m-latest/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		m, _ := models.LoadSessionMeta(staging)
+		t.Fatalf("GET archive = %s %s %+v", resp.Status, b, m)
+	}
+	meta := waitSessionState(t, staging, models.StateCompleted)
+	<-active.done
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipHasBlob(t, data, layer)
+	if _, err := os.Stat(meta.OutZip); !os.IsNotExist(err) {
+		t.Errorf("a streamed session packaged %s as well: %v", meta.OutZip, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(staging, "models")); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("staging files kept after the stream closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSessionArchiveCompleted serves the packaged zip of a completed
+// session whose staging files are gone.
+func TestSessionArchiveCompleted(t *testing.T) {
+	_, ui, _, layer, base := archiveTestServer(t, 64<<10)
+	startTestDownload(t, ui)
+	meta := waitSessionState(t, filepath.Join(base.outputDir, "test-m-latest.staging"), models.StateCompleted)
+
+	resp, err := http.Get(ui.URL + apiPrefix + "/downloads/test-m-latest/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET archive = %s, %v", resp.Status, err)
+	}
+	packaged, err := os.ReadFile(meta.OutZip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, packaged) {
+		t.Errorf("served %d bytes, not the packaged zip of %d", len(data), len(packaged))
+	}
+	zipHasBlob(t, data, layer)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	apperrors "ollama-model-downloader/internal/errors"
+)
+
+// How a registry host takes pulls, as found out by getRegistryToken.
+const (
+	authAnonymous = "anonymous" // no credentials needed
+	authBasic     = "basic"     // the Basic credential on every request, no token service
+)
+
+// registryAuthCache remembers, per registry and user, the hosts that need
+// no token, so every model after the first in a batch skips the
+// unauthenticated probe. Bearer tokens are scoped to one repository and are
+// not kept. A nil cache remembers nothing.
+type registryAuthCache struct {
+	mu    sync.Mutex
+	modes map[string]string
+}
+
+func newRegistryAuthCache() *registryAuthCache {
+	return &registryAuthCache{modes: map[string]string{}}
+}
+
+func authCacheKey(opt options) string {
+	return strings.TrimRight(opt.registry, "/") + "\x00" + opt.username
+}
+
+func (c *registryAuthCache) get(opt options) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.modes[authCacheKey(opt)]
+}
+
+func (c *registryAuthCache) set(opt options, mode string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.modes[authCacheKey(opt)] = mode
+	c.mu.Unlock()
+}
+
+// forget drops what is known about the registry of opt and reports
+// whether there was anything, i.e. whether the probe was skipped.
+func (c *registryAuthCache) forget(opt options) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := authCacheKey(opt)
+	_, ok := c.modes[key]
+	delete(c.modes, key)
+	return ok
+}
+
+// tokenRenewer is the renew func of a blobSource for ref: what opt.authCache
+// knew about the registry is dropped and the token looked up again.
+func tokenRenewer(client *http.Client, opt options, ref modelRef) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		opt.authCache.forget(opt)
+		return getRegistryToken(ctx, client, opt, ref.Repository, ref.Reference)
+	}
+}
+
+// authHeader is the Authorization value for a token from getRegistryToken:
+// a bearer token, or the whole Basic credential for registries without a
+// token service.
+func authHeader(token string) string {
+	if strings.HasPrefix(token, "Basic ") {
+		return token
+	}
+	return "Bearer " + token
+}
+
+// basicCredential is the Basic credential of the registry profile's user.
+func basicCredential(opt options) (string, error) {
+	if opt.username == "" {
+		return "", apperrors.WithKind(apperrors.KindUnauthorized, errors.New("the registry asks for a username and password (Basic auth); set them in a registry profile of the config file"))
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(opt.username+":"+opt.password)), nil
+}
+
+// challengeToken answers the WWW-Authenticate challenge of resp: a Basic
+// challenge with the profile's credentials, a Bearer one with a token for
+// scope (unless the challenge names its own).
+func challengeToken(ctx context.Context, client *http.Client, opt options, resp *http.Response, scope string) (string, error) {
+	if scheme, _, _ := strings.Cut(resp.Header.Get("WWW-Authenticate"), " "); strings.EqualFold(scheme, "Basic") {
+		return basicCredential(opt)
+	}
+	b, err := bearerChallengeFrom(resp)
+	if err != nil {
+		return "", err
+	}
+	if b.Scope == "" {
+		b.Scope = scope
+	}
+	return fetchBearerToken(ctx, client, opt, b)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// batchActions are the model actions that may be applied to several
+// archives in one request.
+var batchActions = map[string]bool{"delete": true, "verify": true, "unzip": true}
+
+// batchJob applies one action to several archives in the background and
+// records a result per archive.
+type batchJob struct {
+	ID      string      `json:"id"`
+	Action  string      `json:"action"`
+	Created time.Time   `json:"created"`
+	Done    bool        `json:"done"`
+	Items   []batchItem `json:"items"`
+}
+
+type batchItem struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // pending, ok or error
+	Message string `json:"message,omitempty"`
+}
+
+// batchJobs keeps recent jobs so clients can poll their results. Finished
+// jobs are dropped after batchJobTTL.
+type batchJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*batchJob
+}
+
+const batchJobTTL = time.Hour
+
+func newJobID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// start creates a job for names and runs it on a goroutine with apply.
+func (j *batchJobs) start(action string, names []string, apply func(name, action string) (string, error)) batchJob {
+	job := &batchJob{ID: newJobID(), Action: action, Created: time.Now()}
+	for _, n := range names {
+		job.Items = append(job.Items, batchItem{Name: n, Status: "pending"})
+	}
+
+	j.mu.Lock()
+	if j.jobs == nil {
+		j.jobs = map[string]*batchJob{}
+	}
+	for id, old := range j.jobs {
+		if old.Done && time.Since(old.Created) > batchJobTTL {
+			delete(j.jobs, id)
+		}
+	}
+	j.jobs[job.ID] = job
+	snapshot := job.copy()
+	j.mu.Unlock()
+
+	go func() {
+		for i := range job.Items {
+			j.mu.Lock()
+			name := job.Items[i].Name
+			j.mu.Unlock()
+
+			msg, err := apply(name, action)
+
+			j.mu.Lock()
+			if err != nil {
+				job.Items[i].Status, job.Items[i].Message = "error", err.Error()
+			} else {
+				job.Items[i].Status, job.Items[i].Message = "ok", msg
+			}
+			j.mu.Unlock()
+		}
+		j.mu.Lock()
+		job.Done = true
+		j.mu.Unlock()
+	}()
+	return snapshot
+}
+
+// get returns a consistent copy of the job with the given ID.
+func (j *batchJobs) get(id string) (batchJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return batchJob{}, false
+	}
+	return job.copy(), true
+}
+
+func (b *batchJob) copy() batchJob {
+	c := *b
+	c.Items = append([]batchItem(nil), b.Items...)
+	return c
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "bench",
+		usage: "measure download throughput for several concurrency and chunk sizes",
+		run:   runBench,
+	})
+}
+
+// benchDefaultModel is benchmarked when neither a model nor -url is given.
+// Its largest layer is a couple of GiB, enough for any -size worth running.
+const benchDefaultModel = "llama3.2"
+
+func runBench(opt options, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	sizeFlag := flags.String("size", "256MiB", "bytes to download for each configuration")
+	concFlag := flags.String("concurrency", "1,4,8", "comma-separated numbers of parallel connections to try")
+	chunkFlag := flags.String("chunk", "8MiB,64MiB", "comma-separated Range request sizes to try")
+	rawURL := flags.String("url", "", "benchmark this URL (it must support Range requests) instead of a model blob")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	size, err := parseByteSize(*sizeFlag)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("invalid -size %q", *sizeFlag)
+	}
+	concs, err := parseIntList(*concFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -concurrency: %w", err)
+	}
+	var chunks []int64
+	for _, s := range strings.Split(*chunkFlag, ",") {
+		n, err := parseByteSize(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid -chunk %q", s)
+		}
+		chunks = append(chunks, n)
+	}
+	model := benchDefaultModel
+	if flags.NArg() > 0 {
+		model = flags.Arg(0)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	client := newHTTPClient(opt)
+	target, headers, available, err := benchTarget(ctx, client, opt, *rawURL, model)
+	if err != nil {
+		return err
+	}
+	if available > 0 && size > available {
+		fmt.Fprintf(os.Stderr, "warning: object is only %s; benchmarking that much\n", humanBytes(available))
+		size = available
+	}
+
+	fmt.Printf("%-12s %-10s %-10s %s\n", "CONCURRENCY", "CHUNK", "TIME", "THROUGHPUT")
+	var best struct {
+		conc  int
+		chunk int64
+		rate  float64
+	}
+	for _, c := range concs {
+		for _, chunk := range chunks {
+			elapsed, err := benchRun(ctx, client, target, headers, size, c, chunk, opt.retries)
+			if err != nil {
+				if ctx.Err() != nil {
+					return errInterrupted
+				}
+				fmt.Printf("%-12d %-10s error: %v\n", c, humanBytes(chunk), err)
+				continue
+			}
+			rate := float64(size) / elapsed.Seconds()
+			fmt.Printf("%-12d %-10s %-10s %s/s\n", c, humanBytes(chunk), elapsed.Round(time.Millisecond), humanBytes(int64(rate)))
+			if rate > best.rate {
+				best.conc, best.chunk, best.rate = c, chunk, rate
+			}
+		}
+	}
+	if best.rate == 0 {
+		return errors.New("every configuration failed")
+	}
+	fmt.Printf("\nfastest: -concurrency %d with %s requests (%s/s)\n", best.conc, humanBytes(best.chunk), humanBytes(int64(best.rate)))
+	return nil
+}
+
+// benchTarget returns the URL to benchmark, the headers to send and its
+// size (0 if unknown). For a model it is the largest layer's blob; the
+// registry's redirect to its storage is followed once up front so every
+// request of the benchmark measures the transfer itself.
+func benchTarget(ctx context.Context, client *http.Client, opt options, rawURL, model string) (string, map[string]string, int64, error) {
+	headers := map[string]string{"User-Agent": "ollama-model-downloader/1.0"}
+	var size int64
+	if rawURL == "" {
+		opt = withModel(opt, model)
+		ref, err := parseModel(opt.registry, opt.model, opt.modelConfig)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		if opt, err = withProfile(opt, ref); err != nil {
+			return "", nil, 0, err
+		}
+		res, err := resolveManifest(ctx, client, opt, ref)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		if err := res.authorize(ctx, client, opt); err != nil {
+			return "", nil, 0, err
+		}
+		var largest manifestLayer
+		for _, l := range res.manifest.Layers {
+			if l.Size > largest.Size {
+				largest = l
+			}
+		}
+		if largest.Digest == "" {
+			return "", nil, 0, fmt.Errorf("%s has no layers", model)
+		}
+		src := blobSource{registry: opt.registry, repository: res.ref.Repository}
+		rawURL, size = src.blobURL(largest.Digest), largest.Size
+		if res.token != "" {
+			headers["Authorization"] = authHeader(res.token)
+		}
+		fmt.Printf("benchmarking %s layer %s (%s)\n", model, largest.Digest, humanBytes(largest.Size))
+	}
+
+	probe := map[string]string{"Range": "bytes=0-0"}
+	for k, v := range headers {
+		probe[k] = v
+	}
+	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, rawURL, probe, opt.retries, opt.verbose)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return "", nil, 0, fmt.Errorf("GET %s: %s (Range requests are required)", rawURL, resp.Status)
+	}
+	if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok && size == 0 {
+		size, _ = strconv.ParseInt(total, 10, 64)
+	}
+	final := resp.Request.URL.String()
+	if final != rawURL {
+		// Storage URLs are pre-signed; the registry token is not for them.
+		delete(headers, "Authorization")
+	}
+	return final, headers, size, nil
+}
+
+// benchRun downloads the first size bytes of url in chunk-sized Range
+// requests over conc connections and returns how long it took.
+func benchRun(ctx context.Context, client *http.Client, url string, headers map[string]string, size int64, conc int, chunk int64, retries int) (time.Duration, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var next atomic.Int64
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < conc; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				off := next.Add(chunk) - chunk
+				if off >= size || ctx.Err() != nil {
+					return
+				}
+				if err := benchChunk(ctx, client, url, headers, off, min64(off+chunk, size)-1, retries); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return time.Since(start), firstErr
+}
+
+func benchChunk(ctx context.Context, client *http.Client, url string, headers map[string]string, from, to int64, retries int) error {
+	h := map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", from, to)}
+	for k, v := range headers {
+		h[k] = v
+	}
+	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, url, h, retries, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range %d-%d: %s", from, to, resp.Status)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err == nil && n != to-from+1 {
+		err = fmt.Errorf("range %d-%d: got %d bytes", from, to, n)
+	}
+	return err
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// byteUnits are the suffixes parseByteSize accepts, longest first so "MiB"
+// is not read as "B".
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as "512", "64MiB", "1.5GiB" or "2GB".
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s, factor = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(factor)), nil
+}
+
+func parseIntList(s string) ([]int, error) {
+	var out []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%q is not a positive number", f)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ollama-model-downloader/models"
+)
+
+// bundleStagingDir is where mirror -o assembles the models layout of
+// out before zipping it. It is kept when the bundle fails so a rerun only
+// fetches what is missing.
+func bundleStagingDir(outputDir, out string) string {
+	name := strings.TrimSuffix(filepath.Base(out), filepath.Ext(out))
+	return filepath.Join(outputDir, ".bundle-"+name)
+}
+
+// writeBundle packages the resolved items into the single archive out. The
+// layout is the one of a normal archive: every manifest under manifests/
+// and each unique blob stored once under blobs/, however many models
+// reference it.
+func writeBundle(ctx context.Context, opt options, items []mirrorItem, out string) error {
+	for _, it := range items {
+		if it.err != nil {
+			return fmt.Errorf("%s: %w", it.model, it.err)
+		}
+	}
+	staging := bundleStagingDir(opt.outputDir, out)
+	modelsRoot := filepath.Join(staging, "models")
+	blobsDir := filepath.Join(modelsRoot, "blobs")
+	if err := fetchSharedBlobs(ctx, opt, items, blobsDir); err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "interrupted; blobs fetched so far are kept in %s\n", staging)
+			return errInterrupted
+		}
+		return err
+	}
+
+	for _, it := range items {
+		ref := it.res.ref
+		if err := writeManifestFile(modelsRoot, ref, it.res.raw, opt.verbose); err != nil {
+			return err
+		}
+		report := &models.VerificationReport{
+			Model:          it.model,
+			Registry:       it.opt.registry,
+			Repository:     ref.Repository,
+			Reference:      ref.Reference,
+			ManifestDigest: manifestDigest(it.res.raw),
+			Platform:       it.opt.platform,
+		}
+		if err := verifyStagedBlobs(report, blobsDir, it.blobs, opt.concurrency, nil); err != nil {
+			return fmt.Errorf("%s: %w", it.model, err)
+		}
+		if err := writeVerificationReport(report, modelDocsDir(modelsRoot, ref, manifestFileTail(ref))); err != nil {
+			return fmt.Errorf("verification report: %w", err)
+		}
+	}
+
+	if dir := filepath.Dir(out); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	// A blob another model keeps is packaged even if -layers leaves it
+	// out for one of them.
+	var kept, leftOut []blobItem
+	for _, it := range items {
+		k, l := opt.layers.apply(manifestBlobs(it.res.manifest, blobSource{}))
+		kept, leftOut = append(kept, k...), append(leftOut, l...)
+	}
+	sum, err := packageArchive(opt, modelsRoot, out, leftOutBlobs(kept, leftOut))
+	if err != nil {
+		return fmt.Errorf("zip: %w", err)
+	}
+	sumsPath, err := writeChecksumSidecar(out, sum)
+	if err != nil {
+		return fmt.Errorf("checksums: %w", err)
+	}
+	if opt.gpgSign != "" {
+		if err := signArtifacts(opt.gpgSign, out, sumsPath); err != nil {
+			return fmt.Errorf("sign: %w", err)
+		}
+	}
+	if err := writeSumsFile(filepath.Dir(out), opt.gpgSign); err != nil {
+		return fmt.Errorf("checksums: %w", err)
+	}
+	fmt.Printf("OK: %s (%d models)\n", out, len(items))
+	if opt.keepStaging {
+		if !opt.quiet {
+			fmt.Println("staging kept at:", staging)
+		}
+		return nil
+	}
+	return os.RemoveAll(staging)
+}
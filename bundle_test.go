@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ollama-model-downloader/internal/registrytest"
+)
+
+func TestBundleRequiresSignature(t *testing.T) {
+	reg, srv, layer := testModel(t, 4<<10)
+	opt := testOptions(t, srv.URL)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.signatureKey = filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(opt.signatureKey, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opt.requireSignature = true
+
+	ctx := context.Background()
+	out := filepath.Join(opt.outputDir, "family.zip")
+	items := resolveMirrorItems(ctx, opt, []string{"test/m:latest"})
+	if err := writeBundle(ctx, opt, items, out); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("writeBundle() error = %v, want the missing signature", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("bundle of an unsigned model written: %v", err)
+	}
+	if n := reg.Requests("/blobs/" + registrytest.Digest(layer)); n != 0 {
+		t.Errorf("model layer fetched %d times for a refused model", n)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Faults the -chaos transport injects, one picked at random per affected
+// request.
+const (
+	chaosStatus   = "500"
+	chaosReset    = "connection reset"
+	chaosSlow     = "slow read"
+	chaosTruncate = "truncated body"
+)
+
+var chaosFaults = []string{chaosStatus, chaosReset, chaosSlow, chaosTruncate}
+
+// chaosTransport injects faults into a fraction of requests so the retry,
+// resume and checksum paths can be exercised on purpose instead of waiting
+// for a flaky network. It is enabled with the hidden -chaos flag.
+type chaosTransport struct {
+	rt   http.RoundTripper
+	rate float64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newChaosTransport(rt http.RoundTripper, rate float64) *chaosTransport {
+	return &chaosTransport{rt: rt, rate: rate, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// pick returns the fault for the next request ("" for none) and, for a
+// truncated body, how many bytes to let through.
+func (t *chaosTransport) pick() (string, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rnd.Float64() >= t.rate {
+		return "", 0
+	}
+	return chaosFaults[t.rnd.Intn(len(chaosFaults))], t.rnd.Int63n(1 << 20)
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fault, keep := t.pick()
+	if fault == "" {
+		return t.rt.RoundTrip(req)
+	}
+	fmt.Fprintf(os.Stderr, "chaos: %s for %s %s\n", fault, req.Method, req.URL.Path)
+	switch fault {
+	case chaosStatus:
+		return &http.Response{
+			Status:     "500 Internal Server Error",
+			StatusCode: http.StatusInternalServerError,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       io.NopCloser(strings.NewReader("chaos\n")),
+			Request:    req,
+		}, nil
+	case chaosReset:
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if fault == chaosSlow {
+		resp.Body = &slowBody{ReadCloser: resp.Body}
+	} else {
+		resp.Body = &truncatedBody{ReadCloser: resp.Body, left: keep}
+	}
+	return resp, nil
+}
+
+// slowBody hands out the body in small pieces with a pause before each of
+// the first reads.
+type slowBody struct {
+	io.ReadCloser
+	reads int
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if b.reads < 20 {
+		b.reads++
+		time.Sleep(200 * time.Millisecond)
+		if len(p) > 4096 {
+			p = p[:4096]
+		}
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// truncatedBody fails with an unexpected EOF after left bytes.
+type truncatedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "clean",
+		usage: "remove orphaned .part files and leftover temporary staging directories",
+		run:   runClean,
+	})
+}
+
+// tempDirMinAge keeps clean away from temporary directories a run may still
+// be writing: nothing records who owns them, so only old ones are removed.
+const tempDirMinAge = time.Hour
+
+type cleanResult struct {
+	Removed   []string
+	Reclaimed int64
+	Skipped   []string // sessions locked by a running download
+}
+
+func runClean(opt options, args []string) error {
+	flags := flag.NewFlagSet("clean", flag.ContinueOnError)
+	parts := flags.Bool("parts", false, "remove .part files, .partial archives and ollama-staging-*/.import-* directories no session uses")
+	dryRun := flags.Bool("dry-run", false, "only report what would be removed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !*parts {
+		return errors.New("clean: choose what to remove: -parts")
+	}
+	res, err := cleanParts(opt.outputDir, []string{".", opt.outputDir}, *dryRun, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, s := range res.Skipped {
+		fmt.Printf("skipped active session: %s\n", s)
+	}
+	for _, p := range res.Removed {
+		if opt.verbose || *dryRun {
+			fmt.Println("remove:", p)
+		}
+	}
+	verb := "reclaimed"
+	if *dryRun {
+		verb = "would reclaim"
+	}
+	fmt.Printf("%d path(s), %s %s\n", len(res.Removed), verb, humanBytes(res.Reclaimed))
+	return nil
+}
+
+// cleanParts removes what interrupted runs leave behind and no session
+// will pick up again:
+//
+//   - .part files in a staging directory without session.json, of a blob
+//     the session's metadata does not list, or next to the finished blob;
+//   - ollama-staging-* directories in tempParents, and .import-* directories
+//     and archives left .partial in outputDir, that nothing has written to
+//     for tempDirMinAge.
+//
+// Sessions whose lock is live are skipped. The mirror's shared blob
+// directory is a cache for the next mirror run and is left alone.
+func cleanParts(outputDir string, tempParents []string, dryRun bool, now time.Time) (cleanResult, error) {
+	var res cleanResult
+	remove := func(path string, size int64, all bool) error {
+		if !dryRun {
+			var err error
+			if all {
+				err = os.RemoveAll(path)
+			} else {
+				err = os.Remove(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		res.Removed = append(res.Removed, path)
+		res.Reclaimed += size
+		return nil
+	}
+
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return res, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".staging") {
+			continue
+		}
+		staging := filepath.Join(outputDir, entry.Name())
+		if _, live := sessionLockOwner(staging); live {
+			res.Skipped = append(res.Skipped, strings.TrimSuffix(entry.Name(), ".staging"))
+			continue
+		}
+		var listed map[string]bool // nil: no metadata, so no .part is wanted
+		if meta, err := models.LoadSessionMeta(staging); err == nil {
+			if len(meta.Blobs) == 0 {
+				// The manifest was never resolved; nothing proves a .part unused.
+				continue
+			}
+			listed = make(map[string]bool, len(meta.Blobs))
+			for _, b := range meta.Blobs {
+				listed[blobFileName(b.Digest)] = true
+			}
+		}
+		blobsDir := filepath.Join(staging, "models", "blobs")
+		blobs, err := os.ReadDir(blobsDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return res, err
+		}
+		for _, b := range blobs {
+			name, ok := strings.CutSuffix(b.Name(), ".part")
+			if !ok || b.IsDir() {
+				continue
+			}
+			if listed[name] {
+				if _, err := os.Stat(filepath.Join(blobsDir, name)); err != nil {
+					continue // the resumable part of a blob still missing
+				}
+			}
+			info, err := b.Info()
+			if err != nil {
+				continue
+			}
+			if err := remove(filepath.Join(blobsDir, b.Name()), info.Size(), false); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	absOut, err := filepath.Abs(outputDir)
+	if err != nil {
+		return res, err
+	}
+	seen := map[string]bool{}
+	for _, parent := range tempParents {
+		abs, err := filepath.Abs(parent)
+		if err != nil || seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return res, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() {
+				if abs != absOut || !strings.HasSuffix(name, ".partial") {
+					continue
+				}
+				info, err := entry.Info()
+				if err != nil || now.Sub(info.ModTime()) < tempDirMinAge {
+					continue
+				}
+				if err := remove(filepath.Join(parent, name), info.Size(), false); err != nil {
+					return res, err
+				}
+				continue
+			}
+			temp := strings.HasPrefix(name, "ollama-staging-") ||
+				(strings.HasPrefix(name, ".import-") && abs == absOut)
+			if !temp {
+				continue
+			}
+			dir := filepath.Join(parent, name)
+			size, newest, err := treeUsage(dir)
+			if err != nil {
+				return res, err
+			}
+			if now.Sub(newest) < tempDirMinAge {
+				continue
+			}
+			if err := remove(dir, size, true); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// treeUsage returns the bytes in the files below dir and the newest
+// modification time of anything in it.
+func treeUsage(dir string) (size int64, newest time.Time, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, newest, err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+func TestCleanParts(t *testing.T) {
+	out := t.TempDir()
+	write := func(path string, size int) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := func(id string, blobs ...string) string {
+		staging := filepath.Join(out, id+".staging")
+		write(filepath.Join(staging, "models", "blobs", ".keep"), 0)
+		if blobs != nil {
+			meta := models.SessionMeta{SessionID: id, StagingRoot: staging, State: models.StatePaused}
+			for _, d := range blobs {
+				meta.Blobs = append(meta.Blobs, models.SessionBlob{Digest: d, Size: 10})
+			}
+			if err := models.SaveSessionMeta(meta); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return filepath.Join(staging, "models", "blobs")
+	}
+
+	a := session("a", "sha256:1", "sha256:2", "sha256:3")
+	write(filepath.Join(a, "sha256-1.part"), 4)   // resumable: kept
+	write(filepath.Join(a, "sha256-2"), 10)       // finished blob
+	write(filepath.Join(a, "sha256-2.part"), 10)  // leftover next to it
+	write(filepath.Join(a, "sha256-9.part"), 100) // not in the session
+	b := session("b")
+	write(filepath.Join(b, "sha256-5.part"), 1000) // no session.json
+	old := filepath.Join(out, "ollama-staging-123")
+	write(filepath.Join(old, "models", "blobs", "sha256-7.part"), 5)
+	fresh := filepath.Join(out, ".import-456")
+	write(filepath.Join(fresh, "session.json"), 2)
+	write(filepath.Join(out, sharedBlobsDirName, "sha256-8.part"), 7)
+	write(filepath.Join(out, "m.zip.partial"), 3) // packaging cut off
+
+	later := time.Now().Add(30 * time.Minute)
+	res, err := cleanParts(out, []string{out}, true, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Removed) != 3 || res.Reclaimed != 1110 {
+		t.Errorf("dry run = %v, %d bytes", res.Removed, res.Reclaimed)
+	}
+	if _, err := os.Stat(filepath.Join(a, "sha256-9.part")); err != nil {
+		t.Error("dry run removed a file")
+	}
+
+	res, err = cleanParts(out, []string{out}, false, later.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removed []string
+	for _, p := range res.Removed {
+		rel, _ := filepath.Rel(out, p)
+		removed = append(removed, filepath.ToSlash(rel))
+	}
+	sort.Strings(removed)
+	want := ".import-456,a.staging/models/blobs/sha256-2.part,a.staging/models/blobs/sha256-9.part,b.staging/models/blobs/sha256-5.part,m.zip.partial,ollama-staging-123"
+	if strings.Join(removed, ",") != want {
+		t.Errorf("removed %v", removed)
+	}
+	for _, kept := range []string{filepath.Join(a, "sha256-1.part"), filepath.Join(a, "sha256-2"), filepath.Join(out, sharedBlobsDirName, "sha256-8.part")} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("%s removed", kept)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/models"
+)
+
+// errInterrupted is returned by runCLI when the user stopped the download;
+// the session is paused and can be resumed.
+var errInterrupted = errors.New("interrupted")
+
+// interruptContext is canceled by the first SIGINT or SIGTERM. Later signals
+// get the default behavior, so a second Ctrl-C still kills the process.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// runCLI is run() for terminal use. Like the web UI it leaves the session in
+// a final state: paused when ctx was canceled (Ctrl-C), with the command that
+// resumes it printed, or failed with the error.
+func runCLI(ctx context.Context, opt options) error {
+	err := settleSession(ctx, opt, run(ctx, opt))
+	if err == errInterrupted {
+		fmt.Fprintf(os.Stderr, "\ninterrupted; progress is kept in %s\nresume with: %s\n", opt.stagingDir, resumeCommand())
+	}
+	return err
+}
+
+// settleSession records how run() ended for opt's session and returns err,
+// or errInterrupted when ctx was canceled and the session is paused.
+func settleSession(ctx context.Context, opt options, err error) error {
+	switch {
+	case err == nil:
+		notifySession(opt, nil)
+		return nil
+	case errors.Is(err, errSessionLocked):
+		// The session belongs to the other process; leave its state alone.
+		return err
+	case errors.Is(err, errDeclined):
+		setSessionStatus(opt.stagingDir, models.StateCanceled, "لغو شد")
+		return err
+	case ctx.Err() != nil:
+		// run() only returns after every blob goroutine has closed its
+		// .part file, so the checkpoints on disk are complete.
+		setSessionStatus(opt.stagingDir, models.StatePaused, "مکث شد")
+		return errInterrupted
+	default:
+		setSessionError(opt.stagingDir, err)
+		notifySession(opt, err)
+		return err
+	}
+}
+
+// printError prints err for the terminal, followed by what the user can do
+// about it when its kind is known.
+func printError(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	if hint := apperrors.Hint(err); hint != "" {
+		fmt.Fprintln(os.Stderr, "hint:", hint)
+	}
+}
+
+// downloadCLI runs opt in the foreground until it finishes or Ctrl-C pauses
+// it. A paused download exits the process with status 130. On a terminal
+// the resolved model is shown for confirmation first, unless -yes is set.
+func downloadCLI(opt options) error {
+	opt.confirm = !opt.yes && isTerminal(os.Stdin)
+	ctx, stop := interruptContext()
+	defer stop()
+	err := waitForStart(ctx, opt.startAt)
+	if err == nil {
+		err = runCLI(ctx, opt)
+	}
+	if err == errInterrupted {
+		os.Exit(130)
+	}
+	return err
+}
+
+// resumeCommand is the current command line, shell-quoted. Running it again
+// continues the same session because the session ID derives from the model.
+func resumeCommand() string {
+	args := make([]string, len(os.Args))
+	for i, a := range os.Args {
+		args[i] = shellQuote(a)
+	}
+	return strings.Join(args, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`!*?&;|<>()[]{}#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
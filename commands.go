@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// command is a named subcommand, e.g. `ollama-model-downloader gc`. Global
+// flags are parsed before dispatch and passed in via opt; args holds what
+// follows the subcommand name so commands can parse their own flags.
+type command struct {
+	name  string
+	usage string
+	run   func(opt options, args []string) error
+}
+
+var commands = map[string]command{}
+
+func registerCommand(c command) {
+	commands[c.name] = c
+}
+
+func lookupCommand(name string) (command, bool) {
+	c, ok := commands[name]
+	return c, ok
+}
+
+func printCommands(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-16s %s\n", name, commands[name].usage)
+	}
+}
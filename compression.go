@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Archive compression selected with -compression.
+const (
+	compressionNone    = "none"    // store: no CPU, and blobs can be read back with Range requests
+	compressionFast    = "fast"    // deflate, best speed
+	compressionDefault = "default" // deflate, the standard level
+	compressionBest    = "best"    // deflate, best compression
+)
+
+// zipDeflateLevel is the level archive/zip's own Deflate compressor uses.
+const zipDeflateLevel = 5
+
+// zipCompression maps a -compression value to the zip method and deflate
+// level it stands for. An empty value is compressionDefault.
+func zipCompression(compression string) (method uint16, level int, err error) {
+	switch compression {
+	case compressionNone:
+		return zip.Store, 0, nil
+	case compressionFast:
+		return zip.Deflate, flate.BestSpeed, nil
+	case "", compressionDefault:
+		return zip.Deflate, zipDeflateLevel, nil
+	case compressionBest:
+		return zip.Deflate, flate.BestCompression, nil
+	}
+	return 0, 0, fmt.Errorf("unknown compression %q (want none, fast, default or best)", compression)
+}
+
+// newZipWriter returns a zip writer on w whose Deflate entries use level,
+// compressed on up to workers goroutines each.
+func newZipWriter(w io.Writer, level, workers int) *zip.Writer {
+	zw := zip.NewWriter(w)
+	switch {
+	case workers > 1:
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return newParallelDeflater(out, level, workers), nil
+		})
+	case level != zipDeflateLevel:
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	return zw
+}
+
+// deflateChunkSize is how much of an entry one compression worker takes at
+// a time.
+const deflateChunkSize = 1 << 20
+
+// deflateDictSize is the deflate window: the tail of the previous chunk
+// that primes the compressor of the next one.
+const deflateDictSize = 32 << 10
+
+// parallelDeflater is a deflate writer that compresses consecutive chunks
+// on up to workers goroutines, the way pigz does. Every chunk but the last
+// ends with a sync flush, so the chunks concatenate into one stream any
+// inflater reads; each compressor starts from the previous chunk's tail to
+// keep most of the ratio.
+type parallelDeflater struct {
+	w       io.Writer
+	level   int
+	buf     []byte // the chunk being filled
+	dict    []byte
+	pending chan chan deflatedChunk // in order; its capacity bounds the chunks in flight
+	done    chan error
+
+	mu  sync.Mutex
+	err error // first write error, seen by Write and Close
+}
+
+type deflatedChunk struct {
+	data []byte
+	err  error
+}
+
+func newParallelDeflater(w io.Writer, level, workers int) *parallelDeflater {
+	d := &parallelDeflater{
+		w:       w,
+		level:   level,
+		buf:     make([]byte, 0, deflateChunkSize),
+		pending: make(chan chan deflatedChunk, workers),
+		done:    make(chan error, 1),
+	}
+	go d.drain()
+	return d
+}
+
+// drain writes the compressed chunks in order as they become ready.
+func (d *parallelDeflater) drain() {
+	var err error
+	for ch := range d.pending {
+		c := <-ch
+		if err == nil {
+			err = c.err
+		}
+		if err == nil {
+			_, err = d.w.Write(c.data)
+		}
+		if err != nil {
+			d.mu.Lock()
+			d.err = err
+			d.mu.Unlock()
+		}
+	}
+	d.done <- err
+}
+
+func (d *parallelDeflater) failed() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+func (d *parallelDeflater) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if err := d.failed(); err != nil {
+			return n, err
+		}
+		k := copy(d.buf[len(d.buf):cap(d.buf)], p)
+		d.buf, p, n = d.buf[:len(d.buf)+k], p[k:], n+k
+		if len(d.buf) == cap(d.buf) {
+			d.dispatch(false)
+		}
+	}
+	return n, nil
+}
+
+// Close compresses what is buffered as the final chunk and waits until
+// every chunk has been written.
+func (d *parallelDeflater) Close() error {
+	d.dispatch(true)
+	close(d.pending)
+	return <-d.done
+}
+
+// dispatch hands the buffered chunk to a worker.
+func (d *parallelDeflater) dispatch(last bool) {
+	data, dict := d.buf, d.dict
+	ch := make(chan deflatedChunk, 1)
+	d.pending <- ch
+	go func() {
+		var c deflatedChunk
+		c.data, c.err = deflateChunk(data, dict, d.level, last)
+		ch <- c
+	}()
+	d.dict = data[max(0, len(data)-deflateDictSize):]
+	d.buf = make([]byte, 0, deflateChunkSize)
+}
+
+// deflateChunk compresses data primed with dict, ending the stream when
+// last is set and with a sync flush otherwise.
+func deflateChunk(data, dict []byte, level int, last bool) ([]byte, error) {
+	var out bytes.Buffer
+	fw, err := flate.NewWriterDict(&out, level, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if last {
+		err = fw.Close()
+	} else {
+		err = fw.Flush()
+	}
+	return out.Bytes(), err
+}
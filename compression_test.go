@@ -0,0 +1,59 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestParallelDeflater(t *testing.T) {
+	random := make([]byte, deflateChunkSize/2)
+	rand.Read(random)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), deflateChunkSize/16)
+	for _, size := range []int{0, 10, deflateChunkSize, 3*deflateChunkSize + 123} {
+		data := make([]byte, 0, size)
+		for len(data) < size {
+			data = append(data, text...)
+			data = append(data, random...)
+		}
+		data = data[:size]
+
+		var zipped bytes.Buffer
+		zw := newZipWriter(&zipped, flate.BestSpeed, 4)
+		fw, err := zw.Create("blob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// uneven writes cross chunk boundaries
+		for rest := data; len(rest) > 0; {
+			n := min(len(rest), 300<<10)
+			if _, err := fw.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, err := zr.File[0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc) // checks the CRC too
+		rc.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("size %d: read back %d bytes, %v", size, len(got), err)
+		}
+		if size > deflateChunkSize && zr.File[0].CompressedSize64 >= uint64(size) {
+			t.Errorf("size %d: compressed to %d bytes", size, zr.File[0].CompressedSize64)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"flag"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -48,3 +50,43 @@ func TestArchFromGo(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := LoadFile(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatalf("LoadFile(missing) error = %v", err)
+	}
+	if cfg.DefaultNamespace != "" || len(cfg.Aliases) != 0 {
+		t.Errorf("Expected empty config for missing file, got %+v", cfg)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	data := `{"defaultNamespace": "ourorg", "aliases": {"work-llm": "ourorg/llama3-ft:q4"},
+		"manifestTypes": {"manifests": ["application/vnd.oci.artifact.manifest.v1+json"]},
+		"network": {"proxy": "http://proxy.internal:3128", "dns": "10.0.0.53"},
+		"uploads": ["rclone:s3:models", "file:///mnt/share"]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if cfg.DefaultNamespace != "ourorg" {
+		t.Errorf("Expected namespace 'ourorg', got '%s'", cfg.DefaultNamespace)
+	}
+	if got := cfg.Aliases["work-llm"]; got != "ourorg/llama3-ft:q4" {
+		t.Errorf("Expected alias target 'ourorg/llama3-ft:q4', got '%s'", got)
+	}
+	if got := cfg.ManifestTypes.Manifests; len(got) != 1 || got[0] != "application/vnd.oci.artifact.manifest.v1+json" {
+		t.Errorf("Expected one extra manifest type, got %v", got)
+	}
+	if cfg.Network.Proxy != "http://proxy.internal:3128" || cfg.Network.DNS != "10.0.0.53" {
+		t.Errorf("Unexpected network section %+v", cfg.Network)
+	}
+	if len(cfg.Uploads) != 2 || cfg.Uploads[1] != "file:///mnt/share" {
+		t.Errorf("Unexpected uploads %v", cfg.Uploads)
+	}
+}
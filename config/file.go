@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// File is the optional JSON configuration file. Everything in it is a
+// default that command-line flags may still override.
+type File struct {
+	// DefaultNamespace is the owner used for model names without one
+	// ("llama3" -> "<namespace>/llama3"). Empty means "library".
+	DefaultNamespace string `json:"defaultNamespace"`
+	// Aliases maps short names to full model references, e.g.
+	// "work-llm": "ourorg/llama3-ft:q4".
+	Aliases map[string]string `json:"aliases"`
+	// Registries are per-registry profiles, tried in order.
+	Registries []Registry `json:"registries"`
+	// ManifestTypes adds media types to those requested from registries and
+	// accepted as manifests, e.g. for new OCI artifact types.
+	ManifestTypes ManifestTypes `json:"manifestTypes"`
+	// Notifiers are told when downloads complete or fail and when a mirror
+	// run ends.
+	Notifiers []Notifier `json:"notifiers"`
+	// Network changes how registries are reached.
+	Network Network `json:"network"`
+	// Uploads are the -upload targets used when no -upload flag is given.
+	Uploads []string `json:"uploads"`
+}
+
+// Network replaces parts of the HTTP client's connection setup.
+type Network struct {
+	// Proxy is an http://, https:// or socks5:// URL used for every
+	// request instead of HTTPS_PROXY and the other proxy variables.
+	Proxy string `json:"proxy"`
+	// DNS is the host:port (port 53 if left out) of a DNS server asked
+	// instead of the system resolver.
+	DNS string `json:"dns"`
+	// MaxConnsPerHost caps the connections open to one host, idle or not.
+	// 0 means no cap.
+	MaxConnsPerHost int `json:"maxConnsPerHost"`
+	// MaxIdleConnsPerHost is how many idle connections to one host are
+	// kept for reuse. 0 keeps Go's default of 2.
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost"`
+	// ResponseHeaderTimeout and TLSHandshakeTimeout are durations such as
+	// "2m" or "500ms"; empty keeps the defaults (60s and 30s).
+	ResponseHeaderTimeout string `json:"responseHeaderTimeout"`
+	TLSHandshakeTimeout   string `json:"tlsHandshakeTimeout"`
+}
+
+// Notifier is one destination for notifications.
+type Notifier struct {
+	// Type is "webhook" (the event as JSON), "telegram", "discord" or
+	// "smtp".
+	Type string `json:"type"`
+	// URL is the endpoint of a webhook or Discord webhook.
+	URL string `json:"url"`
+	// BotToken and ChatID address a Telegram chat. BotTokenEnv names an
+	// environment variable holding the token instead.
+	BotToken    string `json:"botToken"`
+	BotTokenEnv string `json:"botTokenEnv"`
+	ChatID      string `json:"chatId"`
+	// SMTP sends mail through Addr (host:port, STARTTLS when offered) from
+	// From to To, logging in with Username and Password (or PasswordEnv)
+	// when set. Subject and Body are text/template templates.
+	Addr        string   `json:"addr"`
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+	Username    string   `json:"username"`
+	Password    string   `json:"password"`
+	PasswordEnv string   `json:"passwordEnv"`
+	Subject     string   `json:"subject"`
+	Body        string   `json:"body"`
+	// Events limits the notifier to some of "completed", "failed" and
+	// "mirror"; empty means all of them.
+	Events []string `json:"events"`
+}
+
+// Secret returns the SMTP password, preferring PasswordEnv.
+func (n Notifier) Secret() string {
+	if n.PasswordEnv != "" {
+		if v := os.Getenv(n.PasswordEnv); v != "" {
+			return v
+		}
+	}
+	return n.Password
+}
+
+// Token returns the Telegram bot token, preferring BotTokenEnv.
+func (n Notifier) Token() string {
+	if n.BotTokenEnv != "" {
+		if v := os.Getenv(n.BotTokenEnv); v != "" {
+			return v
+		}
+	}
+	return n.BotToken
+}
+
+// ManifestTypes lists extra manifest media types by how they are read:
+// Manifests list blobs directly, Indexes list per-platform manifests.
+type ManifestTypes struct {
+	Manifests []string `json:"manifests"`
+	Indexes   []string `json:"indexes"`
+}
+
+// Registry is a profile for one registry. A model uses the first profile
+// with a prefix of its name, or whose URL host the name spells out
+// ("internal.example.com/team/model").
+type Registry struct {
+	URL      string   `json:"url"`
+	Prefixes []string `json:"prefixes"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	// PasswordEnv names an environment variable holding the password, so
+	// secrets need not live in the file.
+	PasswordEnv       string  `json:"passwordEnv"`
+	Insecure          bool    `json:"insecure"`
+	CAFile            string  `json:"caFile"`
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+}
+
+// Secret returns the profile password, preferring PasswordEnv.
+func (r Registry) Secret() string {
+	if r.PasswordEnv != "" {
+		if v := os.Getenv(r.PasswordEnv); v != "" {
+			return v
+		}
+	}
+	return r.Password
+}
+
+// DefaultFilePath is <user config dir>/ollama-model-downloader/config.json.
+func DefaultFilePath() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "ollama-model-downloader", "config.json")
+}
+
+// LoadFile reads the configuration at path. A missing or empty file is not
+// an error and yields an empty configuration.
+func LoadFile(path string) (File, error) {
+	var f File
+	if path == "" {
+		return f, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return f, nil
+		}
+		return f, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return f, nil
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
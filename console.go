@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consoleLine is one entry of a session console: a -v log line, or a blob
+// starting, finishing or failing.
+type consoleLine struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // "log" or "blob"
+	Message string    `json:"message,omitempty"`
+	Digest  string    `json:"digest,omitempty"`
+	State   string    `json:"state,omitempty"` // blob: "started", "done" or "failed"
+	Size    int64     `json:"size,omitempty"`
+}
+
+const (
+	consoleBacklog = 500 // lines a browser gets when it connects
+	consoleBuffer  = 256 // lines queued per watcher before it misses some
+)
+
+// console collects what a web session would print with -v and hands it to
+// the browsers watching (GET /console). Watchers that fall behind lose
+// lines rather than slowing the download down.
+type console struct {
+	mu       sync.Mutex
+	lines    []consoleLine
+	watchers map[chan consoleLine]struct{}
+	closed   bool
+}
+
+func newConsole() *console {
+	return &console{watchers: make(map[chan consoleLine]struct{})}
+}
+
+func (c *console) add(l consoleLine) {
+	if c == nil {
+		return
+	}
+	if l.Time.IsZero() {
+		l.Time = time.Now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if len(c.lines) == consoleBacklog {
+		c.lines = append(c.lines[:0], c.lines[1:]...)
+	}
+	c.lines = append(c.lines, l)
+	for ch := range c.watchers {
+		select {
+		case ch <- l:
+		default:
+		}
+	}
+}
+
+// watch returns the lines so far and a channel of the ones that follow,
+// closed when the session ends. stop must be called when done watching.
+func (c *console) watch() (backlog []consoleLine, lines <-chan consoleLine, stop func()) {
+	ch := make(chan consoleLine, consoleBuffer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	backlog = append(backlog, c.lines...)
+	if c.closed {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	c.watchers[ch] = struct{}{}
+	return backlog, ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.watchers[ch]; ok {
+			delete(c.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every watch; lines added later are dropped.
+func (c *console) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for ch := range c.watchers {
+		delete(c.watchers, ch)
+		close(ch)
+	}
+}
+
+type consoleKey struct{}
+
+func withConsole(ctx context.Context, c *console) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, consoleKey{}, c)
+}
+
+func consoleOf(ctx context.Context) *console {
+	c, _ := ctx.Value(consoleKey{}).(*console)
+	return c
+}
+
+// logf prints a line to stdout when print is set (normally -v) and adds
+// it to the console of the web session ctx belongs to, if any.
+func logf(ctx context.Context, print bool, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if print {
+		fmt.Print(msg)
+	}
+	consoleOf(ctx).add(consoleLine{Type: "log", Message: strings.TrimSuffix(msg, "\n")})
+}
+
+// blobEvent adds a blob state change to the console of ctx's session.
+func blobEvent(ctx context.Context, digest, state string, size int64) {
+	consoleOf(ctx).add(consoleLine{Type: "blob", Digest: digest, State: state, Size: size})
+}
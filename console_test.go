@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConsoleWebSocket(t *testing.T) {
+	c := newConsole()
+	logf(withConsole(context.Background(), c), false, "Digest: %s\n", "sha256:abc")
+	s := &server{
+		sessions: map[string]*activeSession{"s1": {id: "s1", console: c}},
+		log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.handleConsole))
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL + "?session=s1"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("plain GET = %v, %v; want 400", resp, err)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /?session=s1 HTTP/1.1\r\nHost: "+strings.TrimPrefix(srv.URL, "http://")+
+		"\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake = %s %v", resp.Status, resp.Header)
+	}
+
+	readLine := func() (op byte, l consoleLine) {
+		t.Helper()
+		var hdr [2]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, hdr[1]&0x7f)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatal(err)
+		}
+		if hdr[0]&0x0f == wsText {
+			if err := json.Unmarshal(payload, &l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return hdr[0] & 0x0f, l
+	}
+	if _, l := readLine(); l.Type != "log" || l.Message != "Digest: sha256:abc" {
+		t.Errorf("backlog line = %+v", l)
+	}
+	blobEvent(withConsole(context.Background(), c), "sha256:abc", "done", 42)
+	if _, l := readLine(); l.Type != "blob" || l.State != "done" || l.Size != 42 {
+		t.Errorf("live line = %+v", l)
+	}
+	c.close()
+	if op, _ := readLine(); op != wsClose {
+		t.Errorf("after the session ends got opcode %d, want close", op)
+	}
+}
+
+func TestConsoleRejectsOtherOrigins(t *testing.T) {
+	s := &server{sessions: map[string]*activeSession{"s1": {id: "s1", console: newConsole()}}}
+	req := httptest.NewRequest(http.MethodGet, "/console?session=s1", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "http://evil.example")
+	rec := httptest.NewRecorder()
+	s.handleConsole(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("cross-origin handshake = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// envPrefix lets every flag be set from the environment as OMD_<NAME>, with
+// dashes turned into underscores (OMD_OUTPUT_DIR, OMD_NO_BROWSER=true).
+// Flags given on the command line still win.
+const envPrefix = "OMD_"
+
+// applyEnvFlags sets flags from OMD_* variables; call it before Parse.
+func applyEnvFlags(flags *flag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if serr := flags.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("%s: %w", name, serr)
+		}
+	})
+	return err
+}
+
+// inContainer reports whether the process looks like it runs in a Docker,
+// Podman or Kubernetes container.
+func inContainer() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" || os.Getenv("container") != "" {
+		return true
+	}
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newLogger returns the web UI logger: JSON on stdout in container mode,
+// plain text otherwise.
+func newLogger(container bool) *slog.Logger {
+	if container {
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
+}
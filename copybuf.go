@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Blob bodies are hashed while they are written, so the kernel's
+// socket-to-file paths (splice, sendfile) cannot be used for them: the
+// bytes have to pass through user space anyway. Large pooled buffers keep
+// the number of reads and writes per blob down instead; at 1 Gbps and
+// above io.Copy's 32 KiB default makes the copy loop the bottleneck.
+const (
+	defaultCopyBufferSize = 256 << 10
+	minCopyBufferSize     = 4 << 10
+	maxCopyBufferSize     = 64 << 20
+)
+
+var (
+	copyBufferSize = defaultCopyBufferSize
+	copyBuffers    = sync.Pool{New: func() interface{} {
+		b := make([]byte, copyBufferSize)
+		return &b
+	}}
+)
+
+// setCopyBufferSize sets the -buffer-size. It must be called before the
+// first download.
+func setCopyBufferSize(n int64) error {
+	if n < minCopyBufferSize || n > maxCopyBufferSize {
+		return fmt.Errorf("-buffer-size must be between %s and %s", humanBytes(minCopyBufferSize), humanBytes(maxCopyBufferSize))
+	}
+	copyBufferSize = int(n)
+	return nil
+}
+
+// copyBody is io.Copy through a pooled buffer of copyBufferSize bytes.
+func copyBody(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
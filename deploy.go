@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "deploy",
+		usage: "copy zip archives to another machine over ssh and extract them into its Ollama models directory",
+		run:   runDeploy,
+	})
+}
+
+// remoteModelsDir is the models directory deploy extracts into unless -dir
+// is given, expanded by the remote shell.
+const remoteModelsDir = `"${OLLAMA_MODELS:-$HOME/.ollama/models}"`
+
+func runDeploy(opt options, args []string) error {
+	flags := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	target := flags.String("ssh", "", "user@host (or a Host from ~/.ssh/config) to deploy to")
+	dir := flags.String("dir", "", "remote models directory (default $OLLAMA_MODELS or ~/.ollama/models of the ssh user)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *target == "" || flags.NArg() == 0 {
+		return errors.New("usage: deploy -ssh user@host [-dir path] <archive.zip>...")
+	}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return fmt.Errorf("ssh not found in PATH")
+	}
+	r := sshRemote{command: func(ctx context.Context, script string) *exec.Cmd {
+		return exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", *target, script)
+	}}
+	remoteDir := remoteModelsDir
+	if *dir != "" {
+		remoteDir = shellQuote(*dir)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	for _, archive := range flags.Args() {
+		if err := deployArchive(ctx, r, archive, remoteDir, opt.verbose); err != nil {
+			if ctx.Err() != nil {
+				fmt.Fprintf(os.Stderr, "\ninterrupted; run the same command again to continue the upload\n")
+				os.Exit(130)
+			}
+			return fmt.Errorf("%s: %w", filepath.Base(archive), err)
+		}
+		fmt.Printf("deployed %s to %s\n", filepath.Base(archive), *target)
+	}
+	return nil
+}
+
+// sshRemote runs shell scripts on the deploy target.
+type sshRemote struct {
+	command func(ctx context.Context, script string) *exec.Cmd // ssh, replaced in tests
+}
+
+// run runs script remotely with stdin as its input and returns its output.
+func (r sshRemote) run(ctx context.Context, script string, stdin io.Reader) (string, error) {
+	cmd := r.command(ctx, script)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// deployArchive uploads archive to a hidden .deploy directory below the
+// remote models directory dir (a shell word), appending to what an earlier
+// interrupted run left there, checks the upload's sha256 and extracts it.
+// The upload is named after the archive's sha256, so a changed archive
+// never resumes an upload of another one. The remote side needs only a
+// POSIX shell, sha256sum (or shasum) and unzip.
+func deployArchive(ctx context.Context, r sshRemote, archive, dir string, verbose bool) error {
+	if f, err := importFormat(archive); err != nil {
+		return err
+	} else if f != importZip {
+		return fmt.Errorf("deploy takes zip archives, not a %s", f)
+	}
+	size, sum, err := hashFile(archive, nil)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksumSidecar(archive); err != nil {
+		return err
+	}
+	vars := fmt.Sprintf("d=%s; f=\"$d/.deploy/%s.zip\"; ", dir, sum)
+
+	out, err := r.run(ctx, vars+`mkdir -p "$d/.deploy" && if [ -f "$f" ]; then wc -c < "$f"; else echo 0; fi`, nil)
+	if err != nil {
+		return fmt.Errorf("ssh: %w", err)
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return fmt.Errorf("ssh: unexpected size %q of the remote upload", out)
+	}
+	if offset > size {
+		offset = 0 // not ours after all; start over
+	}
+	if offset < size {
+		if offset > 0 {
+			logf(ctx, verbose, "resuming upload at %s of %s\n", humanBytes(offset), humanBytes(size))
+		}
+		if err := uploadFrom(ctx, r, archive, vars, offset, size); err != nil {
+			return err
+		}
+	}
+
+	out, err = r.run(ctx, vars+`{ sha256sum "$f" 2>/dev/null || shasum -a 256 "$f"; } | cut -d' ' -f1`, nil)
+	if err != nil {
+		return fmt.Errorf("ssh: sha256: %w", err)
+	}
+	if out != sum {
+		r.run(ctx, vars+`rm -f "$f"`, nil)
+		return errors.New("the uploaded copy does not match the archive; it was removed, run deploy again")
+	}
+	if _, err := r.run(ctx, vars+`cd "$d" && unzip -oq "$f" && rm -f "$f" && { rmdir .deploy 2>/dev/null || true; }`, nil); err != nil {
+		return fmt.Errorf("ssh: unzip: %w", err)
+	}
+	return nil
+}
+
+// uploadFrom sends the bytes of archive from offset on, appending them to
+// the remote upload; offset 0 replaces it.
+func uploadFrom(ctx context.Context, r sshRemote, archive, vars string, offset, size int64) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	redirect := ">>"
+	if offset == 0 {
+		redirect = ">"
+	}
+	p := newProgress(size)
+	p.label = "Uploading"
+	p.SetDone(offset)
+	p.Start(ctx)
+	_, err = r.run(ctx, vars+`cat `+redirect+` "$f"`, io.TeeReader(f, p))
+	p.Stop()
+	p.finishLine()
+	if err != nil {
+		return fmt.Errorf("ssh: upload: %w", err)
+	}
+	return nil
+}
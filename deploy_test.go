@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeployArchive(t *testing.T) {
+	if _, err := exec.LookPath("unzip"); err != nil {
+		t.Skip("unzip not installed")
+	}
+	dir := t.TempDir()
+	root := filepath.Join(dir, "models")
+	manifest := filepath.Join(root, "manifests", "registry.ollama.ai", "library", "m", "latest")
+	os.MkdirAll(filepath.Dir(manifest), 0o755)
+	os.WriteFile(manifest, []byte(`{"layers":[]}`), 0o644)
+	archive := filepath.Join(dir, "m.zip")
+	sum, err := zipDir(root, archive, zipOptions{compression: "none", workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(archive)
+
+	// The "remote" is a local shell; an earlier run left half the upload.
+	remote := filepath.Join(dir, "remote")
+	os.MkdirAll(filepath.Join(remote, ".deploy"), 0o755)
+	os.WriteFile(filepath.Join(remote, ".deploy", sum+".zip"), data[:len(data)/2], 0o644)
+	r := sshRemote{command: func(ctx context.Context, script string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", script)
+	}}
+	if err := deployArchive(context.Background(), r, archive, shellQuote(remote), false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(remote, "manifests", "registry.ollama.ai", "library", "m", "latest"))
+	if err != nil || string(got) != `{"layers":[]}` {
+		t.Fatalf("remote manifest = %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(remote, ".deploy")); !os.IsNotExist(err) {
+		t.Error("upload directory left behind")
+	}
+
+	// A stale upload that does not match is thrown away, not extracted.
+	os.MkdirAll(filepath.Join(remote, ".deploy"), 0o755)
+	os.WriteFile(filepath.Join(remote, ".deploy", sum+".zip"), append([]byte("XX"), data[2:]...), 0o644)
+	if err := deployArchive(context.Background(), r, archive, shellQuote(remote), false); err == nil {
+		t.Fatal("deploy accepted a corrupt upload")
+	}
+	if _, err := os.Stat(filepath.Join(remote, ".deploy", sum+".zip")); !os.IsNotExist(err) {
+		t.Error("corrupt upload kept")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// resolveMap holds curl-style host overrides ("host:port:address") used to
+// bypass DNS for specific registry endpoints.
+type resolveMap map[string]string
+
+func (m resolveMap) String() string {
+	var parts []string
+	for k, v := range m {
+		parts = append(parts, k+":"+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set implements flag.Value; it may be called several times.
+func (m resolveMap) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, port, addr, err := parseResolveEntry(entry)
+		if err != nil {
+			return err
+		}
+		m[net.JoinHostPort(host, port)] = net.JoinHostPort(addr, port)
+	}
+	return nil
+}
+
+func parseResolveEntry(entry string) (host, port, addr string, err error) {
+	// host:port:addr where addr may be a bracketed IPv6 literal
+	first := strings.Index(entry, ":")
+	if first <= 0 {
+		return "", "", "", fmt.Errorf("invalid resolve entry %q (want host:port:address)", entry)
+	}
+	rest := entry[first+1:]
+	second := strings.Index(rest, ":")
+	if second <= 0 {
+		return "", "", "", fmt.Errorf("invalid resolve entry %q (want host:port:address)", entry)
+	}
+	host = strings.ToLower(entry[:first])
+	port = rest[:second]
+	addr = strings.Trim(rest[second+1:], "[]")
+	if net.ParseIP(addr) == nil {
+		return "", "", "", fmt.Errorf("invalid address in resolve entry %q", entry)
+	}
+	return host, port, addr, nil
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// withResolveOverrides rewrites dial targets found in overrides before
+// handing them to next. TLS still uses the original host name for SNI and
+// certificate verification because only the TCP target changes.
+func withResolveOverrides(next dialFunc, overrides resolveMap) dialFunc {
+	if len(overrides) == 0 {
+		return next
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if target, ok := overrides[strings.ToLower(addr)]; ok {
+			addr = target
+		}
+		return next(ctx, network, addr)
+	}
+}
+
+// withAddressFamily pins dials to tcp4 or tcp6 when requested, so a broken
+// IPv6 (or IPv4) path is never attempted at all.
+func withAddressFamily(next dialFunc, network string) dialFunc {
+	if network == "" {
+		return next
+	}
+	return func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+// addressFamily maps the -ipv4/-ipv6 flags to a dial network.
+func addressFamily(ipv4, ipv6 bool) (string, error) {
+	switch {
+	case ipv4 && ipv6:
+		return "", fmt.Errorf("-ipv4 and -ipv6 are mutually exclusive")
+	case ipv4:
+		return "tcp4", nil
+	case ipv6:
+		return "tcp6", nil
+	default:
+		return "", nil
+	}
+}
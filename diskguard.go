@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Free space is checked again after diskCheckBytes have been written, and
+// every diskPollInterval while it is low.
+const (
+	diskCheckBytes   = 64 << 20
+	diskPollInterval = 2 * time.Second
+)
+
+// diskGuard holds the blob writes of a run while the volume holding dir has
+// less than min bytes free (-min-free), so a full disk pauses the download
+// instead of failing it mid-blob with ENOSPC. onLow and onOK are called
+// when it starts and stops holding writes.
+type diskGuard struct {
+	dir   string
+	min   int64
+	onLow func(free int64)
+	onOK  func()
+	free  func(path string) (uint64, error) // diskFree, replaced in tests
+	poll  time.Duration
+
+	written atomic.Int64 // bytes since the last check
+	mu      sync.Mutex   // one writer checks, the others wait for it
+	low     bool
+}
+
+func newDiskGuard(dir string, min int64, onLow func(free int64), onOK func()) *diskGuard {
+	g := &diskGuard{dir: dir, min: min, onLow: onLow, onOK: onOK, free: diskFree, poll: diskPollInterval}
+	g.written.Store(diskCheckBytes) // check before the first write
+	return g
+}
+
+// reserve is called before n bytes are written. It returns at once unless
+// a check is due, and otherwise blocks until there is room or ctx ends.
+// Volumes whose free space cannot be read are never held.
+func (g *diskGuard) reserve(ctx context.Context, n int) error {
+	if g.written.Add(int64(n)) < diskCheckBytes {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.written.Load() < diskCheckBytes {
+		return nil // checked by another writer meanwhile
+	}
+	for {
+		free, err := g.free(g.dir)
+		if err != nil || int64(free) >= g.min {
+			g.written.Store(int64(n))
+			if g.low {
+				g.low = false
+				g.onOK()
+			}
+			return nil
+		}
+		if !g.low {
+			g.low = true
+			g.onLow(int64(free))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(g.poll):
+		}
+	}
+}
+
+type diskGuardKey struct{}
+
+// withDiskGuard makes the blob writes of downloads run with the returned
+// context wait for g.
+func withDiskGuard(ctx context.Context, g *diskGuard) context.Context {
+	if g == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, diskGuardKey{}, g)
+}
+
+// guardedWriter is w behind the diskGuard of ctx, if any.
+func guardedWriter(ctx context.Context, w io.Writer) io.Writer {
+	g, ok := ctx.Value(diskGuardKey{}).(*diskGuard)
+	if !ok {
+		return w
+	}
+	return writerFunc(func(b []byte) (int, error) {
+		if err := g.reserve(ctx, len(b)); err != nil {
+			return 0, err
+		}
+		return w.Write(b)
+	})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskGuard(t *testing.T) {
+	var free atomic.Int64
+	free.Store(10 << 20)
+	var lows, oks atomic.Int32
+	g := newDiskGuard(t.TempDir(), 100<<20, func(int64) { lows.Add(1) }, func() { oks.Add(1) })
+	g.free = func(string) (uint64, error) { return uint64(free.Load()), nil }
+	g.poll = 5 * time.Millisecond
+
+	var buf bytes.Buffer
+	w := guardedWriter(withDiskGuard(context.Background(), g), &buf)
+	wrote := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("blob"))
+		wrote <- err
+	}()
+	select {
+	case err := <-wrote:
+		t.Fatalf("write went through with the disk below -min-free: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if lows.Load() != 1 {
+		t.Fatalf("onLow called %d times, want 1", lows.Load())
+	}
+
+	free.Store(200 << 20)
+	select {
+	case err := <-wrote:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write still held after space was freed")
+	}
+	if oks.Load() != 1 || buf.String() != "blob" {
+		t.Errorf("onOK called %d times, wrote %q", oks.Load(), buf.String())
+	}
+
+	// Writes between checks do not touch the disk; a held write gives up
+	// when its download is stopped.
+	free.Store(0)
+	if _, err := w.Write(make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := guardedWriter(withDiskGuard(ctx, g), &buf).Write(make([]byte, diskCheckBytes)); err != context.Canceled {
+		t.Errorf("held write after cancel = %v, want context.Canceled", err)
+	}
+}
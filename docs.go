@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ollama-model-downloader/models"
+)
+
+// ollamaLibraryBase is where model pages for registry.ollama.ai live.
+const ollamaLibraryBase = "https://ollama.com"
+
+// modelDocsDir is where per-model documents (license, model page,
+// verification report) go inside the archive.
+func modelDocsDir(modelsRoot string, ref modelRef, manifestTail string) string {
+	return filepath.Join(modelsRoot, "docs", ref.Host, ref.Repository, manifestTail)
+}
+
+// writeModelDocs places LICENSE (from the manifest's license layers) and,
+// for the public Ollama registry, README.html (the model page) under
+// modelsRoot/docs/<host>/<repo>/<reference> so they travel inside the
+// archive without colliding with other models. Fetching the model page is
+// best effort; a missing page only produces a warning.
+func writeModelDocs(ctx context.Context, client *http.Client, opt options, ref modelRef, manifest imageManifest, modelsRoot, blobsDir, manifestTail string) error {
+	docsDir := modelDocsDir(modelsRoot, ref, manifestTail)
+	if err := os.MkdirAll(docsDir, 0o755); err != nil {
+		return err
+	}
+
+	var license bytes.Buffer
+	for _, l := range manifest.Layers {
+		if l.MediaType != mtOllamaLicense {
+			continue
+		}
+		data, err := models.DefaultBlobStore.Get(blobsDir, l.Digest)
+		if err != nil {
+			return fmt.Errorf("read license layer: %w", err)
+		}
+		if license.Len() > 0 {
+			license.WriteString("\n\n----\n\n")
+		}
+		license.Write(data)
+	}
+	if license.Len() > 0 {
+		if err := os.WriteFile(filepath.Join(docsDir, "LICENSE"), license.Bytes(), 0o644); err != nil {
+			return err
+		}
+	} else if opt.verbose {
+		fmt.Println("no license layer in manifest")
+	}
+
+	if ref.Host != "registry.ollama.ai" {
+		return nil
+	}
+	page := ollamaLibraryBase + "/" + ref.Repository
+	if ref.ReferenceTag != "" && ref.ReferenceTag != "latest" {
+		page += ":" + ref.ReferenceTag
+	}
+	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, page, map[string]string{"User-Agent": "ollama-model-downloader/1.0"}, opt.retries, opt.verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: model page %s: %v\n", page, err)
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		fmt.Fprintf(os.Stderr, "warning: model page %s: %s\n", page, resp.Status)
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: model page %s: %v\n", page, err)
+		return nil
+	}
+	return os.WriteFile(filepath.Join(docsDir, "README.html"), body, 0o644)
+}
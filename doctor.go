@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "doctor",
+		usage: "check connectivity, disk space and the Ollama install; `doctor registry` benchmarks registries",
+		run:   runDoctor,
+	})
+}
+
+func runDoctor(opt options, args []string) error {
+	if len(args) > 0 && args[0] == "registry" {
+		return runDoctorRegistry(opt, args[1:])
+	}
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	minFree := flags.Int64("min-free", 20<<30, "warn when less than this many bytes are free in the output directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var checks []doctorCheck
+	checks = append(checks, checkDirectory("output dir", opt.outputDir, *minFree, "pass -output-dir with a writable directory on a larger disk"))
+	if opt.manifestCache != nil {
+		checks = append(checks, checkDirectory("manifest cache", opt.manifestCache.dir, 0, "pass -manifest-cache-dir with a writable directory, or an empty value to disable it"))
+	}
+	for _, t := range doctorTargets(opt) {
+		checks = append(checks, checkConnectivity(opt, t))
+	}
+	checks = append(checks, checkOllama()...)
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range checks {
+		fmt.Fprintf(w, "[%s]\t%s\t%s\n", c.status, c.name, c.detail)
+		if c.hint != "" && c.status != checkOK {
+			fmt.Fprintf(w, "\t\t-> %s\n", c.hint)
+		}
+		if c.status == checkFail {
+			failed++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "FAIL"
+)
+
+// doctorCheck is one line of the `doctor` report; hint says what to do
+// when status is not ok.
+type doctorCheck struct {
+	name   string
+	status string
+	detail string
+	hint   string
+}
+
+// checkDirectory verifies dir exists (or can be created), is writable, and
+// has at least minFree bytes available (minFree 0 skips the space check).
+func checkDirectory(name, dir string, minFree int64, hint string) doctorCheck {
+	c := doctorCheck{name: name, status: checkOK, hint: hint}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		c.status, c.detail = checkFail, err.Error()
+		return c
+	}
+	f, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		c.status, c.detail = checkFail, fmt.Sprintf("%s is not writable: %v", dir, err)
+		return c
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	free, err := diskFree(dir)
+	if err != nil {
+		c.status, c.detail = checkWarn, fmt.Sprintf("%s is writable; free space unknown: %v", dir, err)
+		return c
+	}
+	c.detail = fmt.Sprintf("%s is writable, %s free", dir, humanBytes(int64(free)))
+	if minFree > 0 && free < uint64(minFree) {
+		c.status = checkWarn
+		c.detail += fmt.Sprintf(" (less than %s)", humanBytes(minFree))
+	}
+	return c
+}
+
+func checkConnectivity(opt options, t modelRef) doctorCheck {
+	c := doctorCheck{name: "registry", status: checkOK, hint: "check DNS, proxy (HTTPS_PROXY) and firewall settings; -trace-http shows each step"}
+	popt, err := withProfile(opt, t)
+	if err != nil {
+		c.status, c.detail = checkFail, err.Error()
+		return c
+	}
+	p := probeRegistry(context.Background(), popt, "", 0)
+	if p.Err != nil {
+		c.status, c.detail = checkFail, fmt.Sprintf("%s: %v", t.Registry, p.Err)
+		return c
+	}
+	c.detail = fmt.Sprintf("%s reachable, first byte after %s", t.Registry, fmtLatency(p.FirstByte))
+	return c
+}
+
+// checkOllama reports the local Ollama CLI and server, and where imported
+// models will be written.
+func checkOllama() []doctorCheck {
+	var out []doctorCheck
+
+	cli := doctorCheck{name: "ollama cli", status: checkOK, hint: "install Ollama from https://ollama.com/download to use imported models"}
+	if path, err := exec.LookPath("ollama"); err != nil {
+		cli.status, cli.detail = checkWarn, "ollama not found in PATH"
+	} else if v, err := exec.Command(path, "--version").CombinedOutput(); err != nil {
+		cli.status, cli.detail = checkWarn, fmt.Sprintf("%s --version failed: %v", path, err)
+	} else {
+		cli.detail = fmt.Sprintf("%s (%s)", path, strings.TrimSpace(string(v)))
+	}
+	out = append(out, cli)
+
+	srv := doctorCheck{name: "ollama server", status: checkOK, hint: "start it with `ollama serve`; it must be restarted after importing models anyway"}
+	if version, err := ollamaServerVersion(); err != nil {
+		srv.status, srv.detail = checkWarn, err.Error()
+	} else {
+		srv.detail = "running, version " + version
+	}
+	out = append(out, srv)
+
+	dir := doctorCheck{name: "models dir", status: checkOK, hint: "set OLLAMA_MODELS_DIR to the directory Ollama reads models from"}
+	if d, source, err := ollamaModelsDirSource(); err != nil {
+		dir.status, dir.detail = checkFail, err.Error()
+	} else if st, err := os.Stat(d); err != nil {
+		dir.status, dir.detail = checkWarn, fmt.Sprintf("%s (from %s) does not exist yet", d, source)
+	} else if !st.IsDir() {
+		dir.status, dir.detail = checkFail, fmt.Sprintf("%s is not a directory", d)
+	} else {
+		dir = checkDirectory("models dir", d, 0, dir.hint)
+		dir.detail += " (from " + source + ")"
+	}
+	out = append(out, dir)
+	return out
+}
+
+// ollamaServerVersion asks the local Ollama API (OLLAMA_HOST or the default
+// 127.0.0.1:11434) for its version.
+func ollamaServerVersion() (string, error) {
+	host := os.Getenv("OLLAMA_HOST")
+	if host == "" {
+		host = "127.0.0.1:11434"
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(strings.TrimRight(host, "/") + "/api/version")
+	if err != nil {
+		return "", fmt.Errorf("not reachable at %s", host)
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Version string `json:"version"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s/api/version: %s", host, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return "", err
+	}
+	return v.Version, nil
+}
+
+// doctorTargets lists -registry followed by every distinct profile URL.
+func doctorTargets(opt options) []modelRef {
+	targets := []modelRef{{Registry: opt.registry}}
+	seen := map[string]bool{strings.TrimRight(opt.registry, "/"): true}
+	for i := range opt.modelConfig.registries {
+		p := &opt.modelConfig.registries[i]
+		u := strings.TrimRight(p.URL, "/")
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		targets = append(targets, modelRef{Registry: p.URL, Profile: p})
+	}
+	return targets
+}
+
+// registryProbe is the measurement for one registry.
+type registryProbe struct {
+	URL        string
+	DNS        time.Duration
+	Connect    time.Duration
+	TLS        time.Duration
+	FirstByte  time.Duration
+	Throughput float64 // bytes per second over the sample; 0 if not measured
+	Err        error   // registry unreachable or unusable
+	SampleErr  error   // reachable, but the throughput sample failed
+}
+
+func runDoctorRegistry(opt options, args []string) error {
+	flags := flag.NewFlagSet("doctor registry", flag.ContinueOnError)
+	model := flags.String("model", "all-minilm", "model whose largest layer is used for the throughput sample")
+	sample := flags.Int64("sample", 8<<20, "bytes to download for the throughput sample (0 skips it)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	targets := doctorTargets(opt)
+
+	// Measure the network, not the cache.
+	opt.manifestCache = nil
+	ctx := context.Background()
+	var probes []registryProbe
+	for _, t := range targets {
+		popt, err := withProfile(opt, t)
+		if err != nil {
+			probes = append(probes, registryProbe{URL: t.Registry, Err: err})
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "probing %s...\n", t.Registry)
+		probes = append(probes, probeRegistry(ctx, popt, *model, *sample))
+	}
+
+	// Fastest sample first; registries without a sample rank by latency,
+	// failures last.
+	sort.SliceStable(probes, func(i, j int) bool {
+		a, b := probes[i], probes[j]
+		if (a.Err == nil) != (b.Err == nil) {
+			return a.Err == nil
+		}
+		if a.Throughput != b.Throughput {
+			return a.Throughput > b.Throughput
+		}
+		return a.FirstByte < b.FirstByte
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tREGISTRY\tDNS\tCONNECT\tTLS\tFIRST BYTE\tTHROUGHPUT\tNOTE")
+	for i, p := range probes {
+		speed, note := "-", ""
+		if p.Throughput > 0 {
+			speed = humanBytes(int64(p.Throughput)) + "/s"
+		}
+		if p.Err != nil {
+			note = p.Err.Error()
+		} else if p.SampleErr != nil {
+			note = p.SampleErr.Error()
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", i+1, p.URL,
+			fmtLatency(p.DNS), fmtLatency(p.Connect), fmtLatency(p.TLS), fmtLatency(p.FirstByte), speed, note)
+	}
+	return w.Flush()
+}
+
+// probeRegistry times a fresh connection to /v2/ and, when sample > 0,
+// downloads the first sample bytes of model's largest layer. A failed
+// sample is reported in SampleErr and keeps the latency figures.
+func probeRegistry(ctx context.Context, opt options, model string, sample int64) registryProbe {
+	res := registryProbe{URL: opt.registry}
+	client := newHTTPClient(opt)
+
+	var start, dnsStart, connStart, tlsStart time.Time
+	ct := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { res.DNS = time.Since(dnsStart) },
+		ConnectStart:         func(string, string) { connStart = time.Now() },
+		ConnectDone:          func(string, string, error) { res.Connect = time.Since(connStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { res.TLS = time.Since(tlsStart) },
+		GotFirstResponseByte: func() { res.FirstByte = time.Since(start) },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, ct), http.MethodGet, strings.TrimRight(opt.registry, "/")+"/v2/", nil)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	req.Header.Set("User-Agent", "ollama-model-downloader/1.0")
+	start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	// 401 is the normal answer from registries that require a token.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		res.Err = fmt.Errorf("/v2/: %s", resp.Status)
+		return res
+	}
+
+	if sample > 0 {
+		res.Throughput, res.SampleErr = sampleThroughput(ctx, client, opt, model, sample)
+	}
+	return res
+}
+
+// sampleThroughput downloads up to sample bytes of model's largest layer
+// with a Range request and returns the observed bytes per second.
+func sampleThroughput(ctx context.Context, client *http.Client, opt options, model string, sample int64) (float64, error) {
+	ref, err := parseModel(opt.registry, model, modelConfig{namespace: opt.modelConfig.namespace})
+	if err != nil {
+		return 0, err
+	}
+	token, err := getRegistryToken(ctx, client, opt, ref.Repository, ref.Reference)
+	if err != nil {
+		return 0, fmt.Errorf("sample: %w", err)
+	}
+	body, _, err := getManifestOrIndex(ctx, client, opt, ref.Repository, ref.Reference, token)
+	if err != nil {
+		return 0, fmt.Errorf("sample: %w", err)
+	}
+	m, err := decodeManifest(body)
+	if err != nil || len(m.Layers) == 0 {
+		return 0, fmt.Errorf("sample: %s has no layers to sample", model)
+	}
+	largest := m.Layers[0]
+	for _, l := range m.Layers[1:] {
+		if l.Size > largest.Size {
+			largest = l
+		}
+	}
+
+	u := fmt.Sprintf("%s/v2/%s/blobs/%s", strings.TrimRight(opt.registry, "/"), ref.Repository, largest.Digest)
+	headers := map[string]string{
+		"User-Agent": "ollama-model-downloader/1.0",
+		"Range":      fmt.Sprintf("bytes=0-%d", sample-1),
+	}
+	if token != "" {
+		headers["Authorization"] = authHeader(token)
+	}
+	start := time.Now()
+	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, u, headers, opt.retries, opt.verbose)
+	if err != nil {
+		return 0, fmt.Errorf("sample: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("sample: %s", resp.Status)
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, sample))
+	if err != nil {
+		return 0, fmt.Errorf("sample: %w", err)
+	}
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		return 0, nil
+	}
+	return float64(n) / elapsed, nil
+}
+
+func fmtLatency(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return d.Round(time.Millisecond).String()
+}
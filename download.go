@@ -1,10 +1,11 @@
 package main
 
 import (
-	"archive/zip"
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -18,10 +19,17 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"text/template"
 	"time"
+
+	"ollama-model-downloader/config"
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/models"
 )
 
 type ProgressData struct {
@@ -39,6 +47,61 @@ const (
 	mtDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
 )
 
+// Ollama layer media types
+const (
+	mtOllamaModel     = "application/vnd.ollama.image.model"
+	mtOllamaAdapter   = "application/vnd.ollama.image.adapter"
+	mtOllamaProjector = "application/vnd.ollama.image.projector"
+	mtOllamaTemplate  = "application/vnd.ollama.image.template"
+	mtOllamaSystem    = "application/vnd.ollama.image.system"
+	mtOllamaParams    = "application/vnd.ollama.image.params"
+	mtOllamaMessages  = "application/vnd.ollama.image.messages"
+	mtOllamaLicense   = "application/vnd.ollama.image.license"
+)
+
+// Config blob media types
+const (
+	mtDockerConfig = "application/vnd.docker.container.image.v1+json"
+	mtOCIConfig    = "application/vnd.oci.image.config.v1+json"
+)
+
+var knownLayerTypes = map[string]bool{
+	mtOllamaModel:     true,
+	mtOllamaAdapter:   true,
+	mtOllamaProjector: true,
+	mtOllamaTemplate:  true,
+	mtOllamaSystem:    true,
+	mtOllamaParams:    true,
+	mtOllamaMessages:  true,
+	mtOllamaLicense:   true,
+}
+
+// checkMediaTypes reports config and layer media types this tool does not
+// know. An unknown type usually means the registry format changed and the
+// archive may not load in Ollama, so it is a warning, or an error in strict
+// mode.
+func checkMediaTypes(m imageManifest, strict bool) error {
+	var unknown []string
+	if t := m.Config.MediaType; t != "" && t != mtDockerConfig && t != mtOCIConfig {
+		unknown = append(unknown, fmt.Sprintf("config %s (%s)", m.Config.Digest, t))
+	}
+	for _, l := range m.Layers {
+		if !knownLayerTypes[l.MediaType] {
+			unknown = append(unknown, fmt.Sprintf("layer %s (%s)", l.Digest, l.MediaType))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	if strict {
+		return fmt.Errorf("unknown media types: %s", strings.Join(unknown, ", "))
+	}
+	for _, u := range unknown {
+		fmt.Fprintf(os.Stderr, "warning: unknown media type for %s; packaging it anyway\n", u)
+	}
+	return nil
+}
+
 type imageIndex struct {
 	Manifests []struct {
 		MediaType string `json:"mediaType"`
@@ -57,11 +120,13 @@ type imageManifest struct {
 		Digest    string `json:"digest"`
 		Size      int64  `json:"size"`
 	} `json:"config"`
-	Layers []struct {
-		MediaType string `json:"mediaType"`
-		Digest    string `json:"digest"`
-		Size      int64  `json:"size"`
-	} `json:"layers"`
+	Layers []manifestLayer `json:"layers"`
+}
+
+type manifestLayer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
 }
 
 type bearerAuth struct {
@@ -71,45 +136,133 @@ type bearerAuth struct {
 }
 
 type options struct {
-	model       string
-	registry    string
-	platform    string // linux/amd64 or linux/arm64
-	outZip      string
-	concurrency int
-	verbose     bool
-	keepStaging bool
-	retries     int
-	timeout     time.Duration
-	insecureTLS bool
-	port        int
-	outputDir   string
-	sessionID   string
-	stagingDir  string
+	model             string
+	registry          string
+	platform          string // os/arch, e.g. linux/amd64 or darwin/arm64
+	platformFallback  bool   // use another platform from the index when platform is missing
+	outZip            string
+	defaultZip        bool               // outZip is the default name, not -o; -name-template may replace it
+	nameTemplate      *template.Template // -name-template for default archive names
+	concurrency       int
+	verbose           bool
+	quiet             bool // print only result lines, warnings and errors
+	keepStaging       bool
+	retries           int
+	timeout           time.Duration
+	insecureTLS       bool
+	traceHTTP         bool
+	resolve           resolveMap
+	ipFamily          string        // "", "tcp4" or "tcp6"
+	dualStack         time.Duration // happy-eyeballs fallback delay; negative disables
+	http1             bool          // never negotiate HTTP/2
+	manifestCache     *manifestCache
+	archiveStreams    *archiveStreams    // web UI: clients streaming sessions' models directories
+	quotaReservations *quotaReservations // bytes admitted downloads still have to write, shared by the web UI's sessions
+	authCache         *registryAuthCache // registries known to need no token probe, shared by a batch
+	signatureKey      string             // PEM public key for cosign signature checks
+	requireSignature  bool
+	baseModel         string    // base model an adapter applies to
+	fetchBase         bool      // also package the base model's blobs and manifest
+	gpgSign           string    // gpg key ID for detached archive signatures ("default" = gpg's default key)
+	includeDocs       bool      // add LICENSE / README.html under docs/ in the archive
+	bundle            string    // archive layout: "zip" or "installer"
+	compression       string    // archive compression: "none", "fast", "default" or "best"
+	zipWorkers        int       // goroutines compressing each archive entry (<= 1 = one)
+	strictMediaTypes  bool      // fail instead of warning on unknown layer media types
+	maxSize           int64     // refuse models whose blobs add up to more (0 = no limit)
+	quota             int64     // bytes -output-dir may hold in all (0 = no limit)
+	minFree           int64     // hold blob writes while -output-dir's volume has less free (0 = off)
+	keepVersions      int       // archives kept per name, current included, when a new digest replaces one (<= 1 = overwrite)
+	installDir        string    // copy the verified models into this models directory instead of packaging an archive
+	uploads           []string  // -upload targets the archive is pushed to once packaged, e.g. rclone:remote:path
+	archivePartSize   int64     // write a tar split into parts of at most this size instead of a zip (0 = as the output filesystem needs)
+	yes               bool      // go ahead without asking, past -max-size
+	confirm           bool      // ask on stdin before downloading (CLI on a terminal)
+	offline           bool      // resume from the stored manifest and staged blobs only
+	startAt           time.Time // CLI: wait until then before downloading (zero = now)
+	progress          *progress // set by the web UI; run() reports here instead of drawing a bar
+	modelConfig       modelConfig
+	manifestTypes     manifestTypes
+	layers            layerFilter
+	resolved          *resolvedManifest
+	wrapTransport     func(http.RoundTripper) http.RoundTripper
+	username          string // registry credentials sent to the token endpoint
+	password          string
+	rootCAs           *x509.CertPool // extra trusted CAs for the registry (nil = system pool)
+	requestsPerSecond float64        // 0 = unlimited
+	proxy             *url.URL       // config network.proxy (nil = proxy environment variables)
+	dial              dialFunc       // opens connections instead of a net.Dialer; -resolve and -ipv4/-ipv6 still apply
+	transport         connTuning     // config network pool sizes and timeouts
+	chaos             float64        // fraction of requests given an injected fault (hidden -chaos flag)
+	port              int
+	noBrowser         bool    // web UI: don't call openBrowser (services, containers)
+	container         bool    // container entrypoint: JSON logs, no browser, fixed port
+	rateLimit         float64 // web UI: POST requests per second per client IP (0 = unlimited)
+	archiveSpotCheck  int     // web UI: blobs hashed per archive by its integrity check
+	maxSessions       int     // web UI: concurrently running downloads (0 = unlimited)
+	onInterrupted     string  // web UI: what to do at startup with sessions a dead process left active ("mark" or "resume")
+	templatesDir      string  // web UI: templates overriding the embedded ones
+	configPath        string  // config file the options were loaded from
+	notifiers         []config.Notifier
+	outputDir         string
+	sessionID         string
+	stagingDir        string
 }
 
 type modelRef struct {
+	Registry     string // registry base URL the model is fetched from
 	Host         string // registry host, e.g. registry.ollama.ai
 	Repository   string // e.g. library/llama3
 	Reference    string // tag or digest
 	ReferenceTag string // tag (if provided)
 	IsDigest     bool
+	Profile      *config.Registry // matching config profile, if any
+}
+
+// modelConfig holds the config-file rules parseModel applies to model names.
+type modelConfig struct {
+	namespace  string            // owner for names without one; empty means library
+	aliases    map[string]string // exact name -> model reference
+	registries []config.Registry // per-registry profiles, first match wins
 }
 
-func parseModel(registryBase, model string) (modelRef, error) {
+func (mc modelConfig) owner() string {
+	if mc.namespace == "" {
+		return "library"
+	}
+	return mc.namespace
+}
+
+func parseModel(registryBase, model string, mc modelConfig) (modelRef, error) {
 	// Accept forms:
+	//   alias (from the config file, expanded once)
 	//   name[:tag]
 	//   owner/name[:tag]
-	//   name@sha256:...
-	//   owner/name@sha256:...
-	// Default tag is latest, default owner is library.
+	//   name[:tag]@sha256:...
+	//   owner/name[:tag]@sha256:...
+	//   host[:port]/owner/name[:tag|@sha256:...]
+	// Default tag is latest, default owner is library (or the configured
+	// namespace). A registry profile matching the name or explicit host
+	// replaces registryBase.
+
+	ref := model
+	if target, ok := mc.aliases[model]; ok {
+		ref = target
+	}
+	profile := mc.profileFor(ref)
+	if explicitHost, rest, ok := splitRegistryHost(ref); ok {
+		registryBase = "https://" + explicitHost
+		ref = rest
+	}
+	if profile != nil && profile.URL != "" {
+		registryBase = profile.URL
+	}
 
 	u, err := url.Parse(registryBase)
 	if err != nil {
 		return modelRef{}, fmt.Errorf("invalid registry base: %w", err)
 	}
 	host := u.Host
-
-	ref := model
 	var repository string
 	var reference string
 	var tag string
@@ -120,8 +273,13 @@ func parseModel(registryBase, model string) (modelRef, error) {
 		name := parts[0]
 		digest := parts[1]
 		isDigest = true
+		// A tag next to the digest only names the stored manifest; the
+		// digest decides what is fetched.
+		if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+			name, tag = name[:i], name[i+1:]
+		}
 		if !strings.Contains(name, "/") {
-			repository = "library/" + name
+			repository = mc.owner() + "/" + name
 		} else {
 			repository = name
 		}
@@ -138,130 +296,116 @@ func parseModel(registryBase, model string) (modelRef, error) {
 			tag = "latest"
 		}
 		if !strings.Contains(name, "/") {
-			repository = "library/" + name
+			repository = mc.owner() + "/" + name
 		} else {
 			repository = name
 		}
 		reference = tag
 	}
 
-	return modelRef{Host: host, Repository: repository, Reference: reference, ReferenceTag: tag, IsDigest: isDigest}, nil
+	return modelRef{Registry: registryBase, Host: host, Repository: repository, Reference: reference, ReferenceTag: tag, IsDigest: isDigest, Profile: profile}, nil
 }
 
-func run(ctx context.Context, opt options) error {
-	// HTTP client with tuned transport
-	client := newHTTPClient(opt)
+func run(ctx context.Context, opt options) (err error) {
+	started := time.Now()
+	var total, existingTotal int64
+	var p *progress
+	var report *models.VerificationReport
+	var digest string
+	defer func() {
+		var fetched int64
+		if p != nil {
+			fetched = atomic.LoadInt64(&p.done) - existingTotal
+		}
+		recordHistory(opt, started, digest, total, fetched, report, err)
+	}()
+
+	// One writer per session, even across processes (web UI and CLI).
+	if opt.stagingDir != "" {
+		lock, err := acquireSessionLock(opt.stagingDir)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
 
-	ref, err := parseModel(opt.registry, opt.model)
+	ref, err := parseModel(opt.registry, opt.model, opt.modelConfig)
 	if err != nil {
 		return err
 	}
-
-	if opt.verbose {
-		fmt.Printf("Resolved repository: %s, reference: %s, host: %s\n", ref.Repository, ref.Reference, ref.Host)
+	if opt, err = withProfile(opt, ref); err != nil {
+		return err
 	}
 
-	// 1) Get auth challenge and token
-	token, err := getRegistryToken(ctx, client, opt, ref.Repository, ref.Reference)
-	if err != nil {
-		return fmt.Errorf("auth failed: %w", err)
-	}
+	// HTTP client with tuned transport
+	client := newHTTPClient(opt)
 
-	// 2) Fetch manifest or index
-	manifestJSON, manifestType, err := getManifestOrIndex(ctx, client, opt, ref.Repository, ref.Reference, token)
+	logf(ctx, opt.verbose, "Resolved repository: %s, reference: %s, host: %s\n", ref.Repository, ref.Reference, ref.Host)
+
+	var res resolvedManifest
+	var repackage bool
+	if opt.resolved != nil {
+		// mirror resolves a whole batch before downloading any of it
+		res = *opt.resolved
+	} else if opt.offline {
+		if err := offlineUnsupported(opt); err != nil {
+			return err
+		}
+		res, err = storedManifest(opt, ref)
+	} else if res, repackage = interruptedPackaging(opt, ref); repackage {
+		// Checks and docs were done before the blobs were fetched.
+		logf(ctx, !opt.quiet, "Packaging was interrupted; rebuilding the archive from the staged blobs\n")
+	} else if res, err = resolveManifest(ctx, client, opt, ref); err == nil {
+		// Blobs need the token even when the manifest was cached.
+		err = res.authorize(ctx, client, opt)
+	}
 	if err != nil {
 		return err
 	}
+	ref, token, manifestJSON, manifest := res.ref, res.token, res.raw, res.manifest
+	digest = manifestDigest(manifestJSON)
+	logf(ctx, !opt.quiet, "Digest: %s\n", digest)
+	if opt, err = withArchiveName(opt, ref, digest); err != nil {
+		return err
+	}
 
-	var manifest imageManifest
-	switch manifestType {
-	case mtOCIManifest, mtDockerManifest:
-		if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
-			return fmt.Errorf("decode manifest: %w", err)
-		}
-	case mtOCIIndex, mtDockerIndex:
-		// select platform
-		var idx imageIndex
-		if err := json.Unmarshal(manifestJSON, &idx); err != nil {
-			return fmt.Errorf("decode index: %w", err)
+	var base *baseModel
+	if opt.baseModel != "" {
+		if base, err = resolveBaseModel(ctx, opt); err != nil {
+			return fmt.Errorf("base model %s: %w", opt.baseModel, err)
 		}
-		arch := strings.Split(opt.platform, "/")
-		targetOS, targetArch := "linux", arch[len(arch)-1]
+	} else if isAdapterOnly(manifest) {
+		fmt.Fprintf(os.Stderr, "warning: %s only contains adapter layers; pass -base-model to record or -fetch-base to include the model it applies to\n", opt.model)
+	}
 
-		// Prefer exact match; if multiple, take first deterministic order
-		var candidates []string
-		for _, m := range idx.Manifests {
-			if strings.EqualFold(m.Platform.OS, targetOS) && strings.EqualFold(m.Platform.Architecture, targetArch) {
-				candidates = append(candidates, m.Digest)
-			}
-		}
-		if len(candidates) == 0 {
-			return fmt.Errorf("no manifest for platform %s found in index", opt.platform)
-		}
-		sort.Strings(candidates)
-		chosen := candidates[0]
-		if opt.verbose {
-			fmt.Printf("Selected platform manifest: %s (%s)\n", chosen, opt.platform)
-		}
-		manifestJSON, _, err = getManifestOrIndex(ctx, client, opt, ref.Repository, chosen, token)
-		if err != nil {
+	// Check provenance and size before spending bandwidth on blobs; the
+	// room checkQuota reserves is given back when the run ends
+	defer opt.quotaReservations.release(opt.stagingDir)
+	switch {
+	case opt.resolved != nil:
+		// mirror checked the batch when it resolved it
+	case opt.offline || repackage:
+		if err := checkMediaTypes(manifest, opt.strictMediaTypes); err != nil {
 			return err
 		}
-		if manifestType != mtOCIManifest && manifestType != mtDockerManifest {
-			return fmt.Errorf("unexpected mediaType for chosen manifest: %s", manifestType)
-		}
-		if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
-			return fmt.Errorf("decode chosen manifest: %w", err)
-		}
-		// When pulling by digest, treat reference as digest for manifest storage
-		if ref.ReferenceTag == "" {
-			ref.IsDigest = true
+		if opt.offline && opt.signatureKey != "" {
+			fmt.Fprintln(os.Stderr, "warning: offline: signature not checked")
 		}
 	default:
-		if opt.verbose {
-			fmt.Printf("Unexpected Content-Type: %s; attempting auto-detect...\n", manifestType)
+		var extra []imageManifest
+		layers := len(manifest.Layers)
+		if base != nil && opt.fetchBase {
+			extra, layers = append(extra, base.res.manifest), layers+len(base.res.manifest.Layers)
 		}
-		// Try to decode as manifest first
-		if err := json.Unmarshal(manifestJSON, &manifest); err == nil && (manifest.Config.Digest != "" || len(manifest.Layers) > 0) {
-			// proceed as manifest
-			break
+		size, err := checkPolicy(ctx, client, opt, res, extra...)
+		if err != nil {
+			return err
 		}
-		// Try to decode as index and select platform
-		var idx imageIndex
-		if err := json.Unmarshal(manifestJSON, &idx); err == nil && len(idx.Manifests) > 0 {
-			arch := strings.Split(opt.platform, "/")
-			targetOS, targetArch := "linux", arch[len(arch)-1]
-			var candidates []string
-			for _, m := range idx.Manifests {
-				if strings.EqualFold(m.Platform.OS, targetOS) && strings.EqualFold(m.Platform.Architecture, targetArch) {
-					candidates = append(candidates, m.Digest)
-				}
-			}
-			if len(candidates) == 0 {
-				return fmt.Errorf("no manifest for platform %s found in index (fallback)", opt.platform)
-			}
-			sort.Strings(candidates)
-			chosen := candidates[0]
-			if opt.verbose {
-				fmt.Printf("Selected platform manifest (fallback): %s (%s)\n", chosen, opt.platform)
-			}
-			manifestJSON, _, err = getManifestOrIndex(ctx, client, opt, ref.Repository, chosen, token)
-			if err != nil {
+		if opt.confirm {
+			if err := confirmDownload(ctx, os.Stdin, os.Stderr, ref, layers, size); err != nil {
 				return err
 			}
-			if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
-				return fmt.Errorf("decode chosen manifest (fallback): %w", err)
-			}
-			if ref.ReferenceTag == "" {
-				ref.IsDigest = true
-			}
-			break
-		}
-		snippet := string(manifestJSON)
-		if len(snippet) > 256 {
-			snippet = snippet[:256] + "..."
 		}
-		return fmt.Errorf("unsupported manifest type: %s; body: %s", manifestType, snippet)
 	}
 
 	// 3) Stage files in a reusable directory
@@ -272,21 +416,25 @@ func run(ctx context.Context, opt options) error {
 	success := false
 	defer func() {
 		if success && !opt.keepStaging {
-			_ = os.RemoveAll(stagingRoot)
+			// A client may still be streaming the models directory.
+			opt.archiveStreams.removeAfter(opt.sessionID, func() {
+				if opt.stagingDir == "" {
+					_ = os.RemoveAll(stagingRoot)
+					return
+				}
+				// Keep session.json so the completed session stays listed
+				_ = os.RemoveAll(filepath.Join(stagingRoot, "models"))
+			})
 		}
 	}()
 	// create models/{manifests,blobs}
 	modelsRoot := filepath.Join(stagingRoot, "models")
 	blobsDir := filepath.Join(modelsRoot, "blobs")
-	manifestsDir := filepath.Join(modelsRoot, "manifests", ref.Host, ref.Repository)
 	if err := os.MkdirAll(blobsDir, 0o755); err != nil {
 		return err
 	}
-	if err := os.MkdirAll(manifestsDir, 0o755); err != nil {
-		return err
-	}
 
-	meta, metaErr := loadSessionMeta(stagingRoot)
+	meta, metaErr := models.LoadSessionMeta(stagingRoot)
 	if metaErr != nil && !errors.Is(metaErr, os.ErrNotExist) {
 		return metaErr
 	}
@@ -295,55 +443,83 @@ func run(ctx context.Context, opt options) error {
 		meta.Model = opt.model
 		meta.StartedAt = time.Now()
 	}
+	// The digest the current archive was built from, for -keep-versions
+	var previousDigest string
+	if meta.State == models.StateCompleted && meta.OutZip == opt.outZip {
+		previousDigest = meta.ManifestDigest
+	}
 	meta.OutZip = opt.outZip
 	meta.Registry = opt.registry
 	meta.Platform = opt.platform
 	meta.Concurrency = opt.concurrency
 	meta.Retries = opt.retries
 	meta.StagingRoot = stagingRoot
-	meta.State = "downloading"
+	meta.ManifestDigest = digest
+	meta.State = models.StateDownloading
 	meta.Message = "در حال دانلود..."
-	if err := saveSessionMeta(meta); err != nil {
+	meta.ErrorKind, meta.Hint = "", ""
+	record := &sessionRecord{meta: meta}
+	if err := record.update(nil); err != nil {
 		return err
 	}
-
-	// 4) Write manifest to path `manifests/<host>/<repo>/<tag or digest>`
-	manifestTail := ref.Reference
-	if ref.IsDigest {
-		// store as sha256-<hex>
-		if prefix, found := strings.CutPrefix(manifestTail, "sha256:"); found {
-			manifestTail = "sha256-" + prefix
-		}
+	if opt.stagingDir != "" {
+		// Stopped before run returns, so callers can set the final state.
+		defer record.heartbeat()()
 	}
-	manifestPath := filepath.Join(manifestsDir, manifestTail)
-	if err := os.WriteFile(manifestPath, manifestJSON, 0o644); err != nil {
-		return fmt.Errorf("write manifest: %w", err)
+	setPhase := func(state models.SessionState, message string) error {
+		logf(ctx, false, "%s\n", state)
+		return record.update(func(m *models.SessionMeta) {
+			m.State = state
+			m.Message = message
+		})
 	}
-	if opt.verbose {
-		fmt.Printf("Wrote manifest: %s\n", manifestPath)
+
+	// 4) Write manifest to path `manifests/<host>/<repo>/<tag or digest>`
+	manifestTail := manifestFileTail(ref)
+	if err := writeManifestFile(modelsRoot, ref, manifestJSON, opt.verbose); err != nil {
+		return err
 	}
 
 	// 5) Download config + layers into blobs as sha256-<hex>
-	var items []blobItem
-	if manifest.Config.Digest != "" {
-		items = append(items, blobItem{digest: manifest.Config.Digest, size: manifest.Config.Size})
+	mainSource := blobSource{client: client, registry: opt.registry, repository: ref.Repository, token: token, renew: tokenRenewer(client, opt, ref)}
+	items := manifestBlobs(manifest, mainSource)
+	if base != nil {
+		if err := writeBaseModelReference(modelDocsDir(modelsRoot, ref, manifestTail), opt, base); err != nil {
+			return fmt.Errorf("base model reference: %w", err)
+		}
+		if opt.fetchBase {
+			if err := writeManifestFile(modelsRoot, base.ref, base.res.raw, opt.verbose); err != nil {
+				return err
+			}
+			items = append(items, manifestBlobs(base.res.manifest, base.src)...)
+		}
+	}
+	items, leftOut := opt.layers.apply(dedupeBlobs(items))
+	if len(leftOut) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: -layers leaves out %d layer(s) the manifest lists; the target must already have those blobs\n", len(leftOut))
 	}
-	for _, l := range manifest.Layers {
-		items = append(items, blobItem{digest: l.Digest, size: l.Size})
+	if opt.offline {
+		if err := checkOfflineBlobs(blobsDir, items); err != nil {
+			return err
+		}
 	}
-	items = dedupeBlobs(items)
 
 	// Progress bar for total known bytes
-	var total int64
 	for _, it := range items {
 		if it.size > 0 {
 			total += it.size
 		}
 	}
-	var p *progress
-	if currentProgress != nil {
-		p = currentProgress
-		p.total = total
+	// A filesystem that cannot hold the archive is found out before the
+	// download, not after it.
+	partSize := opt.archivePartSize
+	if partSize == 0 && opt.installDir == "" {
+		partSize = archiveFileLimit(filepath.Dir(opt.outZip), total)
+	}
+
+	if opt.progress != nil {
+		p = opt.progress
+		atomic.StoreInt64(&p.total, total)
 		// Don't start/stop for web UI, progress shown in browser
 	} else {
 		p = newProgress(total)
@@ -351,16 +527,41 @@ func run(ctx context.Context, opt options) error {
 			p.Start(ctx)
 			defer func() {
 				p.Stop()
-				fmt.Fprintln(os.Stderr) // newline after progress
+				p.finishLine()
 			}()
 		}
 	}
 
-	existingTotal := computeExistingBytes(blobsDir, items)
+	existingTotal = computeExistingBytes(blobsDir, items)
 	if p != nil {
 		p.SetDone(existingTotal)
 	}
+	if err := record.track(p, blobsDir, items, total); err != nil {
+		return err
+	}
 
+	// Temporary staging directories are discarded, so only sessions keep a
+	// speed log.
+	var tracker *speedTracker
+	if opt.stagingDir != "" {
+		tracker = newSpeedTracker(p, stagingRoot)
+		tracker.Start(ctx)
+		defer tracker.Stop()
+	}
+
+	dctx := ctx
+	if opt.minFree > 0 {
+		guard := newDiskGuard(blobsDir, opt.minFree, func(free int64) {
+			fmt.Fprintf(os.Stderr, "\nwarning: only %s free for %s, below -min-free %s; waiting for space\n", humanBytes(free), blobsDir, humanBytes(opt.minFree))
+			logf(ctx, false, "low disk space: %s free, waiting for %s\n", humanBytes(free), humanBytes(opt.minFree))
+			_ = setPhase(models.StateLowDisk, fmt.Sprintf("فضای خالی دیسک (%s) کمتر از %s است؛ دانلود تا آزاد شدن فضا متوقف شد", humanBytes(free), humanBytes(opt.minFree)))
+		}, func() {
+			fmt.Fprintln(os.Stderr, "disk space available again; downloading")
+			_ = setPhase(models.StateDownloading, "در حال دانلود...")
+		})
+		dctx = withDiskGuard(ctx, guard)
+	}
+	stats := newRetryStats()
 	sem := make(chan struct{}, max(1, opt.concurrency))
 	errCh := make(chan error, len(items))
 	for _, it := range items {
@@ -368,9 +569,13 @@ func run(ctx context.Context, opt options) error {
 		sem <- struct{}{}
 		go func() {
 			defer func() { <-sem }()
-			if err := downloadBlob(ctx, client, opt.registry, ref.Repository, it.digest, token, blobsDir, opt.retries, p, it.size, opt.verbose); err != nil {
+			blobEvent(ctx, it.digest, "started", it.size)
+			if err := it.src.download(withRetryStats(dctx, stats, it.digest), it, blobsDir, opt.retries, p, opt.verbose); err != nil {
+				blobEvent(ctx, it.digest, "failed", it.size)
 				errCh <- err
+				return
 			}
+			blobEvent(ctx, it.digest, "done", it.size)
 		}()
 	}
 	// wait for all
@@ -378,36 +583,470 @@ func run(ctx context.Context, opt options) error {
 		sem <- struct{}{}
 	}
 	close(errCh)
+	if tracker != nil {
+		tracker.Stop()
+	}
+	var transfer *models.TransferStats
+	err = record.update(func(m *models.SessionMeta) {
+		if blobStats := stats.snapshot(); len(blobStats) > 0 {
+			if m.Transfer == nil {
+				m.Transfer = &models.TransferStats{}
+			}
+			m.Transfer.Add(blobStats)
+		}
+		transfer = m.Transfer
+	})
+	if err != nil {
+		return err
+	}
+	logf(ctx, opt.verbose, "Transfer: %s\n", summarizeTransfer(transfer))
 	for err := range errCh {
 		if err != nil {
 			return err
 		}
 	}
 
+	if err := setPhase(models.StateVerifying, "در حال بررسی فایل‌ها..."); err != nil {
+		return err
+	}
+	report = &models.VerificationReport{
+		Model:          opt.model,
+		Registry:       opt.registry,
+		Repository:     ref.Repository,
+		Reference:      ref.Reference,
+		ManifestDigest: manifestDigest(manifestJSON),
+		Platform:       opt.platform,
+	}
+	// The CLI bar turns into a verification bar; the web UI shows the
+	// verifying state instead.
+	var vp *progress
+	if opt.progress == nil {
+		vp = p
+		vp.Relabel("Verifying")
+	}
+	if err := verifyStagedBlobs(report, blobsDir, items, opt.concurrency, vp); err != nil {
+		return err
+	}
+	if err := writeVerificationReport(report, modelDocsDir(modelsRoot, ref, manifestTail)); err != nil {
+		return fmt.Errorf("verification report: %w", err)
+	}
+
+	if opt.includeDocs && opt.offline {
+		fmt.Fprintln(os.Stderr, "warning: offline: -include-docs skipped")
+	} else if opt.includeDocs && !repackage {
+		if err := writeModelDocs(ctx, client, opt, ref, manifest, modelsRoot, blobsDir, manifestTail); err != nil {
+			return fmt.Errorf("model docs: %w", err)
+		}
+	}
+
+	// Clients waiting on the archive stream start now; while one streams,
+	// the server does not package a zip of its own.
+	streamed := opt.archiveStreams.verified(opt.sessionID)
+
+	if opt.installDir != "" {
+		if err := setPhase(models.StatePackaging, "در حال نصب در پوشه مدل‌ها..."); err != nil {
+			return err
+		}
+		linked, copied, err := installStaged(modelsRoot, opt.installDir)
+		if err != nil {
+			return fmt.Errorf("install: %w", err)
+		}
+		logf(ctx, !opt.quiet, "Installed into: %s (%d files linked, %d copied)\n", opt.installDir, linked, copied)
+		if err := setPhase(models.StateCompleted, "نصب کامل شد."); err != nil {
+			return err
+		}
+		success = true
+		return nil
+	}
+
+	// finish uploads the archives once packaged and completes the session.
+	finish := func(archives []string) error {
+		if len(opt.uploads) > 0 {
+			if err := uploadArtifacts(ctx, opt, record, archives); err != nil {
+				return fmt.Errorf("upload: %w", err)
+			}
+		}
+		if opt.keepStaging {
+			logf(ctx, !opt.quiet, "staging kept at: %s\n", stagingRoot)
+		}
+		if err := setPhase(models.StateCompleted, "دانلود کامل شد."); err != nil {
+			return err
+		}
+		success = true
+		return nil
+	}
+
+	if streamed && len(opt.uploads) == 0 {
+		logf(ctx, !opt.quiet, "Streamed to a client; no archive written\n")
+		return finish(nil)
+	}
+
+	if partSize > 0 {
+		if err := setPhase(models.StatePackaging, "در حال ساخت فایل tar چندبخشی..."); err != nil {
+			return err
+		}
+		parts, err := writeSplitTar(opt, modelsRoot, partSize, leftOutBlobs(items, leftOut))
+		if err != nil {
+			return fmt.Errorf("tar: %w", err)
+		}
+		logf(ctx, opt.verbose, "Created tar in %d parts of at most %s: %s\n", len(parts), humanBytes(partSize), strings.Join(parts, ", "))
+		if !opt.verbose {
+			for _, part := range parts {
+				fmt.Println("OK:", part)
+			}
+		}
+		return finish(parts)
+	}
+
 	// 6) Zip models/ content to output zip
+	if err := setPhase(models.StatePackaging, "در حال ساخت فایل zip..."); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(filepath.Dir(opt.outZip), 0755); err != nil {
 		return err
 	}
-	if err := zipDir(modelsRoot, opt.outZip); err != nil {
+	// Build next to the archive and swap it in, so a failed run never
+	// costs the copy that is already there.
+	partialZip := opt.outZip + ".partial"
+	zipSum, err := packageArchive(opt, modelsRoot, partialZip, leftOutBlobs(items, leftOut))
+	if err != nil {
+		_ = os.Remove(partialZip)
 		return fmt.Errorf("zip: %w", err)
 	}
-	if opt.verbose {
-		fmt.Printf("Created zip: %s\n", opt.outZip)
-	} else {
+	if err := rotateArchive(opt.outZip, previousDigest, digest, opt.keepVersions); err != nil {
+		_ = os.Remove(partialZip)
+		return fmt.Errorf("keep versions: %w", err)
+	}
+	if err := os.Rename(partialZip, opt.outZip); err != nil {
+		_ = os.Remove(partialZip)
+		return fmt.Errorf("zip: %w", err)
+	}
+	sumsPath, err := writeChecksumSidecar(opt.outZip, zipSum)
+	if err != nil {
+		return fmt.Errorf("checksums: %w", err)
+	}
+	if opt.gpgSign != "" {
+		if err := signArtifacts(opt.gpgSign, opt.outZip, sumsPath); err != nil {
+			return fmt.Errorf("sign: %w", err)
+		}
+	}
+	if err := writeSumsFile(filepath.Dir(opt.outZip), opt.gpgSign); err != nil {
+		return fmt.Errorf("checksums: %w", err)
+	}
+	logf(ctx, opt.verbose, "Created zip: %s (sha256 %s)\n", opt.outZip, zipSum)
+	if !opt.verbose {
 		fmt.Println("OK:", opt.outZip)
 	}
+	return finish([]string{opt.outZip})
+}
+
+// resolvedManifest is what a model reference resolves to on the registry:
+// the pull token and the manifest for the target platform.
+type resolvedManifest struct {
+	ref       modelRef // IsDigest is set when an index was resolved by digest
+	token     string
+	raw       []byte
+	mediaType string // Content-Type of raw
+	manifest  imageManifest
+	// index is the image index the manifest was picked from, if any.
+	index          []byte
+	indexMediaType string
+	// authorized is false when every manifest came fresh from the cache and
+	// no token was looked up; authorize does that before blobs are fetched.
+	authorized bool
+}
+
+// resolveManifest authenticates and fetches the manifest for ref, picking
+// the opt.platform entry when the registry returns an image index.
+func resolveManifest(ctx context.Context, client *http.Client, opt options, ref modelRef) (resolvedManifest, error) {
+	res := resolvedManifest{ref: ref}
+
+	// 1) Get auth challenge and token, unless the manifest is fresh in the
+	// cache and the registry need not be asked at all
+	if !opt.manifestCache.hasFresh(opt.registry, ref.Repository, ref.Reference) {
+		err := res.authorize(ctx, client, opt)
+		if errors.Is(err, errManifestNotFound) {
+			return res, notFoundError(ctx, client, opt, ref, err)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+
+	// 2) Fetch manifest or index
+	manifestJSON, manifestType, err := getManifestOrIndex(ctx, client, opt, ref.Repository, ref.Reference, res.token)
+	if apperrors.KindOf(err) == apperrors.KindUnauthorized && opt.authCache.forget(opt) {
+		// The registry's auth changed since the probe was skipped.
+		res.authorized = false
+		if err = res.authorize(ctx, client, opt); err == nil {
+			manifestJSON, manifestType, err = getManifestOrIndex(ctx, client, opt, ref.Repository, ref.Reference, res.token)
+		}
+	}
+	if errors.Is(err, errManifestNotFound) {
+		return res, notFoundError(ctx, client, opt, ref, err)
+	}
+	if err != nil {
+		return res, err
+	}
+	if ref.IsDigest {
+		if err := checkManifestDigest(manifestJSON, ref.Reference); err != nil {
+			return res, err
+		}
+	}
 
-	if opt.keepStaging {
-		fmt.Println("staging kept at:", stagingRoot)
+	kind, detected := opt.manifestTypes.classify(manifestType, manifestJSON)
+	if detected {
+		logf(ctx, opt.verbose, "Unexpected Content-Type: %s; detected from the body\n", manifestType)
+	}
+	if kind == kindIndex {
+		var idx imageIndex
+		if err := json.Unmarshal(manifestJSON, &idx); err != nil {
+			return res, fmt.Errorf("decode index: %w", err)
+		}
+		res.index, res.indexMediaType = manifestJSON, manifestType
+		chosen, err := selectPlatform(idx, opt.platform)
+		if err != nil && opt.platformFallback {
+			if alt, ok := fallbackPlatform(idx, opt.platform); ok {
+				fmt.Fprintf(os.Stderr, "warning: %s has no %s manifest; using %s instead (-platform-fallback)\n", opt.model, opt.platform, alt)
+				logf(ctx, false, "warning: no %s manifest; using %s instead\n", opt.platform, alt)
+				chosen, err = selectPlatform(idx, alt)
+			}
+		}
+		if err != nil {
+			return res, err
+		}
+		logf(ctx, opt.verbose, "Selected platform manifest: %s (%s)\n", chosen, opt.platform)
+		if !opt.manifestCache.hasFresh(opt.registry, ref.Repository, chosen) {
+			if err := res.authorize(ctx, client, opt); err != nil {
+				return res, err
+			}
+		}
+		manifestJSON, manifestType, err = getManifestOrIndex(ctx, client, opt, ref.Repository, chosen, res.token)
+		if err != nil {
+			return res, err
+		}
+		if err := checkManifestDigest(manifestJSON, chosen); err != nil {
+			return res, err
+		}
+		if kind, _ = opt.manifestTypes.classify(manifestType, manifestJSON); kind != kindManifest {
+			return res, fmt.Errorf("unexpected mediaType for chosen manifest: %s", manifestType)
+		}
+		// When pulling by digest, treat reference as digest for manifest storage
+		if ref.ReferenceTag == "" {
+			res.ref.IsDigest = true
+		}
+	}
+	if kind != kindManifest {
+		snippet := string(manifestJSON)
+		if len(snippet) > 256 {
+			snippet = snippet[:256] + "..."
+		}
+		return res, fmt.Errorf("unsupported manifest type: %s; body: %s", manifestType, snippet)
+	}
+	manifest, err := decodeManifest(manifestJSON)
+	if err != nil {
+		return res, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	res.raw, res.mediaType, res.manifest = manifestJSON, manifestType, manifest
+	return res, nil
+}
+
+// authorize looks up the token for res.ref unless resolveManifest already
+// did; a manifest served from the cache skips that, but its blobs need it.
+func (res *resolvedManifest) authorize(ctx context.Context, client *http.Client, opt options) error {
+	if res.authorized {
+		return nil
+	}
+	token, err := getRegistryToken(ctx, client, opt, res.ref.Repository, res.ref.Reference)
+	if errors.Is(err, errManifestNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("auth failed: %w", err)
+	}
+	res.token, res.authorized = token, true
+	return nil
+}
+
+// checkManifestDigest fails unless raw is the manifest with digest want, so
+// a pinned reference can only ever yield the pinned content.
+func checkManifestDigest(raw []byte, want string) error {
+	if got := manifestDigest(raw); !strings.EqualFold(got, want) {
+		return fmt.Errorf("manifest digest mismatch: requested %s, registry returned %s", want, got)
 	}
-	success = true
 	return nil
 }
 
+// Operating systems and architectures -platform accepts.
+var (
+	platformOSes   = []string{"linux", "darwin", "windows"}
+	platformArches = []string{"amd64", "arm64"}
+)
+
+// parsePlatform splits a -platform value into OS and architecture. A bare
+// architecture means linux, the only OS before others were accepted.
+func parsePlatform(platform string) (goos, arch string, err error) {
+	goos, arch, found := strings.Cut(strings.ToLower(strings.TrimSpace(platform)), "/")
+	if !found {
+		goos, arch = "linux", goos
+	}
+	if !slices.Contains(platformOSes, goos) {
+		return "", "", fmt.Errorf("unsupported OS %q in platform %q (want %s)", goos, platform, strings.Join(platformOSes, ", "))
+	}
+	if !slices.Contains(platformArches, arch) {
+		return "", "", fmt.Errorf("unsupported architecture %q in platform %q (want %s)", arch, platform, strings.Join(platformArches, ", "))
+	}
+	return goos, arch, nil
+}
+
+// selectPlatform picks the manifest for platform (os/arch) from idx; ties
+// go to the lowest digest so the choice is stable. When the index has no
+// such entry the error lists the platforms it does have.
+func selectPlatform(idx imageIndex, platform string) (string, error) {
+	targetOS, targetArch, err := parsePlatform(platform)
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, m := range idx.Manifests {
+		if strings.EqualFold(m.Platform.OS, targetOS) && strings.EqualFold(m.Platform.Architecture, targetArch) {
+			candidates = append(candidates, m.Digest)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no manifest for platform %s found in index; available: %s", platform, strings.Join(indexPlatforms(idx), ", "))
+	}
+	sort.Strings(candidates)
+	return candidates[0], nil
+}
+
+// fallbackPlatform picks the platform -platform-fallback uses when idx has
+// no manifest for platform. Ollama's weights are the same everywhere, so
+// any supported entry will do; the same architecture is preferred, then
+// the same OS, then linux/amd64. Entries such as unknown/unknown
+// attestations are never chosen.
+func fallbackPlatform(idx imageIndex, platform string) (string, bool) {
+	targetOS, targetArch, err := parsePlatform(platform)
+	if err != nil {
+		return "", false
+	}
+	best, bestRank := "", 0
+	for _, p := range indexPlatforms(idx) {
+		goos, arch, err := parsePlatform(p)
+		if err != nil {
+			continue
+		}
+		rank := 1
+		switch {
+		case arch == targetArch && goos == "linux":
+			rank = 5
+		case arch == targetArch:
+			rank = 4
+		case goos == targetOS:
+			rank = 3
+		case p == "linux/amd64":
+			rank = 2
+		}
+		if rank > bestRank {
+			best, bestRank = p, rank
+		}
+	}
+	return best, best != ""
+}
+
+// indexPlatforms lists the distinct os/arch pairs idx publishes, sorted.
+func indexPlatforms(idx imageIndex) []string {
+	var platforms []string
+	for _, m := range idx.Manifests {
+		p := strings.ToLower(m.Platform.OS + "/" + m.Platform.Architecture)
+		if !slices.Contains(platforms, p) {
+			platforms = append(platforms, p)
+		}
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// recordHistory appends the outcome of a run to the history of
+// opt.outputDir. It is best effort: statistics never fail a download.
+func recordHistory(opt options, started time.Time, digest string, total, fetched int64, report *models.VerificationReport, err error) {
+	if opt.outputDir == "" {
+		return
+	}
+	entry := models.HistoryEntry{
+		Model:        opt.model,
+		SessionID:    opt.sessionID,
+		Digest:       digest,
+		StartedAt:    started,
+		EndedAt:      time.Now(),
+		TotalBytes:   total,
+		BytesFetched: max64(fetched, 0),
+		Outcome:      models.OutcomeCompleted,
+		Verification: report,
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		entry.Outcome = models.OutcomeCanceled
+	case err != nil:
+		entry.Outcome = models.OutcomeFailed
+		entry.Error = err.Error()
+	}
+	if mkErr := os.MkdirAll(opt.outputDir, 0o755); mkErr == nil {
+		_ = models.AppendHistory(opt.outputDir, entry)
+	}
+}
+
 // dedupeBlobs removes duplicate digests keeping the first observed size.
 type blobItem struct {
-	digest string
-	size   int64
+	digest    string
+	size      int64
+	mediaType string
+	src       blobSource
+}
+
+// manifestBlobs lists the config and layer blobs of m, fetched from src.
+func manifestBlobs(m imageManifest, src blobSource) []blobItem {
+	var items []blobItem
+	if m.Config.Digest != "" {
+		items = append(items, blobItem{digest: m.Config.Digest, size: m.Config.Size, mediaType: m.Config.MediaType, src: src})
+	}
+	for _, l := range m.Layers {
+		items = append(items, blobItem{digest: l.Digest, size: l.Size, mediaType: l.MediaType, src: src})
+	}
+	return items
+}
+
+// manifestFileTail is the file name a manifest is stored under: the tag, or
+// sha256-<hex> when pulled by digest alone.
+func manifestFileTail(ref modelRef) string {
+	if ref.ReferenceTag != "" {
+		return ref.ReferenceTag
+	}
+	tail := ref.Reference
+	if ref.IsDigest {
+		if prefix, found := strings.CutPrefix(tail, "sha256:"); found {
+			tail = "sha256-" + prefix
+		}
+	}
+	return tail
+}
+
+// writeManifestFile stores raw at manifests/<host>/<repo>/<tail> below
+// modelsRoot.
+func writeManifestFile(modelsRoot string, ref modelRef, raw []byte, verbose bool) error {
+	dir := filepath.Join(modelsRoot, "manifests", ref.Host, ref.Repository)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, manifestFileTail(ref))
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if verbose {
+		fmt.Printf("Wrote manifest: %s\n", path)
+	}
+	return nil
 }
 
 func dedupeBlobs(items []blobItem) []blobItem {
@@ -423,11 +1062,21 @@ func dedupeBlobs(items []blobItem) []blobItem {
 	return out
 }
 
+// getRegistryToken returns what to authorize pulls from repository with
+// (see authHeader), "" when the registry needs nothing. It probes the
+// manifest without credentials unless opt.authCache already knows that the
+// registry takes anonymous pulls or the Basic credential.
 func getRegistryToken(ctx context.Context, client *http.Client, opt options, repository, reference string) (string, error) {
+	switch opt.authCache.get(opt) {
+	case authAnonymous:
+		return "", nil
+	case authBasic:
+		return basicCredential(opt)
+	}
 	// Probe without auth to get challenge (GET for broader compatibility)
 	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", strings.TrimRight(opt.registry, "/"), repository, reference)
 	headers := map[string]string{
-		"Accept":     strings.Join([]string{mtOCIIndex, mtOCIManifest, mtDockerIndex, mtDockerManifest}, ", "),
+		"Accept":     opt.manifestTypes.accept(),
 		"User-Agent": "ollama-model-downloader/1.0",
 	}
 	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, manifestURL, headers, opt.retries, opt.verbose)
@@ -435,28 +1084,44 @@ func getRegistryToken(ctx context.Context, client *http.Client, opt options, rep
 		return "", err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK { // no auth required
+	challenge := resp.Header.Get("WWW-Authenticate")
+	switch {
+	case resp.StatusCode == http.StatusOK: // no auth required
+		opt.authCache.set(opt, authAnonymous)
 		return "", nil
-	}
-	if resp.StatusCode != http.StatusUnauthorized {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("manifest fetch failed: %s: %w", resp.Status, errManifestNotFound)
+	case resp.StatusCode == http.StatusForbidden && challenge == "" && opt.username != "":
+		// Some registries refuse anonymous pulls outright instead of
+		// challenging; try the profile's credentials as Basic auth.
+		opt.authCache.set(opt, authBasic)
+		return basicCredential(opt)
+	case resp.StatusCode == http.StatusForbidden && challenge == "":
+		return "", apperrors.WithKind(apperrors.KindUnauthorized, fmt.Errorf("unexpected status probing auth: %s", resp.Status))
+	case resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden:
 		return "", fmt.Errorf("unexpected status probing auth: %s", resp.Status)
 	}
+	token, err := challengeToken(ctx, client, opt, resp, fmt.Sprintf("repository:%s:pull", repository))
+	if err == nil && strings.HasPrefix(token, "Basic ") {
+		opt.authCache.set(opt, authBasic)
+	}
+	return token, err
+}
+
+// bearerChallengeFrom extracts the bearer challenge from a 401 response.
+func bearerChallengeFrom(resp *http.Response) (bearerAuth, error) {
 	chal := resp.Header.Get("Www-Authenticate")
 	if chal == "" {
 		chal = resp.Header.Get("WWW-Authenticate")
 	}
 	if chal == "" {
-		return "", errors.New("missing WWW-Authenticate header for bearer challenge")
-	}
-	b, err := parseBearerChallenge(chal)
-	if err != nil {
-		return "", err
-	}
-	if b.Scope == "" {
-		// Standard scope for pull
-		b.Scope = fmt.Sprintf("repository:%s:pull", repository)
+		return bearerAuth{}, errors.New("missing WWW-Authenticate header for bearer challenge")
 	}
-	// request token
+	return parseBearerChallenge(chal)
+}
+
+// fetchBearerToken exchanges a bearer challenge for a token at its realm.
+func fetchBearerToken(ctx context.Context, client *http.Client, opt options, b bearerAuth) (string, error) {
 	v := url.Values{}
 	if b.Service != "" {
 		v.Set("service", b.Service)
@@ -469,13 +1134,17 @@ func getRegistryToken(ctx context.Context, client *http.Client, opt options, rep
 		return "", fmt.Errorf("invalid realm: %w", err)
 	}
 	realm.RawQuery = v.Encode()
-	trsp, err := httpReqWithRetry(ctx, client, http.MethodGet, realm.String(), map[string]string{"User-Agent": "ollama-model-downloader/1.0"}, opt.retries, opt.verbose)
+	headers := map[string]string{"User-Agent": "ollama-model-downloader/1.0"}
+	if opt.username != "" {
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(opt.username+":"+opt.password))
+	}
+	trsp, err := httpReqWithRetry(ctx, client, http.MethodGet, realm.String(), headers, opt.retries, opt.verbose)
 	if err != nil {
 		return "", err
 	}
 	defer trsp.Body.Close()
 	if trsp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("token fetch failed: %s", trsp.Status)
+		return "", apperrors.WithKind(statusKind(trsp.StatusCode), fmt.Errorf("token fetch failed: %s", trsp.Status))
 	}
 	var tok struct {
 		Token       string `json:"token"`
@@ -505,22 +1174,51 @@ func parseBearerChallenge(hdr string) (bearerAuth, error) {
 	return bearerAuth{Realm: m[1], Service: m[2], Scope: m[3]}, nil
 }
 
+// statusKind classifies a registry response status for error hints.
+func statusKind(code int) apperrors.Kind {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return apperrors.KindUnauthorized
+	case http.StatusNotFound:
+		return apperrors.KindNotFound
+	}
+	return apperrors.KindUnknown
+}
+
 func getManifestOrIndex(ctx context.Context, client *http.Client, opt options, repository, reference, token string) ([]byte, string, error) {
+	cached, haveCached := opt.manifestCache.get(opt.registry, repository, reference)
+	if haveCached && opt.manifestCache.fresh(cached) {
+		logf(ctx, opt.verbose, "manifest cache hit: %s:%s\n", repository, reference)
+		return cached.Body, cached.ContentType, nil
+	}
+
 	u := fmt.Sprintf("%s/v2/%s/manifests/%s", strings.TrimRight(opt.registry, "/"), repository, reference)
 	headers := map[string]string{
-		"Accept":     strings.Join([]string{mtOCIIndex, mtOCIManifest, mtDockerIndex, mtDockerManifest}, ", "),
+		"Accept":     opt.manifestTypes.accept(),
 		"User-Agent": "ollama-model-downloader/1.0",
 	}
 	if token != "" {
-		headers["Authorization"] = "Bearer " + token
+		headers["Authorization"] = authHeader(token)
+	}
+	if haveCached && cached.ETag != "" {
+		headers["If-None-Match"] = cached.ETag
 	}
 	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, u, headers, opt.retries, opt.verbose)
 	if err != nil {
 		return nil, "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && haveCached {
+		logf(ctx, opt.verbose, "manifest not modified: %s:%s\n", repository, reference)
+		cached.FetchedAt = time.Now()
+		_ = opt.manifestCache.put(cached)
+		return cached.Body, cached.ContentType, nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", fmt.Errorf("manifest fetch failed: %s: %w", resp.Status, errManifestNotFound)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("manifest fetch failed: %s", resp.Status)
+		return nil, "", apperrors.WithKind(statusKind(resp.StatusCode), fmt.Errorf("manifest fetch failed: %s", resp.Status))
 	}
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -534,6 +1232,15 @@ func getManifestOrIndex(ctx context.Context, client *http.Client, opt options, r
 	if i := strings.Index(ctype, ";"); i >= 0 {
 		ctype = strings.TrimSpace(ctype[:i])
 	}
+	_ = opt.manifestCache.put(cachedManifest{
+		Registry:    opt.registry,
+		Repository:  repository,
+		Reference:   reference,
+		ContentType: ctype,
+		ETag:        resp.Header.Get("ETag"),
+		FetchedAt:   time.Now(),
+		Body:        data,
+	})
 	return data, ctype, nil
 }
 
@@ -543,11 +1250,9 @@ func downloadBlob(ctx context.Context, client *http.Client, registryBase, reposi
 	}
 	hexhash := strings.TrimPrefix(digest, "sha256:")
 	outPath := filepath.Join(blobsDir, "sha256-"+hexhash)
-	if st, err := os.Stat(outPath); err == nil {
-		if expectedSize <= 0 || st.Size() >= expectedSize {
-			if verbose {
-				fmt.Printf("blob exists, skipping: %s\n", outPath)
-			}
+	if size, err := models.DefaultBlobStore.Stat(blobsDir, digest); err == nil {
+		if expectedSize <= 0 || size >= expectedSize {
+			logf(ctx, verbose, "blob exists, skipping: %s\n", digest)
 			return nil
 		}
 	}
@@ -556,10 +1261,8 @@ func downloadBlob(ctx context.Context, client *http.Client, registryBase, reposi
 	if expectedSize > 0 {
 		if st, err := os.Stat(tmp); err == nil && st.Size() == expectedSize {
 			if ok, err := verifyFileHash(tmp, hexhash); err == nil && ok {
-				if verbose {
-					fmt.Printf("resuming blob already downloaded: %s\n", tmp)
-				}
-				return os.Rename(tmp, outPath)
+				logf(ctx, verbose, "resuming blob already downloaded: %s\n", tmp)
+				return models.DefaultBlobStore.Put(blobsDir, digest, tmp)
 			}
 		}
 	}
@@ -577,13 +1280,11 @@ func downloadBlob(ctx context.Context, client *http.Client, registryBase, reposi
 		"User-Agent": "ollama-model-downloader/1.0",
 	}
 	if token != "" {
-		headers["Authorization"] = "Bearer " + token
+		headers["Authorization"] = authHeader(token)
 	}
 	if start > 0 {
 		headers["Range"] = fmt.Sprintf("bytes=%d-", start)
-		if verbose {
-			fmt.Printf("resuming blob %s from %d bytes\n", digest, start)
-		}
+		logf(ctx, verbose, "resuming blob %s from %d bytes\n", digest, start)
 	}
 
 	u := fmt.Sprintf("%s/v2/%s/blobs/%s", strings.TrimRight(registryBase, "/"), repository, digest)
@@ -593,7 +1294,7 @@ func downloadBlob(ctx context.Context, client *http.Client, registryBase, reposi
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("blob fetch failed (%s): %s", digest, resp.Status)
+		return apperrors.WithKind(statusKind(resp.StatusCode), fmt.Errorf("blob fetch failed (%s): %s", digest, resp.Status))
 	}
 
 	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY, 0o644)
@@ -626,28 +1327,29 @@ func downloadBlob(ctx context.Context, client *http.Client, registryBase, reposi
 		if p != nil {
 			p.Add(-start)
 		}
+		noteRedownload(ctx, start)
 		hasher.Reset()
 		start = 0
 	}
 
-	writers := []io.Writer{f, hasher}
+	writers := []io.Writer{guardedWriter(ctx, f), hasher}
 	if p != nil {
 		writers = append(writers, p)
 	}
-	if _, err := io.Copy(io.MultiWriter(writers...), resp.Body); err != nil {
+	if _, err := copyBody(io.MultiWriter(writers...), resp.Body); err != nil {
 		return err
 	}
 
 	sum := hex.EncodeToString(hasher.Sum(nil))
 	if sum != hexhash {
-		return fmt.Errorf("sha256 mismatch for %s: got %s", digest, sum)
+		return apperrors.WithKind(apperrors.KindChecksum, fmt.Errorf("sha256 mismatch for %s: got %s", digest, sum))
 	}
 
 	if err := f.Close(); err != nil {
 		return err
 	}
 	f = nil
-	return os.Rename(tmp, outPath)
+	return models.DefaultBlobStore.Put(blobsDir, digest, tmp)
 }
 
 func hashExistingFile(path string, hasher hash.Hash) error {
@@ -656,21 +1358,16 @@ func hashExistingFile(path string, hasher hash.Hash) error {
 		return err
 	}
 	defer f.Close()
-	_, err = io.Copy(hasher, f)
+	_, err = copyBody(hasher, f)
 	return err
 }
 
 func verifyFileHash(path, expected string) (bool, error) {
-	f, err := os.Open(path)
+	_, sum, err := hashFile(path, nil)
 	if err != nil {
 		return false, err
 	}
-	defer f.Close()
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return false, err
-	}
-	return hex.EncodeToString(h.Sum(nil)) == expected, nil
+	return sum == expected, nil
 }
 
 func computeExistingBytes(blobsDir string, items []blobItem) int64 {
@@ -685,16 +1382,13 @@ func existingBytesForBlob(blobsDir, digest string, expected int64) int64 {
 	if !strings.HasPrefix(digest, "sha256:") {
 		return 0
 	}
-	hexhash := strings.TrimPrefix(digest, "sha256:")
-	outPath := filepath.Join(blobsDir, "sha256-"+hexhash)
-	if st, err := os.Stat(outPath); err == nil {
-		size := st.Size()
+	if size, err := models.DefaultBlobStore.Stat(blobsDir, digest); err == nil {
 		if expected > 0 && size > expected {
 			return expected
 		}
 		return size
 	}
-	tmp := outPath + ".part"
+	tmp := filepath.Join(blobsDir, blobFileName(digest)+".part")
 	if st, err := os.Stat(tmp); err == nil {
 		size := st.Size()
 		if expected > 0 && size > expected {
@@ -705,62 +1399,6 @@ func existingBytesForBlob(blobsDir, digest string, expected int64) int64 {
 	return 0
 }
 
-func zipDir(root, outZip string) error {
-	// root folder will be included content-only; we want manifests/ and blobs/ at zip root
-	out, err := os.Create(outZip)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	zw := zip.NewWriter(out)
-	defer zw.Close()
-
-	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if path == root {
-			return nil
-		}
-		rel, err := filepath.Rel(root, path)
-		if err != nil {
-			return err
-		}
-		// zip needs forward slashes
-		name := filepath.ToSlash(rel)
-		if info.IsDir() {
-			if !strings.HasSuffix(name, "/") {
-				name += "/"
-			}
-			_, err := zw.CreateHeader(&zip.FileHeader{
-				Name:     name,
-				Method:   zip.Deflate,
-				Modified: time.Now(),
-			})
-			return err
-		}
-		fh, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
-		fh.Name = name
-		fh.Method = zip.Deflate
-		fh.Modified = time.Now()
-		w, err := zw.CreateHeader(fh)
-		if err != nil {
-			return err
-		}
-		f, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		_, err = io.Copy(w, f)
-		return err
-	})
-}
-
 func ensureStagingRoot(opt options) (string, error) {
 	if opt.stagingDir != "" {
 		if err := os.MkdirAll(opt.stagingDir, 0o755); err != nil {
@@ -771,6 +1409,13 @@ func ensureStagingRoot(opt options) (string, error) {
 	return os.MkdirTemp(".", "ollama-staging-")
 }
 
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -778,12 +1423,20 @@ func max(a, b int) int {
 	return b
 }
 
+// progressBars is false with -quiet or when stderr is not a terminal, where
+// a bar redrawn with carriage returns only fills logs and cron mail. Progress
+// is still counted for session checkpoints.
+var progressBars = true
+
 // progress is a simple concurrent progress tracker printing a single-line bar.
 type progress struct {
 	total int64
 	done  int64
 	tick  *time.Ticker
 	quit  chan struct{}
+
+	mu    sync.Mutex
+	label string // "" means Downloading
 }
 
 func newProgress(total int64) *progress {
@@ -825,6 +1478,23 @@ func (p *progress) SetDone(n int64) {
 	atomic.StoreInt64(&p.done, n)
 }
 
+// Relabel reuses the bar for another pass over the same bytes, starting
+// again from zero.
+func (p *progress) Relabel(label string) {
+	if p == nil {
+		return
+	}
+	if p.total > 0 {
+		// Leave the finished bar on its own line.
+		p.render()
+		p.finishLine()
+	}
+	p.mu.Lock()
+	p.label = label
+	p.mu.Unlock()
+	p.SetDone(0)
+}
+
 func (p *progress) Start(ctx context.Context) {
 	if p == nil || p.total <= 0 {
 		return
@@ -859,7 +1529,17 @@ func (p *progress) Stop() {
 	}
 }
 
+// finishLine ends the line the bar was drawn on.
+func (p *progress) finishLine() {
+	if progressBars {
+		os.Stderr.WriteString("\n")
+	}
+}
+
 func (p *progress) render() {
+	if !progressBars {
+		return
+	}
 	done := atomic.LoadInt64(&p.done)
 	if done > p.total {
 		done = p.total
@@ -868,7 +1548,13 @@ func (p *progress) render() {
 	if p.total > 0 {
 		percent = int((done * 100) / p.total)
 	}
-	line := fmt.Sprintf("Downloading: %s / %s (%d%%)\r", humanBytes(done), humanBytes(p.total), percent)
+	p.mu.Lock()
+	label := p.label
+	p.mu.Unlock()
+	if label == "" {
+		label = "Downloading"
+	}
+	line := fmt.Sprintf("%s: %s / %s (%d%%)\r", label, humanBytes(done), humanBytes(p.total), percent)
 	os.Stderr.WriteString(line)
 }
 
@@ -890,27 +1576,75 @@ func humanBytes(n int64) string {
 	}
 }
 
-// newHTTPClient builds an HTTP client with tuned timeouts suitable for large downloads
+// newDialer is the dialer registry connections use unless opt.dial is set.
+func newDialer(opt options) *net.Dialer {
+	return &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: opt.dualStack,
+	}
+}
+
+// newHTTPClient builds an HTTP client with tuned timeouts suitable for large
+// downloads. opt.dial replaces how connections are opened and
+// opt.wrapTransport wraps the transport (after HTTP/2 fallback and rate
+// limiting, before -chaos and -trace-http), e.g. for instrumentation.
 func newHTTPClient(opt options) *http.Client {
-	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
+	dial := opt.dial
+	if dial == nil {
+		dial = newDialer(opt).DialContext
+	}
+	dial = withAddressFamily(dial, opt.ipFamily)
+	dial = withResolveOverrides(dial, opt.resolve)
+	proxy := http.ProxyFromEnvironment
+	if opt.proxy != nil {
+		proxy = http.ProxyURL(opt.proxy)
 	}
 	tr := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           dialer.DialContext,
+		Proxy:                 proxy,
+		DialContext:           dial,
 		ForceAttemptHTTP2:     true,
-		TLSClientConfig:       &tls.Config{InsecureSkipVerify: opt.insecureTLS},
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: opt.insecureTLS, RootCAs: opt.rootCAs},
 		TLSHandshakeTimeout:   30 * time.Second,
 		MaxIdleConns:          100,
+		MaxConnsPerHost:       opt.transport.maxConnsPerHost,
+		MaxIdleConnsPerHost:   opt.transport.maxIdleConnsPerHost,
 		IdleConnTimeout:       90 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		ResponseHeaderTimeout: 60 * time.Second,
 	}
-	return &http.Client{
-		Transport: tr,
+	if opt.transport.tlsHandshakeTimeout > 0 {
+		tr.TLSHandshakeTimeout = opt.transport.tlsHandshakeTimeout
+	}
+	if opt.transport.responseHeaderTimeout > 0 {
+		tr.ResponseHeaderTimeout = opt.transport.responseHeaderTimeout
+	}
+	var rt http.RoundTripper = tr
+	if opt.http1 {
+		disableHTTP2(tr)
+	} else {
+		h1 := tr.Clone()
+		disableHTTP2(h1)
+		rt = newH2FallbackTransport(tr, h1, opt.verbose)
+	}
+	if opt.requestsPerSecond > 0 {
+		rt = newRateLimitTransport(rt, opt.requestsPerSecond)
+	}
+	if opt.wrapTransport != nil {
+		rt = opt.wrapTransport(rt)
+	}
+	if opt.chaos > 0 {
+		rt = newChaosTransport(rt, opt.chaos)
+	}
+	client := &http.Client{
+		Transport: rt,
 		Timeout:   opt.timeout, // 0 means no overall timeout
 	}
+	if opt.traceHTTP {
+		client.Transport = newTraceTransport(rt)
+		client.CheckRedirect = traceRedirect
+	}
+	return client
 }
 
 // httpReqWithRetry performs the request with basic exponential backoff on
@@ -929,7 +1663,8 @@ func httpReqWithRetry(ctx context.Context, client *http.Client, method, url stri
 				// drain body to reuse connection
 				io.Copy(io.Discard, resp.Body)
 				resp.Body.Close()
-				backoff(i, verbose)
+				noteRetry(ctx, resp.StatusCode)
+				backoff(ctx, i, verbose)
 				continue
 			}
 			return resp, nil
@@ -938,7 +1673,8 @@ func httpReqWithRetry(ctx context.Context, client *http.Client, method, url stri
 		if !isRetryableError(err) || i == attempts-1 {
 			break
 		}
-		backoff(i, verbose)
+		noteRetry(ctx, 0)
+		backoff(ctx, i, verbose)
 	}
 	return nil, lastErr
 }
@@ -969,7 +1705,7 @@ func isRetryableError(err error) bool {
 	return false
 }
 
-func backoff(i int, verbose bool) {
+func backoff(ctx context.Context, i int, verbose bool) {
 	// Exponential with jitter: base 500ms
 	base := 500 * time.Millisecond
 	d := time.Duration(1<<i) * base
@@ -979,8 +1715,6 @@ func backoff(i int, verbose bool) {
 	if sleep < 100*time.Millisecond {
 		sleep = 100 * time.Millisecond
 	}
-	if verbose {
-		fmt.Printf("retrying in %v...\n", sleep)
-	}
+	logf(ctx, verbose, "retrying in %v...\n", sleep)
 	time.Sleep(sleep)
 }
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/internal/registrytest"
+	"ollama-model-downloader/models"
+)
+
+const modelMediaType = "application/vnd.ollama.image.model"
+
+// testModel serves test/m:latest with a random model layer and returns the
+// registry, its server and the layer.
+func testModel(t *testing.T, size int) (*registrytest.Registry, *httptest.Server, []byte) {
+	t.Helper()
+	layer := make([]byte, size)
+	rand.Read(layer)
+	reg := registrytest.New()
+	reg.AddModel("test/m", "latest", []byte(`{"model_format":"gguf"}`),
+		registrytest.Layer{MediaType: modelMediaType, Data: layer},
+		registrytest.Layer{MediaType: "application/vnd.ollama.image.template", Data: []byte("{{ .Prompt }}")},
+	)
+	srv := httptest.NewServer(reg)
+	t.Cleanup(srv.Close)
+	return reg, srv, layer
+}
+
+func testOptions(t *testing.T, registry string) options {
+	t.Helper()
+	opt := options{
+		registry:    registry,
+		platform:    "linux/amd64",
+		concurrency: 2,
+		outputDir:   t.TempDir(),
+	}
+	return withModel(opt, "test/m:latest")
+}
+
+func readZip(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer zr.Close()
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		var buf bytes.Buffer
+		buf.ReadFrom(rc)
+		rc.Close()
+		files[f.Name] = buf.Bytes()
+	}
+	return files
+}
+
+func TestRunPackagesModel(t *testing.T) {
+	reg, srv, layer := testModel(t, 64<<10)
+	reg.RequireAuth = true
+	opt := testOptions(t, srv.URL)
+
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	files := readZip(t, opt.outZip)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if _, ok := files["manifests/"+host+"/test/m/latest"]; !ok {
+		t.Errorf("manifest missing from archive; entries: %v", keys(files))
+	}
+	if got := files["blobs/"+blobFileName(registrytest.Digest(layer))]; !bytes.Equal(got, layer) {
+		t.Errorf("model layer in archive has %d bytes, want %d", len(got), len(layer))
+	}
+	var report models.VerificationReport
+	if err := json.Unmarshal(files["docs/"+host+"/test/m/latest/verification.json"], &report); err != nil {
+		t.Fatalf("verification report: %v", err)
+	}
+	if !report.OK || len(report.Blobs) != 3 {
+		t.Errorf("unexpected verification report: ok=%v blobs=%d", report.OK, len(report.Blobs))
+	}
+	meta, err := models.LoadSessionMeta(opt.stagingDir)
+	if err != nil {
+		t.Fatalf("LoadSessionMeta() error = %v", err)
+	}
+	if meta.State != models.StateCompleted || meta.BytesDone != meta.TotalBytes {
+		t.Errorf("session = %s with %d/%d bytes, want completed", meta.State, meta.BytesDone, meta.TotalBytes)
+	}
+	sidecar, _ := os.ReadFile(opt.outZip + checksumSuffix)
+	sums, err := os.ReadFile(filepath.Join(filepath.Dir(opt.outZip), sumsFileName))
+	if err != nil || len(sidecar) == 0 || !bytes.Contains(sums, sidecar) {
+		t.Errorf("%s = %q (%v), want the zip's checksum %q", sumsFileName, sums, err, sidecar)
+	}
+}
+
+func TestRunSkipsStagedBlobs(t *testing.T) {
+	reg, srv, layer := testModel(t, 64<<10)
+	opt := testOptions(t, srv.URL)
+	blobsDir := filepath.Join(opt.stagingDir, "models", "blobs")
+	os.MkdirAll(blobsDir, 0o755)
+	digest := registrytest.Digest(layer)
+	if err := os.WriteFile(filepath.Join(blobsDir, blobFileName(digest)), layer, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if n := reg.Requests(digest); n != 0 {
+		t.Errorf("staged blob fetched %d times", n)
+	}
+}
+
+func TestRunResumesInterruptedBlob(t *testing.T) {
+	reg, srv, layer := testModel(t, 256<<10)
+	opt := testOptions(t, srv.URL)
+	digest := registrytest.Digest(layer)
+	reg.TruncateNext(digest, 100<<10)
+
+	if err := run(context.Background(), opt); err == nil {
+		t.Fatal("run() succeeded although the blob was cut off")
+	}
+	part := filepath.Join(opt.stagingDir, "models", "blobs", blobFileName(digest)+".part")
+	st, err := os.Stat(part)
+	if err != nil || st.Size() == 0 {
+		t.Fatalf("no partial blob kept after the failed run: %v", err)
+	}
+
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("second run() error = %v", err)
+	}
+	if got, want := reg.Ranges(digest), []string{"bytes=" + strconv.FormatInt(st.Size(), 10) + "-"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("Range requests = %v, want %v", got, want)
+	}
+	if got := readZip(t, opt.outZip)["blobs/"+blobFileName(digest)]; !bytes.Equal(got, layer) {
+		t.Error("resumed blob differs from the original")
+	}
+}
+
+func TestRunOfflineResume(t *testing.T) {
+	_, srv, _ := testModel(t, 64<<10)
+	opt := testOptions(t, srv.URL)
+	opt.keepStaging = true
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	srv.Close()
+	os.Remove(opt.outZip)
+
+	opt.offline = true
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("offline run() error = %v", err)
+	}
+	if _, err := os.Stat(opt.outZip); err != nil {
+		t.Errorf("offline run wrote no archive: %v", err)
+	}
+}
+
+func TestRunResumesInterruptedPackaging(t *testing.T) {
+	_, srv, layer := testModel(t, 64<<10)
+	opt := testOptions(t, srv.URL)
+	opt.keepStaging = true
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	// the process died while writing the archive
+	if err := os.Rename(opt.outZip, opt.outZip+".partial"); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() after interrupted packaging error = %v", err)
+	}
+	if got := readZip(t, opt.outZip)["blobs/"+blobFileName(registrytest.Digest(layer))]; !bytes.Equal(got, layer) {
+		t.Error("rebuilt archive lacks the model layer")
+	}
+	if _, err := os.Stat(opt.outZip + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("partial archive left behind: %v", err)
+	}
+}
+
+func TestRunMaxSize(t *testing.T) {
+	reg, srv, layer := testModel(t, 64<<10)
+	opt := testOptions(t, srv.URL)
+	opt.maxSize = 32 << 10
+
+	err := run(context.Background(), opt)
+	if err == nil || !strings.Contains(err.Error(), "-max-size") {
+		t.Fatalf("run() error = %v, want the -max-size limit", err)
+	}
+	if n := reg.Requests(registrytest.Digest(layer)); n != 0 {
+		t.Errorf("model layer fetched %d times despite the limit", n)
+	}
+
+	opt.yes = true
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() with yes error = %v", err)
+	}
+}
+
+func TestRunQuota(t *testing.T) {
+	reg, srv, layer := testModel(t, 64<<10)
+	opt := testOptions(t, srv.URL)
+	opt.quota = 100 << 10
+	opt.yes = true
+
+	err := run(context.Background(), opt)
+	if err == nil || !strings.Contains(err.Error(), "-quota") {
+		t.Fatalf("run() error = %v, want the -quota limit", err)
+	}
+	if n := reg.Requests(registrytest.Digest(layer)); n != 0 {
+		t.Errorf("model layer fetched %d times despite the quota", n)
+	}
+
+	opt.quota = 1 << 20
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() under quota error = %v", err)
+	}
+	u, err := outputUsage(opt, opt.outputDir)
+	if err != nil || u.UsedBytes < 64<<10 || u.QuotaBytes != opt.quota {
+		t.Errorf("outputUsage() = %+v, %v", u, err)
+	}
+}
+
+// TestRunQuotaConcurrent runs two sessions at once that each fit under
+// -quota but not together: the second must see what the first reserved.
+func TestRunQuotaConcurrent(t *testing.T) {
+	reg, srv, _ := testModel(t, 64<<10)
+	other := make([]byte, 64<<10)
+	rand.Read(other)
+	reg.AddModel("test/m", "other", []byte(`{"model_format":"gguf"}`), registrytest.Layer{MediaType: modelMediaType, Data: other})
+	reg.Latency = 20 * time.Millisecond
+	opt := testOptions(t, srv.URL)
+	opt.quota = 200 << 10
+	opt.yes = true
+	opt.quotaReservations = newQuotaReservations()
+
+	errs := make(chan error, 2)
+	for _, model := range []string{"test/m:latest", "test/m:other"} {
+		mopt := opt
+		mopt.outZip = ""
+		mopt = withModel(mopt, model)
+		go func() { errs <- run(context.Background(), mopt) }()
+	}
+	var refused int
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			if !strings.Contains(err.Error(), "-quota") {
+				t.Fatalf("run() error = %v", err)
+			}
+			refused++
+		}
+	}
+	if refused != 1 {
+		t.Errorf("%d of 2 sessions refused, want 1", refused)
+	}
+	opt.quotaReservations.mu.Lock()
+	defer opt.quotaReservations.mu.Unlock()
+	if n := len(opt.quotaReservations.holds); n != 0 {
+		t.Errorf("%d reservations left after both runs", n)
+	}
+}
+
+func TestRunNameTemplate(t *testing.T) {
+	if _, err := parseNameTemplate("{{.Model}}-{{.Size}}"); err == nil {
+		t.Error("parseNameTemplate() accepted an unknown field")
+	}
+	tmpl, err := parseNameTemplate("{{.Model}}-{{.Tag}}-{{.Platform}}-{{.Digest8}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, srv, _ := testModel(t, 1<<10)
+	opt := testOptions(t, srv.URL)
+	opt.nameTemplate = tmpl
+
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	meta, err := models.LoadSessionMeta(opt.stagingDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(opt.outputDir, "m-latest-linux-amd64-"+strings.TrimPrefix(meta.ManifestDigest, "sha256:")[:8]+".zip")
+	if got := archivePath(opt); got != want {
+		t.Errorf("archivePath() = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("templated archive: %v", err)
+	}
+
+	// An explicit -o wins over the template.
+	explicit := testOptions(t, srv.URL)
+	explicit.outZip, explicit.defaultZip = filepath.Join(explicit.outputDir, "mine.zip"), false
+	explicit.nameTemplate = tmpl
+	if err := run(context.Background(), explicit); err != nil {
+		t.Fatalf("run() with -o error = %v", err)
+	}
+	if got := archivePath(explicit); got != explicit.outZip {
+		t.Errorf("archivePath() with -o = %q, want %q", got, explicit.outZip)
+	}
+}
+
+func TestRunCompression(t *testing.T) {
+	_, srv, layer := testModel(t, 64<<10)
+	for compression, method := range map[string]uint16{compressionNone: zip.Store, compressionFast: zip.Deflate, compressionBest: zip.Deflate} {
+		opt := testOptions(t, srv.URL)
+		opt.compression, opt.zipWorkers = compression, 2
+		if err := run(context.Background(), opt); err != nil {
+			t.Fatalf("run() with %s error = %v", compression, err)
+		}
+		zr, err := zip.OpenReader(opt.outZip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() && f.Method != method {
+				t.Errorf("%s: %s stored with method %d, want %d", compression, f.Name, f.Method, method)
+			}
+		}
+		zr.Close()
+		if got := readZip(t, opt.outZip)["blobs/"+blobFileName(registrytest.Digest(layer))]; !bytes.Equal(got, layer) {
+			t.Errorf("%s: model layer differs after packaging", compression)
+		}
+	}
+	if _, _, err := zipCompression("zstd"); err == nil {
+		t.Error("zipCompression() accepted zstd")
+	}
+}
+
+func TestRunLayerFilter(t *testing.T) {
+	reg, srv, layer := testModel(t, 64<<10)
+	template := registrytest.Digest([]byte("{{ .Prompt }}"))
+	opt := testOptions(t, srv.URL)
+	opt.keepStaging = true
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	// Staged but left out: not packaged.
+	opt.layers, _ = parseLayerFilter("-template")
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatalf("run() with -layers error = %v", err)
+	}
+	files := readZip(t, opt.outZip)
+	if _, ok := files["blobs/"+blobFileName(template)]; ok {
+		t.Error("left-out template layer was packaged")
+	}
+	if _, ok := files["blobs/"+blobFileName(registrytest.Digest(layer))]; !ok {
+		t.Error("model layer missing from the archive")
+	}
+
+	// Not staged and left out: not fetched.
+	fresh := testOptions(t, srv.URL)
+	fresh.layers, _ = parseLayerFilter("model")
+	before := reg.Requests(template)
+	if err := run(context.Background(), fresh); err != nil {
+		t.Fatalf("run() with -layers model error = %v", err)
+	}
+	if n := reg.Requests(template) - before; n != 0 {
+		t.Errorf("left-out template layer fetched %d times", n)
+	}
+}
+
+func TestRunSuggestsTags(t *testing.T) {
+	reg, srv, _ := testModel(t, 1<<10)
+	reg.AddModel("test/m", "8b", []byte(`{}`))
+	opt := withModel(testOptions(t, srv.URL), "test/m:lates")
+
+	err := run(context.Background(), opt)
+	if err == nil || !strings.Contains(err.Error(), "test/m:lates not found; did you mean test/m:latest?") {
+		t.Errorf("run() error = %v, want a suggestion of test/m:latest", err)
+	}
+	if apperrors.KindOf(err) != apperrors.KindNotFound {
+		t.Errorf("error kind = %q, want not_found", apperrors.KindOf(err))
+	}
+}
+
+func TestSelectPlatform(t *testing.T) {
+	var idx imageIndex
+	if err := json.Unmarshal([]byte(`{"manifests": [
+		{"digest": "sha256:b", "platform": {"os": "linux", "architecture": "amd64"}},
+		{"digest": "sha256:a", "platform": {"os": "linux", "architecture": "amd64"}},
+		{"digest": "sha256:c", "platform": {"os": "darwin", "architecture": "arm64"}},
+		{"digest": "sha256:d", "platform": {"os": "windows", "architecture": "amd64"}}
+	]}`), &idx); err != nil {
+		t.Fatal(err)
+	}
+	for platform, want := range map[string]string{"linux/amd64": "sha256:a", "amd64": "sha256:a", "darwin/arm64": "sha256:c", "Windows/AMD64": "sha256:d"} {
+		if got, err := selectPlatform(idx, platform); err != nil || got != want {
+			t.Errorf("selectPlatform(%s) = %s, %v, want %s", platform, got, err, want)
+		}
+	}
+	_, err := selectPlatform(idx, "linux/arm64")
+	if err == nil || !strings.Contains(err.Error(), "available: darwin/arm64, linux/amd64, windows/amd64") {
+		t.Errorf("selectPlatform(linux/arm64) error = %v, want the available platforms", err)
+	}
+	if _, _, err := parsePlatform("freebsd/amd64"); err == nil {
+		t.Error("parsePlatform() accepted freebsd")
+	}
+
+	for platform, want := range map[string]string{"linux/arm64": "darwin/arm64", "darwin/amd64": "linux/amd64", "windows/arm64": "darwin/arm64"} {
+		if got, ok := fallbackPlatform(idx, platform); !ok || got != want {
+			t.Errorf("fallbackPlatform(%s) = %s, %v, want %s", platform, got, ok, want)
+		}
+	}
+	var attestations imageIndex
+	json.Unmarshal([]byte(`{"manifests": [{"digest": "sha256:e", "platform": {"os": "unknown", "architecture": "unknown"}}]}`), &attestations)
+	if got, ok := fallbackPlatform(attestations, "linux/amd64"); ok {
+		t.Errorf("fallbackPlatform() chose %s from an index without supported platforms", got)
+	}
+}
+
+func TestClosest(t *testing.T) {
+	tags := []string{"latest", "8b", "70b", "8b-instruct-q4_0", "405b"}
+	if got := closest("7b", tags); strings.Join(got, ",") != "70b,8b" {
+		t.Errorf("closest(7b) = %v", got)
+	}
+	if got := closest("zzzzzz", tags); len(got) != 0 {
+		t.Errorf("closest(zzzzzz) = %v, want nothing", got)
+	}
+}
+
+func TestConfirmDownload(t *testing.T) {
+	ref := modelRef{Host: "registry.ollama.ai", Repository: "library/llama3", ReferenceTag: "70b"}
+	for input, want := range map[string]error{"y\n": nil, "YES\n": nil, "n\n": errDeclined, "\n": errDeclined, "": errDeclined} {
+		var out bytes.Buffer
+		err := confirmDownload(context.Background(), strings.NewReader(input), &out, ref, 3, 40<<30)
+		if err != want {
+			t.Errorf("answer %q: error = %v, want %v", input, err, want)
+		}
+		if !strings.Contains(out.String(), "registry.ollama.ai/library/llama3:70b") || !strings.Contains(out.String(), "40.00 GiB") {
+			t.Errorf("prompt = %q", out.String())
+		}
+	}
+}
+
+func TestDownloadBlob(t *testing.T) {
+	data := make([]byte, 32<<10)
+	rand.Read(data)
+
+	tests := []struct {
+		name     string
+		setup    func(reg *registrytest.Registry, digest, dir string)
+		retries  int
+		wantErr  string
+		wantKind apperrors.Kind
+		ranges   int
+	}{
+		{name: "fresh"},
+		{
+			name: "resumes part file",
+			setup: func(reg *registrytest.Registry, digest, dir string) {
+				os.WriteFile(filepath.Join(dir, blobFileName(digest)+".part"), data[:1000], 0o644)
+			},
+			ranges: 1,
+		},
+		{
+			name: "range ignored",
+			setup: func(reg *registrytest.Registry, digest, dir string) {
+				reg.IgnoreRange = true
+				os.WriteFile(filepath.Join(dir, blobFileName(digest)+".part"), data[:1000], 0o644)
+			},
+			ranges: 1,
+		},
+		{
+			name: "retries server errors",
+			setup: func(reg *registrytest.Registry, digest, dir string) {
+				reg.FailNext(digest, http.StatusServiceUnavailable)
+			},
+			retries: 1,
+		},
+		{
+			name: "gives up after retries",
+			setup: func(reg *registrytest.Registry, digest, dir string) {
+				reg.FailNext(digest, http.StatusBadGateway)
+			},
+			wantErr: "502",
+		},
+		{
+			name: "rejects corrupt blob",
+			setup: func(reg *registrytest.Registry, digest, dir string) {
+				reg.Corrupt(digest)
+			},
+			wantErr:  "sha256 mismatch",
+			wantKind: apperrors.KindChecksum,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := registrytest.New()
+			digest := reg.AddBlob(data)
+			srv := httptest.NewServer(reg)
+			defer srv.Close()
+			dir := t.TempDir()
+			if tt.setup != nil {
+				tt.setup(reg, digest, dir)
+			}
+
+			err := downloadBlob(context.Background(), srv.Client(), srv.URL, "test/m", digest, "", dir, tt.retries, nil, int64(len(data)), false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("downloadBlob() error = %v, want %q", err, tt.wantErr)
+				}
+				if got := apperrors.KindOf(err); got != tt.wantKind {
+					t.Errorf("error kind = %q, want %q", got, tt.wantKind)
+				}
+				if _, err := os.Stat(filepath.Join(dir, blobFileName(digest))); err == nil {
+					t.Error("blob stored despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("downloadBlob() error = %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, blobFileName(digest)))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("stored blob differs (%v)", err)
+			}
+			if n := len(reg.Ranges(digest)); n != tt.ranges {
+				t.Errorf("%d Range requests, want %d", n, tt.ranges)
+			}
+		})
+	}
+}
+
+func keys(m map[string][]byte) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
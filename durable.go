@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// durableWrites is set by -durable. Blobs are then fsynced before they are
+// renamed into place, and the blobs directory before the rename is relied
+// on or a session checkpoint claims the bytes, so a power cut cannot leave
+// a complete-looking blob or a session.json that is ahead of the disk.
+// Without it the page cache decides, which is much faster on slow disks.
+var durableWrites bool
+
+// syncPath fsyncs the file or directory at path. Windows cannot fsync a
+// directory; renames there are made durable by the file system itself.
+func syncPath(path string) error {
+	if runtime.GOOS == "windows" {
+		if st, err := os.Stat(path); err == nil && st.IsDir() {
+			return nil
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// commitBlob renames the finished download tmp to dst, with -durable
+// syncing the file first and its directory after.
+func commitBlob(tmp, dst string) error {
+	if durableWrites {
+		if err := syncPath(tmp); err != nil {
+			return err
+		}
+		if err := syncPath(filepath.Dir(dst)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	if durableWrites {
+		return syncPath(filepath.Dir(dst))
+	}
+	return nil
+}
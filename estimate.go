@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// sizeEstimate describes what downloading a model would cost, without
+// downloading it.
+type sizeEstimate struct {
+	Model          string          `json:"model"`
+	Repository     string          `json:"repository"`
+	Reference      string          `json:"reference"`
+	ManifestDigest string          `json:"manifestDigest"`
+	TotalBytes     int64           `json:"totalBytes"`
+	LayerCount     int             `json:"layerCount"`
+	CachedBytes    int64           `json:"cachedBytes"`    // already in the session's staging directory
+	RemainingBytes int64           `json:"remainingBytes"` // TotalBytes - CachedBytes
+	Installed      bool            `json:"installed"`      // every blob is already in Ollama's models directory
+	Layers         []layerEstimate `json:"layers"`
+}
+
+type layerEstimate struct {
+	Digest      string `json:"digest"`
+	MediaType   string `json:"mediaType"`
+	Size        int64  `json:"size"`
+	CachedBytes int64  `json:"cachedBytes"`
+	Installed   bool   `json:"installed"`
+}
+
+// estimateModel resolves opt.model (auth, index and platform selection) and
+// sizes its blobs. Blobs count as cached when they, or their .part files,
+// are in opt.stagingDir, and as installed when Ollama already has them.
+func estimateModel(ctx context.Context, opt options) (sizeEstimate, error) {
+	ref, err := parseModel(opt.registry, opt.model, opt.modelConfig)
+	if err != nil {
+		return sizeEstimate{}, err
+	}
+	if opt, err = withProfile(opt, ref); err != nil {
+		return sizeEstimate{}, err
+	}
+	res, err := resolveManifest(ctx, newHTTPClient(opt), opt, ref)
+	if err != nil {
+		return sizeEstimate{}, err
+	}
+
+	est := sizeEstimate{
+		Model:          opt.model,
+		Repository:     res.ref.Repository,
+		Reference:      res.ref.Reference,
+		ManifestDigest: manifestDigest(res.raw),
+		LayerCount:     len(res.manifest.Layers),
+	}
+	blobsDir := filepath.Join(opt.stagingDir, "models", "blobs")
+	var installedDir string
+	if dir, err := ollamaModelsDir(); err == nil {
+		installedDir = filepath.Join(dir, "blobs")
+	}
+
+	layers := res.manifest.Layers
+	if c := res.manifest.Config; c.Digest != "" {
+		layers = append(layers[:0:0], c)
+		layers = append(layers, res.manifest.Layers...)
+	}
+	seen := map[string]bool{}
+	est.Installed = installedDir != ""
+	for _, it := range layers {
+		if seen[it.Digest] {
+			continue
+		}
+		seen[it.Digest] = true
+		l := layerEstimate{Digest: it.Digest, MediaType: it.MediaType, Size: it.Size}
+		if opt.stagingDir != "" {
+			l.CachedBytes = existingBytesForBlob(blobsDir, it.Digest, it.Size)
+		}
+		if installedDir != "" {
+			st, err := os.Stat(filepath.Join(installedDir, blobFileName(it.Digest)))
+			l.Installed = err == nil && (it.Size <= 0 || st.Size() == it.Size)
+		}
+		est.Installed = est.Installed && l.Installed
+		est.TotalBytes += it.Size
+		est.CachedBytes += l.CachedBytes
+		est.Layers = append(est.Layers, l)
+	}
+	est.RemainingBytes = est.TotalBytes - est.CachedBytes
+	return est, nil
+}
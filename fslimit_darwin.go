@@ -0,0 +1,23 @@
+package main
+
+import "syscall"
+
+// fileSizeLimit returns the largest file the filesystem holding path can
+// hold and its name, or 0 when there is no limit worth knowing about.
+func fileSizeLimit(path string) (int64, string) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, ""
+	}
+	var name []byte
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	if string(name) != "msdos" {
+		return 0, ""
+	}
+	return fat32MaxFileSize, "FAT"
+}
@@ -0,0 +1,16 @@
+package main
+
+import "syscall"
+
+// msdosSuperMagic is the statfs type of FAT filesystems (vfat, msdos).
+const msdosSuperMagic = 0x4d44
+
+// fileSizeLimit returns the largest file the filesystem holding path can
+// hold and its name, or 0 when there is no limit worth knowing about.
+func fileSizeLimit(path string) (int64, string) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil || st.Type != msdosSuperMagic {
+		return 0, ""
+	}
+	return fat32MaxFileSize, "FAT"
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+func fileSizeLimit(path string) (int64, string) {
+	return 0, ""
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetVolumePathNameW    = syscall.NewLazyDLL("kernel32.dll").NewProc("GetVolumePathNameW")
+	procGetVolumeInformationW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetVolumeInformationW")
+)
+
+// fileSizeLimit returns the largest file the volume holding path can hold
+// and its file system name, or 0 when there is no limit worth knowing
+// about.
+func fileSizeLimit(path string) (int64, string) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, ""
+	}
+	root := make([]uint16, syscall.MAX_PATH+1)
+	if r, _, _ := procGetVolumePathNameW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&root[0])), uintptr(len(root))); r == 0 {
+		return 0, ""
+	}
+	fs := make([]uint16, syscall.MAX_PATH+1)
+	if r, _, _ := procGetVolumeInformationW.Call(uintptr(unsafe.Pointer(&root[0])), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&fs[0])), uintptr(len(fs))); r == 0 {
+		return 0, ""
+	}
+	name := syscall.UTF16ToString(fs)
+	if !strings.HasPrefix(strings.ToUpper(name), "FAT") {
+		return 0, "" // NTFS, ReFS and exFAT hold files of any size
+	}
+	return fat32MaxFileSize, name
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ollama-model-downloader/models"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "gc",
+		usage: "remove staged blobs no manifest or active session references",
+		run:   runGC,
+	})
+}
+
+type gcResult struct {
+	Removed   []string
+	Reclaimed int64
+	Skipped   []string
+}
+
+func runGC(opt options, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would be removed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	res, err := collectGarbage(opt.outputDir, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, s := range res.Skipped {
+		fmt.Printf("skipped active session: %s\n", s)
+	}
+	for _, p := range res.Removed {
+		if opt.verbose || *dryRun {
+			fmt.Println("remove:", p)
+		}
+	}
+	verb := "reclaimed"
+	if *dryRun {
+		verb = "would reclaim"
+	}
+	fmt.Printf("%d blob(s), %s %s\n", len(res.Removed), verb, humanBytes(res.Reclaimed))
+	return nil
+}
+
+// collectGarbage walks every staging session under outputDir and removes
+// blob files (including .part files) that none of the session's manifests
+// reference. Sessions that are currently downloading are left untouched so
+// a concurrent run() never loses a file it is writing.
+func collectGarbage(outputDir string, dryRun bool) (gcResult, error) {
+	var res gcResult
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return res, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".staging") {
+			continue
+		}
+		staging := filepath.Join(outputDir, entry.Name())
+		if meta, err := models.LoadSessionMeta(staging); err == nil && meta.State.IsActive() {
+			res.Skipped = append(res.Skipped, meta.SessionID)
+			continue
+		}
+		modelsRoot := filepath.Join(staging, "models")
+		refs, err := referencedBlobs(filepath.Join(modelsRoot, "manifests"))
+		if err != nil {
+			return res, err
+		}
+		if len(refs) == 0 {
+			// No manifest yet: nothing proves any blob is unused.
+			continue
+		}
+		blobsDir := filepath.Join(modelsRoot, "blobs")
+		blobs, err := os.ReadDir(blobsDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return res, err
+		}
+		for _, b := range blobs {
+			if b.IsDir() {
+				continue
+			}
+			name := strings.TrimSuffix(b.Name(), ".part")
+			if refs[name] {
+				continue
+			}
+			info, err := b.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(blobsDir, b.Name())
+			if !dryRun {
+				if err := os.Remove(path); err != nil {
+					return res, err
+				}
+			}
+			res.Removed = append(res.Removed, path)
+			res.Reclaimed += info.Size()
+		}
+	}
+	return res, nil
+}
+
+// referencedBlobs returns the blob file names (sha256-<hex>) referenced by
+// any manifest stored below manifestsDir.
+func referencedBlobs(manifestsDir string) (map[string]bool, error) {
+	refs := make(map[string]bool)
+	err := filepath.WalkDir(manifestsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		m, err := decodeManifest(data)
+		if err != nil {
+			return nil
+		}
+		if m.Config.Digest != "" {
+			refs[blobFileName(m.Config.Digest)] = true
+		}
+		for _, l := range m.Layers {
+			refs[blobFileName(l.Digest)] = true
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// blobFileName maps a digest ("sha256:<hex>") to its on-disk name.
+func blobFileName(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
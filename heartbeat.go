@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+// sessionRecord is the session.json of a running download. Phase changes
+// and the heartbeat both write it, so every write goes through update.
+type sessionRecord struct {
+	mu       sync.Mutex
+	meta     models.SessionMeta
+	p        *progress // set once the blob list is known
+	blobsDir string
+}
+
+// update applies fn to the metadata and saves it along with the current
+// byte counts.
+func (r *sessionRecord) update(fn func(m *models.SessionMeta)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if fn != nil {
+		fn(&r.meta)
+	}
+	if r.p != nil {
+		r.meta.BytesDone = atomic.LoadInt64(&r.p.done)
+	}
+	for i := range r.meta.Blobs {
+		b := &r.meta.Blobs[i]
+		b.Done = existingBytesForBlob(r.blobsDir, b.Digest, b.Size)
+	}
+	if durableWrites {
+		r.syncBlobs()
+	}
+	return models.SaveSessionMeta(r.meta)
+}
+
+// syncBlobs fsyncs the partial blobs and the blobs directory so the
+// checkpoint about to be written does not count bytes a power cut would
+// lose. Errors are ignored: the checkpoint is only ever an estimate, and
+// every blob is verified before it is packaged.
+func (r *sessionRecord) syncBlobs() {
+	if r.blobsDir == "" {
+		return
+	}
+	for _, b := range r.meta.Blobs {
+		if b.Done > 0 && b.Done < b.Size {
+			syncPath(filepath.Join(r.blobsDir, blobFileName(b.Digest)+".part"))
+		}
+	}
+	syncPath(r.blobsDir)
+}
+
+// track records the blob inventory of the run and starts reporting p.
+func (r *sessionRecord) track(p *progress, blobsDir string, items []blobItem, total int64) error {
+	blobs := make([]models.SessionBlob, 0, len(items))
+	for _, it := range items {
+		blobs = append(blobs, models.SessionBlob{Digest: it.digest, MediaType: it.mediaType, Size: it.size})
+	}
+	r.mu.Lock()
+	r.p, r.blobsDir = p, blobsDir
+	r.mu.Unlock()
+	return r.update(func(m *models.SessionMeta) {
+		m.TotalBytes = total
+		m.Blobs = blobs
+	})
+}
+
+// heartbeat refreshes LastUpdated and BytesDone every
+// models.HeartbeatInterval until the returned stop function is called, so
+// readers can tell a live session from one whose process died.
+func (r *sessionRecord) heartbeat() (stop func()) {
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(models.HeartbeatInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				_ = r.update(nil)
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+			<-done
+		})
+	}
+}
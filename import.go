@@ -0,0 +1,353 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "import",
+		usage: "install model archives (zip, tar, tar.gz, tar.zst, split tar) or models directories into the Ollama models directory",
+		run:   runImport,
+	})
+}
+
+func runImport(opt options, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	verify := flags.Bool("verify", false, "require the .sha256 sidecar, check it and every entry, and check .asc signatures that are present; a directory has its blobs hashed")
+	signed := flags.Bool("signed", false, "with -verify, also require the .asc signatures of the archive and its .sha256")
+	dir := flags.String("dir", "", "models directory to extract into (default: the one Ollama uses)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: import [-verify [-signed]] [-dir path] <archive or directory>...")
+	}
+	if *signed && !*verify {
+		return errors.New("-signed needs -verify")
+	}
+	dest := *dir
+	if dest == "" {
+		d, err := ollamaModelsDir()
+		if err != nil {
+			return err
+		}
+		dest = d
+	}
+	for _, archive := range flags.Args() {
+		if err := importArchive(archive, dest, *verify, *signed); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(archive), err)
+		}
+		fmt.Printf("imported %s into %s\n", filepath.Base(archive), dest)
+	}
+	return nil
+}
+
+// Formats importArchive accepts, told apart by importFormat.
+const (
+	importDir    = "directory" // a models directory (manifests/ and blobs/), or one holding models/
+	importZip    = "zip"
+	importTar    = "tar"
+	importTarGz  = "tar.gz"
+	importTarZst = "tar.zst" // decompressed with the zstd command
+)
+
+// importFormat detects the format of path from its first bytes, falling
+// back to the file extension for an uncompressed tar with an old header.
+func importFormat(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return importDir, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return importZip, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return importTarGz, nil
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return importTarZst, nil
+	case len(head) >= 262 && string(head[257:262]) == "ustar", strings.HasSuffix(path, ".tar"):
+		return importTar, nil
+	}
+	return "", fmt.Errorf("unrecognized format (want a directory, zip, tar, tar.gz or tar.zst)")
+}
+
+// importArchive installs archive into dest, whatever its format. With
+// verify nothing is written unless the checksum sidecar written at download
+// time is there and matches, every entry reads back intact, and the gpg
+// signatures next to the archive check out; signed makes those signatures
+// mandatory. A directory has no sidecars: verify hashes its blobs instead.
+func importArchive(archive, dest string, verify, signed bool) error {
+	format, err := importFormat(archive)
+	if err != nil {
+		return err
+	}
+	if format == importDir {
+		if signed {
+			return errors.New("-signed needs an archive with .asc signatures, not a directory")
+		}
+		root := archive
+		if st, err := os.Stat(filepath.Join(archive, "models", "manifests")); err == nil && st.IsDir() {
+			root = filepath.Join(archive, "models")
+		}
+		if verify {
+			if err := verifyBlobsDir(filepath.Join(root, "blobs")); err != nil {
+				return err
+			}
+		}
+		return copyTree(root, dest)
+	}
+
+	if verify {
+		for _, part := range tarParts(archive) {
+			if _, err := os.Stat(part + checksumSuffix); err != nil {
+				if os.IsNotExist(err) {
+					return fmt.Errorf("no checksum file %s", filepath.Base(part)+checksumSuffix)
+				}
+				return err
+			}
+			if err := verifySignatures(signed, part, part+checksumSuffix); err != nil {
+				return err
+			}
+		}
+		if format == importZip {
+			err = verifyArchive(archive)
+		} else {
+			err = verifyTar(archive, format)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if format == importZip {
+		return unzipToDir(archive, dest)
+	}
+	rc, err := openTar(archive, format)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return untar(rc, dest, importEntryName)
+}
+
+// importEntryName places a tar entry relative to the models directory:
+// tools that archive the directory itself prefix every entry with models/.
+func importEntryName(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	return strings.TrimPrefix(name, "models/")
+}
+
+// tarParts returns the files of the archive archive names: all its parts,
+// in order, when it is the .001 part of a split tar (see writeSplitTar),
+// and archive alone otherwise.
+func tarParts(archive string) []string {
+	base, ok := strings.CutSuffix(archive, ".001")
+	if !ok {
+		return []string{archive}
+	}
+	parts := []string{archive}
+	for i := 2; ; i++ {
+		part := splitPartName(base, i)
+		if _, err := os.Stat(part); err != nil {
+			return parts
+		}
+		parts = append(parts, part)
+	}
+}
+
+// openTar returns the uncompressed tar stream of archive; the parts of a
+// split tar are read one after another.
+func openTar(archive, format string) (io.ReadCloser, error) {
+	switch format {
+	case importTarZst:
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return nil, fmt.Errorf("zstd not found in PATH")
+		}
+		cmd := exec.Command("zstd", "-dcq", archive)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &cmdReader{ReadCloser: out, cmd: cmd, stderr: &stderr}, nil
+	case importTarGz:
+		f, err := os.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gz, f}, nil
+	}
+	var readers []io.Reader
+	var files multiCloser
+	for _, part := range tarParts(archive) {
+		f, err := os.Open(part)
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		readers = append(readers, f)
+		files = append(files, f)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(readers...), files}, nil
+}
+
+// multiCloser closes all its files.
+type multiCloser []*os.File
+
+func (c multiCloser) Close() error {
+	var first error
+	for _, f := range c {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// cmdReader is the stdout of a running command; Close waits for it and
+// reports how it failed.
+type cmdReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (r *cmdReader) Close() error {
+	io.Copy(io.Discard, r.ReadCloser)
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %v: %s", filepath.Base(r.cmd.Path), err, bytes.TrimSpace(r.stderr.Bytes()))
+	}
+	return nil
+}
+
+// verifyTar checks a tar archive (each part of a split one) against its
+// checksum sidecar and reads it through, so a damaged compressed stream or
+// a truncated file fails, and hashes each blob against the digest in its
+// name.
+func verifyTar(archive, format string) error {
+	for _, part := range tarParts(archive) {
+		if err := verifyChecksumSidecar(part); err != nil {
+			return err
+		}
+	}
+	rc, err := openTar(archive, format)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break // tar padding may follow; read it so gzip checks its trailer
+		}
+		if err != nil {
+			rc.Close()
+			return err
+		}
+		_, sum, err := hashReader(tr, nil)
+		if err != nil {
+			rc.Close()
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		if digest, ok := digestFromBlobName(pathBase(hdr.Name)); ok && "sha256:"+sum != digest {
+			rc.Close()
+			return fmt.Errorf("%s: sha256 mismatch", hdr.Name)
+		}
+	}
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		rc.Close()
+		return err
+	}
+	return rc.Close()
+}
+
+// verifyBlobsDir hashes every blob in dir against the digest in its name.
+func verifyBlobsDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		digest, ok := digestFromBlobName(e.Name())
+		if !ok {
+			continue
+		}
+		ok, err := verifyFileHash(filepath.Join(dir, e.Name()), strings.TrimPrefix(digest, "sha256:"))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s: sha256 mismatch", e.Name())
+		}
+	}
+	return nil
+}
+
+// copyTree copies the regular files below root to the same paths below
+// dest.
+func copyTree(root, dest string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := copyBody(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportArchiveVerify(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "models")
+	manifest := filepath.Join(root, "manifests", "registry.ollama.ai", "library", "m", "latest")
+	if err := os.MkdirAll(filepath.Dir(manifest), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(manifest, []byte(`{"layers":[]}`), 0o644)
+	archive := filepath.Join(dir, "m.zip")
+	sum, err := zipDir(root, archive, zipOptions{compression: "none", workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "ollama")
+	imported := filepath.Join(dest, "manifests", "registry.ollama.ai", "library", "m", "latest")
+
+	if err := importArchive(archive, dest, true, false); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("import -verify without a sidecar = %v", err)
+	}
+	if _, err := os.Stat(imported); !os.IsNotExist(err) {
+		t.Fatal("a refused archive was extracted")
+	}
+
+	os.WriteFile(archive+checksumSuffix, []byte(strings.Repeat("0", 64)+"  m.zip\n"), 0o644)
+	if err := importArchive(archive, dest, true, false); err == nil {
+		t.Fatal("import -verify accepted a wrong checksum")
+	}
+
+	if _, err := writeChecksumSidecar(archive, sum); err != nil {
+		t.Fatal(err)
+	}
+	if err := importArchive(archive, dest, true, true); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("import -verify -signed without signatures = %v", err)
+	}
+	if err := importArchive(archive, dest, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(imported); err != nil {
+		t.Errorf("manifest not extracted: %v", err)
+	}
+}
+
+func TestImportFormats(t *testing.T) {
+	dir := t.TempDir()
+	blob := []byte("weights")
+	sum := sha256.Sum256(blob)
+	blobName := "sha256-" + hex.EncodeToString(sum[:])
+	files := map[string][]byte{
+		"manifests/registry.ollama.ai/library/m/latest": []byte(`{"layers":[]}`),
+		"blobs/" + blobName:                             blob,
+	}
+
+	raw := filepath.Join(dir, "raw")
+	for name, data := range files {
+		path := filepath.Join(raw, "models", filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, data, 0o644)
+	}
+	writeTar := func(name string, gz bool, prefix string, blobData []byte) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var w io.Writer = f
+		var zw *gzip.Writer
+		if gz {
+			zw = gzip.NewWriter(f)
+			w = zw
+		}
+		tw := tar.NewWriter(w)
+		for name, data := range files {
+			if strings.HasPrefix(name, "blobs/") {
+				data = blobData
+			}
+			tw.WriteHeader(&tar.Header{Name: prefix + name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+			tw.Write(data)
+		}
+		tw.Close()
+		if zw != nil {
+			zw.Close()
+		}
+		f.Close()
+		return path
+	}
+
+	for _, tc := range []struct {
+		path, format string
+	}{
+		{raw, importDir},
+		{writeTar("m.tar.gz", true, "./models/", blob), importTarGz},
+		{writeTar("m.bin", false, "", blob), importTar},
+	} {
+		if format, err := importFormat(tc.path); format != tc.format || err != nil {
+			t.Errorf("importFormat(%s) = %q, %v; want %q", filepath.Base(tc.path), format, err, tc.format)
+			continue
+		}
+		if tc.format != importDir {
+			data, _ := os.ReadFile(tc.path)
+			s := sha256.Sum256(data)
+			writeChecksumSidecar(tc.path, hex.EncodeToString(s[:]))
+		}
+		dest := filepath.Join(dir, "ollama-"+filepath.Base(tc.path))
+		if err := importArchive(tc.path, dest, true, false); err != nil {
+			t.Errorf("import %s: %v", filepath.Base(tc.path), err)
+			continue
+		}
+		for name, data := range files {
+			if got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); err != nil || string(got) != string(data) {
+				t.Errorf("%s: %s = %q, %v", filepath.Base(tc.path), name, got, err)
+			}
+		}
+	}
+
+	// A blob that does not match its digest is caught before anything is
+	// written, even when the sidecar was made from the bad archive.
+	bad := writeTar("bad.tar.gz", true, "", []byte("tampered"))
+	data, _ := os.ReadFile(bad)
+	s := sha256.Sum256(data)
+	writeChecksumSidecar(bad, hex.EncodeToString(s[:]))
+	dest := filepath.Join(dir, "ollama-bad")
+	if err := importArchive(bad, dest, true, false); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("import of a tampered tar = %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("a refused tar was extracted")
+	}
+	if _, err := importFormat(filepath.Join(dir, "ollama-bad.txt")); err == nil {
+		t.Error("importFormat accepted a missing file")
+	}
+}
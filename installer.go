@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Archive layouts selected with -bundle.
+const (
+	bundleZip       = "zip"       // models layout at the root, extracted by hand
+	bundleInstaller = "installer" // models/ plus install scripts
+)
+
+// zipEntry is a file written into an archive from memory.
+type zipEntry struct {
+	name string
+	mode os.FileMode
+	data []byte
+}
+
+// installSh copies the models next to it into the Ollama models directory,
+// checking each new blob against its digest when sha256sum is available.
+const installSh = `#!/bin/sh
+# Installs the models in this archive into the Ollama models directory.
+# Usage: sh install.sh [models-dir]   (default: $OLLAMA_MODELS or ~/.ollama/models)
+set -e
+here=$(cd "$(dirname "$0")" && pwd)
+dest=${1:-${OLLAMA_MODELS:-$HOME/.ollama/models}}
+mkdir -p "$dest/blobs" "$dest/manifests"
+for f in "$here"/models/blobs/*; do
+	name=$(basename "$f")
+	[ -e "$dest/blobs/$name" ] && continue
+	if command -v sha256sum >/dev/null 2>&1; then
+		sum=$(sha256sum "$f" | cut -d' ' -f1)
+		if [ "sha256-$sum" != "$name" ]; then
+			echo "corrupt blob: $name" >&2
+			exit 1
+		fi
+	fi
+	cp "$f" "$dest/blobs/$name.part"
+	mv "$dest/blobs/$name.part" "$dest/blobs/$name"
+done
+cp -R "$here/models/manifests/." "$dest/manifests/"
+echo "installed into $dest"
+`
+
+// installPs1 is the Windows counterpart of installSh.
+const installPs1 = `# Installs the models in this archive into the Ollama models directory.
+# Usage: powershell -ExecutionPolicy Bypass -File install.ps1 [models-dir]
+param([string]$Dest = $(if ($env:OLLAMA_MODELS) { $env:OLLAMA_MODELS } else { Join-Path $HOME ".ollama\models" }))
+$ErrorActionPreference = "Stop"
+$src = Join-Path $PSScriptRoot "models"
+New-Item -ItemType Directory -Force -Path (Join-Path $Dest "blobs"), (Join-Path $Dest "manifests") | Out-Null
+Get-ChildItem (Join-Path $src "blobs") | ForEach-Object {
+	$target = Join-Path $Dest (Join-Path "blobs" $_.Name)
+	if (Test-Path $target) { return }
+	$sum = (Get-FileHash $_.FullName -Algorithm SHA256).Hash.ToLower()
+	if ("sha256-$sum" -ne $_.Name) { throw "corrupt blob: $($_.Name)" }
+	Copy-Item $_.FullName "$target.part"
+	Move-Item "$target.part" $target
+}
+Copy-Item -Recurse -Force (Join-Path $src "manifests\*") (Join-Path $Dest "manifests")
+Write-Host "installed into $Dest"
+`
+
+// installCmd lets Windows users double-click the installer.
+const installCmd = "@powershell -NoProfile -ExecutionPolicy Bypass -File \"%~dp0install.ps1\" %*\r\n"
+
+// packageArchive zips the models layout under modelsRoot into out in the
+// opt.bundle layout and returns the archive's sha256. Blob files named in
+// leftOut are not packaged.
+func packageArchive(opt options, modelsRoot, out string, leftOut map[string]bool) (string, error) {
+	prefix, extra, err := bundleLayout(opt.bundle)
+	if err != nil {
+		return "", err
+	}
+	zo := zipOptions{compression: opt.compression, workers: opt.zipWorkers, skip: leftOutSkip(leftOut)}
+	return writeZip(out, modelsRoot, prefix, extra, zo)
+}
+
+// bundleLayout returns where a -bundle layout puts the models directory in
+// the archive and the files it adds at the archive root.
+func bundleLayout(bundle string) (prefix string, extra []zipEntry, err error) {
+	switch bundle {
+	case "", bundleZip:
+		return "", nil, nil
+	case bundleInstaller:
+		return "models/", []zipEntry{
+			{name: "install.sh", mode: 0o755, data: []byte(installSh)},
+			{name: "install.ps1", mode: 0o644, data: []byte(installPs1)},
+			{name: "install.cmd", mode: 0o644, data: []byte(installCmd)},
+		}, nil
+	}
+	return "", nil, fmt.Errorf("unknown -bundle %q", bundle)
+}
+
+// leftOutSkip is the zipOptions skip function leaving out the blob files
+// named in leftOut, or nil when there are none.
+func leftOutSkip(leftOut map[string]bool) func(rel string) bool {
+	if len(leftOut) == 0 {
+		return nil
+	}
+	return func(rel string) bool {
+		name, ok := strings.CutPrefix(rel, "blobs/")
+		return ok && leftOut[name]
+	}
+}
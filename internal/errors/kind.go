@@ -0,0 +1,116 @@
+package errors
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	stderrors "errors"
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// Kind is a class of failure users can do something about. Errors keep
+// their message; the kind only selects the remediation hint shown next to
+// it in the CLI and the web UI.
+type Kind int
+
+const (
+	KindUnknown      Kind = iota
+	KindUnauthorized      // the registry or its token endpoint answered 401/403
+	KindNotFound          // unknown model, tag or blob
+	KindTLS               // certificate not trusted or handshake failed
+	KindDNS               // registry host did not resolve
+	KindDiskFull
+	KindChecksum // downloaded bytes do not match their digest
+)
+
+var kindNames = map[Kind]string{
+	KindUnauthorized: "unauthorized",
+	KindNotFound:     "not_found",
+	KindTLS:          "tls",
+	KindDNS:          "dns",
+	KindDiskFull:     "disk_full",
+	KindChecksum:     "checksum",
+}
+
+var kindHints = map[Kind]string{
+	KindUnauthorized: "the registry refused access: check the username and passwordEnv of its registry profile in the config file, or that the model is public",
+	KindNotFound:     "check the model name and tag (tags are case-sensitive); ollama.com/library lists the public models",
+	KindTLS:          "the registry's certificate is not trusted: add its CA as caFile in the registry profile, check the system clock, or use -insecure on a network you trust",
+	KindDNS:          "the registry host did not resolve: check its spelling, your DNS or proxy settings, or pin an address with -resolve",
+	KindDiskFull:     "the disk is full: free space in -output-dir or choose another one; finished blobs are kept, so running the command again resumes",
+	KindChecksum:     "downloaded bytes did not match their digest: run the command again to fetch them once more; if it keeps happening, a proxy may be altering downloads",
+}
+
+// String is the kind's identifier for JSON, e.g. "not_found"; "" for
+// KindUnknown.
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Hint is what users can do about a failure of kind k, or "".
+func (k Kind) Hint() string {
+	return kindHints[k]
+}
+
+type kindError struct {
+	kind Kind
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+func (e *kindError) Unwrap() error { return e.err }
+
+// WithKind marks err as kind. Nil errors and KindUnknown leave err
+// unchanged.
+func WithKind(kind Kind, err error) error {
+	if err == nil || kind == KindUnknown {
+		return err
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// KindOf returns the kind err was marked with or, for errors from the
+// network stack and the file system, the kind their type implies.
+func KindOf(err error) Kind {
+	if err == nil {
+		return KindUnknown
+	}
+	var ke *kindError
+	if stderrors.As(err, &ke) {
+		return ke.kind
+	}
+	var dnsErr *net.DNSError
+	if stderrors.As(err, &dnsErr) {
+		return KindDNS
+	}
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostErr      x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if stderrors.As(err, &verifyErr) || stderrors.As(err, &recordErr) || stderrors.As(err, &authorityErr) ||
+		stderrors.As(err, &hostErr) || stderrors.As(err, &invalidErr) {
+		return KindTLS
+	}
+	if isDiskFull(err) {
+		return KindDiskFull
+	}
+	return KindUnknown
+}
+
+// Hint returns the remediation hint for err, or "" when there is none.
+func Hint(err error) string {
+	return KindOf(err).Hint()
+}
+
+func isDiskFull(err error) bool {
+	if stderrors.Is(err, syscall.ENOSPC) {
+		return true
+	}
+	// ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL
+	var errno syscall.Errno
+	return runtime.GOOS == "windows" && stderrors.As(err, &errno) && (errno == 39 || errno == 112)
+}
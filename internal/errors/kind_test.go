@@ -0,0 +1,46 @@
+package errors
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Kind
+	}{
+		{"nil", nil, KindUnknown},
+		{"plain", fmt.Errorf("boom"), KindUnknown},
+		{"marked and wrapped", fmt.Errorf("resolve: %w", WithKind(KindNotFound, fmt.Errorf("manifest fetch failed: 404"))), KindNotFound},
+		{"dns", &url.Error{Op: "Get", URL: "https://registry.example", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "registry.example"}}}, KindDNS},
+		{"unknown authority", &url.Error{Op: "Get", URL: "https://registry.example", Err: x509.UnknownAuthorityError{}}, KindTLS},
+		{"disk full", fmt.Errorf("write blob: %w", &fs.PathError{Op: "write", Path: "blob.part", Err: syscall.ENOSPC}), KindDiskFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.err); got != tt.want {
+				t.Errorf("KindOf() = %q, want %q", got, tt.want)
+			}
+			if (Hint(tt.err) != "") != (tt.want != KindUnknown) {
+				t.Errorf("Hint() = %q", Hint(tt.err))
+			}
+		})
+	}
+}
+
+func TestWithKindKeepsMessage(t *testing.T) {
+	err := WithKind(KindChecksum, fmt.Errorf("sha256 mismatch"))
+	if err.Error() != "sha256 mismatch" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if WithKind(KindUnknown, nil) != nil {
+		t.Error("WithKind(nil) is not nil")
+	}
+}
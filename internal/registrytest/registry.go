@@ -0,0 +1,313 @@
+// Package registrytest provides an in-process fake of the registry API the
+// downloader talks to: a bearer token endpoint, manifests, tag lists and
+// blobs, with configurable latency and injected failures. It is an
+// http.Handler, so tests mount it with httptest.NewServer and demos with
+// any server.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is the bearer token the token endpoint hands out.
+const Token = "registrytest-token"
+
+const (
+	mediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeConfig   = "application/vnd.docker.container.image.v1+json"
+)
+
+// Layer is a layer of a model added with AddModel.
+type Layer struct {
+	MediaType string
+	Data      []byte
+}
+
+// Registry serves the manifests and blobs added to it. The exported fields
+// may be changed between requests.
+type Registry struct {
+	// RequireAuth answers 401 with a bearer challenge pointing at /token
+	// unless the request carries Token.
+	RequireAuth bool
+	// BasicAuth, as "user:password", answers 401 with a Basic challenge
+	// unless the request carries that Basic credential, like registries
+	// without a token service.
+	BasicAuth string
+	// Latency is slept before every response.
+	Latency time.Duration
+	// IgnoreRange answers Range requests with the whole blob, like servers
+	// that do not support them.
+	IgnoreRange bool
+
+	mu        sync.Mutex
+	manifests map[string]manifest // repository + "/" + tag or digest
+	blobs     map[string][]byte
+	failures  map[string][]int // path suffix -> statuses for the next requests
+	truncate  map[string]int   // digest -> bytes sent before dropping the next response
+	corrupt   map[string]bool
+	requests  map[string]int // path -> count
+	ranges    map[string][]string
+}
+
+type manifest struct {
+	mediaType string
+	raw       []byte
+}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{
+		manifests: map[string]manifest{},
+		blobs:     map[string][]byte{},
+		failures:  map[string][]int{},
+		truncate:  map[string]int{},
+		corrupt:   map[string]bool{},
+		requests:  map[string]int{},
+		ranges:    map[string][]string{},
+	}
+}
+
+// Digest returns the sha256 digest of data in registry notation.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// AddBlob stores data and returns its digest.
+func (r *Registry) AddBlob(data []byte) string {
+	d := Digest(data)
+	r.mu.Lock()
+	r.blobs[d] = data
+	r.mu.Unlock()
+	return d
+}
+
+// AddManifest serves raw for repository under ref and under its digest,
+// which is returned.
+func (r *Registry) AddManifest(repository, ref, mediaType string, raw []byte) string {
+	d := Digest(raw)
+	m := manifest{mediaType: mediaType, raw: raw}
+	r.mu.Lock()
+	r.manifests[repository+"/"+ref] = m
+	r.manifests[repository+"/"+d] = m
+	r.mu.Unlock()
+	return d
+}
+
+// AddModel stores config and layers as blobs and serves a Docker v2
+// manifest for them as repository:tag. It returns the manifest and its
+// digest.
+func (r *Registry) AddModel(repository, tag string, config []byte, layers ...Layer) ([]byte, string) {
+	type descriptor struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Size      int    `json:"size"`
+	}
+	m := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifest,
+		Config:        descriptor{MediaType: mediaTypeConfig, Digest: r.AddBlob(config), Size: len(config)},
+		Layers:        []descriptor{},
+	}
+	for _, l := range layers {
+		m.Layers = append(m.Layers, descriptor{MediaType: l.MediaType, Digest: r.AddBlob(l.Data), Size: len(l.Data)})
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return raw, r.AddManifest(repository, tag, mediaTypeManifest, raw)
+}
+
+// FailNext answers the next requests whose path ends in suffix (a digest,
+// or "manifests/<tag>") with statuses, one per request.
+func (r *Registry) FailNext(suffix string, statuses ...int) {
+	r.mu.Lock()
+	r.failures[suffix] = append(r.failures[suffix], statuses...)
+	r.mu.Unlock()
+}
+
+// TruncateNext drops the connection of the next response for the blob
+// digest after n bytes of its body.
+func (r *Registry) TruncateNext(digest string, n int) {
+	r.mu.Lock()
+	r.truncate[digest] = n
+	r.mu.Unlock()
+}
+
+// Corrupt serves the blob digest with its first byte flipped.
+func (r *Registry) Corrupt(digest string) {
+	r.mu.Lock()
+	r.corrupt[digest] = true
+	r.mu.Unlock()
+}
+
+// Requests returns how many requests reached a path ending in suffix.
+func (r *Registry) Requests(suffix string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for p, c := range r.requests {
+		if strings.HasSuffix(p, suffix) {
+			n += c
+		}
+	}
+	return n
+}
+
+// Ranges returns the Range headers sent for the blob digest, in order.
+func (r *Registry) Ranges(digest string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ranges[digest]...)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.Latency > 0 {
+		time.Sleep(r.Latency)
+	}
+	p := req.URL.Path
+	r.mu.Lock()
+	r.requests[p]++
+	status := 0
+	for suffix, statuses := range r.failures {
+		if strings.HasSuffix(p, suffix) && len(statuses) > 0 {
+			status, r.failures[suffix] = statuses[0], statuses[1:]
+			break
+		}
+	}
+	r.mu.Unlock()
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if p == "/token" {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token":%q}`, Token)
+		return
+	}
+	if r.RequireAuth && req.Header.Get("Authorization") != "Bearer "+Token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="registrytest"`, req.Host))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if user, pass, ok := strings.Cut(r.BasicAuth, ":"); ok {
+		if u, pw, _ := req.BasicAuth(); u != user || pw != pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registrytest"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	rest, ok := strings.CutPrefix(p, "/v2/")
+	switch {
+	case p == "/v2/" || p == "/v2":
+		w.WriteHeader(http.StatusOK)
+	case ok && strings.HasSuffix(rest, "/tags/list"):
+		r.serveTags(w, req, strings.TrimSuffix(rest, "/tags/list"))
+	case ok && strings.Contains(rest, "/manifests/"):
+		repo, ref, _ := strings.Cut(rest, "/manifests/")
+		r.mu.Lock()
+		m, found := r.manifests[repo+"/"+ref]
+		r.mu.Unlock()
+		if !found {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", Digest(m.raw))
+		w.Write(m.raw)
+	case ok && strings.Contains(rest, "/blobs/"):
+		_, digest, _ := strings.Cut(rest, "/blobs/")
+		r.serveBlob(w, req, digest)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveTags lists the tags (not digests) manifests were added under for
+// repository.
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, repository string) {
+	r.mu.Lock()
+	tags := []string{}
+	for key := range r.manifests {
+		if ref, ok := strings.CutPrefix(key, repository+"/"); ok && !strings.HasPrefix(ref, "sha256:") && !strings.Contains(ref, "/") {
+			tags = append(tags, ref)
+		}
+	}
+	r.mu.Unlock()
+	if len(tags) == 0 {
+		http.Error(w, "name unknown", http.StatusNotFound)
+		return
+	}
+	sort.Strings(tags)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": repository, "tags": tags})
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, digest string) {
+	r.mu.Lock()
+	data, found := r.blobs[digest]
+	if rng := req.Header.Get("Range"); rng != "" {
+		r.ranges[digest] = append(r.ranges[digest], rng)
+	}
+	cut, truncated := r.truncate[digest]
+	delete(r.truncate, digest)
+	corrupt := r.corrupt[digest]
+	r.mu.Unlock()
+	if !found {
+		http.Error(w, "blob unknown", http.StatusNotFound)
+		return
+	}
+	if corrupt {
+		data = append([]byte{data[0] ^ 0xff}, data[1:]...)
+	}
+
+	status := http.StatusOK
+	if start, ok := rangeStart(req.Header.Get("Range")); ok && !r.IgnoreRange && start < len(data) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+		data, status = data[start:], http.StatusPartialContent
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if truncated && cut < len(data) {
+		w.Write(data[:cut])
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		// Abort the response so the client sees an unexpected EOF.
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(data)
+}
+
+// rangeStart parses the "bytes=<start>-" form the downloader sends.
+func rangeStart(h string) (int, bool) {
+	v, ok := strings.CutPrefix(h, "bytes=")
+	if !ok {
+		return 0, false
+	}
+	v, ok = strings.CutSuffix(v, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// layerNames are the short names -layers accepts for Ollama layer types.
+var layerNames = map[string]string{
+	"model":     mtOllamaModel,
+	"adapter":   mtOllamaAdapter,
+	"projector": mtOllamaProjector,
+	"template":  mtOllamaTemplate,
+	"system":    mtOllamaSystem,
+	"params":    mtOllamaParams,
+	"messages":  mtOllamaMessages,
+	"license":   mtOllamaLicense,
+}
+
+// layerFilter selects the layers a download fetches and packages. The
+// config blob is always kept. The zero value keeps everything.
+type layerFilter struct {
+	include map[string]bool // media types to keep; empty keeps all but exclude
+	exclude map[string]bool
+}
+
+// parseLayerFilter parses a -layers value: comma-separated short names
+// (model, template, ...) or media types, each prefixed with "-" to leave it
+// out instead, e.g. "model,template,params" or "-license".
+func parseLayerFilter(s string) (layerFilter, error) {
+	var f layerFilter
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		set := &f.include
+		if rest, ok := strings.CutPrefix(name, "-"); ok {
+			set, name = &f.exclude, rest
+		}
+		mediaType, ok := layerNames[name]
+		if !ok {
+			if !strings.Contains(name, "/") {
+				return f, fmt.Errorf("unknown layer %q (want a media type or one of %s)", name, strings.Join(layerNameList(), ", "))
+			}
+			mediaType = name
+		}
+		if *set == nil {
+			*set = map[string]bool{}
+		}
+		(*set)[mediaType] = true
+	}
+	return f, nil
+}
+
+func layerNameList() []string {
+	names := make([]string, 0, len(layerNames))
+	for name := range layerNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f layerFilter) keeps(mediaType string) bool {
+	if mediaType == mtDockerConfig || mediaType == mtOCIConfig {
+		return true
+	}
+	if f.exclude[mediaType] {
+		return false
+	}
+	return len(f.include) == 0 || f.include[mediaType]
+}
+
+// apply splits items into the blobs f keeps and those it leaves out.
+func (f layerFilter) apply(items []blobItem) (kept, leftOut []blobItem) {
+	for _, it := range items {
+		if f.keeps(it.mediaType) {
+			kept = append(kept, it)
+		} else {
+			leftOut = append(leftOut, it)
+		}
+	}
+	return kept, leftOut
+}
+
+// leftOutBlobs returns the blob file names of leftOut that no kept item
+// needs, for packageArchive to skip.
+func leftOutBlobs(kept, leftOut []blobItem) map[string]bool {
+	needed := map[string]bool{}
+	for _, it := range kept {
+		needed[it.digest] = true
+	}
+	names := map[string]bool{}
+	for _, it := range leftOut {
+		if !needed[it.digest] {
+			names[blobFileName(it.digest)] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestParseLayerFilter(t *testing.T) {
+	f, err := parseLayerFilter("model, template,application/x-custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mediaType, want := range map[string]bool{
+		mtOllamaModel:          true,
+		mtOllamaTemplate:       true,
+		"application/x-custom": true,
+		mtOllamaLicense:        false,
+		mtDockerConfig:         true,
+	} {
+		if got := f.keeps(mediaType); got != want {
+			t.Errorf("keeps(%s) = %v, want %v", mediaType, got, want)
+		}
+	}
+
+	f, err = parseLayerFilter("-license")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.keeps(mtOllamaLicense) || !f.keeps(mtOllamaParams) {
+		t.Error("-license should leave out only the license")
+	}
+
+	if _, err := parseLayerFilter("weights"); err == nil {
+		t.Error("parseLayerFilter() accepted an unknown name")
+	}
+	if !(layerFilter{}).keeps(mtOllamaLicense) {
+		t.Error("the zero filter should keep everything")
+	}
+}
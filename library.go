@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trendingRefresh is how often the web UI refetches the popular model list.
+const trendingRefresh = 6 * time.Hour
+
+// libraryModel is one entry of the Ollama library's popular list.
+type libraryModel struct {
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	Pulls        string        `json:"pulls"` // as shown on the page, e.g. "5.2M"
+	Capabilities []string      `json:"capabilities,omitempty"`
+	Sizes        []librarySize `json:"sizes"`
+	Updated      string        `json:"updated,omitempty"`
+}
+
+// librarySize is a parameter-size tag of a model with a rough download
+// size for Ollama's default 4-bit quantization.
+type librarySize struct {
+	Tag            string `json:"tag"` // e.g. "8b"
+	EstimatedBytes int64  `json:"estimatedBytes,omitempty"`
+}
+
+type trendingResponse struct {
+	Models    []libraryModel `json:"models"`
+	FetchedAt time.Time      `json:"fetchedAt"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// trendingFeed keeps the last successfully fetched popular list. A failed
+// refresh keeps the old list and records the error.
+type trendingFeed struct {
+	mu        sync.Mutex
+	models    []libraryModel
+	fetchedAt time.Time
+	err       error
+}
+
+func (f *trendingFeed) snapshot() trendingResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := trendingResponse{Models: f.models, FetchedAt: f.fetchedAt}
+	if resp.Models == nil {
+		resp.Models = []libraryModel{}
+	}
+	if f.err != nil {
+		resp.Error = f.err.Error()
+	}
+	return resp
+}
+
+// run refreshes the feed now and then every trendingRefresh until ctx ends.
+func (f *trendingFeed) run(ctx context.Context, opt options, logger *slog.Logger) {
+	client := newHTTPClient(opt)
+	for {
+		list, err := fetchTrending(ctx, client, opt)
+		f.mu.Lock()
+		if err == nil {
+			f.models, f.fetchedAt = list, time.Now()
+		}
+		f.err = err
+		f.mu.Unlock()
+		if err != nil {
+			logger.Warn("could not fetch popular models", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(trendingRefresh):
+		}
+	}
+}
+
+var (
+	libraryItemRe       = regexp.MustCompile(`(?s)<li[^>]*x-test-model[^>]*>(.*?)</li>`)
+	libraryHrefRe       = regexp.MustCompile(`href="/library/([^"]+)"`)
+	libraryDescRe       = regexp.MustCompile(`(?s)<p[^>]*>\s*([^<]+?)\s*</p>`)
+	librarySizeRe       = regexp.MustCompile(`x-test-size[^>]*>\s*([^<]+?)\s*<`)
+	libraryCapabilityRe = regexp.MustCompile(`x-test-capability[^>]*>\s*([^<]+?)\s*<`)
+	libraryPullsRe      = regexp.MustCompile(`x-test-pull-count[^>]*>\s*([^<]+?)\s*<`)
+	libraryUpdatedRe    = regexp.MustCompile(`x-test-updated[^>]*>\s*([^<]+?)\s*<`)
+)
+
+// fetchTrending scrapes ollama.com/library sorted by popularity. There is
+// no JSON API for the list, so this follows the page's x-test-* markers.
+func fetchTrending(ctx context.Context, client *http.Client, opt options) ([]libraryModel, error) {
+	page := ollamaLibraryBase + "/library?sort=popular"
+	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, page, map[string]string{"User-Agent": "ollama-model-downloader/1.0"}, opt.retries, opt.verbose)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", page, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	list := parseLibraryPage(string(body))
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%s: no models found in page", page)
+	}
+	return list, nil
+}
+
+func parseLibraryPage(page string) []libraryModel {
+	var list []libraryModel
+	for _, item := range libraryItemRe.FindAllStringSubmatch(page, -1) {
+		li := item[1]
+		m := libraryHrefRe.FindStringSubmatch(li)
+		if m == nil {
+			continue
+		}
+		model := libraryModel{Name: html.UnescapeString(m[1])}
+		if d := libraryDescRe.FindStringSubmatch(li); d != nil {
+			model.Description = html.UnescapeString(d[1])
+		}
+		if p := libraryPullsRe.FindStringSubmatch(li); p != nil {
+			model.Pulls = html.UnescapeString(p[1])
+		}
+		if u := libraryUpdatedRe.FindStringSubmatch(li); u != nil {
+			model.Updated = html.UnescapeString(u[1])
+		}
+		for _, c := range libraryCapabilityRe.FindAllStringSubmatch(li, -1) {
+			model.Capabilities = append(model.Capabilities, html.UnescapeString(c[1]))
+		}
+		for _, s := range librarySizeRe.FindAllStringSubmatch(li, -1) {
+			tag := html.UnescapeString(s[1])
+			model.Sizes = append(model.Sizes, librarySize{Tag: tag, EstimatedBytes: estimateModelBytes(tag)})
+		}
+		list = append(list, model)
+	}
+	return list
+}
+
+// bytesPerParam approximates Q4_K_M, the quantization of Ollama's default
+// tags (an 8b model is about 4.7 GB).
+const bytesPerParam = 0.59
+
+// estimateModelBytes turns a parameter-size tag ("8b", "270m", "8x7b") into
+// an approximate download size, or 0 when the tag is not a size.
+func estimateModelBytes(tag string) int64 {
+	t := strings.ToLower(tag)
+	experts := 1.0
+	if n, rest, ok := strings.Cut(t, "x"); ok {
+		e, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0
+		}
+		experts, t = e, rest
+	}
+	var unit float64
+	switch {
+	case strings.HasSuffix(t, "b"):
+		unit = 1e9
+	case strings.HasSuffix(t, "m"):
+		unit = 1e6
+	default:
+		return 0
+	}
+	n, err := strconv.ParseFloat(t[:len(t)-1], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(experts * n * unit * bytesPerParam)
+}
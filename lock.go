@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sessionLockName is the file in a staging directory that marks the session
+// as being downloaded. Its holder touches it every lockHeartbeat; a lock
+// that has not been touched for lockStaleAfter belongs to a dead process.
+const (
+	sessionLockName = "session.lock"
+	lockHeartbeat   = 5 * time.Second
+	lockStaleAfter  = 30 * time.Second
+)
+
+// errSessionLocked means another process is downloading the session.
+var errSessionLocked = errors.New("session is being downloaded by another process")
+
+// lockOwner is the content of a session lock, for error messages.
+type lockOwner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type lockedError struct {
+	owner lockOwner
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("%v (pid %d on %s since %s)", errSessionLocked, e.owner.PID, e.owner.Host, e.owner.StartedAt.Format(time.RFC3339))
+}
+
+func (e *lockedError) Unwrap() error { return errSessionLocked }
+
+// sessionLock is a held lock on a staging directory.
+type sessionLock struct {
+	path string
+	quit chan struct{}
+	done chan struct{}
+}
+
+// acquireSessionLock locks stagingDir for this process, taking over a stale
+// lock. It fails with an error wrapping errSessionLocked while another
+// process holds a live one.
+func acquireSessionLock(stagingDir string) (*sessionLock, error) {
+	if err := os.MkdirAll(stagingDir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(stagingDir, sessionLockName)
+	host, _ := os.Hostname()
+	data, err := json.Marshal(lockOwner{PID: os.Getpid(), Host: host, StartedAt: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if owner, live := sessionLockOwner(stagingDir); live {
+			return nil, &lockedError{owner}
+		}
+		if attempt > 0 {
+			return nil, fmt.Errorf("take over stale lock %s: %w", path, err)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	l := &sessionLock{path: path, quit: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		tick := time.NewTicker(lockHeartbeat)
+		defer tick.Stop()
+		for {
+			select {
+			case now := <-tick.C:
+				_ = os.Chtimes(path, now, now)
+			case <-l.quit:
+				return
+			}
+		}
+	}()
+	return l, nil
+}
+
+// Release stops the heartbeat and removes the lock.
+func (l *sessionLock) Release() {
+	close(l.quit)
+	<-l.done
+	_ = os.Remove(l.path)
+}
+
+// sessionLockOwner reports who holds the lock on stagingDir and whether the
+// lock is live, i.e. its holder touched it within lockStaleAfter.
+func sessionLockOwner(stagingDir string) (lockOwner, bool) {
+	path := filepath.Join(stagingDir, sessionLockName)
+	info, err := os.Stat(path)
+	if err != nil {
+		return lockOwner{}, false
+	}
+	var owner lockOwner
+	if data, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(data, &owner)
+	}
+	return owner, time.Since(info.ModTime()) < lockStaleAfter
+}
@@ -4,10 +4,8 @@ import (
 	"archive/zip"
 	"context"
 	"embed"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"html/template"
 	"io"
 	"net"
 	"net/http"
@@ -15,14 +13,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"sort"
-	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
+
+	"ollama-model-downloader/config"
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/models"
 )
 
-//go:embed templates/index.html
+//go:embed templates/*.html
 var templateFS embed.FS
 
 const (
@@ -30,294 +29,294 @@ const (
 	defaultWebPort  = 8080
 )
 
-var (
-	currentZip        string
-	currentProgress   *progress
-	globalCancel      context.CancelFunc
-	currentMessage    string
-	pauseRequested    atomic.Bool
-	currentSessionDir string
-)
-
 type PageData struct {
-	Message         string
-	ZipPath         string
-	Downloads       []downloadEntry
-	RunningSession  *partialSessionView
-	PausedSessions  []partialSessionView
-	ErroredSessions []partialSessionView
+	Message           string
+	ZipPath           string
+	Downloads         []models.DownloadEntry
+	RunningSession    *models.SessionView
+	PausedSessions    []models.SessionView
+	ErroredSessions   []models.SessionView
+	CompletedSessions []models.SessionView
+	Usage             string // bytes in the output directory, and the quota if any
 }
 
-type downloadEntry struct {
-	Name    string
-	Model   string
-	Path    string
-	ModTime time.Time
+// setSessionStatus records a state transition; failures are ignored because
+// the status is informational and the session may already be gone.
+func setSessionStatus(dir string, state models.SessionState, message string) {
+	_ = models.SetSessionStatus(dir, state, message)
 }
 
-type sessionMeta struct {
-	Model       string    `json:"model"`
-	SessionID   string    `json:"sessionId"`
-	OutZip      string    `json:"outZip"`
-	StagingRoot string    `json:"stagingRoot"`
-	Registry    string    `json:"registry"`
-	Platform    string    `json:"platform"`
-	Concurrency int       `json:"concurrency"`
-	Retries     int       `json:"retries"`
-	StartedAt   time.Time `json:"startedAt"`
-	LastUpdated time.Time `json:"lastUpdated"`
-	State       string    `json:"state"`
-	Message     string    `json:"message"`
+func setSessionError(dir string, err error) {
+	kind := apperrors.KindOf(err)
+	_ = models.SetSessionError(dir, err.Error(), kind.String(), kind.Hint())
 }
 
-const sessionMetaFileName = "session.json"
-
-func sessionMetaPath(dir string) string {
-	return filepath.Join(dir, sessionMetaFileName)
-}
+func main() {
+	var opt options
 
-func loadSessionMeta(dir string) (sessionMeta, error) {
-	var meta sessionMeta
-	data, err := os.ReadFile(sessionMetaPath(dir))
-	if err != nil {
-		return meta, err
-	}
-	if err := json.Unmarshal(data, &meta); err != nil {
-		return meta, err
+	flag.StringVar(&opt.registry, "registry", defaultRegistry, "registry base URL")
+	flag.IntVar(&opt.concurrency, "concurrency", 4, "number of concurrent blob downloads")
+	flag.BoolVar(&opt.verbose, "v", false, "verbose logging")
+	flag.BoolVar(&opt.quiet, "quiet", false, "print only the final result line, warnings and errors")
+	flag.BoolVar(&opt.keepStaging, "keep-staging", false, "keep staging directory (do not delete after zip)")
+	flag.IntVar(&opt.retries, "retries", 3, "retry attempts for transient errors")
+	var timeoutSec int
+	flag.IntVar(&timeoutSec, "timeout", 0, "overall request timeout seconds (0 = no limit)")
+	flag.BoolVar(&opt.insecureTLS, "insecure", false, "skip TLS verification (NOT recommended)")
+	flag.BoolVar(&opt.traceHTTP, "trace-http", false, "log HTTP requests, responses and connection events to stderr")
+	opt.resolve = resolveMap{}
+	flag.Var(opt.resolve, "resolve", "force host:port to resolve to an address (host:port:address, repeatable)")
+	var ipv4, ipv6 bool
+	flag.BoolVar(&ipv4, "ipv4", false, "only connect over IPv4")
+	flag.BoolVar(&ipv6, "ipv6", false, "only connect over IPv6")
+	flag.BoolVar(&opt.http1, "http1", false, "disable HTTP/2 and always use HTTP/1.1")
+	flag.DurationVar(&opt.dualStack, "fallback-delay", 0, "happy-eyeballs delay before trying the other address family (0 = default 300ms, negative disables)")
+	// Default platform from runtime
+	defaultPlatform := fmt.Sprintf("linux/%s", archFromGo(runtime.GOARCH))
+	flag.StringVar(&opt.platform, "platform", defaultPlatform, "target platform os/arch when the model publishes an index: linux, darwin or windows with amd64 or arm64")
+	flag.BoolVar(&opt.platformFallback, "platform-fallback", false, "when a model's index lacks -platform, use another platform it publishes (same architecture first) with a warning instead of failing")
+	flag.StringVar(&opt.outZip, "o", "", "output zip path (default: <model>.zip)")
+	startAt := flag.String("start-at", "", "wait until this time before downloading: HH:MM (next occurrence), \"YYYY-MM-DD HH:MM\" or RFC 3339")
+	nameTemplate := flag.String("name-template", "", "Go template for archive names when -o is not given, e.g. \"{{.Model}}-{{.Tag}}-{{.Platform}}-{{.Digest8}}\" (fields: Model, Namespace, Tag, Platform, Digest, Digest8)")
+	var uploads uploadList
+	flag.Var(&uploads, "upload", "push each finished archive and its sidecars here, repeatable (all targets run at once): rclone:remote:path (any rclone backend, e.g. S3 or SFTP; needs the rclone command), webdav+https://[user@]host/path (password in $OMD_WEBDAV_PASSWORD) or file:///dir")
+	flag.StringVar(&opt.outputDir, "output-dir", "downloaded-models", "directory to save downloaded models")
+	flag.IntVar(&opt.port, "port", 0, "port to listen on (0 for random)")
+	manifestCacheDir := flag.String("manifest-cache-dir", defaultManifestCacheDir(), "directory for cached manifests (empty disables caching)")
+	flag.StringVar(&opt.signatureKey, "signature-key", "", "PEM public key used to verify cosign signatures on manifests")
+	flag.BoolVar(&opt.requireSignature, "require-signature", false, "fail unless the manifest carries a valid signature")
+	flag.StringVar(&opt.gpgSign, "gpg-sign", "", "sign the archive, its checksum file and SHA256SUMS with this gpg key ID (\"default\" for gpg's default key)")
+	flag.BoolVar(&opt.includeDocs, "include-docs", false, "include the model's LICENSE and model page (README.html) under docs/ in the zip")
+	flag.StringVar(&opt.bundle, "bundle", bundleZip, "archive layout: \"zip\" (extract into the models directory) or \"installer\" (models plus install scripts)")
+	flag.StringVar(&opt.compression, "compression", compressionDefault, "archive compression: \"none\" (store; model weights barely compress), \"fast\", \"default\" or \"best\"")
+	flag.IntVar(&opt.zipWorkers, "zip-workers", 0, "goroutines compressing each archive entry (0 = one per CPU)")
+	layers := flag.String("layers", "", "layers to fetch and package: model, adapter, projector, template, system, params, messages, license or media types, comma-separated; prefix with - to leave one out (e.g. -license); the config is always kept")
+	flag.StringVar(&opt.baseModel, "base-model", "", "base model an adapter-only model applies to; recorded in the archive")
+	flag.BoolVar(&opt.fetchBase, "fetch-base", false, "with -base-model, also package the base model's manifest and blobs")
+	flag.BoolVar(&opt.strictMediaTypes, "strict-media-types", false, "fail when the manifest has config or layer media types this tool does not know")
+	flag.BoolVar(&durableWrites, "durable", false, "fsync blobs and session checkpoints before relying on them (slower; for machines that lose power)")
+	maxSize := flag.String("max-size", "", "refuse models whose blobs add up to more than this, e.g. 20GiB (empty = no limit)")
+	quota := flag.String("quota", "", "refuse downloads that would take -output-dir past this size, e.g. 500GiB (empty = no quota)")
+	minFree := flag.String("min-free", "", "pause blob writes while -output-dir's volume has less free space than this, e.g. 5GiB, and continue once there is (empty = off)")
+	flag.IntVar(&opt.keepVersions, "keep-versions", 1, "archives to keep per model when an updated tag is downloaded again; older ones are renamed with their digest and date (1 = overwrite)")
+	flag.BoolVar(&opt.yes, "yes", false, "do not ask for confirmation on a terminal, and download models larger than -max-size anyway")
+	bufferSize := flag.String("buffer-size", "256KiB", "buffer each blob body is copied through (4KiB to 64MiB)")
+	manifestTTL := flag.Duration("manifest-ttl", 5*time.Minute, "serve cached tag manifests without revalidation for this long")
+	flag.StringVar(&opt.configPath, "config", config.DefaultFilePath(), "JSON config file with model aliases, a default namespace and registry profiles")
+	flag.StringVar(&opt.templatesDir, "templates-dir", "", "web UI: directory of *.html templates that replace or add to the built-in ones")
+	flag.BoolVar(&opt.noBrowser, "no-browser", false, "do not open a browser when starting the web UI")
+	flag.BoolVar(&opt.noBrowser, "no-open", false, "alias for -no-browser")
+	flag.IntVar(&opt.archiveSpotCheck, "archive-spot-check", 0, "web UI: besides reading its zip directory, hash this many of each listed archive's smallest blobs against their digests")
+	flag.Float64Var(&opt.rateLimit, "rate-limit", 2, "web UI: state-changing requests per second allowed per client IP, with bursts of 4x (0 disables)")
+	flag.IntVar(&opt.maxSessions, "max-sessions", 4, "maximum downloads running at once, in the web UI or when pulling several models (0 = unlimited)")
+	flag.StringVar(&opt.onInterrupted, "on-interrupted", "mark", "web UI: at startup, \"mark\" downloads left unfinished by a crash as interrupted or \"resume\" them")
+	flag.BoolVar(&opt.container, "container", inContainer(), "container mode: no browser, JSON logs on stdout, fixed port (auto-detected)")
+	flag.Float64Var(&opt.chaos, "chaos", 0, "development: inject a 500, reset, slow read or truncated body into this fraction of HTTP requests")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] [command] <model[:tag] | model@sha256:digest>...\n\nFlags:\n", os.Args[0])
+		printVisibleDefaults(flag.CommandLine, out)
+		printCommands(out)
+		fmt.Fprintf(out, "\nEvery flag can also be set as an environment variable, e.g. %sOUTPUT_DIR.\n", envPrefix)
+	}
+	if err := applyEnvFlags(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
 	}
-	return meta, nil
-}
-
-func saveSessionMeta(meta sessionMeta) error {
-	meta.LastUpdated = time.Now()
-	data, err := json.MarshalIndent(meta, "", "  ")
-	if err != nil {
-		return err
+	flag.Parse()
+	if opt.container {
+		opt.noBrowser = true
+	}
+	if opt.onInterrupted != "mark" && opt.onInterrupted != "resume" {
+		fmt.Fprintln(os.Stderr, "error: -on-interrupted must be \"mark\" or \"resume\"")
+		os.Exit(2)
+	}
+	if opt.bundle != bundleZip && opt.bundle != bundleInstaller {
+		fmt.Fprintln(os.Stderr, "error: -bundle must be \"zip\" or \"installer\"")
+		os.Exit(2)
+	}
+	if _, _, err := zipCompression(opt.compression); err != nil {
+		fmt.Fprintln(os.Stderr, "error: -compression must be \"none\", \"fast\", \"default\" or \"best\"")
+		os.Exit(2)
+	}
+	if opt.archiveSpotCheck < 0 {
+		fmt.Fprintln(os.Stderr, "error: -archive-spot-check must not be negative")
+		os.Exit(2)
+	}
+	if opt.zipWorkers < 0 {
+		fmt.Fprintln(os.Stderr, "error: -zip-workers must not be negative")
+		os.Exit(2)
+	} else if opt.zipWorkers == 0 {
+		opt.zipWorkers = runtime.GOMAXPROCS(0)
+	}
+	if goos, arch, err := parsePlatform(opt.platform); err != nil {
+		fmt.Fprintln(os.Stderr, "error: -platform:", err)
+		os.Exit(2)
+	} else {
+		opt.platform = goos + "/" + arch
+	}
+	if opt.quiet && opt.verbose {
+		fmt.Fprintln(os.Stderr, "error: -quiet and -v cannot be combined")
+		os.Exit(2)
+	}
+	progressBars = !opt.quiet && isTerminal(os.Stderr)
+	if opt.chaos < 0 || opt.chaos > 1 {
+		fmt.Fprintln(os.Stderr, "error: -chaos must be between 0 and 1")
+		os.Exit(2)
+	}
+	if n, err := parseByteSize(*bufferSize); err != nil {
+		fmt.Fprintln(os.Stderr, "error: -buffer-size:", err)
+		os.Exit(2)
+	} else if err := setCopyBufferSize(n); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+	if *maxSize != "" {
+		n, err := parseByteSize(*maxSize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error: -max-size:", err)
+			os.Exit(2)
+		}
+		opt.maxSize = n
 	}
-	return os.WriteFile(sessionMetaPath(meta.StagingRoot), data, 0o644)
-}
-
-type partialSessionView struct {
-	Model      string
-	SessionID  string
-	Started    string
-	Updated    string
-	StateLabel string
-	Message    string
-}
-
-func discoverPartialSessions(outputDir string) ([]sessionMeta, error) {
-	entries, err := os.ReadDir(outputDir)
-	if err != nil {
-		return nil, err
+	if opt.keepVersions < 1 {
+		fmt.Fprintln(os.Stderr, "error: -keep-versions must be at least 1")
+		os.Exit(2)
 	}
-	var sessions []sessionMeta
-	for _, entry := range entries {
-		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".staging") {
-			continue
-		}
-		meta, err := loadSessionMeta(filepath.Join(outputDir, entry.Name()))
+	if *quota != "" {
+		n, err := parseByteSize(*quota)
 		if err != nil {
-			continue
+			fmt.Fprintln(os.Stderr, "error: -quota:", err)
+			os.Exit(2)
 		}
-		sessions = append(sessions, meta)
+		opt.quota = n
 	}
-	return sessions, nil
-}
-
-func categorizeSessions(metas []sessionMeta) (running *partialSessionView, paused, errored []partialSessionView) {
-	sort.Slice(metas, func(i, j int) bool {
-		return metas[i].LastUpdated.After(metas[j].LastUpdated)
-	})
-	for _, meta := range metas {
-		view := sessionViewFromMeta(meta)
-		switch strings.ToLower(meta.State) {
-		case "downloading":
-			if running == nil {
-				tmp := view
-				running = &tmp
-			}
-		case "paused":
-			paused = append(paused, view)
-		case "error":
-			errored = append(errored, view)
-		default:
-			paused = append(paused, view)
+	if *minFree != "" {
+		n, err := parseByteSize(*minFree)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error: -min-free:", err)
+			os.Exit(2)
 		}
+		opt.minFree = n
 	}
-	return
-}
-
-func downloadsFromDir(dir string) []downloadEntry {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil
+	if t, err := parseStartAt(*startAt, time.Now()); err != nil {
+		fmt.Fprintln(os.Stderr, "error: -start-at:", err)
+		os.Exit(2)
+	} else {
+		opt.startAt = t
 	}
-	var downloads []downloadEntry
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".zip") {
-			continue
-		}
-		info, err := entry.Info()
+	if *nameTemplate != "" {
+		tmpl, err := parseNameTemplate(*nameTemplate)
 		if err != nil {
-			continue
+			fmt.Fprintln(os.Stderr, "error: -name-template:", err)
+			os.Exit(2)
 		}
-		downloads = append(downloads, downloadEntry{
-			Name:    entry.Name(),
-			Model:   strings.TrimSuffix(entry.Name(), ".zip"),
-			Path:    filepath.Join(dir, entry.Name()),
-			ModTime: info.ModTime(),
-		})
-	}
-	sort.Slice(downloads, func(i, j int) bool {
-		return downloads[i].ModTime.After(downloads[j].ModTime)
-	})
-	return downloads
-}
-
-func sessionViewFromMeta(meta sessionMeta) partialSessionView {
-	return partialSessionView{
-		Model:      meta.Model,
-		SessionID:  meta.SessionID,
-		Started:    formatSessionTime(meta.StartedAt),
-		Updated:    formatSessionTime(meta.LastUpdated),
-		StateLabel: stateLabel(meta.State),
-		Message:    meta.Message,
+		opt.nameTemplate = tmpl
 	}
-}
-
-func formatSessionTime(t time.Time) string {
-	if t.IsZero() {
-		return "نامشخص"
+	if f, err := parseLayerFilter(*layers); err != nil {
+		fmt.Fprintln(os.Stderr, "error: -layers:", err)
+		os.Exit(2)
+	} else {
+		opt.layers = f
+	}
+	if durableWrites {
+		models.DefaultStore = models.FileStore{Durable: true}
+		models.DefaultBlobStore = models.FileBlobStore{Durable: true}
+	}
+	if opt.fetchBase && opt.baseModel == "" {
+		fmt.Fprintln(os.Stderr, "error: -fetch-base requires -base-model")
+		os.Exit(2)
 	}
-	return t.Format("2006-01-02 15:04:05")
-}
 
-func stateLabel(state string) string {
-	switch strings.ToLower(state) {
-	case "downloading":
-		return "در حال دانلود"
-	case "paused":
-		return "مکث شده"
-	case "error":
-		return "خطا"
-	default:
-		if state == "" {
-			return "در انتظار"
+	family, err := addressFamily(ipv4, ipv6)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+	opt.ipFamily = family
+	if timeoutSec > 0 {
+		opt.timeout = time.Duration(timeoutSec) * time.Second
+	}
+	opt.manifestCache = newManifestCache(*manifestCacheDir, *manifestTTL)
+	opt.authCache = newRegistryAuthCache()
+	opt.quotaReservations = newQuotaReservations()
+	cfg, err := config.LoadFile(opt.configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: config:", err)
+		os.Exit(2)
+	}
+	opt.modelConfig = modelConfig{namespace: cfg.DefaultNamespace, aliases: cfg.Aliases, registries: cfg.Registries}
+	opt.manifestTypes = newManifestTypes(cfg.ManifestTypes)
+	if err := checkNotifiers(cfg.Notifiers); err != nil {
+		fmt.Fprintln(os.Stderr, "error: config:", err)
+		os.Exit(2)
+	}
+	opt.notifiers = cfg.Notifiers
+	if opt, err = withNetwork(opt, cfg.Network); err != nil {
+		fmt.Fprintln(os.Stderr, "error: config:", err)
+		os.Exit(2)
+	}
+	opt.uploads = uploads
+	if len(opt.uploads) == 0 {
+		opt.uploads = cfg.Uploads
+	}
+	for _, t := range opt.uploads {
+		if _, err := parseUploadTarget(t); err != nil {
+			fmt.Fprintln(os.Stderr, "error: -upload:", err)
+			os.Exit(2)
 		}
-		return state
 	}
-}
-
-func beginDownloadSession(opt options, startMessage string) {
-	pauseRequested.Store(false)
-	currentZip = opt.outZip
-	currentProgress = newProgress(0)
-	currentMessage = startMessage
-	currentSessionDir = opt.stagingDir
-
-	// Create session metadata immediately so it appears in the UI
-	_ = os.MkdirAll(opt.stagingDir, 0o755)
-	meta := sessionMeta{
-		Model:       opt.model,
-		SessionID:   opt.sessionID,
-		OutZip:      opt.outZip,
-		StagingRoot: opt.stagingDir,
-		Registry:    opt.registry,
-		Platform:    opt.platform,
-		Concurrency: opt.concurrency,
-		Retries:     opt.retries,
-		StartedAt:   time.Now(),
-		LastUpdated: time.Now(),
-		State:       "downloading",
-		Message:     "در حال شروع دانلود...",
-	}
-	_ = saveSessionMeta(meta)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	globalCancel = cancel
 
-	go func() {
-		err := run(ctx, opt)
-		globalCancel = nil
-		currentProgress = nil
-		currentSessionDir = ""
-		paused := pauseRequested.Load()
-		pauseRequested.Store(false)
-		if err != nil {
-			if err == context.Canceled {
-				if paused {
-					currentMessage = "دانلود متوقف شد."
-				} else {
-					currentMessage = "دانلود لغو شد."
-				}
-			} else {
-				setSessionStatus(opt.stagingDir, "error", err.Error())
-				currentMessage = fmt.Sprintf("دانلود ناموفق: %s", err.Error())
-			}
-		} else {
-			currentMessage = "دانلود کامل شد."
+	if cmd, ok := lookupCommand(flag.Arg(0)); ok {
+		if err := cmd.run(opt, flag.Args()[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
 		}
-	}()
-}
-
-func setSessionStatus(dir, state, message string) {
-	if dir == "" {
-		return
-	}
-	meta, err := loadSessionMeta(dir)
-	if err != nil {
 		return
 	}
-	meta.State = state
-	meta.Message = message
-	_ = saveSessionMeta(meta)
-}
-
-func main() {
-	var opt options
-
-	flag.StringVar(&opt.registry, "registry", defaultRegistry, "registry base URL")
-	flag.IntVar(&opt.concurrency, "concurrency", 4, "number of concurrent blob downloads")
-	flag.BoolVar(&opt.verbose, "v", false, "verbose logging")
-	flag.BoolVar(&opt.keepStaging, "keep-staging", false, "keep staging directory (do not delete after zip)")
-	flag.IntVar(&opt.retries, "retries", 3, "retry attempts for transient errors")
-	var timeoutSec int
-	flag.IntVar(&timeoutSec, "timeout", 0, "overall request timeout seconds (0 = no limit)")
-	flag.BoolVar(&opt.insecureTLS, "insecure", false, "skip TLS verification (NOT recommended)")
-	// Default platform from runtime
-	defaultPlatform := fmt.Sprintf("linux/%s", archFromGo(runtime.GOARCH))
-	flag.StringVar(&opt.platform, "platform", defaultPlatform, "target platform (linux/amd64 or linux/arm64)")
-	flag.StringVar(&opt.outZip, "o", "", "output zip path (default: <model>.zip)")
-	flag.StringVar(&opt.outputDir, "output-dir", "downloaded-models", "directory to save downloaded models")
-	flag.IntVar(&opt.port, "port", 0, "port to listen on (0 for random)")
-	flag.Parse()
 
 	if flag.NArg() == 0 {
-		startWebServer(opt.port)
+		startWebServer(opt)
 	} else {
-		opt.model = flag.Arg(0)
-		opt.sessionID = sanitizeModelName(opt.model)
-		if opt.outZip == "" {
-			zipName := opt.sessionID
-			if !strings.HasSuffix(strings.ToLower(zipName), ".zip") {
-				zipName += ".zip"
-			}
-			opt.outZip = filepath.Join(opt.outputDir, zipName)
+		if err := pullCLI(opt, flag.Args()); err != nil {
+			printError(err)
+			os.Exit(1)
 		}
-		opt.stagingDir = filepath.Join(opt.outputDir, opt.sessionID+".staging")
+	}
+}
+
+// hiddenFlags are left out of -help: development switches that would only
+// confuse users.
+var hiddenFlags = map[string]bool{"chaos": true}
 
-		if timeoutSec > 0 {
-			opt.timeout = time.Duration(timeoutSec) * time.Second
-		} else {
-			opt.timeout = 0
+// printVisibleDefaults is flag.PrintDefaults without hiddenFlags.
+func printVisibleDefaults(flags *flag.FlagSet, out io.Writer) {
+	visible := flag.NewFlagSet(flags.Name(), flag.ContinueOnError)
+	visible.SetOutput(out)
+	flags.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
 		}
+	})
+	visible.PrintDefaults()
+}
 
-		if err := run(context.Background(), opt); err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
+// withModel fills the per-model fields of opt (session ID, output zip and
+// staging dir) the same way for every entry point. An explicit outZip is kept.
+func withModel(opt options, model string) options {
+	opt.model = model
+	opt.sessionID = sanitizeModelName(model)
+	if opt.outZip == "" {
+		zipName := opt.sessionID
+		if !strings.HasSuffix(strings.ToLower(zipName), ".zip") {
+			zipName += ".zip"
 		}
+		opt.outZip = filepath.Join(opt.outputDir, zipName)
+		opt.defaultZip = true
 	}
+	opt.stagingDir = filepath.Join(opt.outputDir, opt.sessionID+".staging")
+	return opt
 }
 
 func archFromGo(goarch string) string {
@@ -351,299 +350,51 @@ func sanitizeModelName(model string) string {
 	return s
 }
 
-func startWebServer(port int) {
-	// Create template with custom functions
-	funcMap := template.FuncMap{
-		"contains": strings.Contains,
-		"add": func(a, b int) int {
-			return a + b
-		},
-	}
-	tmpl, err := template.New("index.html").Funcs(funcMap).ParseFS(templateFS, "templates/index.html")
+// startWebServer serves the UI. Network-level settings from base (tracing,
+// resolve overrides) are applied to every download started from the browser.
+func startWebServer(base options) {
+	logger := newLogger(base.container)
+	srv, err := newServer(base, logger)
 	if err != nil {
-		fmt.Println("Error parsing template:", err)
-		return
+		logger.Error("error starting server", "err", err)
+		os.Exit(1)
 	}
 
-	downloadsDir := "downloaded-models"
-	if err := os.MkdirAll(downloadsDir, 0o755); err != nil {
-		fmt.Println("Error creating downloads directory:", err)
-		return
-	}
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		data := PageData{Message: currentMessage}
-		if currentZip != "" {
-			if _, err := os.Stat(currentZip); err == nil {
-				data.ZipPath = currentZip
-			}
-		}
-		// List downloaded models
-		data.Downloads = downloadsFromDir(downloadsDir)
-		if sessions, err := discoverPartialSessions(downloadsDir); err == nil {
-			running, paused, errored := categorizeSessions(sessions)
-			data.RunningSession = running
-			data.PausedSessions = paused
-			data.ErroredSessions = errored
-		}
-		tmpl.Execute(w, data)
-	})
-
-	http.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Bad request", http.StatusBadRequest)
-			return
-		}
-		model := r.FormValue("model")
-		outputDir := downloadsDir
-		concurrencyStr := r.FormValue("concurrency")
-		concurrency, _ := strconv.Atoi(concurrencyStr)
-		if concurrency <= 0 {
-			concurrency = 4
-		}
-		retriesStr := r.FormValue("retries")
-		retries, _ := strconv.Atoi(retriesStr)
-		if retries < 0 {
-			retries = 3
-		}
-
-		opt := options{
-			model:       model,
-			registry:    defaultRegistry,
-			platform:    fmt.Sprintf("linux/%s", archFromGo(runtime.GOARCH)),
-			concurrency: concurrency,
-			verbose:     false,
-			keepStaging: false,
-			retries:     retries,
-			timeout:     0,
-			insecureTLS: false,
-			outputDir:   outputDir,
-		}
-
-		sessionID := sanitizeModelName(opt.model)
-		opt.sessionID = sessionID
-		zipName := sessionID
-		if !strings.HasSuffix(strings.ToLower(zipName), ".zip") {
-			zipName += ".zip"
-		}
-		opt.outZip = filepath.Join(opt.outputDir, zipName)
-		opt.stagingDir = filepath.Join(opt.outputDir, sessionID+".staging")
-
-		beginDownloadSession(opt, "در حال دانلود...")
-
-		http.Redirect(w, r, "/", http.StatusFound)
-	})
-
-	http.HandleFunc("/model/action", modelActionHandler(downloadsDir))
-
-	http.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Bad request", http.StatusBadRequest)
-			return
-		}
-		sessionID := r.FormValue("session")
-		if sessionID == "" {
-			http.Error(w, "Missing session ID", http.StatusBadRequest)
-			return
-		}
-		staging := filepath.Join(downloadsDir, sessionID+".staging")
-		meta, err := loadSessionMeta(staging)
-		if err != nil {
-			http.Error(w, "Session not found", http.StatusNotFound)
-			return
-		}
-		registry := meta.Registry
-		if registry == "" {
-			registry = defaultRegistry
-		}
-		platform := meta.Platform
-		if platform == "" {
-			platform = fmt.Sprintf("linux/%s", archFromGo(runtime.GOARCH))
-		}
-		concurrency := meta.Concurrency
-		if concurrency <= 0 {
-			concurrency = 4
-		}
-		retries := meta.Retries
-		if retries < 0 {
-			retries = 3
-		}
-
-		zipPath := meta.OutZip
-		if zipPath == "" {
-			name := sessionID
-			if !strings.HasSuffix(strings.ToLower(name), ".zip") {
-				name += ".zip"
-			}
-			zipPath = filepath.Join(downloadsDir, name)
-		}
-
-		opt := options{
-			model:       meta.Model,
-			registry:    registry,
-			platform:    platform,
-			concurrency: concurrency,
-			verbose:     false,
-			keepStaging: false,
-			retries:     retries,
-			timeout:     0,
-			insecureTLS: false,
-			outputDir:   downloadsDir,
-			sessionID:   meta.SessionID,
-			stagingDir:  staging,
-			outZip:      zipPath,
-		}
-		setSessionStatus(staging, "downloading", "در حال ادامه دانلود...")
-		beginDownloadSession(opt, "در حال ادامه دانلود...")
-		http.Redirect(w, r, "/", http.StatusFound)
-	})
-
-	http.HandleFunc("/download/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		filename := strings.TrimPrefix(r.URL.Path, "/download/")
-		if filename == "" {
-			http.Error(w, "Not found", http.StatusNotFound)
-			return
-		}
-		if _, err := os.Stat(filename); os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
-			return
-		}
-		http.ServeFile(w, r, filename)
-	})
-
-	http.HandleFunc("/progress", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		data := ProgressData{}
-		if currentProgress != nil {
-			data.Done = atomic.LoadInt64(&currentProgress.done)
-			data.Total = currentProgress.total
-			if data.Total > 0 {
-				data.Percent = int((data.Done * 100) / data.Total)
-			}
-		}
-		json.NewEncoder(w).Encode(data)
-	})
-
-	http.HandleFunc("/cancel", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		pauseRequested.Store(false)
-		if globalCancel != nil {
-			setSessionStatus(currentSessionDir, "paused", "لغو شد")
-			globalCancel()
-		}
-		http.Redirect(w, r, "/", http.StatusFound)
-	})
-
-	http.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		if globalCancel != nil {
-			pauseRequested.Store(true)
-			setSessionStatus(currentSessionDir, "paused", "مکث شد")
-			globalCancel()
-		}
-		http.Redirect(w, r, "/", http.StatusFound)
-	})
-
-	bindPort := port
+	bindPort := base.port
 	if bindPort == 0 {
 		bindPort = defaultWebPort
 	}
 	addr := fmt.Sprintf(":%d", bindPort)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		fmt.Printf("Port %d not available, using random port...\n", bindPort)
+		// A container publishes a fixed port; a random one is unreachable.
+		if base.container {
+			logger.Error("error starting server", "addr", addr, "err", err)
+			os.Exit(1)
+		}
+		logger.Warn(fmt.Sprintf("Port %d not available, using random port...", bindPort))
 		listener, err = net.Listen("tcp", ":0")
 		if err != nil {
-			fmt.Println("Error starting server:", err)
-			return
+			logger.Error("error starting server", "err", err)
+			os.Exit(1)
 		}
 	}
 	actualPort := listener.Addr().(*net.TCPAddr).Port
-	fmt.Printf("Running on http://localhost:%d\n", actualPort)
-	go http.Serve(listener, nil)
 	url := fmt.Sprintf("http://localhost:%d", actualPort)
-	openBrowser(url)
-	select {}
-}
-
-func modelActionHandler(downloadsDir string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Bad request", http.StatusBadRequest)
-			return
-		}
-		name := r.FormValue("name")
-		action := r.FormValue("action")
-		if name == "" || action == "" {
-			http.Error(w, "Missing parameters", http.StatusBadRequest)
-			return
-		}
-		target := filepath.Join(downloadsDir, name)
-		var msg string
-		var err error
-		switch action {
-		case "delete":
-			err = os.Remove(target)
-			if err == nil {
-				staging := filepath.Join(downloadsDir, strings.TrimSuffix(name, ".zip")+".staging")
-				_ = os.RemoveAll(staging)
-				msg = fmt.Sprintf("%s حذف شد.", name)
-			}
-		case "open-folder":
-			err = openExplorer(downloadsDir)
-			if err == nil {
-				msg = "پوشه دانلود باز شد."
-			}
-		case "unzip":
-			dest, derr := ollamaModelsDir()
-			if derr != nil {
-				err = derr
-				break
-			}
-			err = unzipToDir(target, dest)
-			if err == nil {
-				msg = fmt.Sprintf("%s به %s استخراج شد.", name, dest)
-			}
-		default:
-			err = fmt.Errorf("عمل نامعتبر: %s", action)
-		}
-		if err != nil {
-			currentMessage = fmt.Sprintf("خطا: %s", err)
-		} else if msg != "" {
-			currentMessage = msg
+	logger.Info("Running on "+url, "port", actualPort, "outputDir", srv.downloadsDir)
+	go http.Serve(listener, srv.handler())
+	go srv.trending.run(context.Background(), base, logger)
+	srv.recoverInterrupted()
+	if !base.container {
+		// The URL is the fallback whenever no browser shows up.
+		fmt.Printf("\n    Open %s in your browser\n\n", url)
+	}
+	if !base.noBrowser {
+		if err := openBrowser(url); err != nil {
+			logger.Warn("could not open a browser", "err", err)
 		}
-		http.Redirect(w, r, "/", http.StatusFound)
 	}
+	select {}
 }
 
 func openExplorer(path string) error {
@@ -661,25 +412,6 @@ func openExplorer(path string) error {
 	return cmd.Start()
 }
 
-func ollamaModelsDir() (string, error) {
-	if dir := os.Getenv("OLLAMA_MODELS_DIR"); dir != "" {
-		return dir, nil
-	}
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
-	}
-	switch runtime.GOOS {
-	case "windows":
-		if local := os.Getenv("LOCALAPPDATA"); local != "" {
-			return filepath.Join(local, "Ollama", "models"), nil
-		}
-		return filepath.Join(home, "AppData", "Local", "Ollama", "models"), nil
-	default:
-		return filepath.Join(home, ".ollama", "models"), nil
-	}
-}
-
 func unzipToDir(zipPath, dest string) error {
 	r, err := zip.OpenReader(zipPath)
 	if err != nil {
@@ -727,18 +459,23 @@ func unzipToDir(zipPath, dest string) error {
 	return nil
 }
 
-func openBrowser(url string) {
+// openBrowser opens url in the user's default browser: $BROWSER if set,
+// otherwise the platform's opener. It fails when no opener is available.
+func openBrowser(url string) error {
 	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "darwin":
+	switch {
+	case os.Getenv("BROWSER") != "":
+		cmd = exec.Command(os.Getenv("BROWSER"), url)
+	case runtime.GOOS == "darwin":
 		cmd = exec.Command("open", url)
-	case "linux":
-		cmd = exec.Command("xdg-open", url)
-	case "windows":
-		cmd = exec.Command("cmd", "/c", "start", "", url)
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
 	default:
-		fmt.Println("Unsupported OS for opening browser")
-		return
+		cmd = exec.Command("xdg-open", url)
 	}
-	cmd.Start()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestCache stores fetched manifests and indexes on disk keyed by
+// registry + repository + reference. Entries younger than ttl are served
+// without contacting the registry; older entries are revalidated with
+// If-None-Match. Digest references are immutable and never expire.
+type manifestCache struct {
+	dir string
+	ttl time.Duration
+}
+
+type cachedManifest struct {
+	Registry    string    `json:"registry"`
+	Repository  string    `json:"repository"`
+	Reference   string    `json:"reference"`
+	ContentType string    `json:"contentType"`
+	ETag        string    `json:"etag"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+	Body        []byte    `json:"body"`
+}
+
+// newManifestCache returns nil (caching disabled) when dir is empty.
+func newManifestCache(dir string, ttl time.Duration) *manifestCache {
+	if dir == "" {
+		return nil
+	}
+	return &manifestCache{dir: dir, ttl: ttl}
+}
+
+// defaultManifestCacheDir is <user cache dir>/ollama-model-downloader/manifests.
+func defaultManifestCacheDir() string {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "ollama-model-downloader", "manifests")
+}
+
+func (c *manifestCache) path(registry, repository, reference string) string {
+	sum := sha256.Sum256([]byte(strings.TrimRight(registry, "/") + "\x00" + repository + "\x00" + reference))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *manifestCache) get(registry, repository, reference string) (cachedManifest, bool) {
+	var entry cachedManifest
+	if c == nil {
+		return entry, false
+	}
+	data, err := os.ReadFile(c.path(registry, repository, reference))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil || len(entry.Body) == 0 {
+		return entry, false
+	}
+	// A digest names its content: a body that no longer hashes to it is
+	// damaged or tampered with, and is fetched again.
+	if strings.HasPrefix(reference, "sha256:") && !strings.EqualFold(manifestDigest(entry.Body), reference) {
+		return entry, false
+	}
+	return entry, true
+}
+
+// hasFresh reports whether get has an entry for reference that is fresh
+// enough to be served without asking the registry.
+func (c *manifestCache) hasFresh(registry, repository, reference string) bool {
+	entry, ok := c.get(registry, repository, reference)
+	return ok && c.fresh(entry)
+}
+
+func (c *manifestCache) fresh(entry cachedManifest) bool {
+	if strings.HasPrefix(entry.Reference, "sha256:") {
+		return true
+	}
+	return c.ttl > 0 && time.Since(entry.FetchedAt) < c.ttl
+}
+
+func (c *manifestCache) put(entry cachedManifest) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := c.path(entry.Registry, entry.Repository, entry.Reference)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
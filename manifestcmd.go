@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "manifest",
+		usage: "show the resolved manifest (and index) of a model; -digest-only prints just its digest",
+		run:   runManifest,
+	})
+}
+
+// mediaTypeNames are the short names `manifest` shows for media types.
+var mediaTypeNames = map[string]string{
+	mtOCIIndex:        "OCI index",
+	mtDockerIndex:     "Docker manifest list",
+	mtOCIManifest:     "OCI manifest",
+	mtDockerManifest:  "Docker manifest",
+	mtDockerConfig:    "config",
+	mtOCIConfig:       "config",
+	mtOllamaModel:     "model",
+	mtOllamaAdapter:   "adapter",
+	mtOllamaProjector: "projector",
+	mtOllamaTemplate:  "template",
+	mtOllamaSystem:    "system prompt",
+	mtOllamaParams:    "parameters",
+	mtOllamaMessages:  "messages",
+	mtOllamaLicense:   "license",
+}
+
+func mediaTypeName(mt string) string {
+	if name, ok := mediaTypeNames[mt]; ok {
+		return name
+	}
+	return mt
+}
+
+func runManifest(opt options, args []string) error {
+	flags := flag.NewFlagSet("manifest", flag.ContinueOnError)
+	digestOnly := flags.Bool("digest-only", false, "print only the digest of the resolved manifest")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: manifest [-digest-only] <model>")
+	}
+	opt.model = flags.Arg(0)
+	ref, err := parseModel(opt.registry, opt.model, opt.modelConfig)
+	if err != nil {
+		return err
+	}
+	if opt, err = withProfile(opt, ref); err != nil {
+		return err
+	}
+	res, err := resolveManifest(context.Background(), newHTTPClient(opt), opt, ref)
+	if err != nil {
+		return err
+	}
+	if *digestOnly {
+		fmt.Println(manifestDigest(res.raw))
+		return nil
+	}
+	return printManifest(opt, res)
+}
+
+func printManifest(opt options, res resolvedManifest) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "model\t%s\n", opt.model)
+	fmt.Fprintf(w, "registry\t%s\n", opt.registry)
+	fmt.Fprintf(w, "repository\t%s\n", res.ref.Repository)
+	fmt.Fprintf(w, "reference\t%s\n", res.ref.Reference)
+	if res.index != nil {
+		var idx imageIndex
+		if err := json.Unmarshal(res.index, &idx); err != nil {
+			return fmt.Errorf("decode index: %w", err)
+		}
+		chosen, _ := selectPlatform(idx, opt.platform)
+		fmt.Fprintf(w, "index\t%s\t%s, %d platforms\n", manifestDigest(res.index), mediaTypeName(res.indexMediaType), len(idx.Manifests))
+		for _, m := range idx.Manifests {
+			mark := ""
+			if m.Digest == chosen {
+				mark = "(selected)"
+			}
+			fmt.Fprintf(w, "  %s/%s\t%s\t%s\n", m.Platform.OS, m.Platform.Architecture, m.Digest, mark)
+		}
+	}
+	fmt.Fprintf(w, "digest\t%s\n", manifestDigest(res.raw))
+	if name := mediaTypeName(res.mediaType); name != res.mediaType {
+		fmt.Fprintf(w, "media type\t%s\t%s\n", name, res.mediaType)
+	} else {
+		fmt.Fprintf(w, "media type\t%s\n", res.mediaType)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "BLOB\tSIZE\tDIGEST")
+	var total int64
+	blobs := manifestBlobs(res.manifest, blobSource{})
+	for _, b := range blobs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", mediaTypeName(b.mediaType), humanBytes(b.size), b.digest)
+		total += b.size
+	}
+	fmt.Fprintf(w, "total\t%s\t%d blobs\n", humanBytes(total), len(blobs))
+	return w.Flush()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"ollama-model-downloader/config"
+)
+
+// manifestKind is how resolveManifest treats a registry response.
+type manifestKind int
+
+const (
+	kindUnknown  manifestKind = iota
+	kindManifest              // image (or artifact) manifest listing blobs
+	kindIndex                 // index of per-platform manifests
+)
+
+// manifestTypes are the manifest media types requested from registries and
+// accepted in responses: the OCI and Docker ones plus any added in the
+// config file. The zero value accepts the built-in types.
+type manifestTypes struct {
+	manifests []string
+	indexes   []string
+}
+
+func newManifestTypes(extra config.ManifestTypes) manifestTypes {
+	return manifestTypes{manifests: extra.Manifests, indexes: extra.Indexes}
+}
+
+// accept is the Accept header for manifest requests, indexes first so
+// multi-platform images are resolved for -platform.
+func (t manifestTypes) accept() string {
+	types := []string{mtOCIIndex, mtDockerIndex}
+	types = append(types, t.indexes...)
+	types = append(types, mtOCIManifest, mtDockerManifest)
+	types = append(types, t.manifests...)
+	return strings.Join(types, ", ")
+}
+
+// classify decides what body is from its Content-Type, falling back to
+// detectManifestKind for types it does not know. detected reports that the
+// fallback was used.
+func (t manifestTypes) classify(contentType string, body []byte) (kind manifestKind, detected bool) {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	switch contentType {
+	case mtOCIManifest, mtDockerManifest:
+		return kindManifest, false
+	case mtOCIIndex, mtDockerIndex:
+		return kindIndex, false
+	}
+	for _, mt := range t.manifests {
+		if strings.EqualFold(mt, contentType) {
+			return kindManifest, false
+		}
+	}
+	for _, mt := range t.indexes {
+		if strings.EqualFold(mt, contentType) {
+			return kindIndex, false
+		}
+	}
+	return detectManifestKind(body), true
+}
+
+// detectManifestKind guesses the kind of a manifest from its JSON shape, for
+// registries that send a generic or unknown Content-Type.
+func detectManifestKind(body []byte) manifestKind {
+	var probe struct {
+		Config *json.RawMessage  `json:"config"`
+		Layers []json.RawMessage `json:"layers"`
+		Blobs  []json.RawMessage `json:"blobs"`
+		// Manifests lists the entries of an index.
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return kindUnknown
+	}
+	switch {
+	case probe.Config != nil || len(probe.Layers) > 0 || len(probe.Blobs) > 0:
+		return kindManifest
+	case len(probe.Manifests) > 0:
+		return kindIndex
+	}
+	return kindUnknown
+}
+
+// decodeManifest decodes an image manifest. The blobs of an OCI artifact
+// manifest are treated as layers.
+func decodeManifest(raw []byte) (imageManifest, error) {
+	var m struct {
+		imageManifest
+		Blobs []manifestLayer `json:"blobs"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return imageManifest{}, err
+	}
+	m.Layers = append(m.Layers, m.Blobs...)
+	return m.imageManifest, nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	apperrors "ollama-model-downloader/internal/errors"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "mirror",
+		usage: "download every tag of a namespace (or list) matching a filter",
+		run:   runMirror,
+	})
+}
+
+const mirrorStateFileName = "mirror.json"
+
+// mirrorState records what has been mirrored into an output directory so
+// repeated runs only fetch new or missing archives.
+type mirrorState struct {
+	Entries map[string]mirrorEntry `json:"entries"`
+}
+
+type mirrorEntry struct {
+	Model      string    `json:"model"`
+	Zip        string    `json:"zip"`
+	MirroredAt time.Time `json:"mirroredAt"`
+}
+
+func loadMirrorState(dir string) (mirrorState, error) {
+	state := mirrorState{Entries: map[string]mirrorEntry{}}
+	data, err := os.ReadFile(filepath.Join(dir, mirrorStateFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, err
+	}
+	if state.Entries == nil {
+		state.Entries = map[string]mirrorEntry{}
+	}
+	return state, nil
+}
+
+func saveMirrorState(dir string, state mirrorState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, mirrorStateFileName), data, 0o644)
+}
+
+func runMirror(opt options, args []string) error {
+	flags := flag.NewFlagSet("mirror", flag.ContinueOnError)
+	listFile := flags.String("list", "", "file with one repository or model[:tag] per line instead of querying _catalog")
+	filter := flags.String("filter", "", "only mirror models whose name:tag matches this regular expression")
+	dryRun := flags.Bool("dry-run", false, "list matching models without downloading")
+	refresh := flags.Bool("refresh", false, "re-download models already recorded in mirror.json")
+	bundle := flags.String("o", "", "package every matching model into this one zip, storing shared blobs once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	namespace := opt.modelConfig.owner()
+	if flags.NArg() > 0 {
+		namespace = strings.Trim(flags.Arg(0), "/")
+	}
+	var re *regexp.Regexp
+	if *filter != "" {
+		var err error
+		if re, err = regexp.Compile(*filter); err != nil {
+			return fmt.Errorf("invalid -filter: %w", err)
+		}
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	client := newHTTPClient(opt)
+
+	var entries []string
+	var err error
+	if *listFile != "" {
+		entries, err = readListFile(*listFile)
+	} else {
+		entries, err = listCatalog(ctx, client, opt, namespace)
+	}
+	if err != nil {
+		return err
+	}
+
+	var models []string
+	for _, e := range entries {
+		if strings.Contains(e, ":") || strings.Contains(e, "@") {
+			models = append(models, e)
+			continue
+		}
+		repo := e
+		if !strings.Contains(repo, "/") {
+			repo = namespace + "/" + repo
+		}
+		tags, err := listTags(ctx, client, opt, repo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: cannot list tags for %s: %v\n", repo, err)
+			continue
+		}
+		for _, t := range tags {
+			models = append(models, shortModelName(repo, opt.modelConfig)+":"+t)
+		}
+	}
+	if re != nil {
+		kept := models[:0]
+		for _, m := range models {
+			if re.MatchString(m) {
+				kept = append(kept, m)
+			}
+		}
+		models = kept
+	}
+	sort.Strings(models)
+
+	if err := os.MkdirAll(opt.outputDir, 0o755); err != nil {
+		return err
+	}
+	state, err := loadMirrorState(opt.outputDir)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, model := range models {
+		// A bundle has to contain every model, mirrored before or not.
+		if prev, ok := state.Entries[model]; ok && !*refresh && *bundle == "" {
+			if _, err := os.Stat(prev.Zip); err == nil {
+				if opt.verbose {
+					fmt.Println("already mirrored:", model)
+				}
+				continue
+			}
+		}
+		if *dryRun {
+			fmt.Println(model)
+			continue
+		}
+		pending = append(pending, model)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// Resolve every manifest first so blobs shared between models are
+	// fetched once, then package the models one by one.
+	if !opt.quiet {
+		fmt.Printf("resolving %d model(s)\n", len(pending))
+	}
+	items := resolveMirrorItems(ctx, opt, pending)
+	if *bundle != "" {
+		if err := writeBundle(ctx, opt, items, *bundle); err != nil {
+			if ctx.Err() == nil {
+				notifyMirror(opt, namespace, nil, pending)
+			}
+			return err
+		}
+		notifyMirror(opt, namespace, pending, nil)
+		for _, it := range items {
+			state.Entries[it.model] = mirrorEntry{Model: it.model, Zip: *bundle, MirroredAt: time.Now()}
+		}
+		return saveMirrorState(opt.outputDir, state)
+	}
+	shared := filepath.Join(opt.outputDir, sharedBlobsDirName)
+	if err := fetchSharedBlobs(ctx, opt, items, shared); err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "interrupted; blobs fetched so far are kept in %s\n", shared)
+			return errInterrupted
+		}
+		notifyMirror(opt, namespace, nil, pending)
+		return err
+	}
+
+	var mirrored, failed []string
+	for _, it := range items {
+		if it.err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", it.model, it.err)
+			failed = append(failed, it.model)
+			continue
+		}
+		if err := seedStagingBlobs(shared, it.opt.stagingDir, it.blobs); err != nil {
+			return err
+		}
+		mopt := it.opt
+		mopt.resolved = it.res
+		mopt.notifiers = nil // reported in the run's summary
+		if !opt.quiet {
+			fmt.Println("mirroring", it.model)
+		}
+		if err := runCLI(ctx, mopt); err != nil {
+			if err == errInterrupted {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", it.model, err)
+			failed = append(failed, it.model)
+			continue
+		}
+		mirrored = append(mirrored, it.model)
+		state.Entries[it.model] = mirrorEntry{Model: it.model, Zip: archivePath(mopt), MirroredAt: time.Now()}
+		if err := saveMirrorState(opt.outputDir, state); err != nil {
+			return err
+		}
+	}
+	notifyMirror(opt, namespace, mirrored, failed)
+	if len(failed) > 0 {
+		// Keep the shared blobs so the next run only fetches what is missing.
+		return fmt.Errorf("%d of %d model(s) failed to mirror", len(failed), len(pending))
+	}
+	return os.RemoveAll(shared)
+}
+
+// shortModelName turns "library/llama3" (or "<namespace>/llama3" for a
+// configured default namespace) into "llama3" and leaves other namespaces
+// intact, matching what parseModel accepts.
+func shortModelName(repo string, mc modelConfig) string {
+	return strings.TrimPrefix(repo, mc.owner()+"/")
+}
+
+func readListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out, sc.Err()
+}
+
+// listCatalog returns the repositories under namespace using the
+// registry's _catalog endpoint, following Link pagination.
+func listCatalog(ctx context.Context, client *http.Client, opt options, namespace string) ([]string, error) {
+	var repos []string
+	next := "/v2/_catalog?n=1000"
+	for next != "" {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		link, err := registryGetJSON(ctx, client, opt, next, "registry:catalog:*", &page)
+		if err != nil {
+			return nil, fmt.Errorf("catalog: %w (use -list to provide repositories)", err)
+		}
+		for _, r := range page.Repositories {
+			if strings.HasPrefix(r, namespace+"/") {
+				repos = append(repos, r)
+			}
+		}
+		next = nextLink(link)
+	}
+	return repos, nil
+}
+
+func listTags(ctx context.Context, client *http.Client, opt options, repository string) ([]string, error) {
+	var out struct {
+		Tags []string `json:"tags"`
+	}
+	if _, err := registryGetJSON(ctx, client, opt, "/v2/"+repository+"/tags/list", fmt.Sprintf("repository:%s:pull", repository), &out); err != nil {
+		return nil, err
+	}
+	return out.Tags, nil
+}
+
+// registryGetJSON GETs path from the registry, answering a bearer challenge
+// with a token for scope when required, and decodes the JSON body into v.
+// It returns the response Link header for pagination.
+func registryGetJSON(ctx context.Context, client *http.Client, opt options, path, scope string, v interface{}) (string, error) {
+	u := strings.TrimRight(opt.registry, "/") + path
+	headers := map[string]string{
+		"Accept":     "application/json",
+		"User-Agent": "ollama-model-downloader/1.0",
+	}
+	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, u, headers, opt.retries, opt.verbose)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := challengeToken(ctx, client, opt, resp, scope)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		headers["Authorization"] = authHeader(token)
+		resp, err = httpReqWithRetry(ctx, client, http.MethodGet, u, headers, opt.retries, opt.verbose)
+		if err != nil {
+			return "", err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", apperrors.WithKind(statusKind(resp.StatusCode), fmt.Errorf("GET %s: %s", path, resp.Status))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("Link"), nil
+}
+
+// nextLink extracts the path from a `<...>; rel="next"` Link header.
+func nextLink(link string) string {
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+	if start < 0 || end <= start {
+		return ""
+	}
+	u, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return u.RequestURI()
+}
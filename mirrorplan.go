@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sharedBlobsDirName is where mirror downloads the blobs of a batch before
+// packaging. Families of quantizations share most layers, so each unique
+// blob is fetched once and linked into every staging directory needing it.
+const sharedBlobsDirName = ".mirror-blobs"
+
+// mirrorItem is one model of a mirror batch after manifest resolution.
+type mirrorItem struct {
+	model string
+	opt   options
+	res   *resolvedManifest
+	blobs []blobItem
+	err   error
+}
+
+// resolveMirrorItems resolves the manifests of models concurrently, at most
+// opt.concurrency at a time, and applies checkPolicy to each. Failures are
+// kept per item.
+func resolveMirrorItems(ctx context.Context, opt options, models []string) []mirrorItem {
+	items := make([]mirrorItem, len(models))
+	sem := make(chan struct{}, max(1, opt.concurrency))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		mopt := opt
+		mopt.outZip = ""
+		items[i] = mirrorItem{model: model, opt: withModel(mopt, model)}
+		wg.Add(1)
+		go func(it *mirrorItem) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			it.res, it.blobs, it.err = resolveMirrorItem(ctx, it.opt)
+		}(&items[i])
+	}
+	wg.Wait()
+	return items
+}
+
+func resolveMirrorItem(ctx context.Context, opt options) (*resolvedManifest, []blobItem, error) {
+	ref, err := parseModel(opt.registry, opt.model, opt.modelConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opt, err = withProfile(opt, ref); err != nil {
+		return nil, nil, err
+	}
+	client := newHTTPClient(opt)
+	res, err := resolveManifest(ctx, client, opt, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := res.authorize(ctx, client, opt); err != nil {
+		return nil, nil, err
+	}
+	// A model that would be refused must not cost its blobs.
+	if _, err := checkPolicy(ctx, client, opt, res); err != nil {
+		return nil, nil, err
+	}
+	src := blobSource{client: client, registry: opt.registry, repository: res.ref.Repository, token: res.token, renew: tokenRenewer(client, opt, res.ref)}
+	blobs, _ := opt.layers.apply(dedupeBlobs(manifestBlobs(res.manifest, src)))
+	return &res, blobs, nil
+}
+
+// fetchSharedBlobs downloads every unique blob of the resolved items into
+// dir. A blob that fails here is only logged: the run of each model that
+// needs it fetches it again on its own.
+func fetchSharedBlobs(ctx context.Context, opt options, items []mirrorItem, dir string) error {
+	var unique []blobItem
+	var total, sum int64
+	seen := map[string]bool{}
+	for _, it := range items {
+		for _, b := range it.blobs {
+			sum += b.size
+			if seen[b.digest] {
+				continue
+			}
+			seen[b.digest] = true
+			unique = append(unique, b)
+			total += b.size
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+	if !opt.quiet {
+		fmt.Printf("%d unique blobs, %s (%s shared between models)\n", len(unique), humanBytes(total), humanBytes(sum-total))
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	p := newProgress(total)
+	p.SetDone(computeExistingBytes(dir, unique))
+	p.Start(ctx)
+	sem := make(chan struct{}, max(1, opt.concurrency))
+	var wg sync.WaitGroup
+	for _, b := range unique {
+		b := b
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := b.src.download(ctx, b, dir, opt.retries, p, opt.verbose); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "\nwarning: %s: %v\n", b.digest, err)
+			}
+		}()
+	}
+	wg.Wait()
+	p.Stop()
+	p.finishLine()
+	return ctx.Err()
+}
+
+// seedStagingBlobs links (or, across file systems, copies) the complete
+// blobs of shared into the blobs directory of stagingDir.
+func seedStagingBlobs(shared, stagingDir string, blobs []blobItem) error {
+	blobsDir := filepath.Join(stagingDir, "models", "blobs")
+	if err := os.MkdirAll(blobsDir, 0o755); err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		name := blobFileName(b.digest)
+		src, dst := filepath.Join(shared, name), filepath.Join(blobsDir, name)
+		if st, err := os.Stat(src); err != nil || st.Size() != b.size {
+			continue
+		}
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := os.Link(src, dst); err == nil {
+			continue
+		}
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return commitBlob(tmp, dst)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ollama-model-downloader/internal/registrytest"
+)
+
+// TestMirrorBatchQuota resolves two models that each fit under -quota but
+// not together: the batch is admitted one model at a time.
+func TestMirrorBatchQuota(t *testing.T) {
+	reg, srv, _ := testModel(t, 64<<10)
+	other := make([]byte, 64<<10)
+	rand.Read(other)
+	reg.AddModel("test/m", "other", []byte(`{"model_format":"gguf"}`), registrytest.Layer{MediaType: modelMediaType, Data: other})
+	opt := testOptions(t, srv.URL)
+	opt.quota = 200 << 10
+	opt.quotaReservations = newQuotaReservations()
+
+	var refused int
+	for _, it := range resolveMirrorItems(context.Background(), opt, []string{"test/m:latest", "test/m:other"}) {
+		if it.err != nil {
+			if !strings.Contains(it.err.Error(), "-quota") {
+				t.Fatalf("%s: %v", it.model, it.err)
+			}
+			refused++
+		}
+	}
+	if refused != 1 {
+		t.Errorf("%d of 2 models refused, want 1", refused)
+	}
+	if n := reg.Requests("/blobs/" + registrytest.Digest(other)); n != 0 {
+		t.Errorf("blobs fetched %d times while resolving", n)
+	}
+}
+
+// TestMirrorRenewsToken rejects the token once while blobs are fetched, as
+// a registry does when a short-lived token expires mid-batch.
+func TestMirrorRenewsToken(t *testing.T) {
+	reg, srv, layer := testModel(t, 16<<10)
+	reg.RequireAuth = true
+	opt := testOptions(t, srv.URL)
+	items := resolveMirrorItems(context.Background(), opt, []string{"test/m:latest"})
+	if items[0].err != nil {
+		t.Fatal(items[0].err)
+	}
+	digest := registrytest.Digest(layer)
+	reg.FailNext("/blobs/"+digest, http.StatusUnauthorized)
+	tokens := reg.Requests("/token")
+
+	dir := filepath.Join(opt.outputDir, sharedBlobsDirName)
+	if err := fetchSharedBlobs(context.Background(), opt, items, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, blobFileName(digest))); err != nil {
+		t.Errorf("blob not fetched after the 401: %v", err)
+	}
+	if reg.Requests("/token") == tokens {
+		t.Error("the token was not renewed")
+	}
+}
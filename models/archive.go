@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// ArchiveInfoSuffix is appended to an archive path for the sidecar holding
+// the user's labels and notes.
+const ArchiveInfoSuffix = ".info.json"
+
+// ArchiveInfo is free-text metadata the user attached to a downloaded
+// archive.
+type ArchiveInfo struct {
+	Labels []string `json:"labels,omitempty"`
+	Notes  string   `json:"notes,omitempty"`
+}
+
+// LoadArchiveInfo reads the sidecar of zipPath. A missing sidecar is not an
+// error and yields an empty ArchiveInfo.
+func LoadArchiveInfo(zipPath string) (ArchiveInfo, error) {
+	var info ArchiveInfo
+	data, err := os.ReadFile(zipPath + ArchiveInfoSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return info, nil
+		}
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// SaveArchiveInfo writes the sidecar of zipPath. Blank labels are dropped,
+// and the sidecar is removed when nothing is left to store.
+func SaveArchiveInfo(zipPath string, info ArchiveInfo) error {
+	var labels []string
+	for _, l := range info.Labels {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	info.Labels = labels
+	info.Notes = strings.TrimSpace(info.Notes)
+	if len(info.Labels) == 0 && info.Notes == "" {
+		err := os.Remove(zipPath + ArchiveInfoSuffix)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(zipPath+ArchiveInfoSuffix, data, 0o644)
+}
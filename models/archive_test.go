@@ -0,0 +1,28 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveInfoRoundTrip(t *testing.T) {
+	zip := filepath.Join(t.TempDir(), "llama3.zip")
+	if err := SaveArchiveInfo(zip, ArchiveInfo{Labels: []string{" prod ", ""}, Notes: "for the demo"}); err != nil {
+		t.Fatalf("SaveArchiveInfo() error = %v", err)
+	}
+	got, err := LoadArchiveInfo(zip)
+	if err != nil {
+		t.Fatalf("LoadArchiveInfo() error = %v", err)
+	}
+	if len(got.Labels) != 1 || got.Labels[0] != "prod" || got.Notes != "for the demo" {
+		t.Errorf("LoadArchiveInfo() = %+v", got)
+	}
+
+	if err := SaveArchiveInfo(zip, ArchiveInfo{}); err != nil {
+		t.Fatalf("SaveArchiveInfo(empty) error = %v", err)
+	}
+	if _, err := os.Stat(zip + ArchiveInfoSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected empty info to remove the sidecar, stat error = %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// BlobStore holds complete blobs by digest ("sha256:<hex>"). Like Store
+// identifies a session by its staging directory, dir identifies a set of
+// blobs by its blobs directory; stores that keep blobs elsewhere may treat
+// it as an opaque key. Partial downloads stay in dir as .part files until
+// they are Put, because resuming them needs a local file to append to.
+//
+// Packaging still zips the staging directory, so a store that keeps blobs
+// elsewhere has to leave them in dir as well.
+type BlobStore interface {
+	// Stat returns the size of the blob; the error satisfies
+	// os.IsNotExist when dir has no complete blob with that digest.
+	Stat(dir, digest string) (int64, error)
+	// Open streams the blob.
+	Open(dir, digest string) (io.ReadCloser, error)
+	// Get reads a small blob, such as a config or template, whole.
+	Get(dir, digest string) ([]byte, error)
+	// Put moves the complete, verified local file into the store under
+	// digest. The file is gone afterwards.
+	Put(dir, digest, file string) error
+}
+
+// DefaultBlobStore is used for staged blobs. Replace it at startup to
+// move them to another backend.
+var DefaultBlobStore BlobStore = FileBlobStore{}
+
+// FileBlobStore keeps each blob in <dir>/sha256-<hex>, the layout of
+// Ollama's models/blobs directory.
+type FileBlobStore struct {
+	// Durable fsyncs a blob before it is renamed into place and the
+	// directory after, so a power cut cannot leave a complete-looking
+	// blob that is not on the disk.
+	Durable bool
+}
+
+// BlobPath is where FileBlobStore keeps digest in dir.
+func BlobPath(dir, digest string) (string, error) {
+	hexhash, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || hexhash == "" || strings.ContainsAny(hexhash, `/\`) {
+		return "", fmt.Errorf("unsupported digest: %s", digest)
+	}
+	return filepath.Join(dir, "sha256-"+hexhash), nil
+}
+
+func (FileBlobStore) Stat(dir, digest string) (int64, error) {
+	path, err := BlobPath(dir, digest)
+	if err != nil {
+		return 0, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func (FileBlobStore) Open(dir, digest string) (io.ReadCloser, error) {
+	path, err := BlobPath(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (FileBlobStore) Get(dir, digest string) ([]byte, error) {
+	path, err := BlobPath(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (s FileBlobStore) Put(dir, digest, file string) error {
+	path, err := BlobPath(dir, digest)
+	if err != nil {
+		return err
+	}
+	if s.Durable {
+		if err := syncPath(file); err != nil {
+			return err
+		}
+		if err := syncPath(dir); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(file, path); err != nil {
+		return err
+	}
+	if s.Durable {
+		return syncPath(dir)
+	}
+	return nil
+}
+
+// syncPath fsyncs the file or directory at path. Windows cannot fsync a
+// directory; renames there are made durable by the file system itself.
+func syncPath(path string) error {
+	if runtime.GOOS == "windows" {
+		if st, err := os.Stat(path); err == nil && st.IsDir() {
+			return nil
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	const digest = "sha256:0123456789abcdef"
+	for _, store := range []FileBlobStore{{}, {Durable: true}} {
+		if _, err := store.Stat(dir, digest); !os.IsNotExist(err) {
+			t.Fatalf("Stat of a missing blob: %v", err)
+		}
+		part := filepath.Join(dir, "sha256-0123456789abcdef.part")
+		if err := os.WriteFile(part, []byte("blob data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(dir, digest, part); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(part); !os.IsNotExist(err) {
+			t.Error("Put left the file behind")
+		}
+		if size, err := store.Stat(dir, digest); err != nil || size != 9 {
+			t.Errorf("Stat = %d, %v", size, err)
+		}
+		if data, err := store.Get(dir, digest); err != nil || string(data) != "blob data" {
+			t.Errorf("Get = %q, %v", data, err)
+		}
+		r, err := store.Open(dir, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if string(data) != "blob data" {
+			t.Errorf("Open read %q", data)
+		}
+		os.Remove(filepath.Join(dir, "sha256-0123456789abcdef"))
+	}
+
+	if _, err := (FileBlobStore{}).Stat(dir, "md5:abc"); err == nil {
+		t.Error("unsupported digest should fail")
+	}
+	if _, err := (FileBlobStore{}).Get(dir, "sha256:../session.json"); err == nil {
+		t.Error("digest with a path separator should fail")
+	}
+}
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const historyFileName = "history.jsonl"
+
+// Outcomes of a download run.
+const (
+	OutcomeCompleted = "completed"
+	OutcomeCanceled  = "canceled"
+	OutcomeFailed    = "failed"
+)
+
+// HistoryEntry records one download run, successful or not. Resuming a
+// session starts a new run.
+type HistoryEntry struct {
+	Model     string    `json:"model"`
+	SessionID string    `json:"sessionId"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+	// TotalBytes is the size of all blobs, BytesFetched what this run
+	// actually transferred.
+	TotalBytes   int64  `json:"totalBytes"`
+	BytesFetched int64  `json:"bytesFetched"`
+	Outcome      string `json:"outcome"`
+	Error        string `json:"error,omitempty"`
+	// Digest is the manifest digest the run resolved to, if it got that far.
+	Digest string `json:"digest,omitempty"`
+	// Verification is the blob integrity report, when the run got that far.
+	Verification *VerificationReport `json:"verification,omitempty"`
+}
+
+// Duration is how long the run took.
+func (e HistoryEntry) Duration() time.Duration {
+	return e.EndedAt.Sub(e.StartedAt)
+}
+
+// HistoryPath is the append-only history log of an output directory.
+func HistoryPath(outputDir string) string {
+	return filepath.Join(outputDir, historyFileName)
+}
+
+// AppendHistory adds entry to the history of outputDir.
+func AppendHistory(outputDir string, entry HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(HistoryPath(outputDir), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadHistory reads the history of outputDir, oldest first. A missing log
+// is empty; lines that do not parse (e.g. a torn last write) are skipped.
+func LoadHistory(outputDir string) ([]HistoryEntry, error) {
+	f, err := os.Open(HistoryPath(outputDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var entries []HistoryEntry
+	sc := bufio.NewScanner(f)
+	// Entries carry verification reports and can exceed the default 64 KiB.
+	sc.Buffer(make([]byte, 64<<10), 4<<20)
+	for sc.Scan() {
+		var e HistoryEntry
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
+
+// Statistics aggregates the history for charting.
+type Statistics struct {
+	Summary StatsSummary `json:"summary"`
+	Daily   []DayStats   `json:"daily"`  // oldest day first
+	Models  []ModelStats `json:"models"` // most bytes first
+}
+
+type StatsSummary struct {
+	Runs      int `json:"runs"`
+	Completed int `json:"completed"`
+	Canceled  int `json:"canceled"`
+	Failed    int `json:"failed"`
+	// FailureRate is Failed / (Completed + Failed); canceled runs were the
+	// user's choice and do not count.
+	FailureRate float64 `json:"failureRate"`
+	Bytes       int64   `json:"bytes"`
+	// AverageBytesPerSecond is bytes fetched over time spent across all
+	// runs that fetched anything.
+	AverageBytesPerSecond float64 `json:"averageBytesPerSecond"`
+}
+
+type DayStats struct {
+	Date      string `json:"date"` // YYYY-MM-DD, local time
+	Bytes     int64  `json:"bytes"`
+	Runs      int    `json:"runs"`
+	Completed int    `json:"completed"`
+	Failed    int    `json:"failed"`
+}
+
+type ModelStats struct {
+	Model                 string    `json:"model"`
+	Bytes                 int64     `json:"bytes"`
+	Runs                  int       `json:"runs"`
+	Completed             int       `json:"completed"`
+	Failed                int       `json:"failed"`
+	AverageBytesPerSecond float64   `json:"averageBytesPerSecond"`
+	LastRun               time.Time `json:"lastRun"`
+}
+
+// ComputeStatistics aggregates entries into totals, per-day and per-model
+// figures. Runs are attributed to the day they ended.
+func ComputeStatistics(entries []HistoryEntry) Statistics {
+	var stats Statistics
+	days := map[string]*DayStats{}
+	perModel := map[string]*ModelStats{}
+	modelTime := map[string]time.Duration{}
+	var totalTime time.Duration
+
+	for _, e := range entries {
+		day := e.EndedAt.Local().Format("2006-01-02")
+		d := days[day]
+		if d == nil {
+			d = &DayStats{Date: day}
+			days[day] = d
+		}
+		m := perModel[e.Model]
+		if m == nil {
+			m = &ModelStats{Model: e.Model}
+			perModel[e.Model] = m
+		}
+
+		stats.Summary.Runs++
+		d.Runs++
+		m.Runs++
+		switch e.Outcome {
+		case OutcomeCompleted:
+			stats.Summary.Completed++
+			d.Completed++
+			m.Completed++
+		case OutcomeFailed:
+			stats.Summary.Failed++
+			d.Failed++
+			m.Failed++
+		case OutcomeCanceled:
+			stats.Summary.Canceled++
+		}
+		stats.Summary.Bytes += e.BytesFetched
+		d.Bytes += e.BytesFetched
+		m.Bytes += e.BytesFetched
+		if e.EndedAt.After(m.LastRun) {
+			m.LastRun = e.EndedAt
+		}
+		if e.BytesFetched > 0 && e.Duration() > 0 {
+			totalTime += e.Duration()
+			modelTime[e.Model] += e.Duration()
+		}
+	}
+
+	if finished := stats.Summary.Completed + stats.Summary.Failed; finished > 0 {
+		stats.Summary.FailureRate = float64(stats.Summary.Failed) / float64(finished)
+	}
+	stats.Summary.AverageBytesPerSecond = rate(stats.Summary.Bytes, totalTime)
+
+	stats.Daily = []DayStats{}
+	for _, d := range days {
+		stats.Daily = append(stats.Daily, *d)
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool { return stats.Daily[i].Date < stats.Daily[j].Date })
+
+	stats.Models = []ModelStats{}
+	for name, m := range perModel {
+		m.AverageBytesPerSecond = rate(m.Bytes, modelTime[name])
+		stats.Models = append(stats.Models, *m)
+	}
+	sort.Slice(stats.Models, func(i, j int) bool {
+		if stats.Models[i].Bytes != stats.Models[j].Bytes {
+			return stats.Models[i].Bytes > stats.Models[j].Bytes
+		}
+		return stats.Models[i].Model < stats.Models[j].Model
+	})
+	return stats
+}
+
+func rate(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) / d.Seconds()
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryStatistics(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	runs := []HistoryEntry{
+		{Model: "llama3", StartedAt: day, EndedAt: day.Add(10 * time.Second), BytesFetched: 1000, Outcome: OutcomeFailed},
+		{Model: "llama3", StartedAt: day, EndedAt: day.Add(10 * time.Second), BytesFetched: 3000, Outcome: OutcomeCompleted},
+		{Model: "gemma", StartedAt: day.AddDate(0, 0, 1), EndedAt: day.AddDate(0, 0, 1).Add(time.Second), BytesFetched: 500, Outcome: OutcomeCanceled},
+	}
+	for _, e := range runs {
+		if err := AppendHistory(dir, e); err != nil {
+			t.Fatalf("AppendHistory() error = %v", err)
+		}
+	}
+	entries, err := LoadHistory(dir)
+	if err != nil || len(entries) != len(runs) {
+		t.Fatalf("LoadHistory() = %d entries, %v", len(entries), err)
+	}
+
+	stats := ComputeStatistics(entries)
+	if stats.Summary.Runs != 3 || stats.Summary.Bytes != 4500 || stats.Summary.FailureRate != 0.5 {
+		t.Errorf("unexpected summary: %+v", stats.Summary)
+	}
+	if len(stats.Daily) != 2 || stats.Daily[0].Date != "2026-03-01" || stats.Daily[0].Bytes != 4000 {
+		t.Errorf("unexpected daily stats: %+v", stats.Daily)
+	}
+	if len(stats.Models) != 2 || stats.Models[0].Model != "llama3" || stats.Models[0].AverageBytesPerSecond != 200 {
+		t.Errorf("unexpected model stats: %+v", stats.Models)
+	}
+}
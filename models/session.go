@@ -1,9 +1,9 @@
 package models
 
 import (
-	"encoding/json"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -14,9 +14,45 @@ type SessionState string
 
 const (
 	StateDownloading SessionState = "downloading"
+	StateVerifying   SessionState = "verifying"
+	StatePackaging   SessionState = "packaging"
+	StateCompleted   SessionState = "completed"
 	StatePaused      SessionState = "paused"
+	StateCanceled    SessionState = "canceled"
+	// StateInterrupted marks a session that was still active when its
+	// process died (crash, power loss); it can be resumed like a paused one.
+	StateInterrupted SessionState = "interrupted"
 	StateError       SessionState = "error"
 	StateReady       SessionState = ""
+	// StateScheduled marks a session waiting for its StartAt time.
+	StateScheduled SessionState = "scheduled"
+	// StateLowDisk marks a running download holding its writes until the
+	// output volume has -min-free bytes free again.
+	StateLowDisk SessionState = "low-disk"
+	// StateUploading marks a packaged session pushing its archive to its
+	// -upload target.
+	StateUploading SessionState = "uploading"
+)
+
+func (s SessionState) normalized() SessionState {
+	return SessionState(strings.ToLower(string(s)))
+}
+
+// IsActive reports whether a download may currently be writing into the
+// session's staging directory.
+func (s SessionState) IsActive() bool {
+	switch s.normalized() {
+	case StateDownloading, StateVerifying, StatePackaging, StateLowDisk, StateUploading:
+		return true
+	}
+	return false
+}
+
+// A running download refreshes LastUpdated every HeartbeatInterval. An
+// active session not refreshed for StalledAfter lost its process.
+const (
+	HeartbeatInterval = 5 * time.Second
+	StalledAfter      = 30 * time.Second
 )
 
 type SessionMeta struct {
@@ -32,6 +68,107 @@ type SessionMeta struct {
 	LastUpdated time.Time    `json:"lastUpdated"`
 	State       SessionState `json:"state"`
 	Message     string       `json:"message"`
+	// ErrorKind and Hint classify a failed session's Message (see
+	// internal/errors) and say what the user can do about it.
+	ErrorKind string `json:"errorKind,omitempty"`
+	Hint      string `json:"hint,omitempty"`
+	// BytesDone is the blob bytes on disk as of LastUpdated, out of
+	// TotalBytes for the blobs listed in Blobs.
+	BytesDone  int64         `json:"bytesDone,omitempty"`
+	TotalBytes int64         `json:"totalBytes,omitempty"`
+	Blobs      []SessionBlob `json:"blobs,omitempty"`
+	// Transfer accumulates retry and re-download counts over every run of
+	// the session.
+	Transfer *TransferStats `json:"transfer,omitempty"`
+	// ManifestDigest is the manifest the last run resolved to; pulling
+	// model@<digest> fetches exactly the same content.
+	ManifestDigest string `json:"manifestDigest,omitempty"`
+	// StartAt is when a scheduled session is started.
+	StartAt time.Time `json:"startAt,omitempty"`
+	// Uploads is where the archive of the last run was pushed, one entry
+	// per -upload target.
+	Uploads []UploadStatus `json:"uploads,omitempty"`
+}
+
+// UploadStatus is the progress of pushing a session's archive to one
+// upload target.
+type UploadStatus struct {
+	Target     string `json:"target"`
+	State      string `json:"state"` // "uploading", "done" or "failed"
+	Bytes      int64  `json:"bytes"`
+	TotalBytes int64  `json:"totalBytes"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error,omitempty"` // of the last attempt
+}
+
+// SessionBlob is one blob of the resolved manifest(s) of a session.
+type SessionBlob struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType,omitempty"`
+	Size      int64  `json:"size"`
+	Done      int64  `json:"done"`
+}
+
+// Percent is BytesDone as a share of TotalBytes, or 0 while the blob list
+// is unknown.
+func (m SessionMeta) Percent() int {
+	if m.TotalBytes <= 0 {
+		return 0
+	}
+	return int(m.BytesDone * 100 / m.TotalBytes)
+}
+
+// IsStalled reports whether the session claims to be active but its
+// heartbeat stopped, e.g. because the process was killed.
+func (m SessionMeta) IsStalled(now time.Time) bool {
+	return m.State.IsActive() && now.Sub(m.LastUpdated) > StalledAfter
+}
+
+// TransferStats counts how hard a session had to work for its bytes.
+type TransferStats struct {
+	Retries           int                   `json:"retries"`
+	NetworkErrors     int                   `json:"networkErrors"`
+	RetryableStatuses map[string]int        `json:"retryableStatuses,omitempty"` // HTTP status code -> times seen
+	BytesRedownloaded int64                 `json:"bytesRedownloaded"`
+	Blobs             map[string]*BlobStats `json:"blobs,omitempty"` // by digest
+}
+
+// BlobStats are the TransferStats of a single blob.
+type BlobStats struct {
+	Retries           int            `json:"retries"`
+	NetworkErrors     int            `json:"networkErrors"`
+	RetryableStatuses map[string]int `json:"retryableStatuses,omitempty"`
+	BytesRedownloaded int64          `json:"bytesRedownloaded"`
+}
+
+// Add merges the per-blob counts in blobs into t and updates its totals.
+func (t *TransferStats) Add(blobs map[string]BlobStats) {
+	if t.Blobs == nil {
+		t.Blobs = map[string]*BlobStats{}
+	}
+	for digest, b := range blobs {
+		dst := t.Blobs[digest]
+		if dst == nil {
+			dst = &BlobStats{}
+			t.Blobs[digest] = dst
+		}
+		dst.Retries += b.Retries
+		dst.NetworkErrors += b.NetworkErrors
+		dst.BytesRedownloaded += b.BytesRedownloaded
+		t.Retries += b.Retries
+		t.NetworkErrors += b.NetworkErrors
+		t.BytesRedownloaded += b.BytesRedownloaded
+		for code, n := range b.RetryableStatuses {
+			if dst.RetryableStatuses == nil {
+				dst.RetryableStatuses = map[string]int{}
+			}
+			if t.RetryableStatuses == nil {
+				t.RetryableStatuses = map[string]int{}
+			}
+			dst.RetryableStatuses[code] += n
+			t.RetryableStatuses[code] += n
+		}
+	}
 }
 
 type SessionView struct {
@@ -41,13 +178,76 @@ type SessionView struct {
 	Updated    string
 	StateLabel string
 	Message    string
+	Hint       string
+	ZipName    string
+	Stalled    bool
+	TotalBytes int64
+	Percent    int
+}
+
+// SessionDetail is a session's metadata plus what its detail view derives
+// from it: each blob's state and retries, how long the session ran and
+// where its archive ended up.
+type SessionDetail struct {
+	SessionMeta
+	StateLabel     string       `json:"stateLabel"`
+	Percent        int          `json:"percent"`
+	ElapsedSeconds float64      `json:"elapsedSeconds"` // StartedAt to LastUpdated
+	Blobs          []BlobDetail `json:"blobs"`
+	Archive        string       `json:"archive,omitempty"` // the packaged zip, once it exists
+	ArchiveBytes   int64        `json:"archiveBytes,omitempty"`
+	Running        bool         `json:"running"` // downloading in this process right now
+}
+
+// BlobDetail is a SessionBlob with its state and transfer counts.
+type BlobDetail struct {
+	SessionBlob
+	BlobStats
+	State string `json:"state"` // "pending", "partial" or "done"
+}
+
+func SessionDetailFromMeta(meta SessionMeta) SessionDetail {
+	d := SessionDetail{
+		SessionMeta: meta,
+		StateLabel:  SessionViewFromMeta(meta).StateLabel,
+		Percent:     meta.Percent(),
+		Blobs:       make([]BlobDetail, 0, len(meta.Blobs)),
+	}
+	if !meta.StartedAt.IsZero() && meta.LastUpdated.After(meta.StartedAt) {
+		d.ElapsedSeconds = meta.LastUpdated.Sub(meta.StartedAt).Seconds()
+	}
+	for _, b := range meta.Blobs {
+		bd := BlobDetail{SessionBlob: b, State: "pending"}
+		switch {
+		case b.Size > 0 && b.Done >= b.Size:
+			bd.State = "done"
+		case b.Done > 0:
+			bd.State = "partial"
+		}
+		if meta.Transfer != nil && meta.Transfer.Blobs[b.Digest] != nil {
+			bd.BlobStats = *meta.Transfer.Blobs[b.Digest]
+		}
+		d.Blobs = append(d.Blobs, bd)
+	}
+	if meta.OutZip != "" {
+		if info, err := os.Stat(meta.OutZip); err == nil && info.Mode().IsRegular() {
+			d.Archive, d.ArchiveBytes = meta.OutZip, info.Size()
+		}
+	}
+	return d
 }
 
 type DownloadEntry struct {
-	Name    string
-	Model   string
-	Path    string
-	ModTime time.Time
+	Name    string    `json:"name"`
+	Model   string    `json:"model"`
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"modTime"`
+	Labels  []string  `json:"labels,omitempty"`
+	Notes   string    `json:"notes,omitempty"`
+	// Integrity is the web UI's quick check of the archive: "checking",
+	// "ok" or "corrupt" with IntegrityError saying why.
+	Integrity      string `json:"integrity,omitempty"`
+	IntegrityError string `json:"integrityError,omitempty"`
 }
 
 func SessionMetaPath(dir string) string {
@@ -55,35 +255,35 @@ func SessionMetaPath(dir string) string {
 }
 
 func LoadSessionMeta(dir string) (SessionMeta, error) {
-	var meta SessionMeta
-	data, err := os.ReadFile(SessionMetaPath(dir))
-	if err != nil {
-		return meta, err
-	}
-	if err := json.Unmarshal(data, &meta); err != nil {
-		return meta, err
-	}
-	return meta, nil
+	return DefaultStore.Load(dir)
 }
 
 func SaveSessionMeta(meta SessionMeta) error {
-	meta.LastUpdated = time.Now()
-	data, err := json.MarshalIndent(meta, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(SessionMetaPath(meta.StagingRoot), data, 0o644)
+	return DefaultStore.Save(meta)
 }
 
 func SessionViewFromMeta(meta SessionMeta) SessionView {
-	return SessionView{
+	var zipName string
+	if meta.OutZip != "" {
+		zipName = filepath.Base(meta.OutZip)
+	}
+	view := SessionView{
 		Model:      meta.Model,
 		SessionID:  meta.SessionID,
 		Started:    formatSessionTime(meta.StartedAt),
 		Updated:    formatSessionTime(meta.LastUpdated),
 		StateLabel: StateLabel(meta.State),
 		Message:    meta.Message,
+		Hint:       meta.Hint,
+		ZipName:    zipName,
+		TotalBytes: meta.TotalBytes,
+		Percent:    meta.Percent(),
+	}
+	if meta.IsStalled(time.Now()) {
+		view.StateLabel = "بدون پاسخ"
+		view.Stalled = true
 	}
+	return view
 }
 
 func formatSessionTime(t time.Time) string {
@@ -94,12 +294,28 @@ func formatSessionTime(t time.Time) string {
 }
 
 func StateLabel(state SessionState) string {
-	switch strings.ToLower(string(state)) {
-	case "downloading":
+	switch state.normalized() {
+	case StateDownloading:
 		return "در حال دانلود"
-	case "paused":
+	case StateVerifying:
+		return "در حال بررسی"
+	case StatePackaging:
+		return "در حال بسته‌بندی"
+	case StateCompleted:
+		return "تکمیل شده"
+	case StatePaused:
 		return "مکث شده"
-	case "error":
+	case StateCanceled:
+		return "لغو شده"
+	case StateInterrupted:
+		return "قطع شده"
+	case StateScheduled:
+		return "زمان‌بندی شده"
+	case StateLowDisk:
+		return "منتظر فضای دیسک"
+	case StateUploading:
+		return "در حال آپلود"
+	case StateError:
 		return "خطا"
 	default:
 		if state == "" {
@@ -109,7 +325,7 @@ func StateLabel(state SessionState) string {
 	}
 }
 
-func SetSessionStatus(dir, state string, message string) error {
+func SetSessionStatus(dir string, state SessionState, message string) error {
 	if dir == "" {
 		return nil
 	}
@@ -117,52 +333,57 @@ func SetSessionStatus(dir, state string, message string) error {
 	if err != nil {
 		return err
 	}
-	meta.State = SessionState(state)
+	meta.State = state
 	meta.Message = message
+	meta.ErrorKind, meta.Hint = "", ""
 	return SaveSessionMeta(meta)
 }
 
-func DiscoverPartialSessions(outputDir string) ([]SessionMeta, error) {
-	entries, err := os.ReadDir(outputDir)
-	if err != nil {
-		return nil, err
+// SetSessionError marks the session in dir failed with message and, when
+// known, the error's kind and remediation hint.
+func SetSessionError(dir, message, kind, hint string) error {
+	if dir == "" {
+		return nil
 	}
-	var sessions []SessionMeta
-	for _, entry := range entries {
-		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".staging") {
-			continue
-		}
-		meta, err := LoadSessionMeta(filepath.Join(outputDir, entry.Name()))
-		if err != nil {
-			continue
-		}
-		sessions = append(sessions, meta)
+	meta, err := LoadSessionMeta(dir)
+	if err != nil {
+		return err
 	}
-	return sessions, nil
+	meta.State = StateError
+	meta.Message = message
+	meta.ErrorKind, meta.Hint = kind, hint
+	return SaveSessionMeta(meta)
 }
 
-func CategorizeSessions(metas []SessionMeta) (running *SessionView, paused, errored []SessionView) {
-	// Sort by last updated time (newest first)
-	for i := 0; i < len(metas)-1; i++ {
-		for j := i + 1; j < len(metas); j++ {
-			if metas[i].LastUpdated.Before(metas[j].LastUpdated) {
-				metas[i], metas[j] = metas[j], metas[i]
-			}
-		}
-	}
+func DiscoverPartialSessions(outputDir string) ([]SessionMeta, error) {
+	return DefaultStore.List(outputDir)
+}
 
+// CategorizeSessions sorts metas newest first and groups them for display.
+// Only the most recent live active session is reported as running; stalled
+// ones are listed with the paused sessions so they can be resumed.
+func CategorizeSessions(metas []SessionMeta) (running *SessionView, paused, errored, completed []SessionView) {
+	sort.SliceStable(metas, func(i, j int) bool {
+		return metas[i].LastUpdated.After(metas[j].LastUpdated)
+	})
 	for _, meta := range metas {
 		view := SessionViewFromMeta(meta)
-		switch strings.ToLower(string(meta.State)) {
-		case "downloading":
+		if view.Stalled {
+			paused = append(paused, view)
+			continue
+		}
+		switch meta.State.normalized() {
+		case StateDownloading, StateVerifying, StatePackaging, StateLowDisk, StateUploading:
 			if running == nil {
 				tmp := view
 				running = &tmp
 			}
-		case "paused":
+		case StatePaused, StateCanceled, StateInterrupted, StateScheduled:
 			paused = append(paused, view)
-		case "error":
+		case StateError:
 			errored = append(errored, view)
+		case StateCompleted:
+			completed = append(completed, view)
 		default:
 			paused = append(paused, view)
 		}
@@ -184,21 +405,21 @@ func DownloadsFromDir(dir string) []DownloadEntry {
 		if err != nil {
 			continue
 		}
+		path := filepath.Join(dir, entry.Name())
+		// A damaged sidecar only hides the annotations, not the archive.
+		note, _ := LoadArchiveInfo(path)
 		downloads = append(downloads, DownloadEntry{
 			Name:    entry.Name(),
 			Model:   strings.TrimSuffix(entry.Name(), ".zip"),
-			Path:    filepath.Join(dir, entry.Name()),
+			Path:    path,
 			ModTime: info.ModTime(),
+			Labels:  note.Labels,
+			Notes:   note.Notes,
 		})
 	}
 
-	// Sort by modification time (newest first)
-	for i := 0; i < len(downloads)-1; i++ {
-		for j := i + 1; j < len(downloads); j++ {
-			if downloads[i].ModTime.Before(downloads[j].ModTime) {
-				downloads[i], downloads[j] = downloads[j], downloads[i]
-			}
-		}
-	}
+	sort.SliceStable(downloads, func(i, j int) bool {
+		return downloads[i].ModTime.After(downloads[j].ModTime)
+	})
 	return downloads
 }
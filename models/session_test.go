@@ -0,0 +1,123 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCategorizeSessions(t *testing.T) {
+	now := time.Now()
+	metas := []SessionMeta{
+		{SessionID: "old-running", State: StateDownloading, LastUpdated: now.Add(-10 * time.Second)},
+		{SessionID: "paused", State: StatePaused, LastUpdated: now.Add(-2 * time.Hour)},
+		{SessionID: "packaging", State: StatePackaging, LastUpdated: now},
+		{SessionID: "canceled", State: StateCanceled, LastUpdated: now.Add(-3 * time.Hour)},
+		{SessionID: "failed", State: StateError, LastUpdated: now},
+		{SessionID: "done", State: StateCompleted, OutZip: "out/done.zip", LastUpdated: now},
+		{SessionID: "crashed", State: StateInterrupted, LastUpdated: now.Add(-4 * time.Hour)},
+		{SessionID: "stalled", State: StateDownloading, LastUpdated: now.Add(-5 * time.Hour)},
+	}
+
+	running, paused, errored, completed := CategorizeSessions(metas)
+
+	if running == nil || running.SessionID != "packaging" {
+		t.Fatalf("expected newest active session to be running, got %+v", running)
+	}
+	if len(paused) != 4 || paused[0].SessionID != "paused" || paused[1].SessionID != "canceled" || paused[2].SessionID != "crashed" || paused[3].SessionID != "stalled" || !paused[3].Stalled {
+		t.Errorf("unexpected paused sessions: %+v", paused)
+	}
+	if len(errored) != 1 || errored[0].SessionID != "failed" {
+		t.Errorf("unexpected errored sessions: %+v", errored)
+	}
+	if len(completed) != 1 || completed[0].ZipName != "done.zip" {
+		t.Errorf("unexpected completed sessions: %+v", completed)
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := FileStore{}
+	meta := SessionMeta{SessionID: "llama3", Model: "llama3", StagingRoot: dir, State: StatePaused}
+	if err := store.Save(meta); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	got, err := store.Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.SessionID != meta.SessionID || got.State != StatePaused || got.LastUpdated.IsZero() {
+		t.Errorf("Load() = %+v", got)
+	}
+}
+
+func TestFileStoreDurableSave(t *testing.T) {
+	dir := t.TempDir()
+	store := FileStore{Durable: true}
+	for _, state := range []SessionState{StateDownloading, StatePaused} {
+		if err := store.Save(SessionMeta{SessionID: "llama3", StagingRoot: dir, State: state}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	got, err := store.Load(dir)
+	if err != nil || got.State != StatePaused {
+		t.Fatalf("Load() = %+v, %v", got, err)
+	}
+	if _, err := os.Stat(SessionMetaPath(dir) + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestTransferStatsAdd(t *testing.T) {
+	var stats TransferStats
+	stats.Add(map[string]BlobStats{
+		"sha256:a": {Retries: 2, RetryableStatuses: map[string]int{"503": 2}},
+		"sha256:b": {Retries: 1, NetworkErrors: 1, BytesRedownloaded: 100},
+	})
+	stats.Add(map[string]BlobStats{"sha256:a": {Retries: 1, RetryableStatuses: map[string]int{"503": 1}}})
+
+	if stats.Retries != 4 || stats.NetworkErrors != 1 || stats.BytesRedownloaded != 100 || stats.RetryableStatuses["503"] != 3 {
+		t.Errorf("unexpected totals: %+v", stats)
+	}
+	if a := stats.Blobs["sha256:a"]; a == nil || a.Retries != 3 || a.RetryableStatuses["503"] != 3 {
+		t.Errorf("unexpected stats for sha256:a: %+v", a)
+	}
+}
+
+func TestSessionDetailFromMeta(t *testing.T) {
+	zip := filepath.Join(t.TempDir(), "m.zip")
+	if err := os.WriteFile(zip, []byte("zip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-90 * time.Second)
+	meta := SessionMeta{
+		SessionID:   "m",
+		OutZip:      zip,
+		State:       StatePackaging,
+		StartedAt:   start,
+		LastUpdated: start.Add(90 * time.Second),
+		Blobs: []SessionBlob{
+			{Digest: "sha256:a", Size: 10, Done: 10},
+			{Digest: "sha256:b", Size: 10, Done: 4},
+			{Digest: "sha256:c", Size: 10},
+		},
+		Transfer: &TransferStats{Blobs: map[string]*BlobStats{"sha256:b": {Retries: 2}}},
+	}
+
+	d := SessionDetailFromMeta(meta)
+	var states []string
+	for _, b := range d.Blobs {
+		states = append(states, b.State)
+	}
+	if strings.Join(states, ",") != "done,partial,pending" {
+		t.Errorf("blob states = %v", states)
+	}
+	if d.Blobs[1].Retries != 2 || d.Blobs[0].Retries != 0 {
+		t.Errorf("blob retries = %d, %d", d.Blobs[0].Retries, d.Blobs[1].Retries)
+	}
+	if d.ElapsedSeconds != 90 || d.Archive != zip || d.ArchiveBytes != 3 || d.StateLabel != StateLabel(StatePackaging) {
+		t.Errorf("detail = %+v", d)
+	}
+}
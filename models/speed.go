@@ -0,0 +1,66 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const speedFileName = "speed.jsonl"
+
+// SpeedSample is the transfer rate of a session over one sampling interval.
+type SpeedSample struct {
+	Time           time.Time `json:"time"`
+	BytesPerSecond float64   `json:"bytesPerSecond"`
+	Done           int64     `json:"done"` // bytes on disk at Time
+}
+
+// SpeedLogPath is the speed log of the session staged in dir. It lives next
+// to session.json so it survives completion and is kept across resumes.
+func SpeedLogPath(dir string) string {
+	return filepath.Join(dir, speedFileName)
+}
+
+// AppendSpeedSamples adds samples to the speed log of the session in dir.
+func AppendSpeedSamples(dir string, samples ...SpeedSample) error {
+	f, err := os.OpenFile(SpeedLogPath(dir), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, s := range samples {
+		if err := enc.Encode(s); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadSpeedSamples reads the speed log of the session in dir, oldest first.
+func LoadSpeedSamples(dir string) ([]SpeedSample, error) {
+	f, err := os.Open(SpeedLogPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var samples []SpeedSample
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var s SpeedSample
+		if json.Unmarshal(sc.Bytes(), &s) == nil {
+			samples = append(samples, s)
+		}
+	}
+	return samples, sc.Err()
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Store persists session metadata. A session is identified by its staging
+// directory; non-filesystem stores may treat that path as an opaque key.
+type Store interface {
+	Load(dir string) (SessionMeta, error)
+	Save(meta SessionMeta) error
+	List(outputDir string) ([]SessionMeta, error)
+}
+
+// DefaultStore is used by the package-level helpers. Replace it at startup
+// to move session metadata to another backend.
+var DefaultStore Store = FileStore{}
+
+// FileStore keeps each session's metadata in <staging>/session.json.
+type FileStore struct {
+	// Durable writes session.json to a temporary file, fsyncs it and
+	// renames it into place, so a crash leaves the old or the new
+	// metadata and never a truncated file.
+	Durable bool
+}
+
+func (FileStore) Load(dir string) (SessionMeta, error) {
+	var meta SessionMeta
+	data, err := os.ReadFile(SessionMetaPath(dir))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, err
+	}
+	return meta, nil
+}
+
+func (s FileStore) Save(meta SessionMeta) error {
+	meta.LastUpdated = time.Now()
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := SessionMetaPath(meta.StagingRoot)
+	if !s.Durable {
+		return os.WriteFile(path, data, 0o644)
+	}
+	return writeFileSync(path, data)
+}
+
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// Make the rename itself durable.
+	return syncPath(filepath.Dir(path))
+}
+
+// List returns the metadata of every *.staging directory in outputDir;
+// directories without readable metadata are skipped.
+func (s FileStore) List(outputDir string) ([]SessionMeta, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []SessionMeta
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".staging") {
+			continue
+		}
+		meta, err := s.Load(filepath.Join(outputDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, meta)
+	}
+	return sessions, nil
+}
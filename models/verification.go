@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// VerificationReportName is the file name of the report inside archives.
+const VerificationReportName = "verification.json"
+
+// VerificationReport records the integrity check of every blob of a
+// download, for audits.
+type VerificationReport struct {
+	Model          string             `json:"model"`
+	Registry       string             `json:"registry"`
+	Repository     string             `json:"repository"`
+	Reference      string             `json:"reference"`
+	ManifestDigest string             `json:"manifestDigest"`
+	Platform       string             `json:"platform"`
+	VerifiedAt     time.Time          `json:"verifiedAt"`
+	OK             bool               `json:"ok"`
+	Blobs          []BlobVerification `json:"blobs"`
+}
+
+// BlobVerification compares one staged blob against its manifest entry.
+type BlobVerification struct {
+	Digest         string `json:"digest"`
+	MediaType      string `json:"mediaType,omitempty"`
+	SourceURL      string `json:"sourceUrl"`
+	ExpectedSize   int64  `json:"expectedSize"`
+	Size           int64  `json:"size"`
+	ExpectedSHA256 string `json:"expectedSha256"`
+	ActualSHA256   string `json:"actualSha256"`
+	OK             bool   `json:"ok"`
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// systemdOllamaHome is the home of the "ollama" user created by Ollama's
+// Linux install script; the service keeps its models below it.
+const systemdOllamaHome = "/usr/share/ollama"
+
+// ollamaModelsDir returns the directory Ollama loads models from.
+func ollamaModelsDir() (string, error) {
+	dir, _, err := ollamaModelsDirSource()
+	return dir, err
+}
+
+// ollamaModelsDirSource resolves the models directory and says where the
+// answer came from, in order: OLLAMA_MODELS_DIR (ours), OLLAMA_MODELS
+// (Ollama's own), the environment of a running Ollama server, the systemd
+// service (Linux), and finally the platform default. Ollama's HTTP API does
+// not report its models path, so the running server is inspected through
+// /proc instead.
+func ollamaModelsDirSource() (dir, source string, err error) {
+	if dir := os.Getenv("OLLAMA_MODELS_DIR"); dir != "" {
+		return dir, "OLLAMA_MODELS_DIR", nil
+	}
+	if dir := os.Getenv("OLLAMA_MODELS"); dir != "" {
+		return dir, "OLLAMA_MODELS", nil
+	}
+	if runtime.GOOS == "linux" {
+		if dir := runningOllamaModels(); dir != "" {
+			return dir, "running ollama server", nil
+		}
+		if dir := systemdOllamaModels(); dir != "" {
+			return dir, "ollama.service", nil
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if local := os.Getenv("LOCALAPPDATA"); local != "" {
+			return filepath.Join(local, "Ollama", "models"), "default", nil
+		}
+		return filepath.Join(home, "AppData", "Local", "Ollama", "models"), "default", nil
+	default:
+		return filepath.Join(home, ".ollama", "models"), "default", nil
+	}
+}
+
+// runningOllamaModels returns OLLAMA_MODELS from the environment of a
+// running `ollama serve` process, if one is visible and readable.
+func runningOllamaModels() string {
+	procs, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return ""
+	}
+	for _, comm := range procs {
+		name, err := os.ReadFile(comm)
+		if err != nil || strings.TrimSpace(string(name)) != "ollama" {
+			continue
+		}
+		env, err := os.ReadFile(filepath.Join(filepath.Dir(comm), "environ"))
+		if err != nil {
+			continue
+		}
+		for _, kv := range bytes.Split(env, []byte{0}) {
+			if v, ok := strings.CutPrefix(string(kv), "OLLAMA_MODELS="); ok && v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// systemdOllamaModels reads OLLAMA_MODELS from the ollama systemd service
+// (including drop-ins). A service without it uses the ollama user's home.
+func systemdOllamaModels() string {
+	out, err := exec.Command("systemctl", "show", "ollama.service", "--property=Environment", "--property=LoadState", "--property=User").Output()
+	if err != nil {
+		return ""
+	}
+	var loaded bool
+	var user string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		key, value, _ := strings.Cut(sc.Text(), "=")
+		switch key {
+		case "LoadState":
+			loaded = value == "loaded"
+		case "User":
+			user = value
+		case "Environment":
+			for _, kv := range strings.Fields(value) {
+				if v, ok := strings.CutPrefix(strings.Trim(kv, `"`), "OLLAMA_MODELS="); ok && v != "" {
+					return v
+				}
+			}
+		}
+	}
+	if loaded && user == "ollama" {
+		dir := filepath.Join(systemdOllamaHome, ".ollama", "models")
+		if st, err := os.Stat(dir); err == nil && st.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
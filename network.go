@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"ollama-model-downloader/config"
+)
+
+// withNetwork applies the config file's network section: a fixed proxy, a
+// DNS server of its own and connection pool and timeout settings, all
+// plugged into newHTTPClient through opt.
+func withNetwork(opt options, n config.Network) (options, error) {
+	if n.Proxy != "" {
+		u, err := url.Parse(n.Proxy)
+		if err != nil {
+			return opt, fmt.Errorf("network.proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return opt, fmt.Errorf("network.proxy: unsupported scheme %q (want http, https or socks5)", u.Scheme)
+		}
+		if u.Host == "" {
+			return opt, fmt.Errorf("network.proxy: %q has no host", n.Proxy)
+		}
+		opt.proxy = u
+	}
+	if n.DNS != "" {
+		server := n.DNS
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		if host, _, _ := net.SplitHostPort(server); host == "" {
+			return opt, fmt.Errorf("network.dns: %q has no host", n.DNS)
+		}
+		opt.dial = dnsDial(opt, server)
+	}
+	if n.MaxConnsPerHost < 0 || n.MaxIdleConnsPerHost < 0 {
+		return opt, fmt.Errorf("network: maxConnsPerHost and maxIdleConnsPerHost must not be negative")
+	}
+	opt.transport.maxConnsPerHost, opt.transport.maxIdleConnsPerHost = n.MaxConnsPerHost, n.MaxIdleConnsPerHost
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{
+		{"responseHeaderTimeout", n.ResponseHeaderTimeout, &opt.transport.responseHeaderTimeout},
+		{"tlsHandshakeTimeout", n.TLSHandshakeTimeout, &opt.transport.tlsHandshakeTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return opt, fmt.Errorf("network.%s: %q is not a positive duration such as \"90s\"", d.name, d.value)
+		}
+		*d.to = v
+	}
+	return opt, nil
+}
+
+// connTuning overrides the connection pool and timeouts newHTTPClient
+// sets up; zero fields keep its defaults. The defaults suit ordinary
+// links: a data-center link may want more connections per host, a
+// satellite one longer timeouts.
+type connTuning struct {
+	maxConnsPerHost       int
+	maxIdleConnsPerHost   int
+	responseHeaderTimeout time.Duration
+	tlsHandshakeTimeout   time.Duration
+}
+
+// dnsDial dials like newDialer but looks host names up at server.
+func dnsDial(opt options, server string) dialFunc {
+	dialer := newDialer(opt)
+	dialer.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+	return dialer.DialContext
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ollama-model-downloader/config"
+	apperrors "ollama-model-downloader/internal/errors"
+)
+
+func TestNewHTTPClientHooks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var dials, requests atomic.Int32
+	opt := options{
+		http1: true,
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+		wrapTransport: func(next http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				requests.Add(1)
+				return next.RoundTrip(req)
+			})
+		},
+	}
+	client := newHTTPClient(opt)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if dials.Load() != 1 || requests.Load() != 2 {
+		t.Errorf("dials = %d, requests = %d; want 1 and 2", dials.Load(), requests.Load())
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestWithNetwork(t *testing.T) {
+	opt, err := withNetwork(options{}, config.Network{Proxy: "socks5://proxy.internal:1080", DNS: "10.0.0.53"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.proxy == nil || opt.proxy.Host != "proxy.internal:1080" || opt.dial == nil {
+		t.Errorf("proxy = %v, dial set = %v", opt.proxy, opt.dial != nil)
+	}
+	if opt, _ := withNetwork(options{}, config.Network{}); opt.proxy != nil || opt.dial != nil {
+		t.Error("an empty network section should change nothing")
+	}
+	opt, err = withNetwork(options{}, config.Network{MaxConnsPerHost: 16, MaxIdleConnsPerHost: 16, ResponseHeaderTimeout: "3m", TLSHandshakeTimeout: "90s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := connTuning{maxConnsPerHost: 16, maxIdleConnsPerHost: 16, responseHeaderTimeout: 3 * time.Minute, tlsHandshakeTimeout: 90 * time.Second}
+	if opt.transport != want {
+		t.Errorf("transport = %+v, want %+v", opt.transport, want)
+	}
+	for _, n := range []config.Network{{Proxy: "ftp://proxy:21"}, {Proxy: "proxy.internal:3128"}, {DNS: ":53"}, {MaxConnsPerHost: -1}, {ResponseHeaderTimeout: "60"}, {TLSHandshakeTimeout: "-1s"}} {
+		if _, err := withNetwork(options{}, n); err == nil {
+			t.Errorf("%+v should be rejected", n)
+		}
+	}
+}
+
+func TestRegistryAuthModes(t *testing.T) {
+	reg, srv, _ := testModel(t, 1<<10)
+	const manifestPath = "/v2/test/m/manifests/latest"
+	resolve := func(opt options) error {
+		ref, err := parseModel(opt.registry, opt.model, opt.modelConfig)
+		if err != nil {
+			return err
+		}
+		_, err = resolveManifest(context.Background(), newHTTPClient(opt), opt, ref)
+		return err
+	}
+
+	// Anonymous: only the first model of a batch is probed.
+	opt := testOptions(t, srv.URL)
+	opt.username, opt.password = "alice", "secret"
+	opt.authCache = newRegistryAuthCache()
+	for i := 0; i < 2; i++ {
+		if err := resolve(opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := reg.Requests(manifestPath); n != 3 {
+		t.Errorf("anonymous: %d manifest requests, want a probe and 2 fetches", n)
+	}
+
+	// Basic auth straight on /v2/, with no token service.
+	reg.BasicAuth = "alice:secret"
+	if err := resolve(opt); err != nil {
+		t.Fatalf("a cached anonymous registry that now wants auth: %v", err)
+	}
+	anonymous := opt
+	anonymous.username, anonymous.password = "", ""
+	if err := resolve(anonymous); apperrors.KindOf(err) != apperrors.KindUnauthorized {
+		t.Errorf("Basic auth without credentials = %v", err)
+	}
+	opt.authCache = newRegistryAuthCache()
+	before := reg.Requests(manifestPath)
+	for i := 0; i < 2; i++ {
+		if err := resolve(opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := reg.Requests(manifestPath) - before; n != 3 {
+		t.Errorf("basic: %d manifest requests, want a probe and 2 fetches", n)
+	}
+
+	// A 403 without a challenge is answered with the credentials as well.
+	opt.authCache = newRegistryAuthCache()
+	reg.FailNext(manifestPath, http.StatusForbidden)
+	if err := resolve(opt); err != nil {
+		t.Errorf("403 probe with credentials: %v", err)
+	}
+}
+
+func TestManifestCacheSkipsProbe(t *testing.T) {
+	reg, srv, _ := testModel(t, 1<<10)
+	reg.BasicAuth = "alice:secret"
+	const manifestPath = "/v2/test/m/manifests/latest"
+	opt := testOptions(t, srv.URL)
+	opt.username, opt.password = "alice", "secret"
+	opt.manifestCache = newManifestCache(t.TempDir(), time.Hour)
+	ref, err := parseModel(opt.registry, opt.model, opt.modelConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := resolveManifest(context.Background(), newHTTPClient(opt), opt, ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := reg.Requests(manifestPath); n != 2 {
+		t.Errorf("%d manifest requests, want a probe and a fetch, then none", n)
+	}
+
+	// The blobs still get the credentials the cached manifest did without.
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManifestCacheVerifiesDigest(t *testing.T) {
+	c := newManifestCache(t.TempDir(), 0)
+	body := []byte(`{"schemaVersion":2}`)
+	entry := cachedManifest{Registry: "r", Repository: "test/m", Reference: manifestDigest(body), Body: body}
+	if err := c.put(entry); err != nil {
+		t.Fatal(err)
+	}
+	if !c.hasFresh("r", "test/m", entry.Reference) {
+		t.Fatal("a digest entry should always be fresh")
+	}
+	entry.Body = []byte(`{"schemaVersion":2,"layers":[]}`)
+	if err := c.put(entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get("r", "test/m", entry.Reference); ok {
+		t.Error("an entry whose body does not hash to its digest was served")
+	}
+}
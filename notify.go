@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"ollama-model-downloader/config"
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/models"
+)
+
+// Notification events.
+const (
+	eventCompleted = "completed"
+	eventFailed    = "failed"
+	eventMirror    = "mirror"
+)
+
+// telegramAPI is the Telegram Bot API base URL.
+var telegramAPI = "https://api.telegram.org"
+
+// notifyTimeout bounds each notification so an unreachable chat service
+// cannot hold up the end of a download.
+const notifyTimeout = 15 * time.Second
+
+// notification is what a notifier is told. Webhooks receive it as JSON,
+// chat notifiers receive Text and mail templates are executed with it.
+type notification struct {
+	Event    string   `json:"event"`
+	Model    string   `json:"model,omitempty"`
+	Session  string   `json:"session,omitempty"`
+	Zip      string   `json:"zip,omitempty"`
+	Bytes    int64    `json:"bytes,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Hint     string   `json:"hint,omitempty"`
+	Mirrored []string `json:"mirrored,omitempty"`
+	Failed   []string `json:"failed,omitempty"`
+	Text     string   `json:"text"`
+}
+
+// checkNotifiers validates the notifiers of the config file.
+func checkNotifiers(list []config.Notifier) error {
+	for i, n := range list {
+		var err error
+		switch n.Type {
+		case "webhook", "discord":
+			if n.URL == "" {
+				err = fmt.Errorf("%s needs a url", n.Type)
+			}
+		case "telegram":
+			if n.Token() == "" || n.ChatID == "" {
+				err = fmt.Errorf("telegram needs botToken (or botTokenEnv) and chatId")
+			}
+		case "smtp":
+			if n.Addr == "" || n.From == "" || len(n.To) == 0 {
+				err = fmt.Errorf("smtp needs addr, from and to")
+			} else if _, _, err = mailTemplates(n); err == nil {
+				_, _, err = net.SplitHostPort(n.Addr)
+			}
+		default:
+			err = fmt.Errorf("unknown type %q (want webhook, telegram, discord or smtp)", n.Type)
+		}
+		if err == nil {
+			for _, e := range n.Events {
+				if e != eventCompleted && e != eventFailed && e != eventMirror {
+					err = fmt.Errorf("unknown event %q", e)
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("notifier %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// notifySession reports how the download of opt ended: completed when err
+// is nil, failed otherwise.
+func notifySession(opt options, err error) {
+	if len(opt.notifiers) == 0 {
+		return
+	}
+	n := notification{Event: eventCompleted, Model: opt.model, Session: opt.sessionID}
+	if meta, merr := models.LoadSessionMeta(opt.stagingDir); merr == nil {
+		n.Bytes = meta.TotalBytes
+	}
+	if err == nil {
+		n.Zip = archivePath(opt)
+		n.Text = fmt.Sprintf("Downloaded %s: %s (%s)", opt.model, n.Zip, humanBytes(n.Bytes))
+	} else {
+		n.Event, n.Error, n.Hint = eventFailed, err.Error(), apperrors.Hint(err)
+		n.Text = fmt.Sprintf("Download of %s failed: %v", opt.model, err)
+		if n.Hint != "" {
+			n.Text += "\nHint: " + n.Hint
+		}
+	}
+	notify(opt, n)
+}
+
+// notifyMirror reports the outcome of a mirror run.
+func notifyMirror(opt options, namespace string, mirrored, failed []string) {
+	if len(opt.notifiers) == 0 {
+		return
+	}
+	n := notification{Event: eventMirror, Mirrored: mirrored, Failed: failed}
+	n.Text = fmt.Sprintf("Mirror of %s: %d model(s) mirrored, %d failed", namespace, len(mirrored), len(failed))
+	if len(mirrored) > 0 {
+		n.Text += "\nMirrored: " + strings.Join(mirrored, ", ")
+	}
+	if len(failed) > 0 {
+		n.Text += "\nFailed: " + strings.Join(failed, ", ")
+	}
+	notify(opt, n)
+}
+
+// notify sends n to every notifier subscribed to its event. Failures are
+// warnings; they never fail the download they report on.
+func notify(opt options, n notification) {
+	client := newHTTPClient(opt)
+	for _, nf := range opt.notifiers {
+		if len(nf.Events) > 0 && !containsString(nf.Events, n.Event) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err := sendNotification(ctx, client, nf, n)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s notifier: %v\n", nf.Type, err)
+		}
+	}
+}
+
+func sendNotification(ctx context.Context, client *http.Client, nf config.Notifier, n notification) error {
+	if nf.Type == "smtp" {
+		return sendMail(ctx, nf, n)
+	}
+	var endpoint string
+	var body interface{}
+	switch nf.Type {
+	case "webhook":
+		endpoint, body = nf.URL, n
+	case "discord":
+		// Discord rejects messages over 2000 characters.
+		text := n.Text
+		if len(text) > 2000 {
+			text = text[:1997] + "..."
+		}
+		endpoint, body = nf.URL, map[string]string{"content": text}
+	case "telegram":
+		endpoint = fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimRight(telegramAPI, "/"), nf.Token())
+		body = map[string]string{"chat_id": nf.ChatID, "text": n.Text}
+	default:
+		return fmt.Errorf("unknown type %q", nf.Type)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ollama-model-downloader/1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		// The Telegram URL carries the bot token; keep it out of logs.
+		var uerr *url.Error
+		if nf.Type == "telegram" && errors.As(err, &uerr) {
+			return fmt.Errorf("POST sendMessage: %w", uerr.Err)
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST: %s", resp.Status)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
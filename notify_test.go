@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-model-downloader/config"
+)
+
+func TestNotify(t *testing.T) {
+	type request struct {
+		path string
+		body map[string]interface{}
+	}
+	var got []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		got = append(got, request{r.URL.Path, body})
+	}))
+	defer srv.Close()
+	defer func(api string) { telegramAPI = api }(telegramAPI)
+	telegramAPI = srv.URL
+
+	opt := options{notifiers: []config.Notifier{
+		{Type: "webhook", URL: srv.URL + "/hook"},
+		{Type: "discord", URL: srv.URL + "/discord"},
+		{Type: "telegram", BotToken: "123:abc", ChatID: "-42"},
+		{Type: "webhook", URL: srv.URL + "/mirror-only", Events: []string{eventMirror}},
+	}}
+	if err := checkNotifiers(opt.notifiers); err != nil {
+		t.Fatalf("checkNotifiers() error = %v", err)
+	}
+	notify(opt, notification{Event: eventFailed, Model: "llama3", Error: "boom", Text: "Download of llama3 failed: boom"})
+
+	if len(got) != 3 {
+		t.Fatalf("%d requests, want 3 (the mirror-only notifier skipped): %+v", len(got), got)
+	}
+	if got[0].path != "/hook" || got[0].body["event"] != eventFailed || got[0].body["error"] != "boom" {
+		t.Errorf("webhook got %+v", got[0])
+	}
+	if got[1].path != "/discord" || !strings.Contains(got[1].body["content"].(string), "failed") {
+		t.Errorf("discord got %+v", got[1])
+	}
+	if got[2].path != "/bot123:abc/sendMessage" || got[2].body["chat_id"] != "-42" || got[2].body["text"] == "" {
+		t.Errorf("telegram got %+v", got[2])
+	}
+}
+
+func TestCheckNotifiers(t *testing.T) {
+	for _, n := range []config.Notifier{
+		{Type: "slack", URL: "https://example.com"},
+		{Type: "telegram", ChatID: "1"},
+		{Type: "discord"},
+		{Type: "webhook", URL: "https://example.com", Events: []string{"started"}},
+		{Type: "smtp", Addr: "mail.example.com", From: "a@example.com", To: []string{"b@example.com"}},
+		{Type: "smtp", Addr: "mail.example.com:25", From: "a@example.com", To: []string{"b@example.com"}, Subject: "{{.Model"},
+	} {
+		if err := checkNotifiers([]config.Notifier{n}); err == nil {
+			t.Errorf("checkNotifiers(%+v) accepted an invalid notifier", n)
+		}
+	}
+}
+
+// fakeSMTP accepts one session on a local port and sends the DATA it
+// received on the returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 fake ESMTP\r\n")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					fmt.Fprint(conn, "250 queued\r\n")
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+			switch strings.ToUpper(strings.Fields(line)[0]) {
+			case "EHLO", "HELO":
+				fmt.Fprint(conn, "250 fake\r\n")
+			case "DATA":
+				inData = true
+				fmt.Fprint(conn, "354 go ahead\r\n")
+			case "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				got <- data.String()
+				return
+			default:
+				fmt.Fprint(conn, "250 ok\r\n")
+			}
+		}
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestSendMail(t *testing.T) {
+	addr, got := fakeSMTP(t)
+	nf := config.Notifier{Type: "smtp", Addr: addr, From: "omd@example.com", To: []string{"ops@example.com"}, Subject: "{{.Model}} {{.Event}}"}
+	if err := checkNotifiers([]config.Notifier{nf}); err != nil {
+		t.Fatalf("checkNotifiers() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n := notification{Event: eventCompleted, Model: "llama3", Zip: "llama3.zip", Bytes: 2 << 30, Text: "Downloaded llama3"}
+	if err := sendMail(ctx, nf, n); err != nil {
+		t.Fatalf("sendMail() error = %v", err)
+	}
+	msg := <-got
+	for _, want := range []string{"To: ops@example.com", "Subject: llama3 completed", "Downloaded llama3", "Archive: llama3.zip (2.00 GiB)"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestBuildMailDefaultSubject(t *testing.T) {
+	nf := config.Notifier{Type: "smtp", From: "a@example.com", To: []string{"b@example.com"}}
+	msg, err := buildMail(nf, notification{Event: eventMirror, Mirrored: []string{"a", "b"}, Failed: []string{"c"}}, time.Now())
+	if err != nil {
+		t.Fatalf("buildMail() error = %v", err)
+	}
+	if !strings.Contains(string(msg), "Subject: [ollama-model-downloader] mirror: 2 mirrored, 1 failed\r\n") {
+		t.Errorf("unexpected message:\n%s", msg)
+	}
+}
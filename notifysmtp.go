@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"ollama-model-downloader/config"
+)
+
+// Default mail templates. They are executed with the notification; bytes
+// formats a size.
+const (
+	defaultMailSubject = `[ollama-model-downloader] {{if eq .Event "mirror"}}mirror: {{len .Mirrored}} mirrored, {{len .Failed}} failed{{else}}{{.Model}} {{.Event}}{{end}}`
+	defaultMailBody    = `{{.Text}}
+{{if .Zip}}
+Archive: {{.Zip}} ({{bytes .Bytes}})
+{{end}}{{if .Session}}Session: {{.Session}}
+{{end}}`
+)
+
+var mailFuncs = template.FuncMap{"bytes": humanBytes}
+
+// mailTemplates parses the subject and body templates of an smtp notifier,
+// falling back to the defaults.
+func mailTemplates(nf config.Notifier) (subject, body *template.Template, err error) {
+	s, b := nf.Subject, nf.Body
+	if s == "" {
+		s = defaultMailSubject
+	}
+	if b == "" {
+		b = defaultMailBody
+	}
+	if subject, err = template.New("subject").Funcs(mailFuncs).Parse(s); err != nil {
+		return nil, nil, fmt.Errorf("subject: %w", err)
+	}
+	if body, err = template.New("body").Funcs(mailFuncs).Parse(b); err != nil {
+		return nil, nil, fmt.Errorf("body: %w", err)
+	}
+	return subject, body, nil
+}
+
+// buildMail renders n into an RFC 5322 message.
+func buildMail(nf config.Notifier, n notification, now time.Time) ([]byte, error) {
+	st, bt, err := mailTemplates(nf)
+	if err != nil {
+		return nil, err
+	}
+	var subject, body bytes.Buffer
+	if err := st.Execute(&subject, n); err != nil {
+		return nil, fmt.Errorf("subject: %w", err)
+	}
+	if err := bt.Execute(&body, n); err != nil {
+		return nil, fmt.Errorf("body: %w", err)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", nf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(nf.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
+
+// sendMail delivers n through the notifier's SMTP server. Unlike
+// smtp.SendMail it gives up when ctx ends.
+func sendMail(ctx context.Context, nf config.Notifier, n notification) error {
+	msg, err := buildMail(nf, n, time.Now())
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(nf.Addr)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", nf.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if nf.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", nf.Username, nf.Secret(), host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(nf.From); err != nil {
+		return err
+	}
+	for _, to := range nf.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
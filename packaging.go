@@ -0,0 +1,313 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveWriter is one archive format as packTo sees it. zipArchive is the
+// default; tarArchive is used for archives split into parts.
+type archiveWriter interface {
+	// dir adds a directory entry; name ends with a slash.
+	dir(name string) error
+	// file adds a file entry of size bytes, written to the returned writer
+	// before the next entry is added.
+	file(name string, mode os.FileMode, size int64) (io.Writer, error)
+	Close() error
+}
+
+// zipArchive writes zip entries with one compression method. archive/zip
+// switches to Zip64 records by itself for entries and archives over 4 GiB.
+type zipArchive struct {
+	zw     *zip.Writer
+	method uint16
+}
+
+func (a zipArchive) dir(name string) error {
+	_, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: a.method, Modified: time.Now()})
+	return err
+}
+
+func (a zipArchive) file(name string, mode os.FileMode, size int64) (io.Writer, error) {
+	fh := &zip.FileHeader{Name: name, Method: a.method, Modified: time.Now(), UncompressedSize64: uint64(size)}
+	fh.SetMode(mode)
+	return a.zw.CreateHeader(fh)
+}
+
+func (a zipArchive) Close() error { return a.zw.Close() }
+
+// tarArchive writes an uncompressed tar. archive/tar uses PAX headers for
+// entries the ustar size field cannot hold (8 GiB and over).
+type tarArchive struct {
+	tw *tar.Writer
+}
+
+func (a tarArchive) dir(name string) error {
+	return a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0o755, ModTime: time.Now()})
+}
+
+func (a tarArchive) file(name string, mode os.FileMode, size int64) (io.Writer, error) {
+	err := a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: int64(mode.Perm()), Size: size, ModTime: time.Now()})
+	return a.tw, err
+}
+
+func (a tarArchive) Close() error { return a.tw.Close() }
+
+// zipOptions controls how zipTo writes an archive.
+type zipOptions struct {
+	compression string                // -compression value
+	workers     int                   // goroutines compressing each entry
+	skip        func(rel string) bool // leaves out the file at this slash-separated path below root
+}
+
+// zipDir writes the contents of root to outZip and returns the sha256 of the
+// archive bytes, hashed while writing so no second pass is needed.
+func zipDir(root, outZip string, zo zipOptions) (string, error) {
+	// root folder will be included content-only; we want manifests/ and blobs/ at zip root
+	return writeZip(outZip, root, "", nil, zo)
+}
+
+// writeZip is zipDir with the contents of root placed under prefix and the
+// extra files written first, at the archive root.
+func writeZip(outZip, root, prefix string, extra []zipEntry, zo zipOptions) (string, error) {
+	out, err := os.Create(outZip)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	hasher := sha256.New()
+	if err := zipTo(io.MultiWriter(out, hasher), root, prefix, extra, zo); err != nil {
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// zipTo writes the archive of writeZip to w as zo says.
+func zipTo(w io.Writer, root, prefix string, extra []zipEntry, zo zipOptions) error {
+	method, level, err := zipCompression(zo.compression)
+	if err != nil {
+		return err
+	}
+	return packTo(zipArchive{zw: newZipWriter(w, level, zo.workers), method: method}, root, prefix, extra, zo.skip)
+}
+
+// tarTo is zipTo writing an uncompressed tar; blobs hardly compress anyway.
+func tarTo(w io.Writer, root, prefix string, extra []zipEntry, skip func(rel string) bool) error {
+	return packTo(tarArchive{tw: tar.NewWriter(w)}, root, prefix, extra, skip)
+}
+
+// packTo writes the extra files and then the contents of root, under
+// prefix, to aw and closes it. Files skip reports are left out.
+func packTo(aw archiveWriter, root, prefix string, extra []zipEntry, skip func(rel string) bool) error {
+	for _, e := range extra {
+		fw, err := aw.file(e.name, e.mode, int64(len(e.data)))
+		if err != nil {
+			aw.Close()
+			return err
+		}
+		if _, err := fw.Write(e.data); err != nil {
+			aw.Close()
+			return err
+		}
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		// archives need forward slashes
+		name := prefix + filepath.ToSlash(rel)
+		if info.IsDir() {
+			return aw.dir(name + "/")
+		}
+		if skip != nil && skip(filepath.ToSlash(rel)) {
+			return nil
+		}
+		fw, err := aw.file(name, info.Mode(), info.Size())
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil {
+		aw.Close()
+		return err
+	}
+	return aw.Close()
+}
+
+// fat32MaxFileSize is the largest file FAT32 (and FAT16) can hold.
+const fat32MaxFileSize = 1<<32 - 1
+
+// archiveFileLimit returns the largest file the filesystem dir will be on
+// can hold when an archive of about size bytes is more than that, and 0
+// otherwise. It warns up front, before anything is downloaded, that the
+// archive will be written as a tar split into parts instead: neither zip
+// nor tar helps when the single file cannot be written.
+func archiveFileLimit(dir string, size int64) int64 {
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	limit, fs := fileSizeLimit(dir)
+	if limit == 0 || size <= limit {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "warning: %s is on a %s filesystem, which cannot hold files over %s; the %s archive will be a tar split into parts of at most that size\n", dir, fs, humanBytes(limit), humanBytes(size))
+	return limit
+}
+
+// splitTarBase is the name the parts of a split tar replacing outZip share;
+// they add .001, .002 and so on.
+func splitTarBase(outZip string) string {
+	return strings.TrimSuffix(outZip, filepath.Ext(outZip)) + ".tar"
+}
+
+// writeSplitTar packages modelsRoot as packageArchive does, but as a tar
+// split into parts of at most partSize bytes next to opt.outZip. Each part
+// gets a checksum sidecar and, with -sign, signatures; the parts of an
+// earlier, larger archive are removed. cat joins the parts into the tar.
+func writeSplitTar(opt options, modelsRoot string, partSize int64, leftOut map[string]bool) ([]string, error) {
+	prefix, extra, err := bundleLayout(opt.bundle)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(opt.outZip), 0o755); err != nil {
+		return nil, err
+	}
+	sw := &splitWriter{base: splitTarBase(opt.outZip), limit: partSize}
+	err = tarTo(sw, modelsRoot, prefix, extra, leftOutSkip(leftOut))
+	if cerr := sw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		for _, p := range sw.parts {
+			os.Remove(p + ".partial")
+		}
+		return nil, err
+	}
+	for i, p := range sw.parts {
+		if err := os.Rename(p+".partial", p); err != nil {
+			return nil, err
+		}
+		sumsPath, err := writeChecksumSidecar(p, sw.sums[i])
+		if err != nil {
+			return nil, fmt.Errorf("checksums: %w", err)
+		}
+		if opt.gpgSign != "" {
+			if err := signArtifacts(opt.gpgSign, p, sumsPath); err != nil {
+				return nil, fmt.Errorf("sign: %w", err)
+			}
+		}
+	}
+	for i := len(sw.parts) + 1; ; i++ {
+		stale := splitPartName(sw.base, i)
+		if err := os.Remove(stale); err != nil {
+			break
+		}
+		for _, sidecar := range archiveSidecars {
+			os.Remove(stale + sidecar)
+		}
+	}
+	if err := writeSumsFile(filepath.Dir(sw.base), opt.gpgSign); err != nil {
+		return nil, fmt.Errorf("checksums: %w", err)
+	}
+	return sw.parts, nil
+}
+
+// splitPartName is the name of part i (counting from 1) of a split archive.
+func splitPartName(base string, i int) string {
+	return fmt.Sprintf("%s.%03d", base, i)
+}
+
+// splitWriter writes a stream into part files of at most limit bytes,
+// each first named with a .partial suffix, and hashes every part.
+type splitWriter struct {
+	base  string
+	limit int64
+	parts []string // final names
+	sums  []string // sha256 of each finished part
+
+	f *os.File
+	h hash.Hash
+	n int64 // bytes in the current part
+}
+
+func (w *splitWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if w.f == nil || w.n == w.limit {
+			if err := w.next(); err != nil {
+				return written, err
+			}
+		}
+		chunk := b
+		if room := w.limit - w.n; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := w.f.Write(chunk)
+		w.h.Write(chunk[:n])
+		w.n += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// next finishes the current part and starts the following one.
+func (w *splitWriter) next() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+	name := splitPartName(w.base, len(w.parts)+1)
+	f, err := os.Create(name + ".partial")
+	if err != nil {
+		return err
+	}
+	w.f, w.h, w.n = f, sha256.New(), 0
+	w.parts = append(w.parts, name)
+	return nil
+}
+
+func (w *splitWriter) finish() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	w.sums = append(w.sums, hex.EncodeToString(w.h.Sum(nil)))
+	return err
+}
+
+func (w *splitWriter) Close() error { return w.finish() }
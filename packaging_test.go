@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sparseBuffer keeps what is written to it apart from runs of zeros, so a
+// multi-gigabyte archive of an all-zero file fits in memory and reads back.
+type sparseBuffer struct {
+	chunks map[int64][]byte // nonzero writes by offset
+	size   int64
+}
+
+var zeros = make([]byte, 1<<20)
+
+func (b *sparseBuffer) Write(p []byte) (int, error) {
+	for off := 0; off < len(p); off += len(zeros) {
+		chunk := p[off:min(off+len(zeros), len(p))]
+		if !bytes.Equal(chunk, zeros[:len(chunk)]) {
+			b.chunks[b.size+int64(off)] = bytes.Clone(chunk)
+		}
+	}
+	b.size += int64(len(p))
+	return len(p), nil
+}
+
+func (b *sparseBuffer) ReadAt(p []byte, off int64) (int, error) {
+	clear(p)
+	n := len(p)
+	if off+int64(n) > b.size {
+		n = int(max64(0, b.size-off))
+	}
+	for at, chunk := range b.chunks {
+		if at+int64(len(chunk)) <= off || at >= off+int64(n) {
+			continue
+		}
+		src, dst := max64(0, off-at), max64(0, at-off)
+		copy(p[dst:n], chunk[src:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// TestPackageLargeEntry packages a blob over 4 GiB, past what zip without
+// Zip64 records and a ustar header without extensions can describe, and
+// reads it back from both formats.
+func TestPackageLargeEntry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads 4 GiB per format")
+	}
+	const size int64 = 1<<32 + 1
+	root := t.TempDir()
+	blob := filepath.Join(root, "blobs", "sha256-"+strings.Repeat("0", 64))
+	os.MkdirAll(filepath.Dir(blob), 0o755)
+	f, err := os.Create(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil { // sparse: all zeros
+		t.Fatal(err)
+	}
+	f.Close()
+	name := "blobs/" + filepath.Base(blob)
+
+	t.Run("zip", func(t *testing.T) {
+		buf := &sparseBuffer{chunks: map[int64][]byte{}}
+		if err := zipTo(buf, root, "", nil, zipOptions{compression: compressionNone}); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(buf, buf.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, zf := range zr.File {
+			if zf.Name != name {
+				continue
+			}
+			if zf.UncompressedSize64 != uint64(size) {
+				t.Fatalf("entry size = %d, want %d", zf.UncompressedSize64, size)
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			n, err := io.Copy(io.Discard, rc) // checks the CRC-32 at the end
+			if err != nil || n != size {
+				t.Fatalf("read %d bytes: %v", n, err)
+			}
+			return
+		}
+		t.Fatalf("no %s in the zip", name)
+	})
+
+	t.Run("tar", func(t *testing.T) {
+		buf := &sparseBuffer{chunks: map[int64][]byte{}}
+		if err := tarTo(buf, root, "", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(io.NewSectionReader(buf, 0, buf.size))
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatalf("no %s in the tar: %v", name, err)
+			}
+			if hdr.Name != name {
+				continue
+			}
+			n, err := io.Copy(io.Discard, tr)
+			if err != nil || n != size || hdr.Size != size {
+				t.Fatalf("entry of %d bytes, read %d: %v", hdr.Size, n, err)
+			}
+			return
+		}
+	})
+}
+
+func TestSplitTar(t *testing.T) {
+	_, srv, layer := testModel(t, 64<<10)
+	opt := testOptions(t, srv.URL)
+	opt.archivePartSize = 20 << 10
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(opt.outZip); !os.IsNotExist(err) {
+		t.Errorf("a split run wrote the zip: %v", err)
+	}
+	parts, _ := filepath.Glob(splitTarBase(opt.outZip) + ".[0-9][0-9][0-9]")
+	sort.Strings(parts)
+	if len(parts) < 4 {
+		t.Fatalf("parts = %v, want the 64 KiB layer over at least 4", parts)
+	}
+	for _, part := range parts {
+		if st, err := os.Stat(part); err != nil {
+			t.Error(err)
+		} else if st.Size() > opt.archivePartSize {
+			t.Errorf("%s is %d bytes, over the part size", filepath.Base(part), st.Size())
+		}
+		if _, err := os.Stat(part + checksumSuffix); err != nil {
+			t.Errorf("no checksum for %s", filepath.Base(part))
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "ollama")
+	if err := importArchive(parts[0], dest, true, false); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	blobs, _ := os.ReadDir(filepath.Join(dest, "blobs"))
+	for _, b := range blobs {
+		data, _ := os.ReadFile(filepath.Join(dest, "blobs", b.Name()))
+		found = found || bytes.Equal(data, layer)
+	}
+	if !found {
+		t.Error("layer not imported from the parts")
+	}
+
+	// Bigger parts replace the old set without leaving its tail behind.
+	opt.archivePartSize = 1 << 20
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := filepath.Glob(splitTarBase(opt.outZip) + ".[0-9][0-9][0-9]*"); len(again) != 2 {
+		t.Errorf("after repackaging: %v, want one part and its checksum", again)
+	}
+}
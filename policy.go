@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+)
+
+// checkPolicy applies the checks a resolved model has to pass before any of
+// its blobs is fetched: -strict-media-types, the signature policy,
+// -max-size and -quota. extra are manifests fetched along with it, such as
+// the base model's with -fetch-base. It returns the bytes to be fetched.
+func checkPolicy(ctx context.Context, client *http.Client, opt options, res resolvedManifest, extra ...imageManifest) (int64, error) {
+	if err := checkMediaTypes(res.manifest, opt.strictMediaTypes); err != nil {
+		return 0, err
+	}
+	if err := checkSignature(ctx, client, opt, res.ref.Repository, manifestDigest(res.raw), res.token); err != nil {
+		return 0, err
+	}
+	size := plannedBytes(opt.layers, append([]imageManifest{res.manifest}, extra...)...)
+	if err := checkMaxSize(opt, size); err != nil {
+		return 0, err
+	}
+	var staged int64
+	if opt.stagingDir != "" {
+		staged = computeExistingBytes(filepath.Join(opt.stagingDir, "models", "blobs"), manifestBlobs(res.manifest, blobSource{}))
+	}
+	if err := checkQuota(opt, size, staged); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
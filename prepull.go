@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "prepull",
+		usage: "download models straight into a models directory and print a JSON summary (for init containers and cache-warming jobs)",
+		run:   runPrepull,
+	})
+}
+
+// prepullSummary is what prepull prints when it is done.
+type prepullSummary struct {
+	Dir    string         `json:"dir"`
+	Models []prepullModel `json:"models"`
+	Failed int            `json:"failed"`
+}
+
+type prepullModel struct {
+	Model          string  `json:"model"`
+	Status         string  `json:"status"` // "present", "installed", "failed" or "interrupted"
+	Bytes          int64   `json:"bytes,omitempty"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	Error          string  `json:"error,omitempty"`
+}
+
+func runPrepull(opt options, args []string) error {
+	flags := flag.NewFlagSet("prepull", flag.ContinueOnError)
+	dir := flags.String("dir", "", "models directory to install into (default: the one Ollama uses)")
+	summaryPath := flags.String("summary", "", "also write the JSON summary to this file, e.g. /dev/termination-log")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: prepull [-dir path] [-summary file] <model>...")
+	}
+	if opt.outZip != "" {
+		return errors.New("-o names an archive; prepull installs without one")
+	}
+	if *dir == "" {
+		d, err := ollamaModelsDir()
+		if err != nil {
+			return err
+		}
+		*dir = d
+	}
+	// Stdout carries only the summary.
+	opt.installDir, opt.quiet, opt.verbose, opt.yes, opt.confirm = *dir, true, false, true, false
+	progressBars = false
+
+	summary := prepullSummary{Dir: *dir, Models: []prepullModel{}}
+	var missing []string
+	for _, name := range flags.Args() {
+		if size, ok := installedModel(opt, name); ok {
+			summary.Models = append(summary.Models, prepullModel{Model: name, Status: "present", Bytes: size})
+			continue
+		}
+		missing = append(missing, name)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	if len(missing) > 0 {
+		for _, r := range pullModels(ctx, opt, missing) {
+			m := prepullModel{Model: r.model, Status: "installed", ElapsedSeconds: r.elapsed.Seconds()}
+			switch {
+			case r.err == errInterrupted:
+				m.Status = "interrupted"
+			case r.err != nil:
+				m.Status, m.Error = "failed", r.err.Error()
+			default:
+				m.Bytes, _ = installedModel(opt, r.model)
+			}
+			if r.err != nil {
+				summary.Failed++
+			}
+			summary.Models = append(summary.Models, m)
+		}
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	os.Stdout.Write(data)
+	if *summaryPath != "" {
+		if err := os.WriteFile(*summaryPath, data, 0o644); err != nil {
+			return fmt.Errorf("summary: %w", err)
+		}
+	}
+	if ctx.Err() != nil {
+		os.Exit(130)
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d models failed", summary.Failed, len(summary.Models))
+	}
+	return nil
+}
+
+// installedModel reports whether opt.installDir already holds the manifest
+// of model and every blob it lists, at the right size, and how many bytes
+// those blobs take. Nothing is fetched, so a tag that moved on the registry
+// since it was installed still counts as present.
+func installedModel(opt options, model string) (int64, bool) {
+	ref, err := parseModel(opt.registry, model, opt.modelConfig)
+	if err != nil {
+		return 0, false
+	}
+	raw, err := os.ReadFile(filepath.Join(opt.installDir, "manifests", ref.Host, ref.Repository, manifestFileTail(ref)))
+	if err != nil {
+		return 0, false
+	}
+	var m imageManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return 0, false
+	}
+	layers := m.Layers
+	if m.Config.Digest != "" {
+		layers = append(layers, manifestLayer{Digest: m.Config.Digest, Size: m.Config.Size})
+	}
+	var total int64
+	for _, l := range layers {
+		st, err := os.Stat(filepath.Join(opt.installDir, "blobs", blobFileName(l.Digest)))
+		if err != nil || st.Size() != l.Size {
+			return 0, false
+		}
+		total += l.Size
+	}
+	return total, true
+}
+
+// installStaged puts the blobs and then the manifests below modelsRoot
+// into the models directory dest, so Ollama never sees a manifest whose
+// blobs are still missing. Blobs dest already has at the same size are
+// skipped. It returns how many files were hard-linked and how many copied.
+func installStaged(modelsRoot, dest string) (linked, copied int, err error) {
+	install := func(src, dst string) error {
+		ok, err := installFile(src, dst)
+		if ok {
+			linked++
+		} else if err == nil {
+			copied++
+		}
+		return err
+	}
+	blobs, err := os.ReadDir(filepath.Join(modelsRoot, "blobs"))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range blobs {
+		if _, ok := digestFromBlobName(e.Name()); !ok {
+			continue
+		}
+		src, dst := filepath.Join(modelsRoot, "blobs", e.Name()), filepath.Join(dest, "blobs", e.Name())
+		if info, err := e.Info(); err == nil {
+			if st, err := os.Stat(dst); err == nil && st.Size() == info.Size() {
+				continue
+			}
+		}
+		if err := install(src, dst); err != nil {
+			return linked, copied, err
+		}
+	}
+	manifests := filepath.Join(modelsRoot, "manifests")
+	err = filepath.WalkDir(manifests, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(modelsRoot, path)
+		if err != nil {
+			return err
+		}
+		return install(path, filepath.Join(dest, rel))
+	})
+	return linked, copied, err
+}
+
+// installFile puts src at dst under a temporary name first, then renames it
+// into place. When both are on the same filesystem the temporary name is a
+// hard link, which takes no time whatever the size; otherwise (or where
+// links are not supported) the file is copied.
+func installFile(src, dst string) (linked bool, err error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return false, err
+	}
+	tmp := dst + ".tmp"
+	os.Remove(tmp)
+	if os.Link(src, tmp) == nil {
+		if err := os.Rename(tmp, dst); err != nil {
+			os.Remove(tmp)
+			return false, err
+		}
+		return true, nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	out, err := os.Create(tmp)
+	if err != nil {
+		return false, err
+	}
+	if _, err := copyBody(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrepullInstall(t *testing.T) {
+	_, srv, layer := testModel(t, 64<<10)
+	opt := testOptions(t, srv.URL)
+	opt.outZip, opt.defaultZip = "", false
+	opt.installDir = filepath.Join(t.TempDir(), "ollama")
+
+	if _, ok := installedModel(opt, "test/m:latest"); ok {
+		t.Fatal("empty models directory reported as installed")
+	}
+	results := pullModels(context.Background(), opt, []string{"test/m:latest"})
+	if results[0].err != nil {
+		t.Fatal(results[0].err)
+	}
+	size, ok := installedModel(opt, "test/m:latest")
+	if !ok || size < int64(len(layer)) {
+		t.Fatalf("installedModel after install = %d, %v", size, ok)
+	}
+	if _, err := os.Stat(results[0].archive); !os.IsNotExist(err) {
+		t.Errorf("install mode wrote an archive: %v", err)
+	}
+	blobs, _ := os.ReadDir(filepath.Join(opt.installDir, "blobs"))
+	var found bool
+	for _, b := range blobs {
+		data, _ := os.ReadFile(filepath.Join(opt.installDir, "blobs", b.Name()))
+		found = found || bytes.Equal(data, layer)
+	}
+	if !found {
+		t.Error("model layer not installed")
+	}
+
+	// A blob gone missing makes the model incomplete again.
+	os.Remove(filepath.Join(opt.installDir, "blobs", blobs[0].Name()))
+	if _, ok := installedModel(opt, "test/m:latest"); ok {
+		t.Error("model with a missing blob reported as installed")
+	}
+}
+
+func TestInstallStagedLinks(t *testing.T) {
+	dir := t.TempDir()
+	staged := filepath.Join(dir, "staging", "models")
+	blob := filepath.Join(staged, "blobs", "sha256-"+strings.Repeat("a", 64))
+	manifest := filepath.Join(staged, "manifests", "registry.ollama.ai", "library", "m", "latest")
+	for path, data := range map[string]string{blob: "weights", manifest: `{"layers":[]}`} {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte(data), 0o644)
+	}
+	dest := filepath.Join(dir, "ollama")
+	linked, copied, err := installStaged(staged, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked+copied != 2 {
+		t.Fatalf("installStaged = %d linked, %d copied; want 2 files", linked, copied)
+	}
+	// Both trees are in one temporary directory, so the files are the same
+	// wherever hard links work.
+	if linked == 2 {
+		a, _ := os.Stat(blob)
+		b, err := os.Stat(filepath.Join(dest, "blobs", filepath.Base(blob)))
+		if err != nil || !os.SameFile(a, b) {
+			t.Errorf("linked blob is not the staged file: %v", err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "manifests", "registry.ollama.ai", "library", "m", "latest")); err != nil || string(data) != `{"layers":[]}` {
+		t.Errorf("manifest = %q, %v", data, err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dest, "*", "*.tmp")); len(matches) > 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
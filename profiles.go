@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"ollama-model-downloader/config"
+)
+
+// splitRegistryHost separates an explicit registry host from a model name
+// ("internal.example.com:5000/team/model:tag"). Like docker, the first path
+// segment is a host only if it contains a dot or a port, or is localhost.
+func splitRegistryHost(name string) (host, rest string, ok bool) {
+	i := strings.Index(name, "/")
+	if i < 0 {
+		return "", name, false
+	}
+	first := name[:i]
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return "", name, false
+	}
+	return first, name[i+1:], true
+}
+
+// profileFor returns the first registry profile that matches name by prefix
+// or by the explicit host it starts with, or nil.
+func (mc modelConfig) profileFor(name string) *config.Registry {
+	host, _, explicit := splitRegistryHost(name)
+	for i := range mc.registries {
+		p := &mc.registries[i]
+		for _, prefix := range p.Prefixes {
+			if prefix != "" && strings.HasPrefix(name, prefix) {
+				return p
+			}
+		}
+		if explicit && p.URL != "" {
+			if u, err := url.Parse(p.URL); err == nil && u.Host == host {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// withProfile points opt at the registry ref resolved to and applies the
+// credentials, TLS and rate-limit settings of its profile.
+func withProfile(opt options, ref modelRef) (options, error) {
+	opt.registry = ref.Registry
+	p := ref.Profile
+	if p == nil {
+		return opt, nil
+	}
+	if opt.verbose {
+		fmt.Printf("Using registry profile %s\n", p.URL)
+	}
+	if p.Username != "" {
+		opt.username = p.Username
+		opt.password = p.Secret()
+	}
+	opt.insecureTLS = opt.insecureTLS || p.Insecure
+	if p.CAFile != "" {
+		pem, err := os.ReadFile(p.CAFile)
+		if err != nil {
+			return opt, fmt.Errorf("registry profile %s: %w", p.URL, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return opt, fmt.Errorf("registry profile %s: no certificates in %s", p.URL, p.CAFile)
+		}
+		opt.rootCAs = pool
+	}
+	if p.RequestsPerSecond > 0 {
+		opt.requestsPerSecond = p.RequestsPerSecond
+	}
+	return opt, nil
+}
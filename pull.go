@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "pull",
+		usage: "download one or more models, same as passing them without a command; model@sha256:... pins a manifest",
+		run:   runPull,
+	})
+}
+
+func runPull(opt options, args []string) error {
+	flags := flag.NewFlagSet("pull", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: pull <model[:tag] | model[:tag]@sha256:digest>...")
+	}
+	return pullCLI(opt, flags.Args())
+}
+
+// pullCLI downloads names in the foreground: one model exactly like
+// downloadCLI, several at once with pullModels, followed by a summary.
+func pullCLI(opt options, names []string) error {
+	if len(names) == 1 {
+		return downloadCLI(withModel(opt, names[0]))
+	}
+	if opt.outZip != "" {
+		return errors.New("-o names a single archive; leave it out when pulling several models")
+	}
+	seen := map[string]string{}
+	for _, name := range names {
+		id := sanitizeModelName(name)
+		if prev, ok := seen[id]; ok {
+			return fmt.Errorf("%s and %s would share the session %s; pull them separately", prev, name, id)
+		}
+		seen[id] = name
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	if !opt.yes && isTerminal(os.Stdin) {
+		if err := confirmModels(ctx, os.Stdin, os.Stderr, names); err != nil {
+			if ctx.Err() != nil {
+				os.Exit(130)
+			}
+			return err
+		}
+	}
+	if err := waitForStart(ctx, opt.startAt); err != nil {
+		os.Exit(130)
+	}
+	results := pullModels(ctx, opt, names)
+	printPullSummary(os.Stdout, results)
+
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.err == errInterrupted:
+		case r.err != nil:
+			printError(fmt.Errorf("%s: %w", r.model, r.err))
+			failed++
+		}
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "\ninterrupted; progress is kept in %s\nresume with: %s\n", opt.outputDir, resumeCommand())
+		os.Exit(130)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d models failed", failed, len(results))
+	}
+	return nil
+}
+
+// confirmModels asks once before several models are downloaded; their sizes
+// are only known once each manifest is resolved.
+func confirmModels(ctx context.Context, in io.Reader, out io.Writer, names []string) error {
+	fmt.Fprintf(out, "Models: %s\nDownload %d models? [y/N] ", strings.Join(names, ", "), len(names))
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		answer <- strings.ToLower(strings.TrimSpace(line))
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case a := <-answer:
+		if a == "y" || a == "yes" {
+			return nil
+		}
+		return errDeclined
+	}
+}
+
+// pullResult is how the download of one model in a pullModels call ended.
+type pullResult struct {
+	model   string
+	archive string
+	size    int64 // archive size, 0 unless the download succeeded
+	elapsed time.Duration
+	err     error // errInterrupted when the session was paused
+}
+
+// pullModels downloads names concurrently, at most opt.maxSessions at a
+// time like the web UI, and returns one result per name in the same order.
+// Each model is its own session, so an interrupted batch resumes model by
+// model. The per-model bars are replaced by one combined bar.
+func pullModels(ctx context.Context, opt options, names []string) []pullResult {
+	results := make([]pullResult, len(names))
+	bars := make([]*progress, len(names))
+	for i := range bars {
+		bars[i] = newProgress(0)
+	}
+	var finished int32
+	stopBar := startPullProgress(ctx, bars, &finished)
+	defer stopBar()
+
+	slots := len(names)
+	if opt.maxSessions > 0 && opt.maxSessions < slots {
+		slots = opt.maxSessions
+	}
+	sem := make(chan struct{}, slots)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			defer atomic.AddInt32(&finished, 1)
+			mopt := withModel(opt, name)
+			mopt.confirm = false
+			mopt.progress = bars[i]
+			results[i] = pullResult{model: name, archive: mopt.outZip}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i].err = errInterrupted
+				return
+			}
+			start := time.Now()
+			err := settleSession(ctx, mopt, run(ctx, mopt))
+			results[i].elapsed = time.Since(start)
+			results[i].archive = archivePath(mopt)
+			results[i].err = err
+			if err == nil {
+				if info, statErr := os.Stat(results[i].archive); statErr == nil {
+					results[i].size = info.Size()
+				}
+			}
+		}(i, name)
+	}
+	wg.Wait()
+	return results
+}
+
+// startPullProgress draws the combined bar of bars until the returned
+// function is called.
+func startPullProgress(ctx context.Context, bars []*progress, finished *int32) func() {
+	if !progressBars {
+		return func() {}
+	}
+	render := func() {
+		var done, total int64
+		for _, p := range bars {
+			done += atomic.LoadInt64(&p.done)
+			total += atomic.LoadInt64(&p.total)
+		}
+		percent := 0
+		if total > 0 {
+			percent = int(min(done, total) * 100 / total)
+		}
+		fmt.Fprintf(os.Stderr, "Downloading %d models: %s / %s (%d%%), %d finished\r",
+			len(bars), humanBytes(min(done, total)), humanBytes(total), percent, atomic.LoadInt32(finished))
+	}
+	quit := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tick := time.NewTicker(200 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				render()
+			case <-quit:
+				render()
+				os.Stderr.WriteString("\n")
+				return
+			case <-ctx.Done():
+				os.Stderr.WriteString("\n")
+				return
+			}
+		}
+	}()
+	return func() {
+		select {
+		case quit <- struct{}{}:
+		case <-stopped:
+		}
+		<-stopped
+	}
+}
+
+// printPullSummary prints one line per model of a pullModels call.
+func printPullSummary(w io.Writer, results []pullResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tSTATUS\tSIZE\tTIME\tARCHIVE")
+	for _, r := range results {
+		status, size, elapsed, archive := "done", humanBytes(r.size), r.elapsed.Round(time.Second).String(), r.archive
+		switch {
+		case r.err == errInterrupted:
+			status, size, archive = "paused", "-", "-"
+		case errors.Is(r.err, errSessionLocked):
+			status, size, archive = "busy", "-", "-"
+		case r.err != nil:
+			status, size, archive = "failed", "-", "-"
+		}
+		if r.elapsed == 0 {
+			elapsed = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.model, status, size, elapsed, archive)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"ollama-model-downloader/internal/registrytest"
+)
+
+func TestPullModels(t *testing.T) {
+	reg, srv, layer := testModel(t, 64<<10)
+	reg.AddModel("test/n", "latest", []byte(`{"model_format":"gguf"}`),
+		registrytest.Layer{MediaType: modelMediaType, Data: []byte("second model")},
+	)
+	opt := testOptions(t, srv.URL)
+	opt.outZip, opt.defaultZip = "", false
+	opt.maxSessions = 2
+
+	results := pullModels(context.Background(), opt, []string{"test/m:latest", "test/n:latest", "test/missing:latest"})
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for _, r := range results[:2] {
+		if r.err != nil {
+			t.Fatalf("%s: %v", r.model, r.err)
+		}
+		if r.size == 0 {
+			t.Errorf("%s: archive size not recorded", r.model)
+		}
+	}
+	if results[2].err == nil {
+		t.Error("missing model should fail")
+	}
+	if files := readZip(t, results[0].archive); !bytes.Contains(joinFiles(files), layer) {
+		t.Error("first archive lacks its model layer")
+	}
+	if files := readZip(t, results[1].archive); !bytes.Contains(joinFiles(files), []byte("second model")) {
+		t.Error("second archive lacks its model layer")
+	}
+
+	var out bytes.Buffer
+	printPullSummary(&out, results)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "MODEL") {
+		t.Fatalf("summary:\n%s", out.String())
+	}
+	if !strings.Contains(lines[1], "done") || !strings.Contains(lines[3], "failed") {
+		t.Errorf("summary:\n%s", out.String())
+	}
+}
+
+func joinFiles(files map[string][]byte) []byte {
+	var all []byte
+	for _, data := range files {
+		all = append(all, data...)
+	}
+	return all
+}
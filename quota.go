@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dirUsage is the size of the regular files below dir; a missing dir uses
+// nothing.
+func dirUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// quotaReservations holds the bytes admitted downloads have yet to write
+// to the output directory, so downloads running side by side (web UI
+// sessions, the models of a mirror batch) are not each admitted against
+// the same room under -quota. Reservations are keyed by staging directory.
+// A nil value reserves nothing.
+type quotaReservations struct {
+	mu    sync.Mutex
+	holds map[string]quotaHold
+}
+
+// quotaHold is the need of one admitted download and the size of its
+// staging directory at admission, so what it has written since is not
+// counted twice.
+type quotaHold struct {
+	need, base int64
+}
+
+func newQuotaReservations() *quotaReservations {
+	return &quotaReservations{holds: map[string]quotaHold{}}
+}
+
+// outstanding sums what the downloads other than stagingDir's reserved
+// and have not written yet. The caller holds q.mu.
+func (q *quotaReservations) outstanding(stagingDir string) int64 {
+	if q == nil {
+		return 0
+	}
+	var total int64
+	for dir, h := range q.holds {
+		if dir == stagingDir {
+			continue
+		}
+		usage, _ := dirUsage(dir)
+		if left := h.need - max64(0, usage-h.base); left > 0 {
+			total += left
+		}
+	}
+	return total
+}
+
+// release drops the reservation of stagingDir once its download finished
+// or failed.
+func (q *quotaReservations) release(stagingDir string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	delete(q.holds, stagingDir)
+	q.mu.Unlock()
+}
+
+// checkQuota fails when downloading size more bytes (staged bytes of the
+// session excluded) and packaging them would take -output-dir past
+// -quota, counting what other running downloads were admitted for. Blobs
+// and the archive coexist until packaging ends, so both are counted. On
+// success the bytes are reserved in opt.quotaReservations until released.
+func checkQuota(opt options, size, staged int64) error {
+	if opt.quota <= 0 {
+		return nil
+	}
+	q := opt.quotaReservations
+	if q != nil {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+	}
+	used, err := dirUsage(opt.outputDir)
+	if err != nil {
+		return fmt.Errorf("quota: %w", err)
+	}
+	reserved := q.outstanding(opt.stagingDir)
+	need := size - staged + size
+	if used+reserved+need <= opt.quota {
+		if q != nil {
+			base, _ := dirUsage(opt.stagingDir)
+			q.holds[opt.stagingDir] = quotaHold{need: need, base: base}
+		}
+		return nil
+	}
+	inUse := humanBytes(used) + " in use"
+	if reserved > 0 {
+		inUse += ", " + humanBytes(reserved) + " more reserved by running downloads"
+	}
+	return fmt.Errorf("%s needs about %s more in %s, which would exceed -quota %s (%s); delete archives or sessions there, or raise -quota",
+		opt.model, humanBytes(need), opt.outputDir, humanBytes(opt.quota), inUse)
+}
+
+// usageResponse is the disk use of the output directory.
+type usageResponse struct {
+	UsedBytes  int64 `json:"usedBytes"`
+	QuotaBytes int64 `json:"quotaBytes,omitempty"` // 0 = no quota
+	FreeBytes  int64 `json:"freeBytes,omitempty"`  // free on its volume, if known
+}
+
+func outputUsage(opt options, dir string) (usageResponse, error) {
+	used, err := dirUsage(dir)
+	if err != nil {
+		return usageResponse{}, err
+	}
+	u := usageResponse{UsedBytes: used, QuotaBytes: opt.quota}
+	if free, err := diskFree(dir); err == nil {
+		u.FreeBytes = int64(free)
+	}
+	return u, nil
+}
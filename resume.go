@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ollama-model-downloader/models"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "resume",
+		usage: "continue a stored session; -offline packages it from staged blobs without the registry",
+		run:   runResume,
+	})
+}
+
+func runResume(opt options, args []string) error {
+	flags := flag.NewFlagSet("resume", flag.ContinueOnError)
+	offline := flags.Bool("offline", false, "use the stored manifest and staged blobs; do not contact the registry")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: resume [-offline] <model or session id>")
+	}
+	_, staging, meta, err := findSession(opt.outputDir, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if meta.State == models.StateCompleted {
+		return fmt.Errorf("session %s is already completed", meta.SessionID)
+	}
+	ropt := sessionOptions(opt, opt.outputDir, meta, staging)
+	ropt.offline = *offline
+	return downloadCLI(ropt)
+}
+
+// findSession locates the stored session arg names, either by session ID or
+// by model name.
+func findSession(outputDir, arg string) (sessionID, staging string, meta models.SessionMeta, err error) {
+	sessionID = arg
+	staging = filepath.Join(outputDir, sessionID+".staging")
+	if _, err := os.Stat(staging); err != nil {
+		sessionID = sanitizeModelName(sessionID)
+		staging = filepath.Join(outputDir, sessionID+".staging")
+	}
+	meta, err = models.LoadSessionMeta(staging)
+	if err != nil {
+		return "", "", meta, fmt.Errorf("session %s: %w", sessionID, err)
+	}
+	return sessionID, staging, meta, nil
+}
+
+// sessionOptions rebuilds download options for an existing session from its
+// stored metadata, falling back to base.
+func sessionOptions(base options, outputDir string, meta models.SessionMeta, staging string) options {
+	opt := base
+	opt.outputDir = outputDir
+	opt.model = meta.Model
+	opt.sessionID = meta.SessionID
+	opt.stagingDir = staging
+	if meta.Registry != "" {
+		opt.registry = meta.Registry
+	}
+	if meta.Platform != "" {
+		opt.platform = meta.Platform
+	}
+	opt.concurrency = meta.Concurrency
+	if opt.concurrency <= 0 {
+		opt.concurrency = 4
+	}
+	opt.retries = meta.Retries
+	if opt.retries < 0 {
+		opt.retries = 3
+	}
+	opt.outZip = meta.OutZip
+	if opt.outZip == "" {
+		name := meta.SessionID
+		if !strings.HasSuffix(strings.ToLower(name), ".zip") {
+			name += ".zip"
+		}
+		opt.outZip = filepath.Join(outputDir, name)
+		opt.defaultZip = true
+	}
+	return opt
+}
+
+// storedManifest resolves ref from the manifest an earlier run wrote into
+// the session's staging directory instead of asking the registry.
+func storedManifest(opt options, ref modelRef) (resolvedManifest, error) {
+	res := resolvedManifest{ref: ref}
+	path := filepath.Join(opt.stagingDir, "models", "manifests", ref.Host, ref.Repository, manifestFileTail(ref))
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return res, fmt.Errorf("offline: no stored manifest: %w", err)
+	}
+	m, err := decodeManifest(raw)
+	if err != nil {
+		return res, fmt.Errorf("offline: decode %s: %w", path, err)
+	}
+	if m.Config.Digest == "" && len(m.Layers) == 0 {
+		return res, fmt.Errorf("offline: %s is not an image manifest", path)
+	}
+	res.raw, res.manifest = raw, m
+	return res, nil
+}
+
+// interruptedPackaging returns the stored manifest of a session whose last
+// run died while packaging, which left its .partial archive behind. When
+// every blob is staged only the archive has to be built again, so the
+// registry is not asked and nothing is downloaded.
+func interruptedPackaging(opt options, ref modelRef) (resolvedManifest, bool) {
+	if opt.stagingDir == "" || opt.baseModel != "" {
+		return resolvedManifest{}, false
+	}
+	if _, err := os.Stat(archivePath(opt) + ".partial"); err != nil {
+		return resolvedManifest{}, false
+	}
+	res, err := storedManifest(opt, ref)
+	if err != nil {
+		return resolvedManifest{}, false
+	}
+	blobsDir := filepath.Join(opt.stagingDir, "models", "blobs")
+	items, _ := opt.layers.apply(manifestBlobs(res.manifest, blobSource{}))
+	if checkOfflineBlobs(blobsDir, items) != nil {
+		return resolvedManifest{}, false
+	}
+	return res, true
+}
+
+// checkOfflineBlobs fails unless every blob is complete in blobsDir, since
+// an offline run cannot fetch the rest.
+func checkOfflineBlobs(blobsDir string, items []blobItem) error {
+	var missing int
+	var want, have int64
+	for _, it := range items {
+		n := existingBytesForBlob(blobsDir, it.digest, it.size)
+		if n < it.size {
+			missing++
+		}
+		want += it.size
+		have += n
+	}
+	if missing > 0 {
+		return fmt.Errorf("offline: %d blobs are incomplete (%s of %s staged); resume online to fetch them", missing, humanBytes(have), humanBytes(want))
+	}
+	return nil
+}
+
+// offlineUnsupported rejects options that need the registry.
+func offlineUnsupported(opt options) error {
+	switch {
+	case opt.requireSignature:
+		return errors.New("offline: -require-signature needs the registry")
+	case opt.baseModel != "":
+		return errors.New("offline: -base-model needs the registry")
+	}
+	return nil
+}
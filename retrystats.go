@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ollama-model-downloader/models"
+)
+
+// retryStats collects per-blob retry counts of one run. Blob downloads run
+// concurrently, so every update takes the lock.
+type retryStats struct {
+	mu    sync.Mutex
+	blobs map[string]models.BlobStats
+}
+
+func newRetryStats() *retryStats {
+	return &retryStats{blobs: map[string]models.BlobStats{}}
+}
+
+type retryKey struct{}
+
+type retryScope struct {
+	stats  *retryStats
+	digest string
+}
+
+// withRetryStats makes httpReqWithRetry calls made with the returned context
+// count their retries against digest in stats.
+func withRetryStats(ctx context.Context, stats *retryStats, digest string) context.Context {
+	if stats == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retryKey{}, retryScope{stats, digest})
+}
+
+func (r *retryStats) update(digest string, fn func(b *models.BlobStats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.blobs[digest]
+	fn(&b)
+	r.blobs[digest] = b
+}
+
+// noteRetry records that a request made with ctx is retried after a
+// retryable status (status > 0) or a network error.
+func noteRetry(ctx context.Context, status int) {
+	scope, ok := ctx.Value(retryKey{}).(retryScope)
+	if !ok {
+		return
+	}
+	scope.stats.update(scope.digest, func(b *models.BlobStats) {
+		b.Retries++
+		if status == 0 {
+			b.NetworkErrors++
+			return
+		}
+		if b.RetryableStatuses == nil {
+			b.RetryableStatuses = map[string]int{}
+		}
+		b.RetryableStatuses[strconv.Itoa(status)]++
+	})
+}
+
+// noteRedownload records n bytes that had been fetched before but had to be
+// fetched again, e.g. when a server ignored a Range request.
+func noteRedownload(ctx context.Context, n int64) {
+	scope, ok := ctx.Value(retryKey{}).(retryScope)
+	if !ok || n <= 0 {
+		return
+	}
+	scope.stats.update(scope.digest, func(b *models.BlobStats) {
+		b.BytesRedownloaded += n
+	})
+}
+
+// snapshot returns the blobs that needed any extra work.
+func (r *retryStats) snapshot() map[string]models.BlobStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := map[string]models.BlobStats{}
+	for digest, b := range r.blobs {
+		if b.Retries > 0 || b.BytesRedownloaded > 0 {
+			out[digest] = b
+		}
+	}
+	return out
+}
+
+// summarizeTransfer renders t for verbose output, e.g.
+// "5 retries (503 x3, network errors x2), 12.00 MiB re-downloaded".
+func summarizeTransfer(t *models.TransferStats) string {
+	if t == nil || (t.Retries == 0 && t.BytesRedownloaded == 0) {
+		return "no retries"
+	}
+	var causes []string
+	codes := make([]string, 0, len(t.RetryableStatuses))
+	for code := range t.RetryableStatuses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		causes = append(causes, fmt.Sprintf("%s x%d", code, t.RetryableStatuses[code]))
+	}
+	if t.NetworkErrors > 0 {
+		causes = append(causes, fmt.Sprintf("network errors x%d", t.NetworkErrors))
+	}
+	s := fmt.Sprintf("%d retries", t.Retries)
+	if len(causes) > 0 {
+		s += " (" + strings.Join(causes, ", ") + ")"
+	}
+	return fmt.Sprintf("%s, %s re-downloaded", s, humanBytes(t.BytesRedownloaded))
+}
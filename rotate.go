@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// versionTimeLayout stamps rotated archives; it sorts like the time it
+// encodes.
+const versionTimeLayout = "20060102T150405"
+
+// versionName is the name the archive outZip, built from the manifest
+// digest (may be empty) and last written at stamp, is kept under once a
+// newer version replaces it: llama3.zip becomes
+// llama3-0123abcd-20240102T150405.zip.
+func versionName(outZip, digest, stamp string) string {
+	stem := strings.TrimSuffix(outZip, filepath.Ext(outZip))
+	if sum := strings.TrimPrefix(digest, "sha256:"); len(sum) >= 8 {
+		stem += "-" + sum[:8]
+	}
+	return stem + "-" + stamp + filepath.Ext(outZip)
+}
+
+// archiveVersions lists the rotated versions of outZip, oldest first.
+func archiveVersions(outZip string) ([]string, error) {
+	dir, base := filepath.Split(outZip)
+	ext := filepath.Ext(base)
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(strings.TrimSuffix(base, ext)) + `(-[0-9a-f]{8})?-(\d{8}T\d{6})` + regexp.QuoteMeta(ext) + `$`)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	type version struct{ path, stamp string }
+	var found []version
+	for _, e := range entries {
+		if m := pattern.FindStringSubmatch(e.Name()); m != nil && e.Type().IsRegular() {
+			found = append(found, version{filepath.Join(dir, e.Name()), m[2]})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].stamp < found[j].stamp })
+	versions := make([]string, len(found))
+	for i, v := range found {
+		versions[i] = v.path
+	}
+	return versions, nil
+}
+
+// rotateArchive makes room for a new build of outZip with manifest digest
+// digest. With keep > 1 the existing archive, built from previousDigest, is
+// moved aside under versionName and versions beyond the newest keep-1 are
+// removed, sidecars included. Rebuilding the same digest replaces the
+// archive in place, as does keep <= 1.
+func rotateArchive(outZip, previousDigest, digest string, keep int) error {
+	if keep <= 1 {
+		return nil
+	}
+	info, err := os.Stat(outZip)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if previousDigest == "" || previousDigest != digest {
+		to := versionName(outZip, previousDigest, info.ModTime().UTC().Format(versionTimeLayout))
+		if err := moveArchive(outZip, to); err != nil {
+			return err
+		}
+	}
+	versions, err := archiveVersions(outZip)
+	if err != nil {
+		return err
+	}
+	for len(versions) > keep-1 {
+		if err := os.Remove(versions[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, sidecar := range archiveSidecars {
+			_ = os.Remove(versions[0] + sidecar)
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateArchive(t *testing.T) {
+	dir := t.TempDir()
+	zip := filepath.Join(dir, "m.zip")
+	build := func(content string, age time.Duration) {
+		t.Helper()
+		if err := os.WriteFile(zip, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writeChecksumSidecar(zip, "00"); err != nil {
+			t.Fatal(err)
+		}
+		at := time.Now().Add(-age)
+		os.Chtimes(zip, at, at)
+	}
+	digest := func(c byte) string { return "sha256:" + strings.Repeat(string(c), 64) }
+
+	// keep 1 replaces in place
+	build("v1", 4*time.Hour)
+	if err := rotateArchive(zip, digest('1'), digest('2'), 1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := archiveVersions(zip); len(v) != 0 {
+		t.Errorf("keep 1 kept versions %v", v)
+	}
+
+	// the same digest is rebuilt in place
+	if err := rotateArchive(zip, digest('1'), digest('1'), 3); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := archiveVersions(zip); len(v) != 0 {
+		t.Errorf("rebuild kept versions %v", v)
+	}
+
+	for i, c := range []byte{'1', '2', '3'} {
+		if err := rotateArchive(zip, digest(c), digest(c+1), 3); err != nil {
+			t.Fatal(err)
+		}
+		build("v", time.Duration(3-i)*time.Hour)
+	}
+	v, err := archiveVersions(zip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 2 {
+		t.Fatalf("versions = %v, want 2", v)
+	}
+	for i, c := range []string{"22222222", "33333333"} {
+		if got := filepath.Base(v[i]); !strings.HasPrefix(got, "m-"+c+"-") {
+			t.Errorf("version %d = %s, want digest %s", i, got, c)
+		}
+		if _, err := os.Stat(v[i] + checksumSuffix); err != nil {
+			t.Errorf("version %d lost its checksum file: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(zip); err != nil {
+		t.Errorf("current archive: %v", err)
+	}
+}
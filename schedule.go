@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+// parseStartAt reads a -start-at or startAt value: a clock time such as
+// "01:00", meaning its next occurrence after now in local time, or an
+// RFC 3339 or "2006-01-02 15:04" date and time. Empty means now.
+func parseStartAt(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, now.Location()); err == nil {
+		return t, nil
+	}
+	clock, err := time.ParseInLocation("15:04", value, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start time %q (want HH:MM, \"YYYY-MM-DD HH:MM\" or RFC 3339)", value)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// waitForStart blocks the CLI until at, returning errInterrupted if ctx is
+// canceled first.
+func waitForStart(ctx context.Context, at time.Time) error {
+	wait := time.Until(at)
+	if wait <= 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "waiting until %s to start (Ctrl-C to cancel)\n", at.Format("2006-01-02 15:04"))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return errInterrupted
+	}
+}
+
+// schedule records opt's session as scheduled for at and starts it then.
+// The state is kept in session.json, so a restarted server schedules it
+// again (see recoverInterrupted).
+func (s *server) schedule(opt options, at time.Time) string {
+	_ = os.MkdirAll(opt.stagingDir, 0o755)
+	meta := models.SessionMeta{
+		Model:       opt.model,
+		SessionID:   opt.sessionID,
+		OutZip:      opt.outZip,
+		StagingRoot: opt.stagingDir,
+		Registry:    opt.registry,
+		Platform:    opt.platform,
+		Concurrency: opt.concurrency,
+		Retries:     opt.retries,
+		State:       models.StateScheduled,
+		StartAt:     at,
+	}
+	if prev, err := models.LoadSessionMeta(opt.stagingDir); err == nil {
+		meta.StartedAt = prev.StartedAt
+		meta.Transfer = prev.Transfer
+		meta.BytesDone, meta.TotalBytes, meta.Blobs = prev.BytesDone, prev.TotalBytes, prev.Blobs
+	}
+	msg := fmt.Sprintf("دانلود در %s شروع می‌شود.", at.Local().Format("2006-01-02 15:04"))
+	meta.Message = msg
+	_ = models.SaveSessionMeta(meta)
+	s.arm(opt.sessionID, at)
+	s.log.Info("download scheduled", "model", opt.model, "session", opt.sessionID, "at", at)
+	return msg
+}
+
+// scheduleRetry is how long a scheduled download that found -max-sessions
+// downloads running waits before trying again.
+const scheduleRetry = time.Minute
+
+// arm starts the scheduled session id at at, replacing an earlier timer.
+func (s *server) arm(id string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scheduled == nil {
+		s.scheduled = map[string]*time.Timer{}
+	}
+	if old := s.scheduled[id]; old != nil {
+		old.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(at), func() {
+		s.mu.Lock()
+		current := s.scheduled[id] == timer
+		if current {
+			delete(s.scheduled, id)
+		}
+		s.mu.Unlock()
+		if current {
+			s.startScheduled(id)
+		}
+	})
+	s.scheduled[id] = timer
+}
+
+// startScheduled begins the scheduled session id, trying again later while
+// too many downloads are running.
+func (s *server) startScheduled(id string) {
+	staging := filepath.Join(s.downloadsDir, id+".staging")
+	meta, err := models.LoadSessionMeta(staging)
+	if err != nil || meta.State != models.StateScheduled {
+		return
+	}
+	const msg = "دانلود زمان‌بندی شده شروع شد."
+	switch err := s.begin(s.resumeOptions(meta, staging), msg); err {
+	case nil, errSessionRunning:
+	case errTooManySessions:
+		s.arm(id, time.Now().Add(scheduleRetry))
+	default:
+		s.log.Warn("could not start scheduled download", "session", id, "err", err)
+	}
+}
+
+// unschedule cancels the timer of a scheduled session and reports whether
+// there was one.
+func (s *server) unschedule(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timer := s.scheduled[id]
+	if timer == nil {
+		return false
+	}
+	timer.Stop()
+	delete(s.scheduled, id)
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+func TestParseStartAt(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.Local)
+	for value, want := range map[string]time.Time{
+		"":                     {},
+		"01:00":                time.Date(2024, 1, 3, 1, 0, 0, 0, time.Local),
+		"23:30":                time.Date(2024, 1, 2, 23, 30, 0, 0, time.Local),
+		"10:00":                time.Date(2024, 1, 3, 10, 0, 0, 0, time.Local),
+		"2024-05-01 02:00":     time.Date(2024, 5, 1, 2, 0, 0, 0, time.Local),
+		"2024-05-01T02:00:00Z": time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC),
+	} {
+		got, err := parseStartAt(value, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseStartAt(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"25:00", "1am", "tomorrow"} {
+		if _, err := parseStartAt(value, now); err == nil {
+			t.Errorf("parseStartAt(%q) should fail", value)
+		}
+	}
+}
+
+func TestScheduledDownload(t *testing.T) {
+	_, srv, _ := testModel(t, 32<<10)
+	base := testOptions(t, srv.URL)
+	base.outZip, base.defaultZip = "", false
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := newServer(base, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, apiPrefix+"/downloads",
+		strings.NewReader(`{"model": "test/m:latest", "startAt": "`+at+`"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST downloads = %d %s", rec.Code, rec.Body)
+	}
+	staging := filepath.Join(base.outputDir, "test-m-latest.staging")
+	meta, err := models.LoadSessionMeta(staging)
+	if err != nil || meta.State != models.StateScheduled || meta.StartAt.Format(time.RFC3339) != at {
+		t.Fatalf("meta = %+v, %v", meta, err)
+	}
+	if s.session("test-m-latest") != nil {
+		t.Fatal("a scheduled download should not run yet")
+	}
+
+	// A restarted server picks the schedule up from session.json; starting
+	// it early runs the download.
+	s.unschedule("test-m-latest")
+	s, err = newServer(base, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.recoverInterrupted()
+	if !s.unschedule("test-m-latest") {
+		t.Fatal("schedule not restored")
+	}
+	s.arm("test-m-latest", time.Now())
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		meta, _ = models.LoadSessionMeta(staging)
+		if meta.State == models.StateCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scheduled download did not complete: %+v", meta)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Canceling a scheduled download needs no running session.
+	s.arm("test-m-latest", time.Now().Add(time.Hour))
+	setSessionStatus(staging, models.StateScheduled, "")
+	if !s.stop("test-m-latest", false) {
+		t.Fatal("stop did not find the scheduled download")
+	}
+	if meta, _ := models.LoadSessionMeta(staging); meta.State != models.StateCanceled {
+		t.Errorf("state after cancel = %s", meta.State)
+	}
+}
@@ -0,0 +1,813 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ollama-model-downloader/models"
+	"ollama-model-downloader/web"
+)
+
+// activeSession is the in-memory handle of a download started from the web
+// UI. It is owned by the server and looked up by session ID.
+type activeSession struct {
+	id         string
+	stagingDir string
+	outZip     string
+	progress   *progress
+	console    *console
+	cancel     context.CancelFunc
+	done       chan struct{} // closed once run has returned and its outcome is recorded
+	pause      atomic.Bool
+}
+
+// server holds all state of the web UI. Every download gets its own
+// activeSession so concurrent requests never share progress or cancel
+// handles.
+type server struct {
+	base         options
+	downloadsDir string
+	tmpl         *template.Template
+	static       *staticAssets
+	log          *slog.Logger
+
+	mu        sync.Mutex
+	sessions  map[string]*activeSession
+	scheduled map[string]*time.Timer // by session ID; see schedule
+	message   string
+	lastZip   string
+
+	jobs     batchJobs
+	trending trendingFeed
+	checks   archiveChecks
+}
+
+func newServer(base options, logger *slog.Logger) (*server, error) {
+	static, err := newStaticAssets()
+	if err != nil {
+		return nil, err
+	}
+	funcMap := template.FuncMap{
+		"static":   static.url,
+		"contains": strings.Contains,
+		"join":     strings.Join,
+		"bytes":    humanBytes,
+		"seconds": func(sec float64) string {
+			return time.Duration(sec * float64(time.Second)).Round(time.Second).String()
+		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+	}
+	embedded, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := web.ParseTemplates(embedded, base.templatesDir, funcMap)
+	if err != nil {
+		return nil, fmt.Errorf("parse templates: %w", err)
+	}
+	// A base model belongs to one adapter download, not to every model
+	// requested from the browser.
+	base.baseModel, base.fetchBase = "", false
+	base.archiveStreams = newArchiveStreams()
+	downloadsDir := base.outputDir
+	if downloadsDir == "" {
+		downloadsDir = "downloaded-models"
+	}
+	if err := os.MkdirAll(downloadsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create downloads directory: %w", err)
+	}
+	return &server{
+		base:         base,
+		downloadsDir: downloadsDir,
+		tmpl:         tmpl,
+		static:       static,
+		log:          logger,
+		sessions:     make(map[string]*activeSession),
+		checks:       archiveChecks{spot: base.archiveSpotCheck},
+	}, nil
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/download", s.handleDownload)
+	mux.HandleFunc("/model/action", s.handleModelAction)
+	mux.HandleFunc("/resume", s.handleResume)
+	mux.HandleFunc("/download/", s.handleFileDownload)
+	mux.HandleFunc("/progress", s.handleProgress)
+	mux.HandleFunc("/console", s.handleConsole)
+	mux.HandleFunc("/session", s.handleSessionPage)
+	mux.HandleFunc("/session/delete", s.handleSessionDelete)
+	mux.HandleFunc("/cancel", s.handleCancel)
+	mux.HandleFunc("/pause", s.handlePause)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.Handle("/static/", s.static)
+	s.registerAPI(mux)
+	return mux
+}
+
+// handler is routes() with request logging, body size caps and per-client
+// rate limiting. Polling endpoints are left out of the log so it stays
+// readable.
+func (s *server) handler() http.Handler {
+	var limiter *clientLimiter
+	if s.base.rateLimit > 0 {
+		limiter = newClientLimiter(s.base.rateLimit, int(math.Max(1, 4*s.base.rateLimit)))
+	}
+	mux := limitRequests(limiter, s.routes())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/progress" && r.URL.Path != apiPrefix+"/progress" && r.URL.Path != "/healthz" {
+			s.log.Info("request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// handleHealthz is the liveness probe for containers and service managers.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	active := len(s.sessions)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "activeSessions": active})
+}
+
+func (s *server) setMessage(msg string) {
+	s.mu.Lock()
+	s.message = msg
+	s.mu.Unlock()
+}
+
+// session returns the active session with the given ID. An empty ID selects
+// the only active session, which keeps single-download clients working.
+func (s *server) session(id string) *activeSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id != "" {
+		return s.sessions[id]
+	}
+	if len(s.sessions) == 1 {
+		for _, a := range s.sessions {
+			return a
+		}
+	}
+	return nil
+}
+
+// begin starts run() for opt in the background and registers it as an
+// active session. It returns false without starting anything when the
+// session is already running, so two submissions never write into the same
+// staging directory, or when -max-sessions downloads are already running.
+func (s *server) begin(opt options, startMessage string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	active := &activeSession{
+		id:         opt.sessionID,
+		stagingDir: opt.stagingDir,
+		outZip:     opt.outZip,
+		progress:   newProgress(0),
+		console:    newConsole(),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	opt.progress = active.progress
+	ctx = withConsole(ctx, active.console)
+
+	s.mu.Lock()
+	if _, running := s.sessions[active.id]; running {
+		s.mu.Unlock()
+		cancel()
+		return errSessionRunning
+	}
+	if s.base.maxSessions > 0 && len(s.sessions) >= s.base.maxSessions {
+		s.mu.Unlock()
+		cancel()
+		return errTooManySessions
+	}
+	if _, live := sessionLockOwner(opt.stagingDir); live {
+		// Another process (the CLI or a second server) is downloading it.
+		s.mu.Unlock()
+		cancel()
+		return errSessionRunning
+	}
+	s.sessions[active.id] = active
+	if timer := s.scheduled[active.id]; timer != nil {
+		// Started early by hand.
+		timer.Stop()
+		delete(s.scheduled, active.id)
+	}
+	s.lastZip = opt.outZip
+	s.message = startMessage
+	s.mu.Unlock()
+
+	// Create session metadata immediately so it appears in the UI
+	_ = os.MkdirAll(opt.stagingDir, 0o755)
+	meta := models.SessionMeta{
+		Model:       opt.model,
+		SessionID:   opt.sessionID,
+		OutZip:      opt.outZip,
+		StagingRoot: opt.stagingDir,
+		Registry:    opt.registry,
+		Platform:    opt.platform,
+		Concurrency: opt.concurrency,
+		Retries:     opt.retries,
+		StartedAt:   time.Now(),
+		LastUpdated: time.Now(),
+		State:       models.StateDownloading,
+		Message:     "در حال شروع دانلود...",
+	}
+	if prev, err := models.LoadSessionMeta(opt.stagingDir); err == nil {
+		if !prev.StartedAt.IsZero() {
+			meta.StartedAt = prev.StartedAt
+		}
+		meta.Transfer = prev.Transfer
+	}
+	_ = models.SaveSessionMeta(meta)
+	s.log.Info("download started", "model", opt.model, "session", opt.sessionID)
+
+	go func() {
+		defer close(active.done)
+		err := run(ctx, opt)
+		if err != nil {
+			logf(ctx, false, "error: %v\n", err)
+		}
+		active.console.close()
+		cancel()
+		s.mu.Lock()
+		if s.sessions[active.id] == active {
+			delete(s.sessions, active.id)
+		}
+		s.mu.Unlock()
+
+		var msg string
+		if err != nil {
+			if errors.Is(err, errSessionLocked) {
+				msg = beginMessage(errSessionRunning, opt.model, "")
+			} else if err == context.Canceled {
+				if active.pause.Load() {
+					setSessionStatus(opt.stagingDir, models.StatePaused, "مکث شد")
+					msg = "دانلود متوقف شد."
+				} else {
+					setSessionStatus(opt.stagingDir, models.StateCanceled, "لغو شد")
+					msg = "دانلود لغو شد."
+				}
+			} else {
+				setSessionError(opt.stagingDir, err)
+				notifySession(opt, err)
+				msg = fmt.Sprintf("دانلود ناموفق: %s", err.Error())
+			}
+			s.log.Warn("download stopped", "model", opt.model, "session", opt.sessionID, "err", err)
+		} else {
+			msg = "دانلود کامل شد."
+			notifySession(opt, nil)
+			zip := archivePath(opt)
+			s.mu.Lock()
+			s.lastZip = zip
+			s.mu.Unlock()
+			s.log.Info("download completed", "model", opt.model, "session", opt.sessionID, "zip", zip)
+		}
+		s.setMessage(msg)
+	}()
+	return nil
+}
+
+var (
+	errSessionRunning  = errors.New("session already running")
+	errTooManySessions = errors.New("too many active downloads")
+)
+
+// beginMessage is the user-facing message for the outcome of begin.
+func beginMessage(err error, model, started string) string {
+	switch err {
+	case nil:
+		return started
+	case errTooManySessions:
+		return "تعداد دانلودهای همزمان به حداکثر رسیده است. بعداً دوباره تلاش کنید."
+	default:
+		return fmt.Sprintf("%s در حال دانلود است.", model)
+	}
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	data := PageData{Message: s.message}
+	lastZip := s.lastZip
+	s.mu.Unlock()
+	if lastZip != "" {
+		if _, err := os.Stat(lastZip); err == nil {
+			data.ZipPath = lastZip
+		}
+	}
+	// List downloaded models
+	data.Downloads = models.DownloadsFromDir(s.downloadsDir)
+	s.checks.annotate(data.Downloads)
+	if u, err := outputUsage(s.base, s.downloadsDir); err == nil {
+		data.Usage = humanBytes(u.UsedBytes)
+		if u.QuotaBytes > 0 {
+			data.Usage += " / " + humanBytes(u.QuotaBytes)
+		}
+	}
+	if sessions, err := models.DiscoverPartialSessions(s.downloadsDir); err == nil {
+		running, paused, errored, completed := models.CategorizeSessions(sessions)
+		data.RunningSession = running
+		data.PausedSessions = paused
+		data.ErroredSessions = errored
+		data.CompletedSessions = completed
+	}
+	s.tmpl.Execute(w, data)
+}
+
+func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	concurrency, _ := strconv.Atoi(r.FormValue("concurrency"))
+	retries, _ := strconv.Atoi(r.FormValue("retries"))
+	startAt, err := parseStartAt(r.FormValue("start_at"), time.Now())
+	if err != nil {
+		s.setMessage("زمان شروع نامعتبر است.")
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	_, msg := s.submit(r.FormValue("model"), concurrency, retries, startAt)
+	s.setMessage(msg)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// submit starts a download of model and returns its session ID and a
+// message for the user. The same model maps to the same session ID, so a
+// running download is attached to and an unfinished one is resumed instead
+// of starting a second writer into the same staging directory. A startAt
+// in the future schedules the download instead of starting it.
+func (s *server) submit(model string, concurrency, retries int, startAt time.Time) (string, string) {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	if retries < 0 {
+		retries = 3
+	}
+	opt := s.base
+	opt.outputDir = s.downloadsDir
+	opt.outZip = ""
+	opt.concurrency = concurrency
+	opt.retries = retries
+	opt = withModel(opt, model)
+
+	if s.session(opt.sessionID) != nil {
+		return opt.sessionID, beginMessage(errSessionRunning, model, "")
+	}
+	msg := "در حال دانلود..."
+	if meta, err := models.LoadSessionMeta(opt.stagingDir); err == nil && meta.State != models.StateCompleted {
+		opt = s.resumeOptions(meta, opt.stagingDir)
+		msg = "دانلود ناتمام قبلی ادامه یافت."
+	}
+	if startAt.After(time.Now()) {
+		return opt.sessionID, s.schedule(opt, startAt)
+	}
+	return opt.sessionID, beginMessage(s.begin(opt, msg), model, msg)
+}
+
+func (s *server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	sessionID := r.FormValue("session")
+	if sessionID == "" {
+		http.Error(w, "Missing session ID", http.StatusBadRequest)
+		return
+	}
+	if !validSessionID(sessionID) {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	msg, err := s.resume(sessionID)
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	s.setMessage(msg)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// validSessionID reports whether id can name a session's staging directory:
+// a single path element other than . and ..
+func validSessionID(id string) bool {
+	return id != "" && id == filepath.Base(id) && id != "." && id != ".."
+}
+
+// resume restarts a stored session. It fails only when the session has no
+// metadata on disk.
+func (s *server) resume(sessionID string) (string, error) {
+	staging := filepath.Join(s.downloadsDir, sessionID+".staging")
+	meta, err := models.LoadSessionMeta(staging)
+	if err != nil {
+		return "", err
+	}
+	if s.session(sessionID) != nil {
+		return "این دانلود در حال اجراست.", nil
+	}
+	opt := s.resumeOptions(meta, staging)
+
+	const msg = "در حال ادامه دانلود..."
+	switch err := s.begin(opt, msg); err {
+	case nil:
+		return msg, nil
+	case errSessionRunning:
+		return "این دانلود در حال اجراست.", nil
+	default:
+		return beginMessage(err, meta.Model, msg), nil
+	}
+}
+
+// resumeOptions rebuilds download options for an existing session from its
+// stored metadata, falling back to the server defaults.
+func (s *server) resumeOptions(meta models.SessionMeta, staging string) options {
+	return sessionOptions(s.base, s.downloadsDir, meta, staging)
+}
+
+func (s *server) handleFileDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filename := strings.TrimPrefix(r.URL.Path, "/download/")
+	if filename == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err := s.checks.corrupt(filename); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.ServeFile(w, r, filename)
+}
+
+func (s *server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.progressOf(r.URL.Query().Get("session")))
+}
+
+// handleConsole streams the console of a running session over a
+// WebSocket, one JSON consoleLine per message, starting with the recent
+// backlog. The socket closes when the session stops.
+func (s *server) handleConsole(w http.ResponseWriter, r *http.Request) {
+	active := s.session(r.URL.Query().Get("session"))
+	if active == nil {
+		http.Error(w, "session not running", http.StatusNotFound)
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	switch {
+	case errors.Is(err, errNotWebSocket):
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return
+	case errors.Is(err, errCrossOrigin):
+		http.Error(w, "cross-origin console requests are not allowed", http.StatusForbidden)
+		return
+	case err != nil:
+		s.log.Warn("console", "err", err)
+		return
+	}
+	defer conn.Close()
+	backlog, lines, stop := active.console.watch()
+	defer stop()
+	send := func(l consoleLine) bool {
+		b, _ := json.Marshal(l)
+		return conn.WriteText(b) == nil
+	}
+	for _, l := range backlog {
+		if !send(l) {
+			return
+		}
+	}
+	for {
+		select {
+		case l, ok := <-lines:
+			if !ok || !send(l) {
+				return
+			}
+		case <-conn.Done():
+			return
+		}
+	}
+}
+
+func (s *server) progressOf(sessionID string) ProgressData {
+	data := ProgressData{}
+	if active := s.session(sessionID); active != nil {
+		data.Done = atomic.LoadInt64(&active.progress.done)
+		data.Total = atomic.LoadInt64(&active.progress.total)
+		if data.Total > 0 {
+			data.Percent = int((data.Done * 100) / data.Total)
+		}
+	} else if sessionID != "" {
+		// Paused and stalled sessions report what their metadata recorded.
+		if meta, err := models.LoadSessionMeta(filepath.Join(s.downloadsDir, sessionID+".staging")); err == nil {
+			data.Done, data.Total, data.Percent = meta.BytesDone, meta.TotalBytes, meta.Percent()
+		}
+	}
+	return data
+}
+
+func (s *server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.stop(r.FormValue("session"), false)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (s *server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.stop(r.FormValue("session"), true)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// stop pauses or cancels the active session and reports whether there was
+// one to stop.
+func (s *server) stop(sessionID string, pause bool) bool {
+	active := s.session(sessionID)
+	if active == nil {
+		if sessionID == "" || !s.unschedule(sessionID) {
+			return false
+		}
+		// A scheduled download has nothing running yet.
+		staging := filepath.Join(s.downloadsDir, sessionID+".staging")
+		if pause {
+			setSessionStatus(staging, models.StatePaused, "مکث شد")
+		} else {
+			setSessionStatus(staging, models.StateCanceled, "لغو شد")
+		}
+		return true
+	}
+	active.pause.Store(pause)
+	if pause {
+		setSessionStatus(active.stagingDir, models.StatePaused, "مکث شد")
+	} else {
+		setSessionStatus(active.stagingDir, models.StateCanceled, "لغو شد")
+	}
+	active.cancel()
+	return true
+}
+
+func (s *server) handleModelAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	action := r.FormValue("action")
+	if name == "" || action == "" {
+		http.Error(w, "Missing parameters", http.StatusBadRequest)
+		return
+	}
+	var msg string
+	var err error
+	switch action {
+	case "rename":
+		msg, err = s.renameArchive(name, r.FormValue("new_name"))
+	case "annotate":
+		msg, err = s.annotateArchive(name, splitLabels(r.FormValue("labels")), r.FormValue("notes"))
+	default:
+		msg, err = s.modelAction(name, action)
+	}
+	if err != nil {
+		s.setMessage(fmt.Sprintf("خطا: %s", err))
+	} else if msg != "" {
+		s.setMessage(msg)
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// modelAction applies action (delete, verify, unzip, open-folder) to the
+// archive name in the downloads directory and returns a message for the
+// user.
+func (s *server) modelAction(name, action string) (string, error) {
+	target, err := s.archivePath(name)
+	if err != nil {
+		return "", err
+	}
+	var msg string
+	switch action {
+	case "delete":
+		err = os.Remove(target)
+		if err == nil {
+			for _, sidecar := range archiveSidecars {
+				_ = os.Remove(target + sidecar)
+			}
+			staging := filepath.Join(s.downloadsDir, strings.TrimSuffix(name, ".zip")+".staging")
+			_ = os.RemoveAll(staging)
+			msg = fmt.Sprintf("%s حذف شد.", name)
+		}
+	case "open-folder":
+		err = openExplorer(s.downloadsDir)
+		if err == nil {
+			msg = "پوشه دانلود باز شد."
+		}
+	case "unzip":
+		if err = s.checks.corrupt(target); err != nil {
+			break
+		}
+		dest, derr := ollamaModelsDir()
+		if derr != nil {
+			err = derr
+			break
+		}
+		err = unzipToDir(target, dest)
+		if err == nil {
+			msg = fmt.Sprintf("%s به %s استخراج شد.", name, dest)
+		}
+	case "verify":
+		err = verifyArchive(target)
+		s.checks.verified(target, err)
+		if err == nil {
+			msg = fmt.Sprintf("%s سالم است.", name)
+		}
+	default:
+		err = fmt.Errorf("عمل نامعتبر: %s", action)
+	}
+	return msg, err
+}
+
+// archivePath resolves name inside the downloads directory, rejecting
+// anything that is not a plain file name.
+func (s *server) archivePath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("نام نامعتبر: %s", name)
+	}
+	return filepath.Join(s.downloadsDir, name), nil
+}
+
+// archiveSidecars are the files that travel with an archive: its checksum,
+// detached signatures and the user's labels and notes.
+var archiveSidecars = []string{checksumSuffix, ".asc", checksumSuffix + ".asc", models.ArchiveInfoSuffix}
+
+// renameArchive renames the archive name to newName (".zip" is added when
+// missing) together with its sidecars.
+func (s *server) renameArchive(name, newName string) (string, error) {
+	newName = zipFileName(newName)
+	from, err := s.archivePath(name)
+	if err != nil {
+		return "", err
+	}
+	to, err := s.archivePath(newName)
+	if err != nil {
+		return "", err
+	}
+	if from == to {
+		return "", nil
+	}
+	if _, err := os.Stat(to); err == nil {
+		return "", fmt.Errorf("%s از قبل وجود دارد", newName)
+	}
+	if err := moveArchive(from, to); err != nil {
+		return "", err
+	}
+	// Keep a completed session pointing at the archive it produced.
+	staging := filepath.Join(s.downloadsDir, strings.TrimSuffix(name, ".zip")+".staging")
+	if meta, err := models.LoadSessionMeta(staging); err == nil && filepath.Base(meta.OutZip) == name {
+		meta.OutZip = to
+		_ = models.SaveSessionMeta(meta)
+	}
+	return fmt.Sprintf("%s به %s تغییر نام یافت.", name, newName), nil
+}
+
+// moveArchive renames the archive from to to together with its sidecars.
+// The checksum file is rewritten for the new name, which invalidates its
+// signature, so that is removed.
+func moveArchive(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	for _, sidecar := range archiveSidecars {
+		if err := os.Rename(from+sidecar, to+sidecar); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if data, err := os.ReadFile(to + checksumSuffix); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			if _, err := writeChecksumSidecar(to, fields[0]); err != nil {
+				return err
+			}
+			_ = os.Remove(to + checksumSuffix + ".asc")
+		}
+	}
+	return nil
+}
+
+// zipFileName trims name and adds ".zip" when it is missing.
+func zipFileName(name string) string {
+	name = strings.TrimSpace(name)
+	if name != "" && !strings.HasSuffix(strings.ToLower(name), ".zip") {
+		name += ".zip"
+	}
+	return name
+}
+
+// annotateArchive replaces the labels and notes stored for the archive name.
+func (s *server) annotateArchive(name string, labels []string, notes string) (string, error) {
+	target, err := s.archivePath(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(target); err != nil {
+		return "", err
+	}
+	if err := models.SaveArchiveInfo(target, models.ArchiveInfo{Labels: labels, Notes: notes}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("یادداشت %s ذخیره شد.", name), nil
+}
+
+// splitLabels parses the comma-separated labels field of the UI form.
+func splitLabels(s string) []string {
+	var labels []string
+	for _, l := range strings.Split(s, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// recoverInterrupted handles sessions that a previous process left in an
+// active state: nothing is running yet when the server starts, so whoever
+// was writing into them died. They are marked interrupted and, with
+// -on-interrupted=resume, started again.
+func (s *server) recoverInterrupted() {
+	metas, err := models.DiscoverPartialSessions(s.downloadsDir)
+	if err != nil {
+		s.log.Warn("could not scan sessions", "err", err)
+		return
+	}
+	for _, meta := range metas {
+		if meta.State == models.StateScheduled {
+			s.arm(meta.SessionID, meta.StartAt)
+			continue
+		}
+		staging := filepath.Join(s.downloadsDir, meta.SessionID+".staging")
+		if _, live := sessionLockOwner(staging); !meta.State.IsActive() || live {
+			continue
+		}
+		msg := "دانلود به‌دلیل توقف برنامه قطع شد"
+		if meta.State == models.StatePackaging {
+			msg = "ساخت فایل zip به‌دلیل توقف برنامه قطع شد؛ ادامه فقط فایل zip را دوباره می‌سازد"
+		}
+		setSessionStatus(staging, models.StateInterrupted, msg)
+		s.log.Warn("found interrupted download", "model", meta.Model, "session", meta.SessionID, "state", meta.State)
+		if s.base.onInterrupted != "resume" {
+			continue
+		}
+		msg, err := s.resume(meta.SessionID)
+		if err != nil {
+			s.log.Warn("could not resume interrupted download", "session", meta.SessionID, "err", err)
+			continue
+		}
+		s.setMessage(msg)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "serve-registry",
+		usage: "serve downloaded archives and staging directories as a read-only registry",
+		run:   runServeRegistry,
+	})
+}
+
+const defaultManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+// localManifest is a manifest found in an archive or models directory.
+type localManifest struct {
+	raw       []byte
+	mediaType string
+	digest    string
+}
+
+// localBlob is where a blob is stored: an entry of an open archive or a
+// file on disk.
+type localBlob struct {
+	entry *zip.File
+	path  string
+	size  int64
+}
+
+// localRegistry indexes the manifests and blobs below a directory. The
+// registry host a model was downloaded from is dropped: a model is served
+// under its repository alone, e.g. library/llama3.
+type localRegistry struct {
+	manifests map[string]map[string]localManifest // repository -> tag or digest
+	blobs     map[string]localBlob
+	archives  []*zip.ReadCloser
+}
+
+func runServeRegistry(opt options, args []string) error {
+	flags := flag.NewFlagSet("serve-registry", flag.ContinueOnError)
+	addr := flags.String("addr", ":5000", "address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	root := opt.outputDir
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	reg, err := openLocalRegistry(root)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	if len(reg.manifests) == 0 {
+		return fmt.Errorf("no models found in %s", root)
+	}
+	for _, repo := range reg.repositories() {
+		fmt.Printf("%s: %s\n", repo, strings.Join(reg.tags(repo), ", "))
+	}
+	fmt.Printf("serving %d repositories and %d blobs on %s\n", len(reg.manifests), len(reg.blobs), *addr)
+	return http.ListenAndServe(*addr, reg)
+}
+
+// openLocalRegistry scans root for zip archives and models directories (a
+// directory holding manifests/ and blobs/, such as <session>.staging/models).
+func openLocalRegistry(root string) (*localRegistry, error) {
+	reg := &localRegistry{
+		manifests: map[string]map[string]localManifest{},
+		blobs:     map[string]localBlob{},
+	}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if isModelsDir(p) {
+				if err := reg.addModelsDir(p); err != nil {
+					return fmt.Errorf("%s: %w", p, err)
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(p), ".zip") {
+			if err := reg.addArchive(p); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: skipping %s: %v\n", p, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		reg.Close()
+		return nil, err
+	}
+	return reg, nil
+}
+
+func isModelsDir(dir string) bool {
+	for _, sub := range []string{"manifests", "blobs"} {
+		if st, err := os.Stat(filepath.Join(dir, sub)); err != nil || !st.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+func (reg *localRegistry) Close() {
+	for _, a := range reg.archives {
+		a.Close()
+	}
+	reg.archives = nil
+}
+
+func (reg *localRegistry) addArchive(archive string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	reg.archives = append(reg.archives, zr)
+	for _, f := range zr.File {
+		// Installer bundles keep the models layout under models/.
+		name := strings.TrimPrefix(f.Name, "models/")
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(name, "manifests/"); ok {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			raw, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			reg.addManifest(rest, raw)
+		} else if rest, ok := strings.CutPrefix(name, "blobs/"); ok {
+			digest, ok := digestFromBlobName(rest)
+			// Keep a copy that can answer Range requests over a deflated one.
+			if prev, seen := reg.blobs[digest]; ok && (!seen || !prev.seekable()) {
+				reg.blobs[digest] = localBlob{entry: f, size: int64(f.UncompressedSize64)}
+			}
+		}
+	}
+	return nil
+}
+
+func (b localBlob) seekable() bool {
+	return b.entry == nil || b.entry.Method == zip.Store
+}
+
+func (reg *localRegistry) addModelsDir(dir string) error {
+	manifests := filepath.Join(dir, "manifests")
+	err := filepath.WalkDir(manifests, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(manifests, p)
+		if err != nil {
+			return err
+		}
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		reg.addManifest(filepath.ToSlash(rel), raw)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs"))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		digest, ok := digestFromBlobName(e.Name())
+		if !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		reg.blobs[digest] = localBlob{path: filepath.Join(dir, "blobs", e.Name()), size: info.Size()}
+	}
+	return nil
+}
+
+// addManifest registers raw, stored at <host>/<repository>/<tail> below
+// manifests/, under its tag (or digest) and its own digest.
+func (reg *localRegistry) addManifest(rel string, raw []byte) {
+	parts := strings.Split(rel, "/")
+	if len(parts) < 3 {
+		return
+	}
+	repo := strings.Join(parts[1:len(parts)-1], "/")
+	ref := parts[len(parts)-1]
+	if hexhash, ok := strings.CutPrefix(ref, "sha256-"); ok {
+		ref = "sha256:" + hexhash
+	}
+	var head struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(raw, &head) != nil {
+		return
+	}
+	m := localManifest{raw: raw, mediaType: head.MediaType, digest: manifestDigest(raw)}
+	if m.mediaType == "" {
+		m.mediaType = defaultManifestMediaType
+	}
+	if reg.manifests[repo] == nil {
+		reg.manifests[repo] = map[string]localManifest{}
+	}
+	reg.manifests[repo][ref] = m
+	reg.manifests[repo][m.digest] = m
+}
+
+// digestFromBlobName turns a blobs/ file name (sha256-<hex>) into a digest.
+// Partial downloads and other files are ignored.
+func digestFromBlobName(name string) (string, bool) {
+	hexhash, ok := strings.CutPrefix(name, "sha256-")
+	if !ok || len(hexhash) != 64 || strings.Contains(hexhash, ".") {
+		return "", false
+	}
+	return "sha256:" + hexhash, true
+}
+
+func (reg *localRegistry) repositories() []string {
+	repos := make([]string, 0, len(reg.manifests))
+	for repo := range reg.manifests {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+// tags lists the tags of repo; digest-only references are left out.
+func (reg *localRegistry) tags(repo string) []string {
+	var tags []string
+	for ref := range reg.manifests[repo] {
+		if !strings.HasPrefix(ref, "sha256:") {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// ServeHTTP implements the read-only part of the registry API that pulls
+// use: /v2/, manifests, blobs, tags/list and _catalog.
+func (reg *localRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		registryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "this registry is read-only")
+		return
+	}
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	p := path.Clean(r.URL.Path)
+	switch {
+	case p == "/v2":
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+	case p == "/v2/_catalog":
+		writeRegistryJSON(w, map[string][]string{"repositories": reg.repositories()})
+	case strings.HasSuffix(p, "/tags/list"):
+		repo := strings.TrimSuffix(strings.TrimPrefix(p, "/v2/"), "/tags/list")
+		if reg.manifests[repo] == nil {
+			registryError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository not found")
+			return
+		}
+		writeRegistryJSON(w, map[string]interface{}{"name": repo, "tags": reg.tags(repo)})
+	case strings.Contains(p, "/manifests/"):
+		repo, ref, _ := strings.Cut(strings.TrimPrefix(p, "/v2/"), "/manifests/")
+		m, ok := reg.manifests[repo][ref]
+		if !ok {
+			registryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest not found")
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", m.digest)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.raw)))
+		if r.Method == http.MethodGet {
+			w.Write(m.raw)
+		}
+	case strings.Contains(p, "/blobs/"):
+		_, digest, _ := strings.Cut(strings.TrimPrefix(p, "/v2/"), "/blobs/")
+		b, ok := reg.blobs[digest]
+		if !ok {
+			registryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob not found")
+			return
+		}
+		reg.serveBlob(w, r, digest, b)
+	default:
+		registryError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+	}
+}
+
+// serveBlob answers Range requests for files and stored archive entries.
+// Deflated entries cannot seek, so they are always sent whole.
+func (reg *localRegistry) serveBlob(w http.ResponseWriter, r *http.Request, digest string, b localBlob) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	if b.entry == nil {
+		f, err := os.Open(b.path)
+		if err != nil {
+			registryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		defer f.Close()
+		var mod time.Time
+		if st, err := f.Stat(); err == nil {
+			mod = st.ModTime()
+		}
+		http.ServeContent(w, r, "", mod, f)
+		return
+	}
+	if b.entry.Method == zip.Store {
+		rc, err := b.entry.OpenRaw()
+		if ra, ok := rc.(io.ReaderAt); err == nil && ok {
+			http.ServeContent(w, r, "", b.entry.Modified, io.NewSectionReader(ra, 0, b.size))
+			return
+		}
+	}
+	rc, err := b.entry.Open()
+	if err != nil {
+		registryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Length", strconv.FormatInt(b.size, 10))
+	if r.Method == http.MethodGet {
+		io.Copy(w, rc)
+	}
+}
+
+func writeRegistryJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// registryError writes an error in the registry API's format.
+func registryError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
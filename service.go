@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// serviceName identifies the registered service, launchd agent or task.
+const serviceName = "ollama-model-downloader"
+
+func init() {
+	registerCommand(command{
+		name:  "service",
+		usage: "install|uninstall|start the web UI as a launchd agent (macOS) or a scheduled boot task, not a service (Windows)",
+		run:   runService,
+	})
+}
+
+func runService(opt options, args []string) error {
+	flags := flag.NewFlagSet("service", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	switch flags.Arg(0) {
+	case "install":
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if exe, err = filepath.EvalSymlinks(exe); err != nil {
+			return err
+		}
+		cmdline, err := serviceCommandLine(exe, opt)
+		if err != nil {
+			return err
+		}
+		logPath, err := installService(cmdline)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("installed %s (logs: %s)\n", serviceName, logPath)
+		return nil
+	case "uninstall":
+		return uninstallService()
+	case "start":
+		return startService()
+	default:
+		return errors.New("usage: service install|uninstall|start")
+	}
+}
+
+// serviceCommandLine is how the service runs this binary: the web UI on a
+// fixed port, without a browser, with absolute paths because services do
+// not start in the user's working directory.
+func serviceCommandLine(exe string, opt options) ([]string, error) {
+	port := opt.port
+	if port == 0 {
+		port = defaultWebPort
+	}
+	outputDir, err := filepath.Abs(opt.outputDir)
+	if err != nil {
+		return nil, err
+	}
+	cmdline := []string{exe, "-no-browser", "-port", strconv.Itoa(port), "-output-dir", outputDir, "-registry", opt.registry}
+	if opt.configPath != "" {
+		configPath, err := filepath.Abs(opt.configPath)
+		if err != nil {
+			return nil, err
+		}
+		cmdline = append(cmdline, "-config", configPath)
+	}
+	return cmdline, nil
+}
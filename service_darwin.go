@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const launchdLabel = "com." + serviceName
+
+func launchdPlistPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+}
+
+// installService writes a launchd agent that starts at login and is kept
+// alive, logging to ~/Library/Logs/ollama-model-downloader.
+func installService(cmdline []string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	logDir := filepath.Join(home, "Library", "Logs", serviceName)
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return "", err
+	}
+	logPath := filepath.Join(logDir, "service.log")
+	plist, err := launchdPlistPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(plist), 0o755); err != nil {
+		return "", err
+	}
+
+	var args bytes.Buffer
+	for _, a := range cmdline {
+		args.WriteString("\t\t<string>")
+		xml.EscapeText(&args, []byte(a))
+		args.WriteString("</string>\n")
+	}
+	var logEsc bytes.Buffer
+	xml.EscapeText(&logEsc, []byte(logPath))
+	content := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, launchdLabel, args.String(), logEsc.String(), logEsc.String())
+	if err := os.WriteFile(plist, []byte(content), 0o644); err != nil {
+		return "", err
+	}
+	if out, err := exec.Command("launchctl", "load", "-w", plist).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("launchctl load: %v: %s", err, out)
+	}
+	return logPath, nil
+}
+
+func uninstallService() error {
+	plist, err := launchdPlistPath()
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("launchctl", "unload", "-w", plist).CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: launchctl unload: %v: %s\n", err, out)
+	}
+	return os.Remove(plist)
+}
+
+func startService() error {
+	if out, err := exec.Command("launchctl", "start", launchdLabel).CombinedOutput(); err != nil {
+		return fmt.Errorf("launchctl start: %v: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errServiceUnsupported = errors.New("service management is only built in on macOS and Windows; use your init system (e.g. a systemd unit running this binary with -no-browser)")
+
+func installService(cmdline []string) (string, error) {
+	return "", fmt.Errorf("%w\ncommand line: %s", errServiceUnsupported, strings.Join(cmdline, " "))
+}
+
+func uninstallService() error { return errServiceUnsupported }
+
+func startService() error { return errServiceUnsupported }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// installService registers a scheduled task that runs at boot as SYSTEM.
+// It is a task, not a Windows service: a real service would have to speak
+// the service control manager protocol, which needs golang.org/x/sys; a
+// boot task gives the same always-available behaviour without it. The
+// command line goes into a .cmd wrapper, since /TR takes at most 261
+// characters and quotes nested inside cmd /c "..." do not survive it.
+// Requires an elevated prompt.
+func installService(cmdline []string) (string, error) {
+	logDir := filepath.Join(serviceDir(), "logs")
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return "", err
+	}
+	logPath := filepath.Join(logDir, "service.log")
+
+	quoted := make([]string, len(cmdline))
+	for i, a := range cmdline {
+		// %% keeps cmd from expanding variables in a batch file
+		quoted[i] = `"` + strings.ReplaceAll(a, "%", "%%") + `"`
+	}
+	script := fmt.Sprintf("@echo off\r\n%s >> \"%s\" 2>&1\r\n", strings.Join(quoted, " "), strings.ReplaceAll(logPath, "%", "%%"))
+	wrapper := serviceWrapperPath()
+	if err := os.WriteFile(wrapper, []byte(script), 0o644); err != nil {
+		return "", err
+	}
+	out, err := exec.Command("schtasks", "/Create", "/F", "/TN", serviceName, "/SC", "ONSTART", "/RU", "SYSTEM", "/RL", "HIGHEST", "/TR", `"`+wrapper+`"`).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("schtasks /Create: %v: %s", err, out)
+	}
+	return logPath, nil
+}
+
+// serviceDir is %ProgramData%\ollama-model-downloader, which holds the
+// task's wrapper script and logs.
+func serviceDir() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	return filepath.Join(programData, serviceName)
+}
+
+func serviceWrapperPath() string {
+	return filepath.Join(serviceDir(), "service.cmd")
+}
+
+func uninstallService() error {
+	if out, err := exec.Command("schtasks", "/Delete", "/F", "/TN", serviceName).CombinedOutput(); err != nil {
+		return fmt.Errorf("schtasks /Delete: %v: %s", err, out)
+	}
+	if err := os.Remove(serviceWrapperPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func startService() error {
+	if out, err := exec.Command("schtasks", "/Run", "/TN", serviceName).CombinedOutput(); err != nil {
+		return fmt.Errorf("schtasks /Run: %v: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/models"
+)
+
+// sessionDetail loads a stored session for /api/v1/sessions/<id> and the
+// /session page. A session running here reports its live byte count, which
+// session.json only catches up with at the next checkpoint.
+func (s *server) sessionDetail(id string) (models.SessionDetail, *apperrors.AppError) {
+	if id == "" || id != filepath.Base(id) {
+		return models.SessionDetail{}, apperrors.NotFound("session not found", nil)
+	}
+	meta, err := models.LoadSessionMeta(filepath.Join(s.downloadsDir, id+".staging"))
+	if err != nil {
+		return models.SessionDetail{}, apperrors.NotFound("session not found", err)
+	}
+	s.mu.Lock()
+	active := s.sessions[id]
+	s.mu.Unlock()
+	if active != nil {
+		if done := atomic.LoadInt64(&active.progress.done); done > meta.BytesDone {
+			meta.BytesDone = done
+		}
+	}
+	d := models.SessionDetailFromMeta(meta)
+	d.Running = active != nil
+	return d, nil
+}
+
+// handleSessionDetail serves GET (the detail) and DELETE (discard) on
+// /api/v1/sessions/<id>.
+func (s *server) handleSessionDetail(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, apiPrefix+"/sessions/")
+	var body interface{}
+	switch r.Method {
+	case http.MethodGet:
+		d, appErr := s.sessionDetail(id)
+		if appErr != nil {
+			appErr.WriteHTTPResponse(w)
+			return
+		}
+		body = d
+	case http.MethodDelete:
+		if err := s.discard(id); err != nil {
+			discardError(err).WriteHTTPResponse(w)
+			return
+		}
+		body = sessionResponse{SessionID: id, Message: "deleted"}
+	default:
+		apperrors.New(http.StatusMethodNotAllowed, "method not allowed", nil).WriteHTTPResponse(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleSessionDelete is the UI form for discard.
+func (s *server) handleSessionDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.discard(r.FormValue("session")); err != nil {
+		s.setMessage(fmt.Sprintf("حذف نشست ناموفق: %s", discardError(err).Message))
+	} else {
+		s.setMessage("نشست و فایل‌های موقت آن حذف شد.")
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+var errSessionStuck = errors.New("session did not stop")
+
+// discard cancels a session if it runs here and removes its staging
+// directory: the blobs fetched so far, the speed log and session.json. A
+// packaged archive is left alone; deleting it is a library action.
+func (s *server) discard(id string) error {
+	if id == "" || id != filepath.Base(id) {
+		return os.ErrNotExist
+	}
+	staging := filepath.Join(s.downloadsDir, id+".staging")
+	if _, err := os.Stat(staging); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	active := s.sessions[id]
+	s.mu.Unlock()
+	if active != nil {
+		active.cancel()
+		select {
+		case <-active.done:
+		case <-time.After(30 * time.Second):
+			return errSessionStuck
+		}
+	}
+	if _, live := sessionLockOwner(staging); live {
+		return errSessionRunning // another process is downloading it
+	}
+	s.unschedule(id)
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	s.log.Info("session deleted", "session", id)
+	return nil
+}
+
+func discardError(err error) *apperrors.AppError {
+	switch {
+	case os.IsNotExist(err):
+		return apperrors.NotFound("session not found", err)
+	case errors.Is(err, errSessionRunning):
+		return apperrors.New(http.StatusConflict, "another process is downloading this session", err)
+	default:
+		return apperrors.InternalServerError("delete session", err)
+	}
+}
+
+// handleSessionPage renders session.html, the detail view the session
+// cards link to.
+func (s *server) handleSessionPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d, appErr := s.sessionDetail(r.URL.Query().Get("id"))
+	if appErr != nil {
+		appErr.WriteHTTPResponse(w)
+		return
+	}
+	if err := s.tmpl.ExecuteTemplate(w, "session.html", d); err != nil {
+		s.log.Warn("render session page", "session", d.SessionID, "err", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+func TestSessionDetail(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newServer(options{outputDir: dir}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging := filepath.Join(dir, "llama3-8b.staging")
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err = models.SaveSessionMeta(models.SessionMeta{
+		Model:          "llama3:8b",
+		SessionID:      "llama3-8b",
+		StagingRoot:    staging,
+		State:          models.StatePaused,
+		StartedAt:      time.Now().Add(-time.Minute),
+		LastUpdated:    time.Now(),
+		ManifestDigest: "sha256:feed",
+		Blobs:          []models.SessionBlob{{Digest: "sha256:0123456789abcdef0123", Size: 100, Done: 40}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.handler()
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get(apiPrefix + "/sessions/llama3-8b")
+	var d models.SessionDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET sessions/llama3-8b = %d %s", rec.Code, rec.Body)
+	}
+	if d.ManifestDigest != "sha256:feed" || len(d.Blobs) != 1 || d.Blobs[0].State != "partial" || d.Running {
+		t.Errorf("detail = %+v", d)
+	}
+	for _, target := range []string{apiPrefix + "/sessions/nope", apiPrefix + "/sessions/x/y", "/session?id=nope"} {
+		if rec := get(target); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", target, rec.Code)
+		}
+	}
+
+	rec = get("/session?id=llama3-8b")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "sha256:0123456789ab") || !strings.Contains(rec.Body.String(), "partial") {
+		t.Errorf("GET /session = %d\n%s", rec.Code, rec.Body)
+	}
+
+	// Discarding a session that runs here cancels it first.
+	done := make(chan struct{})
+	s.sessions["llama3-8b"] = &activeSession{id: "llama3-8b", progress: newProgress(0), done: done, cancel: func() {
+		s.mu.Lock()
+		delete(s.sessions, "llama3-8b")
+		s.mu.Unlock()
+		close(done)
+	}}
+	del := httptest.NewRequest(http.MethodDelete, apiPrefix+"/sessions/llama3-8b", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, del)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE = %d %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("staging directory still there: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, apiPrefix+"/sessions/llama3-8b", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE = %d, want 404", rec.Code)
+	}
+}
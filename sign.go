@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// checksumSuffix is appended to archive paths for their sha256sum-compatible
+// checksum sidecar.
+const checksumSuffix = ".sha256"
+
+// writeChecksumSidecar writes "<hex>  <name>" next to archive so receivers
+// can run `sha256sum -c` on it, and returns the sidecar path.
+func writeChecksumSidecar(archive, sum string) (string, error) {
+	path := archive + checksumSuffix
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(archive))
+	return path, os.WriteFile(path, []byte(line), 0o644)
+}
+
+// sumsFileName is the checksum list of every archive in an output
+// directory, for `sha256sum -c SHA256SUMS` and one signature over them all.
+const sumsFileName = "SHA256SUMS"
+
+// writeSumsFile rewrites SHA256SUMS in dir from the checksum sidecars there
+// and, with keyID set, signs it as SHA256SUMS.asc. Without a key a
+// signature left by an earlier signed run is removed, as it no longer
+// matches.
+func writeSumsFile(dir, keyID string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var sums bytes.Buffer
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), checksumSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		sums.Write(bytes.TrimSpace(data))
+		sums.WriteByte('\n')
+	}
+	// Sessions of the web UI package into the same directory at once.
+	tmp, err := os.CreateTemp(dir, sumsFileName+".*.partial")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(sums.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, sumsFileName)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if keyID == "" {
+		if err := os.Remove(path + ".asc"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return signArtifacts(keyID, path)
+}
+
+// verifyArchive checks archive against its checksum sidecar, when there is
+// one, and reads every zip entry so truncated or corrupt members fail their
+// CRC check.
+func verifyArchive(archive string) error {
+	if err := verifyChecksumSidecar(archive); err != nil {
+		return err
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// verifyChecksumSidecar checks file against its checksum sidecar, when
+// there is one.
+func verifyChecksumSidecar(file string) error {
+	data, err := os.ReadFile(file + checksumSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return fmt.Errorf("%s: empty checksum file", filepath.Base(file)+checksumSuffix)
+	}
+	ok, err := verifyFileHash(file, fields[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: sha256 mismatch", filepath.Base(file))
+	}
+	return nil
+}
+
+// signArtifacts writes an ASCII-armored detached gpg signature (<file>.asc)
+// for each file. keyID selects the signing key; "default" leaves the choice
+// to gpg.
+func signArtifacts(keyID string, files ...string) error {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return fmt.Errorf("gpg not found in PATH")
+	}
+	for _, f := range files {
+		args := []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", f + ".asc"}
+		if keyID != "default" {
+			args = append(args, "--local-user", keyID)
+		}
+		args = append(args, f)
+		var stderr bytes.Buffer
+		cmd := exec.Command("gpg", args...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("gpg %s: %v: %s", filepath.Base(f), err, bytes.TrimSpace(stderr.Bytes()))
+		}
+	}
+	return nil
+}
+
+// verifySignatures checks the detached gpg signature (<file>.asc) of each
+// file with `gpg --verify`. A file without one fails only when required.
+func verifySignatures(required bool, files ...string) error {
+	var present []string
+	for _, f := range files {
+		if _, err := os.Stat(f + ".asc"); err == nil {
+			present = append(present, f)
+		} else if !os.IsNotExist(err) {
+			return err
+		} else if required {
+			return fmt.Errorf("no signature %s", filepath.Base(f)+".asc")
+		}
+	}
+	if len(present) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return fmt.Errorf("gpg not found in PATH")
+	}
+	for _, f := range present {
+		var stderr bytes.Buffer
+		cmd := exec.Command("gpg", "--batch", "--verify", f+".asc", f)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("gpg %s: %v: %s", filepath.Base(f)+".asc", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+	}
+	return nil
+}
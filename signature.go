@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	mtCosignSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	mtCosignArtifactSig   = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	cosignSignatureAnnot  = "dev.cosignproject.cosign/signature"
+)
+
+// errNoSignature is returned when neither the cosign tag nor the referrers
+// API yields a signature for the manifest.
+var errNoSignature = errors.New("no signature found")
+
+type signatureManifest struct {
+	Layers []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Size        int64             `json:"size"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// simpleSigningPayload is the part of the cosign payload we check: it binds
+// the signature to a manifest digest.
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// manifestDigest returns the content digest of raw manifest bytes.
+func manifestDigest(manifestJSON []byte) string {
+	sum := sha256.Sum256(manifestJSON)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// checkSignature enforces the signature policy in opt for the manifest
+// identified by digest. Without -signature-key nothing is checked; with a
+// key, a present-but-invalid signature always fails, and a missing one only
+// fails under -require-signature.
+func checkSignature(ctx context.Context, client *http.Client, opt options, repository, digest, token string) error {
+	if opt.signatureKey == "" {
+		if opt.requireSignature {
+			return errors.New("-require-signature needs -signature-key")
+		}
+		return nil
+	}
+	pub, err := loadPublicKey(opt.signatureKey)
+	if err != nil {
+		return fmt.Errorf("load signature key: %w", err)
+	}
+	err = verifyManifestSignature(ctx, client, opt, repository, digest, token, pub)
+	if errors.Is(err, errNoSignature) && !opt.requireSignature {
+		fmt.Fprintf(os.Stderr, "warning: %s@%s is not signed\n", repository, digest)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	logf(ctx, opt.verbose, "Verified signature for %s\n", digest)
+	return nil
+}
+
+func verifyManifestSignature(ctx context.Context, client *http.Client, opt options, repository, digest, token string, pub crypto.PublicKey) error {
+	sigTag := strings.Replace(digest, ":", "-", 1) + ".sig"
+	candidates := []string{sigTag}
+	if refs, err := signatureReferrers(ctx, client, opt, repository, digest, token); err == nil {
+		candidates = append(candidates, refs...)
+	}
+	var lastErr error = errNoSignature
+	for _, ref := range candidates {
+		body, status, err := fetchRegistryObject(ctx, client, opt, repository, "manifests", ref, token)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusNotFound {
+			continue
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("fetch signature manifest %s: status %d", ref, status)
+		}
+		var sm signatureManifest
+		if err := json.Unmarshal(body, &sm); err != nil {
+			return fmt.Errorf("decode signature manifest: %w", err)
+		}
+		for _, l := range sm.Layers {
+			sigB64 := l.Annotations[cosignSignatureAnnot]
+			if sigB64 == "" {
+				continue
+			}
+			sig, err := base64.StdEncoding.DecodeString(sigB64)
+			if err != nil {
+				lastErr = fmt.Errorf("decode signature: %w", err)
+				continue
+			}
+			payload, status, err := fetchRegistryObject(ctx, client, opt, repository, "blobs", l.Digest, token)
+			if err != nil {
+				return err
+			}
+			if status != http.StatusOK {
+				lastErr = fmt.Errorf("fetch signature payload: status %d", status)
+				continue
+			}
+			if manifestDigest(payload) != l.Digest {
+				lastErr = errors.New("signature payload digest mismatch")
+				continue
+			}
+			if err := verifyPayload(pub, payload, sig); err != nil {
+				lastErr = err
+				continue
+			}
+			var p simpleSigningPayload
+			if err := json.Unmarshal(payload, &p); err != nil {
+				lastErr = fmt.Errorf("decode signature payload: %w", err)
+				continue
+			}
+			if p.Critical.Image.DockerManifestDigest != digest {
+				lastErr = fmt.Errorf("signature is for %s, not %s", p.Critical.Image.DockerManifestDigest, digest)
+				continue
+			}
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// signatureReferrers queries the OCI referrers API for cosign signature
+// manifests attached to digest.
+func signatureReferrers(ctx context.Context, client *http.Client, opt options, repository, digest, token string) ([]string, error) {
+	body, status, err := fetchRegistryObject(ctx, client, opt, repository, "referrers", digest, token)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("referrers: status %d", status)
+	}
+	var idx struct {
+		Manifests []struct {
+			Digest       string `json:"digest"`
+			ArtifactType string `json:"artifactType"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &idx); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, m := range idx.Manifests {
+		if m.ArtifactType == mtCosignArtifactSig || m.ArtifactType == mtCosignSimpleSigning {
+			out = append(out, m.Digest)
+		}
+	}
+	return out, nil
+}
+
+func verifyPayload(pub crypto.PublicKey, payload, sig []byte) error {
+	h := sha256.Sum256(payload)
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, h[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err != nil {
+			if err := rsa.VerifyPSS(k, crypto.SHA256, h[:], sig, nil); err != nil {
+				return errors.New("invalid RSA signature")
+			}
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// fetchRegistryObject GETs /v2/<repo>/<kind>/<ref> and returns the body and
+// status code; non-200 statuses are not treated as errors.
+func fetchRegistryObject(ctx context.Context, client *http.Client, opt options, repository, kind, ref, token string) ([]byte, int, error) {
+	u := fmt.Sprintf("%s/v2/%s/%s/%s", strings.TrimRight(opt.registry, "/"), repository, kind, ref)
+	headers := map[string]string{
+		"Accept":     strings.Join([]string{mtOCIIndex, mtOCIManifest, mtDockerManifest, "application/json", "*/*"}, ", "),
+		"User-Agent": "ollama-model-downloader/1.0",
+	}
+	if token != "" {
+		headers["Authorization"] = authHeader(token)
+	}
+	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, u, headers, opt.retries, opt.verbose)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, resp.StatusCode, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errDeclined is returned by run when the user answers no to the
+// confirmation prompt.
+var errDeclined = errors.New("download canceled")
+
+// plannedBytes is the size of the distinct blobs of the manifests that
+// layers keeps.
+func plannedBytes(layers layerFilter, manifests ...imageManifest) int64 {
+	var items []blobItem
+	for _, m := range manifests {
+		items = append(items, manifestBlobs(m, blobSource{})...)
+	}
+	items, _ = layers.apply(dedupeBlobs(items))
+	var total int64
+	for _, it := range items {
+		total += it.size
+	}
+	return total
+}
+
+// checkMaxSize fails when total is more than -max-size, so a mistyped tag
+// (":70b" for ":7b") is caught before any of it is fetched. -yes downloads
+// it anyway.
+func checkMaxSize(opt options, total int64) error {
+	if opt.maxSize <= 0 || total <= opt.maxSize {
+		return nil
+	}
+	if opt.yes {
+		fmt.Fprintf(os.Stderr, "warning: %s is %s, more than -max-size %s\n", opt.model, humanBytes(total), humanBytes(opt.maxSize))
+		return nil
+	}
+	return fmt.Errorf("%s is %s, more than -max-size %s; pass -yes to download it anyway", opt.model, humanBytes(total), humanBytes(opt.maxSize))
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmDownload shows what ref resolved to and asks on in whether to
+// download it. Ctrl-C while waiting returns ctx's error.
+func confirmDownload(ctx context.Context, in io.Reader, out io.Writer, ref modelRef, layers int, total int64) error {
+	name := ref.Host + "/" + ref.Repository
+	if ref.ReferenceTag != "" {
+		name += ":" + ref.ReferenceTag
+	}
+	fmt.Fprintf(out, "Model: %s\nLayers: %d\nSize: %s\nDownload? [y/N] ", name, layers, humanBytes(total))
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		answer <- strings.ToLower(strings.TrimSpace(line))
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case a := <-answer:
+		if a == "y" || a == "yes" {
+			return nil
+		}
+		return errDeclined
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+// speedSampleInterval is how often a running download records its rate.
+const speedSampleInterval = 5 * time.Second
+
+// speedTracker samples a progress counter and writes one SpeedSample per
+// interval to the session's speed log.
+type speedTracker struct {
+	p        *progress
+	dir      string
+	interval time.Duration
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newSpeedTracker(p *progress, stagingDir string) *speedTracker {
+	return &speedTracker{p: p, dir: stagingDir, interval: speedSampleInterval, done: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+// Start samples in the background until Stop is called or ctx ends.
+func (t *speedTracker) Start(ctx context.Context) {
+	go func() {
+		defer close(t.stopped)
+		tick := time.NewTicker(t.interval)
+		defer tick.Stop()
+		lastAt, last := time.Now(), atomic.LoadInt64(&t.p.done)
+		sample := func(now time.Time) {
+			done := atomic.LoadInt64(&t.p.done)
+			elapsed := now.Sub(lastAt).Seconds()
+			if elapsed <= 0 {
+				return
+			}
+			rate := float64(done-last) / elapsed
+			if rate < 0 {
+				rate = 0
+			}
+			_ = models.AppendSpeedSamples(t.dir, models.SpeedSample{Time: now, BytesPerSecond: rate, Done: done})
+			lastAt, last = now, done
+		}
+		for {
+			select {
+			case now := <-tick.C:
+				sample(now)
+			case <-t.done:
+				sample(time.Now())
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop records a final sample and waits for the sampler to exit. It may be
+// called more than once.
+func (t *speedTracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		<-t.stopped
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The stylesheet and font the page needs, so the web UI works without
+// internet access.
+//
+//go:embed static
+var staticFS embed.FS
+
+// staticAssets serves staticFS under /static/. Pages link files through
+// url, which adds a hash of the content, so those responses are cached
+// for good and a new build still gets its own copy.
+type staticAssets struct {
+	files  fs.FS
+	hashes map[string]string // file -> first 12 hex digits of its sha256
+}
+
+func newStaticAssets() (*staticAssets, error) {
+	files, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		return nil, err
+	}
+	a := &staticAssets{files: files, hashes: make(map[string]string)}
+	err = fs.WalkDir(files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(files, path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		a.hashes[path] = hex.EncodeToString(sum[:])[:12]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// url is the "static" template function: the versioned path of a file.
+func (a *staticAssets) url(name string) string {
+	if h, ok := a.hashes[name]; ok {
+		return "/static/" + name + "?v=" + h
+	}
+	return "/static/" + name
+}
+
+func (a *staticAssets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	hash, ok := a.hashes[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch v := r.URL.Query().Get("v"); {
+	case v == hash:
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	case v == "":
+		// Files the stylesheet refers to, such as fonts.
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	default:
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	f, err := a.files.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("ETag", `"`+hash+`"`)
+	http.ServeContent(w, r, name, time.Time{}, f.(io.ReadSeeker))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	classAttr  = regexp.MustCompile("class(?:Name)?\\s*[=:]\\s*([\"'`])([^\"'`]*)[\"'`]")
+	classList  = regexp.MustCompile(`classList\.(?:add|remove)\('([^']+)'\)`)
+	cssEscapes = strings.NewReplacer(`:`, `\:`, `/`, `\/`, `.`, `\.`, `[`, `\[`, `]`, `\]`)
+)
+
+// TestStaticCSSCoversTemplates keeps static/app.css in step with the
+// templates: every class they use needs a rule there or in styles.html,
+// or is a hook the scripts select by.
+func TestStaticCSSCoversTemplates(t *testing.T) {
+	css, err := fs.ReadFile(staticFS, "static/app.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := fs.Glob(templateFS, "templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src strings.Builder
+	for _, p := range pages {
+		b, err := fs.ReadFile(templateFS, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src.Write(b)
+	}
+	rules := string(css) + src.String() // styles.html and the scripts
+	var classes []string
+	for _, m := range classAttr.FindAllStringSubmatch(src.String(), -1) {
+		classes = append(classes, strings.Fields(m[2])...)
+	}
+	for _, m := range classList.FindAllStringSubmatch(src.String(), -1) {
+		classes = append(classes, m[1])
+	}
+	for _, c := range classes {
+		if strings.ContainsAny(c, "{$") || c == "active" {
+			continue
+		}
+		sel := "." + cssEscapes.Replace(c)
+		if !regexp.MustCompile(regexp.QuoteMeta(sel) + `[\s:{,>'"]`).MatchString(rules) {
+			t.Errorf("class %q has no rule in static/app.css", c)
+		}
+	}
+	if strings.Contains(src.String(), "https://cdn.") || strings.Contains(src.String(), "fonts.googleapis.com") {
+		t.Error("templates load assets from the internet")
+	}
+}
+
+func TestStaticAssets(t *testing.T) {
+	a, err := newStaticAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(target, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		return rec
+	}
+
+	url := a.url("app.css")
+	rec := get(url, "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/css") {
+		t.Fatalf("GET %s = %d %q", url, rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("versioned Cache-Control = %q", cc)
+	}
+	if rec := get(url, rec.Header().Get("ETag")); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation = %d, want 304", rec.Code)
+	}
+	if rec := get("/static/fonts/Vazirmatn-Regular.ttf", ""); rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "public, max-age=86400" {
+		t.Errorf("font = %d %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	for _, target := range []string{"/static/", "/static/fonts/", "/static/missing.css"} {
+		if rec := get(target, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	apperrors "ollama-model-downloader/internal/errors"
+)
+
+// errManifestNotFound is wrapped by getManifestOrIndex on a 404.
+var errManifestNotFound = errors.New("manifest not found")
+
+// maxSuggestions is how many near-matches a not-found error lists.
+const maxSuggestions = 3
+
+// notFoundError turns a 404 for ref into "<model> not found; did you mean
+// ...?". Near-matches come from the repository's tags and, for the Ollama
+// registry, the library search; if neither answers, err is returned as is.
+func notFoundError(ctx context.Context, client *http.Client, opt options, ref modelRef, err error) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	name := shortModelName(ref.Repository, opt.modelConfig)
+	tag := ref.ReferenceTag
+	if tag == "" {
+		tag = "latest"
+	}
+
+	var suggestions []string
+	if tags, terr := listTags(ctx, client, opt, ref.Repository); terr == nil && len(tags) > 0 {
+		// The model exists; only the tag is wrong.
+		for _, t := range closest(tag, tags) {
+			suggestions = append(suggestions, name+":"+t)
+		}
+	} else if ref.Host == "registry.ollama.ai" {
+		suggestions = searchLibrary(ctx, client, opt, name, tag)
+	}
+	if len(suggestions) == 0 {
+		return apperrors.WithKind(apperrors.KindNotFound, err)
+	}
+	if !ref.IsDigest {
+		name += ":" + tag
+	}
+	return apperrors.WithKind(apperrors.KindNotFound, fmt.Errorf("%s not found; did you mean %s?", name, joinOr(suggestions)))
+}
+
+// searchLibrary asks ollama.com for models named like name and returns the
+// closest, with tag when the model has it.
+func searchLibrary(ctx context.Context, client *http.Client, opt options, name, tag string) []string {
+	page := ollamaLibraryBase + "/search?q=" + url.QueryEscape(name)
+	resp, err := httpReqWithRetry(ctx, client, http.MethodGet, page, map[string]string{"User-Agent": "ollama-model-downloader/1.0"}, 0, opt.verbose)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil
+	}
+	found := map[string]libraryModel{}
+	var names []string
+	for _, m := range parseLibraryPage(string(body)) {
+		found[m.Name] = m
+		names = append(names, m.Name)
+	}
+	var out []string
+	for _, n := range closest(name, names) {
+		s := n
+		for _, size := range found[n].Sizes {
+			if size.Tag == tag {
+				s += ":" + tag
+				break
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// closest returns up to maxSuggestions candidates nearest to want by edit
+// distance, ignoring ones so different they are unlikely to be meant.
+func closest(want string, candidates []string) []string {
+	type scored struct {
+		s    string
+		dist int
+	}
+	limit := max(2, len(want)/2)
+	var list []scored
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(want), strings.ToLower(c))
+		if strings.HasPrefix(c, want) || strings.HasPrefix(want, c) {
+			d = min(d, 1)
+		}
+		if d <= limit && c != want {
+			list = append(list, scored{c, d})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].dist != list[j].dist {
+			return list[i].dist < list[j].dist
+		}
+		return list[i].s < list[j].s
+	})
+	var out []string
+	for i := 0; i < len(list) && i < maxSuggestions; i++ {
+		out = append(out, list[i].s)
+	}
+	return out
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// joinOr joins items as "a", "a or b" or "a, b or c".
+func joinOr(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
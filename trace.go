@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tracedHeaders lists the response headers worth printing when tracing;
+// everything else is noise for diagnosing proxies and middleboxes.
+var tracedHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Accept-Ranges",
+	"Docker-Content-Digest",
+	"Location",
+	"Via",
+	"Server",
+	"Www-Authenticate",
+}
+
+// traceTransport logs every request/response pair together with connection
+// level events collected through httptrace.
+type traceTransport struct {
+	next http.RoundTripper
+	seq  atomic.Int64
+}
+
+func newTraceTransport(next http.RoundTripper) *traceTransport {
+	return &traceTransport{next: next}
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := t.seq.Add(1)
+	start := time.Now()
+	tracef(id, "--> %s %s", req.Method, req.URL.Redacted())
+	for k, v := range req.Header {
+		tracef(id, "    %s: %s", k, redactHeader(k, strings.Join(v, ", ")))
+	}
+
+	ct := &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				tracef(id, "    dns error: %v", info.Err)
+				return
+			}
+			addrs := make([]string, 0, len(info.Addrs))
+			for _, a := range info.Addrs {
+				addrs = append(addrs, a.String())
+			}
+			tracef(id, "    dns: %s", strings.Join(addrs, ", "))
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				tracef(id, "    connect %s %s failed: %v", network, addr, err)
+				return
+			}
+			tracef(id, "    connected %s %s", network, addr)
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				tracef(id, "    tls handshake failed: %v", err)
+				return
+			}
+			tracef(id, "    tls %s, alpn=%q, server=%s", tlsVersionName(state.Version), state.NegotiatedProtocol, state.ServerName)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			tracef(id, "    conn %s reused=%t idle=%t (%v)", info.Conn.RemoteAddr(), info.Reused, info.WasIdle, info.IdleTime)
+		},
+		GotFirstResponseByte: func() {
+			tracef(id, "    first byte after %v", time.Since(start).Round(time.Millisecond))
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), ct))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		tracef(id, "<-- error after %v: %v", time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	tracef(id, "<-- %s %s (%v)", resp.Proto, resp.Status, time.Since(start).Round(time.Millisecond))
+	for _, k := range tracedHeaders {
+		if v := resp.Header.Get(k); v != "" {
+			tracef(id, "    %s: %s", k, v)
+		}
+	}
+	return resp, nil
+}
+
+// traceRedirect is installed as http.Client.CheckRedirect so the whole
+// redirect chain shows up in the trace output.
+func traceRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	prev := via[len(via)-1]
+	fmt.Fprintf(os.Stderr, "[trace] redirect %d: %s -> %s\n", len(via), prev.URL.Redacted(), req.URL.Redacted())
+	return nil
+}
+
+func tracef(id int64, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "[trace #%d] %s\n", id, fmt.Sprintf(format, args...))
+}
+
+func redactHeader(name, value string) string {
+	switch strings.ToLower(name) {
+	case "authorization", "proxy-authorization", "cookie":
+		if i := strings.IndexByte(value, ' '); i > 0 {
+			return value[:i] + " <redacted>"
+		}
+		return "<redacted>"
+	}
+	return value
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
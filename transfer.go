@@ -0,0 +1,249 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "export-session",
+		usage: "pack a paused session into a bundle that can be resumed elsewhere",
+		run:   runExportSession,
+	})
+	registerCommand(command{
+		name:  "import-session",
+		usage: "unpack a session bundle into -output-dir so it can be resumed",
+		run:   runImportSession,
+	})
+}
+
+func runExportSession(opt options, args []string) error {
+	flags := flag.NewFlagSet("export-session", flag.ContinueOnError)
+	out := flags.String("o", "", "bundle path (default <session>.session.tar.gz)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: export-session [-o bundle] <model or session id>")
+	}
+	sessionID, staging, meta, err := findSession(opt.outputDir, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if meta.State.IsActive() {
+		return fmt.Errorf("session %s is still %s; pause it first", sessionID, meta.State)
+	}
+	if *out == "" {
+		*out = sessionID + ".session.tar.gz"
+	}
+	skipped, err := exportSession(staging, *out)
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	for _, p := range skipped {
+		fmt.Fprintf(os.Stderr, "warning: %s failed verification and was left out\n", p)
+	}
+	fmt.Println("OK:", *out)
+	return nil
+}
+
+// exportSession writes staging (session.json, manifests, verified blobs and
+// .part checkpoints) to a gzip-compressed tar at out. Complete blobs whose
+// content does not match their digest are skipped and returned so the
+// importing side re-downloads them instead of packaging a corrupt file.
+func exportSession(staging, out string) ([]string, error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	var skipped []string
+	err = filepath.Walk(staging, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if info.IsDir() || info.Name() == sessionLockName {
+			return nil
+		}
+		if filepath.Base(filepath.Dir(path)) == "blobs" && strings.HasPrefix(info.Name(), "sha256-") && !strings.HasSuffix(info.Name(), ".part") {
+			ok, err := verifyFileHash(path, strings.TrimPrefix(info.Name(), "sha256-"))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				skipped = append(skipped, rel)
+				return nil
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return skipped, f.Close()
+}
+
+func runImportSession(opt options, args []string) error {
+	flags := flag.NewFlagSet("import-session", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace an existing staging directory for the same session")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: import-session [-force] <bundle>")
+	}
+	if err := os.MkdirAll(opt.outputDir, 0o755); err != nil {
+		return err
+	}
+	meta, err := importSession(flags.Arg(0), opt.outputDir, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %s into %s\n", meta.Model, meta.StagingRoot)
+	fmt.Printf("resume with: ollama-model-downloader -output-dir %s %s\n", opt.outputDir, meta.Model)
+	return nil
+}
+
+// importSession unpacks bundle into outputDir/<session>.staging and points
+// the stored metadata at the new location. The session is marked paused so
+// both the CLI and the web UI pick it up as resumable.
+func importSession(bundle, outputDir string, force bool) (models.SessionMeta, error) {
+	tmp, err := os.MkdirTemp(outputDir, ".import-")
+	if err != nil {
+		return models.SessionMeta{}, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := untarGz(bundle, tmp); err != nil {
+		return models.SessionMeta{}, fmt.Errorf("unpack %s: %w", bundle, err)
+	}
+	meta, err := models.LoadSessionMeta(tmp)
+	if err != nil {
+		return meta, fmt.Errorf("%s is not a session bundle: %w", bundle, err)
+	}
+	if meta.SessionID == "" {
+		meta.SessionID = sanitizeModelName(meta.Model)
+	}
+	// The id comes from the bundle and names a directory in outputDir.
+	if id := meta.SessionID; id != filepath.Base(id) || id == "." || id == ".." {
+		return meta, fmt.Errorf("%s: invalid session id %q", bundle, id)
+	}
+
+	staging := filepath.Join(outputDir, meta.SessionID+".staging")
+	if _, err := os.Stat(staging); err == nil {
+		if !force {
+			return meta, fmt.Errorf("%s already exists (use -force to replace it)", staging)
+		}
+		if existing, err := models.LoadSessionMeta(staging); err == nil && existing.State.IsActive() {
+			return meta, fmt.Errorf("session %s is currently %s", meta.SessionID, existing.State)
+		}
+		if err := os.RemoveAll(staging); err != nil {
+			return meta, err
+		}
+	}
+	if err := os.Rename(tmp, staging); err != nil {
+		return meta, err
+	}
+	_ = os.Chmod(staging, 0o755)
+
+	meta.StagingRoot = staging
+	zipName := meta.SessionID + ".zip"
+	if meta.OutZip != "" {
+		zipName = filepath.Base(meta.OutZip)
+	}
+	meta.OutZip = filepath.Join(outputDir, zipName)
+	meta.State = models.StatePaused
+	meta.Message = "منتقل شده از دستگاه دیگر"
+	meta.LastUpdated = time.Now()
+	return meta, models.SaveSessionMeta(meta)
+}
+
+func untarGz(path, dest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return untar(gz, dest, func(name string) string { return name })
+}
+
+// untar writes the regular files of the tar stream r below dest, at the
+// path rename returns for each entry name; an empty path skips the entry.
+func untar(r io.Reader, dest string, rename func(name string) string) error {
+	destClean := filepath.Clean(dest)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := rename(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || name == "" {
+			continue
+		}
+		targetPath := filepath.Join(destClean, filepath.FromSlash(name))
+		if !strings.HasPrefix(filepath.Clean(targetPath), destClean+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid file path: %s", hdr.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(targetPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+}
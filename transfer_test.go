@@ -0,0 +1,42 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportSessionRejectsPathInSessionID(t *testing.T) {
+	dir := t.TempDir()
+	outputDir := filepath.Join(dir, "a", "b", "out")
+	os.MkdirAll(outputDir, 0o755)
+	victim := filepath.Join(dir, "x.staging")
+	os.MkdirAll(victim, 0o755)
+	os.WriteFile(filepath.Join(victim, "keep"), []byte("keep"), 0o644)
+
+	for _, id := range []string{"../../../x", "..", "sub/x"} {
+		bundle := filepath.Join(dir, "evil.tar.gz")
+		f, err := os.Create(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		meta := []byte(`{"model":"test/m:latest","sessionId":"` + id + `"}`)
+		tw.WriteHeader(&tar.Header{Name: "session.json", Mode: 0o644, Size: int64(len(meta)), Typeflag: tar.TypeReg})
+		tw.Write(meta)
+		tw.Close()
+		gz.Close()
+		f.Close()
+
+		if _, err := importSession(bundle, outputDir, true); err == nil || !strings.Contains(err.Error(), "invalid session id") {
+			t.Errorf("session id %q: err = %v", id, err)
+		}
+		if _, err := os.Stat(filepath.Join(victim, "keep")); err != nil {
+			t.Fatalf("session id %q: directory outside the output dir touched: %v", id, err)
+		}
+	}
+}
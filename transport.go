@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// h2ResetThreshold is how many HTTP/2 stream failures are tolerated before
+// the client permanently switches to HTTP/1.1.
+const h2ResetThreshold = 3
+
+// disableHTTP2 turns tr into an HTTP/1.1-only transport.
+func disableHTTP2(tr *http.Transport) {
+	tr.ForceAttemptHTTP2 = false
+	tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+}
+
+// h2FallbackTransport sends requests over h2 until HTTP/2 specific failures
+// (stream resets, GOAWAY) have been seen h2ResetThreshold times, then routes
+// everything through h1. Some transparent proxies mangle HTTP/2 framing and
+// reset long blob transfers midway; HTTP/1.1 survives them.
+type h2FallbackTransport struct {
+	h2       http.RoundTripper
+	h1       http.RoundTripper
+	failures atomic.Int32
+	verbose  bool
+}
+
+func newH2FallbackTransport(h2, h1 http.RoundTripper, verbose bool) *h2FallbackTransport {
+	return &h2FallbackTransport{h2: h2, h1: h1, verbose: verbose}
+}
+
+func (t *h2FallbackTransport) downgraded() bool {
+	return t.failures.Load() >= h2ResetThreshold
+}
+
+func (t *h2FallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.downgraded() {
+		return t.h1.RoundTrip(req)
+	}
+	resp, err := t.h2.RoundTrip(req)
+	if err != nil {
+		t.observe(err)
+		return nil, err
+	}
+	if resp.ProtoMajor == 2 {
+		resp.Body = &h2WatchedBody{ReadCloser: resp.Body, t: t}
+	}
+	return resp, nil
+}
+
+func (t *h2FallbackTransport) observe(err error) {
+	if !isHTTP2StreamError(err) {
+		return
+	}
+	if n := t.failures.Add(1); n == h2ResetThreshold {
+		fmt.Fprintf(os.Stderr, "warning: %d HTTP/2 stream failures, falling back to HTTP/1.1\n", n)
+	} else if t.verbose && n < h2ResetThreshold {
+		fmt.Printf("HTTP/2 stream failure %d/%d: %v\n", n, h2ResetThreshold, err)
+	}
+}
+
+// h2WatchedBody reports read errors back to the fallback transport since
+// mid-transfer resets surface while copying the body, not in RoundTrip.
+type h2WatchedBody struct {
+	io.ReadCloser
+	t *h2FallbackTransport
+}
+
+func (b *h2WatchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.t.observe(err)
+	}
+	return n, err
+}
+
+func isHTTP2StreamError(err error) bool {
+	s := err.Error()
+	return strings.Contains(s, "stream error") ||
+		strings.Contains(s, "GOAWAY") ||
+		strings.Contains(s, "http2: ") ||
+		strings.Contains(s, "INTERNAL_ERROR")
+}
+
+// rateLimitTransport spaces requests so no more than perSecond start each
+// second, for registries that throttle aggressive clients.
+type rateLimitTransport struct {
+	rt       http.RoundTripper
+	interval time.Duration
+
+	mu   sync.Mutex
+	slot time.Time // earliest start of the next request
+}
+
+func newRateLimitTransport(rt http.RoundTripper, perSecond float64) *rateLimitTransport {
+	return &rateLimitTransport{rt: rt, interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	now := time.Now()
+	if t.slot.Before(now) {
+		t.slot = now
+	}
+	wait := t.slot.Sub(now)
+	t.slot = t.slot.Add(t.interval)
+	t.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return t.rt.RoundTrip(req)
+}
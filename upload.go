@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-model-downloader/models"
+)
+
+// uploader pushes the files of a finished download to one -upload target.
+type uploader interface {
+	// upload copies files to the target, calling progress with the bytes
+	// of file sent so far.
+	upload(ctx context.Context, files []string, progress func(file string, sent int64)) error
+	// String is the target as shown in logs and session state, without
+	// credentials.
+	String() string
+}
+
+// parseUploadTarget returns the uploader for an -upload value.
+func parseUploadTarget(target string) (uploader, error) {
+	scheme, rest, _ := strings.Cut(target, ":")
+	switch scheme {
+	case "rclone":
+		if remote, _, ok := strings.Cut(rest, ":"); !ok || remote == "" {
+			return nil, fmt.Errorf("%q: want rclone:remote:path", target)
+		}
+		return rcloneUploader{dest: rest}, nil
+	case "webdav+http", "webdav+https":
+		return newWebDAVUploader(strings.TrimPrefix(target, "webdav+"))
+	case "file":
+		u, err := url.Parse(target)
+		if err != nil || u.Path == "" || (u.Host != "" && u.Host != "localhost") {
+			return nil, fmt.Errorf("%q: want file:///dir", target)
+		}
+		return localUploader{dir: filepath.FromSlash(u.Path)}, nil
+	}
+	return nil, fmt.Errorf("unknown upload target %q (want rclone:remote:path, webdav+https://host/path or file:///dir)", redactURL(target))
+}
+
+// uploadList collects repeated -upload flags.
+type uploadList []string
+
+func (l *uploadList) String() string { return strings.Join(*l, ",") }
+
+func (l *uploadList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// redactURL hides the password of a URL-shaped target.
+func redactURL(target string) string {
+	if u, err := url.Parse(target); err == nil {
+		return u.Redacted()
+	}
+	return target
+}
+
+// rcloneUploader copies files with the rclone command, to any backend it
+// has a remote configured for. rclone retries failed transfers itself.
+type rcloneUploader struct {
+	dest string // remote:path
+}
+
+func (u rcloneUploader) String() string { return "rclone:" + u.dest }
+
+func (u rcloneUploader) upload(ctx context.Context, files []string, progress func(file string, sent int64)) error {
+	if _, err := exec.LookPath("rclone"); err != nil {
+		return fmt.Errorf("rclone not found in PATH")
+	}
+	for _, f := range files {
+		dest := strings.TrimSuffix(u.dest, "/") + "/" + filepath.Base(f)
+		if strings.HasSuffix(u.dest, ":") {
+			dest = u.dest + filepath.Base(f)
+		}
+		cmd := exec.CommandContext(ctx, "rclone", "copyto", "--use-json-log", "--stats", "1s", "--stats-log-level", "NOTICE", f, dest)
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		var errs []string
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			var line struct {
+				Level string `json:"level"`
+				Msg   string `json:"msg"`
+				Stats *struct {
+					Bytes int64 `json:"bytes"`
+				} `json:"stats"`
+			}
+			if json.Unmarshal(sc.Bytes(), &line) != nil {
+				errs = append(errs, sc.Text())
+				continue
+			}
+			switch {
+			case line.Stats != nil:
+				progress(f, line.Stats.Bytes)
+			case line.Level == "error" || line.Level == "critical":
+				errs = append(errs, line.Msg)
+			}
+		}
+		if err := cmd.Wait(); err != nil {
+			if len(errs) > 0 {
+				return fmt.Errorf("rclone %s: %v: %s", filepath.Base(f), err, errs[len(errs)-1])
+			}
+			return fmt.Errorf("rclone %s: %w", filepath.Base(f), err)
+		}
+	}
+	return nil
+}
+
+// localUploader copies files into a directory, such as a mounted share.
+// Each file is renamed into place once complete; an interrupted copy is
+// continued where it stopped.
+type localUploader struct {
+	dir string
+}
+
+func (u localUploader) String() string { return "file://" + filepath.ToSlash(u.dir) }
+
+func (u localUploader) upload(ctx context.Context, files []string, progress func(file string, sent int64)) error {
+	for _, f := range files {
+		if err := u.copy(ctx, f, func(n int64) { progress(f, n) }); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+	}
+	return nil
+}
+
+func (u localUploader) copy(ctx context.Context, file string, progress func(int64)) error {
+	if err := os.MkdirAll(u.dir, 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	// The partial copy is named after the source's size and modification
+	// time, so only a copy of this very file is continued.
+	dst := filepath.Join(u.dir, filepath.Base(file))
+	partial := fmt.Sprintf("%s.%x-%x.partial", dst, info.Size(), info.ModTime().UnixNano())
+	out, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err == nil && offset > info.Size() {
+		if err = out.Truncate(0); err == nil {
+			offset, err = out.Seek(0, io.SeekStart)
+		}
+	}
+	if err == nil {
+		_, err = in.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		progress(offset)
+		_, err = copyBody(out, &countingReader{r: contextReader{ctx, in}, n: offset, progress: progress})
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err // the partial copy is kept for the next attempt
+	}
+	return os.Rename(partial, dst)
+}
+
+// contextReader stops reading once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// uploadArtifacts pushes archives and the sidecars next to them to
+// every opt.uploads target at once, keeping the session's Uploads entries
+// (and the CLI bar) up to date. Each target retries on its own, up to
+// opt.retries times, and one that fails does not stop the others. The
+// session is in StateUploading meanwhile.
+func uploadArtifacts(ctx context.Context, opt options, record *sessionRecord, archives []string) error {
+	targets := make([]uploader, len(opt.uploads))
+	for i, t := range opt.uploads {
+		u, err := parseUploadTarget(t)
+		if err != nil {
+			return err
+		}
+		targets[i] = u
+	}
+	var files []string
+	for _, archive := range archives {
+		files = append(files, archive)
+		for _, sidecar := range archiveSidecars {
+			if _, err := os.Stat(archive + sidecar); err == nil {
+				files = append(files, archive+sidecar)
+			}
+		}
+	}
+	var total int64
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+	}
+
+	var mu sync.Mutex // guards statuses and saved
+	statuses := make([]models.UploadStatus, len(targets))
+	names := make([]string, len(targets))
+	for i, u := range targets {
+		statuses[i] = models.UploadStatus{Target: u.String(), State: "uploading", TotalBytes: total}
+		names[i] = u.String()
+	}
+	var saved time.Time
+	saveLocked := func() error {
+		saved = time.Now()
+		return record.update(func(m *models.SessionMeta) {
+			m.State = models.StateUploading
+			m.Message = fmt.Sprintf("در حال آپلود به %s...", strings.Join(names, "، "))
+			m.Uploads = append([]models.UploadStatus(nil), statuses...)
+		})
+	}
+	mu.Lock()
+	err := saveLocked()
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	logf(ctx, !opt.quiet, "Uploading to %s\n", strings.Join(names, ", "))
+	var p *progress // the web UI shows the session's Uploads instead
+	if opt.progress == nil {
+		p = newProgress(total * int64(len(targets)))
+		p.label = "Uploading"
+		p.Start(ctx)
+		defer func() {
+			p.Stop()
+			p.finishLine()
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for i, u := range targets {
+		wg.Add(1)
+		go func(i int, u uploader) {
+			defer wg.Done()
+			var err error
+			for attempt := 0; attempt <= opt.retries; attempt++ {
+				if attempt > 0 {
+					logf(ctx, opt.verbose, "upload to %s: %v\n", u, err)
+					backoff(ctx, attempt-1, opt.verbose)
+					if ctx.Err() != nil {
+						break
+					}
+				}
+				mu.Lock()
+				statuses[i].Attempts = attempt + 1
+				mu.Unlock()
+				sent := map[string]int64{}
+				err = u.upload(ctx, files, func(file string, n int64) {
+					sent[file] = n
+					var sum int64
+					for _, n := range sent {
+						sum += n
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					p.Add(sum - statuses[i].Bytes)
+					statuses[i].Bytes = sum
+					if time.Since(saved) >= time.Second {
+						saveLocked()
+					}
+				})
+				if err == nil || ctx.Err() != nil {
+					break
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				statuses[i].State, statuses[i].Error = "failed", err.Error()
+			} else {
+				p.Add(total - statuses[i].Bytes)
+				statuses[i].State, statuses[i].Bytes = "done", total
+			}
+		}(i, u)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err := saveLocked(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var failed []string
+	for _, st := range statuses {
+		if st.State == "failed" {
+			failed = append(failed, fmt.Sprintf("%s: %s", st.Target, st.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d targets failed: %s", len(failed), len(statuses), strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"ollama-model-downloader/models"
+)
+
+// fakeRclone puts an rclone on PATH that copies `copyto src remote:path`
+// into dir/path, reporting progress like rclone's JSON log.
+func fakeRclone(t *testing.T, dir string) {
+	bin := t.TempDir()
+	script := `#!/bin/sh
+for last; do :; done
+eval src=\${$(($#-1))}
+dst="` + dir + `/${last#*:}"
+mkdir -p "$(dirname "$dst")" && cp "$src" "$dst" || exit 1
+echo '{"level":"notice","msg":"stats","stats":{"bytes":'$(wc -c < "$src")'}}' >&2
+`
+	if err := os.WriteFile(filepath.Join(bin, "rclone"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestUploadRclone(t *testing.T) {
+	if _, err := parseUploadTarget("rclone:nocolon"); err == nil {
+		t.Error("rclone target without a path accepted")
+	}
+	if _, err := parseUploadTarget("ftp://host/dir"); err == nil {
+		t.Error("unknown target accepted")
+	}
+
+	remote := t.TempDir()
+	fakeRclone(t, remote)
+	_, srv, _ := testModel(t, 32<<10)
+	opt := testOptions(t, srv.URL)
+	opt.uploads = []string{"rclone:backup:models/"}
+	if err := run(context.Background(), opt); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := os.ReadFile(opt.outZip)
+	got, err := os.ReadFile(filepath.Join(remote, "models", filepath.Base(opt.outZip)))
+	if err != nil || string(got) != string(want) {
+		t.Fatalf("uploaded archive differs: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "models", filepath.Base(opt.outZip)+checksumSuffix)); err != nil {
+		t.Errorf("checksum sidecar not uploaded: %v", err)
+	}
+	meta, err := models.LoadSessionMeta(opt.stagingDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.State != models.StateCompleted || len(meta.Uploads) != 1 {
+		t.Fatalf("meta = %+v", meta)
+	}
+	if u := meta.Uploads[0]; u.State != "done" || u.Bytes != u.TotalBytes || u.TotalBytes < int64(len(want)) {
+		t.Errorf("upload status = %+v", u)
+	}
+}
+
+func TestUploadWebDAV(t *testing.T) {
+	files := map[string][]byte{}
+	cols := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "alice" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case "MKCOL":
+			if cols[r.URL.Path] {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			cols[r.URL.Path] = true
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPut:
+			if !cols[path.Dir(r.URL.Path)+"/"] {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			files[r.URL.Path], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv(webdavPasswordEnv, "secret")
+
+	target := strings.Replace(srv.URL, "http://", "webdav+http://alice@", 1) + "/dav/models"
+	u, err := parseUploadTarget(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(u.String(), "alice") {
+		t.Errorf("target shown with credentials: %s", u)
+	}
+	archive := filepath.Join(t.TempDir(), "m.zip")
+	os.WriteFile(archive, []byte("zip bytes"), 0o644)
+	var sent int64
+	for i := 0; i < 2; i++ { // the second run finds the collections in place
+		if err := u.upload(context.Background(), []string{archive}, func(_ string, n int64) { sent = n }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(files["/dav/models/m.zip"]) != "zip bytes" || sent != int64(len("zip bytes")) {
+		t.Errorf("files = %q, sent = %d", files, sent)
+	}
+
+	t.Setenv(webdavPasswordEnv, "wrong")
+	u, _ = parseUploadTarget(target)
+	if err := u.upload(context.Background(), []string{archive}, func(string, int64) {}); err == nil {
+		t.Error("upload with a wrong password succeeded")
+	}
+}
+
+func TestUploadSeveralTargets(t *testing.T) {
+	remote, shared := t.TempDir(), t.TempDir()
+	fakeRclone(t, remote)
+	blocked := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(blocked, nil, 0o644)
+	_, srv, _ := testModel(t, 32<<10)
+	opt := testOptions(t, srv.URL)
+	opt.retries = 1
+	opt.uploads = []string{"rclone:backup:models", "file://" + filepath.ToSlash(shared), "file://" + filepath.ToSlash(blocked) + "/sub"}
+
+	err := run(context.Background(), opt)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 targets failed") {
+		t.Fatalf("run = %v", err)
+	}
+	for _, dir := range []string{filepath.Join(remote, "models"), shared} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.Base(opt.outZip))); err != nil {
+			t.Errorf("archive missing from a working target: %v", err)
+		}
+	}
+	meta, _ := models.LoadSessionMeta(opt.stagingDir)
+	if len(meta.Uploads) != 3 {
+		t.Fatalf("uploads = %+v", meta.Uploads)
+	}
+	for i, want := range []string{"done", "done", "failed"} {
+		if u := meta.Uploads[i]; u.State != want {
+			t.Errorf("%s: state %s, want %s", u.Target, u.State, want)
+		}
+	}
+	if u := meta.Uploads[2]; u.Attempts != 2 || u.Error == "" {
+		t.Errorf("failed target = %+v, want 2 attempts and an error", u)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	archive := filepath.Join(t.TempDir(), "m.zip")
+	os.WriteFile(archive, data, 0o644)
+
+	// A Nextcloud-like server that drops the connection on the third part
+	// the first time round.
+	var mu sync.Mutex
+	store := map[string][]byte{}
+	puts, failed := 0, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "MKCOL":
+			w.WriteHeader(http.StatusCreated)
+		case "PROPFIND":
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+			for name, b := range store {
+				if path.Dir(name) == r.URL.Path {
+					fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:getcontentlength>%d</d:getcontentlength></d:prop></d:propstat></d:response>`, name, len(b))
+				}
+			}
+			fmt.Fprint(w, `</d:multistatus>`)
+		case http.MethodPut:
+			puts++
+			if path.Base(r.URL.Path) == "00003" && !failed {
+				failed = true
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			store[r.URL.Path], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case "MOVE":
+			var all []byte
+			for n := 1; ; n++ {
+				b, ok := store[fmt.Sprintf("%s/%05d", path.Dir(r.URL.Path), n)]
+				if !ok {
+					break
+				}
+				all = append(all, b...)
+			}
+			dest, _ := url.Parse(r.Header.Get("Destination"))
+			store[dest.Path] = all
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := newWebDAVUploader(srv.URL + "/remote.php/dav/files/alice/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.chunkSize = 3000
+	if err := u.upload(context.Background(), []string{archive}, func(string, int64) {}); err == nil {
+		t.Fatal("upload through a failing part succeeded")
+	}
+	puts = 0
+	var sent int64
+	if err := u.upload(context.Background(), []string{archive}, func(_ string, n int64) { sent = n }); err != nil {
+		t.Fatal(err)
+	}
+	if puts != 2 {
+		t.Errorf("resumed upload sent %d parts, want the last 2 of 4", puts)
+	}
+	if !bytes.Equal(store["/remote.php/dav/files/alice/models/m.zip"], data) || sent != int64(len(data)) {
+		t.Errorf("assembled file differs (%d bytes reported)", sent)
+	}
+
+	// A local copy continues from its partial file.
+	dir := t.TempDir()
+	info, _ := os.Stat(archive)
+	partial := fmt.Sprintf("%s.%x-%x.partial", filepath.Join(dir, "m.zip"), info.Size(), info.ModTime().UnixNano())
+	os.WriteFile(partial, data[:4000], 0o644)
+	var first int64 = -1
+	err = localUploader{dir: dir}.upload(context.Background(), []string{archive}, func(_ string, n int64) {
+		if first < 0 {
+			first = n
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "m.zip")); !bytes.Equal(got, data) || first != 4000 {
+		t.Errorf("local copy differs or did not resume (first progress %d)", first)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	apperrors "ollama-model-downloader/internal/errors"
+	"ollama-model-downloader/models"
+)
+
+// verifyStagedBlobs re-hashes every staged blob and fills report with the
+// expected and actual digest and size of each. Blobs that were already on
+// disk from an earlier run were never hashed by this one, so nothing is
+// taken on trust. Up to workers blobs are hashed at once and the bytes
+// read are added to p (which may be nil). It fails on the first missing
+// blob and after checking all of them if any mismatched.
+func verifyStagedBlobs(report *models.VerificationReport, blobsDir string, items []blobItem, workers int, p *progress) error {
+	results := make([]models.BlobVerification, len(items))
+	errs := make([]error, len(items))
+	sem := make(chan struct{}, max(1, workers))
+	var wg sync.WaitGroup
+	for i, it := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, it blobItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = verifyStagedBlob(blobsDir, it, p)
+		}(i, it)
+	}
+	wg.Wait()
+
+	report.OK = true
+	var bad []string
+	for i, it := range items {
+		if errs[i] != nil {
+			report.OK = false
+			return fmt.Errorf("blob %s missing after download: %w", it.digest, errs[i])
+		}
+		if !results[i].OK {
+			report.OK = false
+			bad = append(bad, it.digest)
+		}
+		report.Blobs = append(report.Blobs, results[i])
+	}
+	report.VerifiedAt = time.Now()
+	if len(bad) > 0 {
+		return apperrors.WithKind(apperrors.KindChecksum, fmt.Errorf("verification failed for %s", strings.Join(bad, ", ")))
+	}
+	return nil
+}
+
+func verifyStagedBlob(blobsDir string, it blobItem, p *progress) (models.BlobVerification, error) {
+	v := models.BlobVerification{
+		Digest:         it.digest,
+		MediaType:      it.mediaType,
+		SourceURL:      it.src.blobURL(it.digest),
+		ExpectedSize:   it.size,
+		ExpectedSHA256: strings.TrimPrefix(it.digest, "sha256:"),
+	}
+	r, err := models.DefaultBlobStore.Open(blobsDir, it.digest)
+	if err != nil {
+		return v, err
+	}
+	defer r.Close()
+	size, sum, err := hashReader(r, p)
+	if err != nil {
+		return v, err
+	}
+	v.Size, v.ActualSHA256 = size, sum
+	v.OK = v.ActualSHA256 == v.ExpectedSHA256 && (it.size <= 0 || size == it.size)
+	return v, nil
+}
+
+// hashFile returns the size and sha256 of path, adding the bytes read to p.
+func hashFile(path string, p *progress) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	return hashReader(f, p)
+}
+
+// hashReader returns the size and sha256 of what r yields, adding the
+// bytes read to p.
+func hashReader(r io.Reader, p *progress) (int64, string, error) {
+	h := sha256.New()
+	n, err := copyBody(io.MultiWriter(h, p), r)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeVerificationReport stores report as verification.json in dir.
+func writeVerificationReport(report *models.VerificationReport, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, models.VerificationReportName), data, 0o644)
+}
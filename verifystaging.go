@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"ollama-model-downloader/models"
+)
+
+func init() {
+	registerCommand(command{
+		name:  "verify-staging",
+		usage: "re-hash every blob of a stored session and re-download the ones that do not match",
+		run:   runVerifyStaging,
+	})
+}
+
+func runVerifyStaging(opt options, args []string) error {
+	flags := flag.NewFlagSet("verify-staging", flag.ContinueOnError)
+	noRepair := flags.Bool("no-repair", false, "only report mismatches; do not delete or re-download them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: verify-staging [-no-repair] <model or session id>")
+	}
+	_, staging, meta, err := findSession(opt.outputDir, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	sopt := sessionOptions(opt, opt.outputDir, meta, staging)
+	lock, err := acquireSessionLock(staging)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	blobsDir := filepath.Join(staging, "models", "blobs")
+	items, err := stagedBlobs(sopt, blobsDir)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		fmt.Println("no complete blobs staged")
+		return nil
+	}
+	var total int64
+	for _, it := range items {
+		total += it.size
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	p := newProgress(total)
+	p.Relabel("Verifying")
+	p.Start(ctx)
+	report := &models.VerificationReport{Model: meta.Model, Registry: sopt.registry}
+	verr := verifyStagedBlobs(report, blobsDir, items, sopt.concurrency, p)
+	p.Stop()
+	p.finishLine()
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+
+	var bad []blobItem
+	for i, v := range report.Blobs {
+		if !v.OK {
+			fmt.Printf("mismatch: %s (%s, sha256 %s)\n", v.Digest, humanBytes(v.Size), v.ActualSHA256)
+			bad = append(bad, items[i])
+		}
+	}
+	if verr != nil && len(bad) == 0 {
+		return verr
+	}
+	fmt.Printf("%d of %d blobs OK\n", len(items)-len(bad), len(items))
+	if len(bad) == 0 {
+		return nil
+	}
+	if *noRepair {
+		return fmt.Errorf("%d blobs do not match their digest", len(bad))
+	}
+	return repairBlobs(ctx, sopt, blobsDir, bad)
+}
+
+// stagedBlobs lists the complete blobs in blobsDir. Sizes come from the
+// session's stored manifest when it lists the blob, so a truncated blob is
+// reported even before it is hashed; other blobs are sized from disk.
+func stagedBlobs(opt options, blobsDir string) ([]blobItem, error) {
+	sizes := map[string]int64{}
+	if ref, err := parseModel(opt.registry, opt.model, opt.modelConfig); err == nil {
+		if res, err := storedManifest(opt, ref); err == nil {
+			for _, it := range manifestBlobs(res.manifest, blobSource{}) {
+				sizes[it.digest] = it.size
+			}
+		}
+	}
+	entries, err := os.ReadDir(blobsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var items []blobItem
+	for _, e := range entries {
+		digest, ok := digestFromBlobName(e.Name())
+		if !ok {
+			continue
+		}
+		size, known := sizes[digest]
+		if !known {
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			size = info.Size()
+		}
+		items = append(items, blobItem{digest: digest, size: size})
+	}
+	return items, nil
+}
+
+// repairBlobs deletes the bad blobs and fetches them again from the
+// session's registry. downloadBlob checks each digest as it writes.
+func repairBlobs(ctx context.Context, opt options, blobsDir string, bad []blobItem) error {
+	for _, it := range bad {
+		name := filepath.Join(blobsDir, blobFileName(it.digest))
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+		os.Remove(name + ".part")
+	}
+	res, _, err := resolveMirrorItem(ctx, opt)
+	if err != nil {
+		return fmt.Errorf("repair: %w", err)
+	}
+	client := newHTTPClient(opt)
+	registry := opt.registry
+	if res.ref.Registry != "" {
+		registry = res.ref.Registry
+	}
+	var total int64
+	for _, it := range bad {
+		total += it.size
+	}
+	p := newProgress(total)
+	p.Start(ctx)
+	defer func() {
+		p.Stop()
+		p.finishLine()
+	}()
+	for _, it := range bad {
+		if err := downloadBlob(ctx, client, registry, res.ref.Repository, it.digest, res.token, blobsDir, opt.retries, p, it.size, opt.verbose); err != nil {
+			return fmt.Errorf("repair %s: %w", it.digest, err)
+		}
+	}
+	fmt.Printf("re-downloaded %d blobs\n", len(bad))
+	return nil
+}
@@ -24,7 +24,11 @@ func NewServer(templateFS fs.FS, downloadsDir string) (*Server, error) {
 		"js":       js,
 	}
 
-	tmpl, err := template.New("index.html").Funcs(funcMap).ParseFS(templateFS, "templates/index.html")
+	sub, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := ParseTemplates(sub, "", funcMap)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing template: %w", err)
 	}
@@ -181,20 +185,22 @@ func (s *Server) getPageData() interface{} {
 	}
 
 	// Categorize sessions
-	running, paused, errored := models.CategorizeSessions(sessions)
+	running, paused, errored, completed := models.CategorizeSessions(sessions)
 
 	return struct {
-		Downloads       []models.DownloadEntry
-		RunningSession  *models.SessionView
-		PausedSessions  []models.SessionView
-		ErroredSessions []models.SessionView
-		Message         string
+		Downloads         []models.DownloadEntry
+		RunningSession    *models.SessionView
+		PausedSessions    []models.SessionView
+		ErroredSessions   []models.SessionView
+		CompletedSessions []models.SessionView
+		Message           string
 	}{
-		Downloads:       downloads,
-		RunningSession:  running,
-		PausedSessions:  paused,
-		ErroredSessions: errored,
-		Message:         "", // Can be set based on query params or other logic
+		Downloads:         downloads,
+		RunningSession:    running,
+		PausedSessions:    paused,
+		ErroredSessions:   errored,
+		CompletedSessions: completed,
+		Message:           "", // Can be set based on query params or other logic
 	}
 }
 
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// ParseTemplates parses the *.html templates in embedded, each named after
+// its file. When dir is set, a file there replaces the embedded one of the
+// same name and files only dir has are added, so a deployment can restyle
+// or extend the UI without rebuilding. The page is rendered by executing
+// "index.html".
+func ParseTemplates(embedded fs.FS, dir string, funcs template.FuncMap) (*template.Template, error) {
+	sources := make(map[string]fs.FS)
+	names, err := fs.Glob(embedded, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		sources[name] = embedded
+	}
+	if dir != "" {
+		own := os.DirFS(dir)
+		names, err := fs.Glob(own, "*.html")
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no *.html templates in %s", dir)
+		}
+		for _, name := range names {
+			sources[name] = own
+		}
+	}
+	if sources["index.html"] == nil {
+		return nil, fmt.Errorf("no index.html template")
+	}
+
+	names = names[:0]
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	root := template.New("index.html").Funcs(funcs)
+	for _, name := range names {
+		b, err := fs.ReadFile(sources[name], name)
+		if err != nil {
+			return nil, err
+		}
+		t := root
+		if name != root.Name() {
+			t = root.New(name)
+		}
+		if _, err := t.Parse(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
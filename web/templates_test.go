@@ -0,0 +1,48 @@
+package web
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseTemplates(t *testing.T) {
+	embedded := fstest.MapFS{
+		"index.html":  {Data: []byte(`{{template "header.html" .}}|{{template "theme.html" .}}`)},
+		"header.html": {Data: []byte(`built-in {{.}}`)},
+		"theme.html":  {Data: []byte(``)},
+	}
+	render := func(tmpl *template.Template) string {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, "x"); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	tmpl, err := ParseTemplates(embedded, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := render(tmpl); got != "built-in x|" {
+		t.Errorf("embedded = %q", got)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "theme.html"), []byte(`<b>{{template "brand.html"}}</b>`), 0o644)
+	os.WriteFile(filepath.Join(dir, "brand.html"), []byte(`ACME`), 0o644)
+	tmpl, err = ParseTemplates(embedded, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := render(tmpl); got != "built-in x|<b>ACME</b>" {
+		t.Errorf("overridden = %q", got)
+	}
+
+	if _, err := ParseTemplates(embedded, filepath.Join(dir, "missing"), nil); err == nil {
+		t.Error("missing templates dir accepted")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// webdavPasswordEnv holds the WebDAV password when the -upload URL names
+// only the user, so it stays out of the process list and shell history.
+const webdavPasswordEnv = envPrefix + "WEBDAV_PASSWORD"
+
+// webdavChunkSize is the part size of chunked uploads; files up to this
+// size are sent with a single PUT.
+const webdavChunkSize = 64 << 20
+
+// webdavUploader PUTs files into a WebDAV collection (Nextcloud, ownCloud,
+// SharePoint and the like), creating the collection and its parents with
+// MKCOL first. Credentials are sent with basic auth.
+//
+// Below a Nextcloud or ownCloud files URL, large files go through their
+// chunked upload API instead: the parts land in an upload collection named
+// after the file, its size and modification time, so a run that fails part
+// way lists what is already there and sends only the rest. Other servers
+// have no portable way to append, and a failed PUT starts over.
+type webdavUploader struct {
+	base       *url.URL // the collection, without user info
+	uploads    string   // path of the chunked upload area, "" when the server has none
+	user, pass string
+	client     *http.Client
+	chunkSize  int64
+}
+
+func newWebDAVUploader(target string) (*webdavUploader, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%q: want webdav+https://[user@]host/path", redactURL(target))
+	}
+	w := &webdavUploader{client: &http.Client{}}
+	if u.User != nil {
+		w.user = u.User.Username()
+		w.pass, _ = u.User.Password()
+		if w.pass == "" {
+			w.pass = os.Getenv(webdavPasswordEnv)
+		}
+		u.User = nil
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	w.base, w.chunkSize = u, webdavChunkSize
+	if prefix, rest, ok := strings.Cut(u.Path, "/remote.php/dav/files/"); ok {
+		user, _, _ := strings.Cut(rest, "/")
+		w.uploads = prefix + "/remote.php/dav/uploads/" + user
+	}
+	return w, nil
+}
+
+func (w *webdavUploader) String() string { return "webdav+" + w.base.String() }
+
+func (w *webdavUploader) upload(ctx context.Context, files []string, progress func(file string, sent int64)) error {
+	if err := w.mkcol(ctx); err != nil {
+		return err
+	}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		if w.uploads != "" && info.Size() > w.chunkSize {
+			err = w.putChunked(ctx, f, info, func(n int64) { progress(f, n) })
+		} else {
+			err = w.put(ctx, f, func(n int64) { progress(f, n) })
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+	}
+	return nil
+}
+
+// mkcol creates the collection and any missing parents. Servers answer 405
+// for a collection that already exists.
+func (w *webdavUploader) mkcol(ctx context.Context) error {
+	var dir string
+	for _, part := range strings.Split(strings.Trim(w.base.Path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+		resp, err := w.do(ctx, "MKCOL", "/"+dir+"/", nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusCreated, http.StatusMethodNotAllowed, http.StatusOK:
+		case http.StatusForbidden, http.StatusConflict:
+			// Parents above the user's own space (e.g. /remote.php/dav)
+			// cannot be created but exist; the PUT will tell.
+		default:
+			return fmt.Errorf("MKCOL /%s: %s", dir, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (w *webdavUploader) put(ctx context.Context, file string, progress func(int64)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	body := &countingReader{r: f, progress: progress}
+	resp, err := w.do(ctx, http.MethodPut, w.base.Path+"/"+filepath.Base(file), body, info.Size(), nil)
+	if err != nil {
+		return err
+	}
+	return webdavStatus(resp, "PUT")
+}
+
+// putChunked sends file through the chunked upload API in parts of
+// w.chunkSize, skipping the parts a previous run already stored, and then
+// has the server assemble them at the destination.
+func (w *webdavUploader) putChunked(ctx context.Context, file string, info os.FileInfo, progress func(int64)) error {
+	dest := *w.base
+	dest.Path = w.base.Path + "/" + filepath.Base(file)
+	header := http.Header{}
+	header.Set("Destination", dest.String())
+	header.Set("OC-Total-Length", strconv.FormatInt(info.Size(), 10))
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s %d %d", dest.Path, info.Size(), info.ModTime().UnixNano())))
+	dir := w.uploads + "/omd-" + hex.EncodeToString(key[:16])
+
+	resp, err := w.do(ctx, "MKCOL", dir, nil, 0, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("MKCOL upload: %s", resp.Status)
+	}
+	stored, err := w.listSizes(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var done int64
+	for n, off := 1, int64(0); off < info.Size(); n, off = n+1, off+w.chunkSize {
+		size := min(w.chunkSize, info.Size()-off)
+		name := fmt.Sprintf("%05d", n)
+		if stored[name] == size {
+			done += size
+			progress(done)
+			continue
+		}
+		base := done
+		body := &countingReader{r: io.NewSectionReader(f, off, size), progress: func(n int64) { progress(base + n) }}
+		resp, err := w.do(ctx, http.MethodPut, dir+"/"+name, body, size, header)
+		if err != nil {
+			return err
+		}
+		if err := webdavStatus(resp, "PUT part "+name); err != nil {
+			return err
+		}
+		done += size
+	}
+	resp, err = w.do(ctx, "MOVE", dir+"/.file", nil, 0, header)
+	if err != nil {
+		return err
+	}
+	return webdavStatus(resp, "MOVE")
+}
+
+// listSizes returns the size of each member of the collection at p.
+func (w *webdavUploader) listSizes(ctx context.Context, p string) (map[string]int64, error) {
+	header := http.Header{}
+	header.Set("Depth", "1")
+	header.Set("Content-Type", "application/xml")
+	query := `<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/></d:prop></d:propfind>`
+	resp, err := w.do(ctx, "PROPFIND", p, strings.NewReader(query), int64(len(query)), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND upload: %s", resp.Status)
+	}
+	var ms struct {
+		Responses []struct {
+			Href   string `xml:"href"`
+			Length int64  `xml:"propstat>prop>getcontentlength"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND upload: %w", err)
+	}
+	sizes := map[string]int64{}
+	for _, r := range ms.Responses {
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			href = r.Href
+		}
+		sizes[path.Base(strings.TrimSuffix(href, "/"))] = r.Length
+	}
+	return sizes, nil
+}
+
+// webdavStatus closes resp and fails unless the server accepted the
+// request.
+func webdavStatus(resp *http.Response, what string) error {
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s: %s: %s", what, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func (w *webdavUploader) do(ctx context.Context, method, p string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u := *w.base
+	u.Path = p
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = size
+	if w.user != "" {
+		req.SetBasicAuth(w.user, w.pass)
+	}
+	return w.client.Do(req)
+}
+
+// countingReader reports the bytes read through it so far.
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	progress func(int64)
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	if n > 0 {
+		c.progress(c.n)
+	}
+	return n, err
+}